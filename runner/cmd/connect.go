@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"syscall"
 
+	"github.com/scienceol/xyzen/runner/internal/chaos"
 	"github.com/scienceol/xyzen/runner/internal/client"
 	"github.com/scienceol/xyzen/runner/internal/config"
 	"github.com/scienceol/xyzen/runner/internal/power"
@@ -20,6 +21,8 @@ var (
 	flagURL       string
 	flagWorkDir   string
 	flagKeepAwake bool
+	flagDryRun    bool
+	flagChaos     string
 )
 
 func init() {
@@ -27,6 +30,11 @@ func init() {
 	connectCmd.Flags().StringVar(&flagURL, "url", "", "WebSocket URL (e.g. wss://cloud.example.com/xyzen/ws/v1/runner)")
 	connectCmd.Flags().StringVar(&flagWorkDir, "work-dir", "", "Working directory for file operations (default: current directory)")
 	connectCmd.Flags().BoolVar(&flagKeepAwake, "keep-awake", false, "Prevent system sleep while the runner is connected")
+	connectCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Report what agent commands and file changes would do without doing them")
+	// Developer-only fault injection; see package chaos.
+	connectCmd.Flags().StringVar(&flagChaos, "chaos", "", "Inject transport faults (latency=,jitter=,drop=,reconnect=)")
+	connectCmd.Flags().Lookup("chaos").NoOptDefVal = "on"
+	_ = connectCmd.Flags().MarkHidden("chaos")
 	rootCmd.AddCommand(connectCmd)
 }
 
@@ -56,11 +64,30 @@ The connection automatically reconnects with exponential backoff if interrupted.
 		if err != nil {
 			return fmt.Errorf("configuration error: %w", err)
 		}
+		if flagDryRun {
+			cfg.DryRun = true
+		}
+		if flagChaos != "" {
+			if cfg.Chaos, err = chaos.Parse(flagChaos); err != nil {
+				return err
+			}
+		}
 
 		fmt.Fprintln(os.Stderr)
 		ui.KeyValue("Endpoint", cfg.URL)
 		ui.KeyValue("Work dir", cfg.WorkDir)
+		ui.KeyValue("Device", cfg.DeviceName)
 		ui.KeyValue("Keep awake", fmt.Sprintf("%v", cfg.KeepAwake))
+		ui.KeyValue("Redaction", fmt.Sprintf("%v", cfg.Redact.RedactPTYOutput()))
+		ui.KeyValue("Workers", fmt.Sprintf("%d (queue %d)", cfg.Workers.Size, cfg.Workers.QueueSize))
+		ui.KeyValue("Auth", cfg.Auth.Scheme)
+		ui.KeyValue("E2E", cfg.E2E.Mode)
+		if cfg.DryRun {
+			ui.KeyValue("Dry run", "on (side effects are reported, not performed)")
+		}
+		if cfg.Chaos != nil {
+			ui.KeyValue("Chaos", cfg.Chaos.String())
+		}
 		ui.Separator()
 
 		// Start sleep inhibitor if requested
@@ -78,7 +105,10 @@ The connection automatically reconnects with exponential backoff if interrupted.
 
 		ui.Info("Waiting for connection...")
 
-		c := client.New(cfg)
+		c, err := client.New(cfg)
+		if err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
 
 		// Handle graceful shutdown
 		sigCh := make(chan os.Signal, 1)
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/state"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagGCDryRun  bool
+	flagGCWorkDir string
+)
+
+func init() {
+	gcCmd.Flags().BoolVar(&flagGCDryRun, "dry-run", false, "Show what would be removed without deleting anything")
+	gcCmd.Flags().StringVar(&flagGCWorkDir, "work-dir", "", "Working directory whose trash to collect (default: work_dir from the config, or the current directory)")
+	rootCmd.AddCommand(gcCmd)
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove expired runner-managed data from ~/.xyzen",
+	Long: `Applies the retention policies (max age / max total size) for trash,
+snapshots, recordings, artifacts, job logs, caches and temp workspaces. A connected
+runner also does this in the background; see gc.interval in the config.
+As it cannot tell what a runner still uses, gc refuses to run (other
+than with --dry-run) while a runner is connected.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadLocal()
+		if err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
+		if flagGCWorkDir != "" {
+			cfg.WorkDir = flagGCWorkDir
+		}
+		if cfg.WorkDir == "" {
+			if cfg.WorkDir, err = os.Getwd(); err != nil {
+				return fmt.Errorf("failed to get current directory: %w", err)
+			}
+		}
+		if cfg.WorkDir, err = filepath.Abs(cfg.WorkDir); err != nil {
+			return fmt.Errorf("invalid work directory: %w", err)
+		}
+		if !flagGCDryRun {
+			st, err := state.Open(filepath.Join(config.StateDir(), "state"))
+			if err != nil {
+				return err
+			}
+			pid, err := st.Runner()
+			if err != nil {
+				return fmt.Errorf("check for a running runner: %w", err)
+			}
+			if pid != 0 {
+				return fmt.Errorf("a runner (pid %d) is connected and collects its own garbage; stop it first, or use --dry-run", pid)
+			}
+		}
+		collector := &gc.Collector{Areas: cfg.GCAreas()}
+
+		var removed int
+		var freed int64
+		for _, r := range collector.Run(flagGCDryRun) {
+			ui.KeyValue(r.Area, fmt.Sprintf("%d removed (%s), %d kept (%s)",
+				r.Removed, gc.FormatBytes(r.Freed), r.Remaining, gc.FormatBytes(r.Bytes)))
+			for _, e := range r.Errors {
+				ui.Warn("%s: %s", r.Area, e)
+			}
+			removed += r.Removed
+			freed += r.Freed
+		}
+		if flagGCDryRun {
+			ui.Info("Dry run: would remove %d entries (%s)", removed, gc.FormatBytes(freed))
+		} else {
+			ui.Success("Removed %d entries (%s)", removed, gc.FormatBytes(freed))
+		}
+		return nil
+	},
+}
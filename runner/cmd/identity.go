@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/identity"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(identityCmd)
+}
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Print this machine's runner identity fingerprint",
+	Long: `Prints the fingerprint of the persistent runner identity key stored in
+~/.xyzen. Compare it with the fingerprint shown in the Xyzen UI before
+trusting a device. The key is created on first use.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := identity.LoadOrCreate(config.StateDir())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Fingerprint: %s\n", id.Fingerprint())
+		fmt.Printf("Public key:  %s\n", id.PublicKeyString())
+		if m := identity.MachineFingerprint(); m != "" {
+			fmt.Printf("Machine:     %s\n", m)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/auth"
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagLoginToken string
+	flagLoginURL   string
+)
+
+func init() {
+	loginCmd.Flags().StringVar(&flagLoginToken, "token", "", "Runner authentication token")
+	loginCmd.Flags().StringVar(&flagLoginURL, "url", "", "WebSocket URL (e.g. wss://cloud.example.com/xyzen/ws/v1/runner)")
+	rootCmd.AddCommand(loginCmd)
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Exchange the runner token for a short-lived login token",
+	Long: `Mints a short-lived JWT from the runner token and caches it in
+~/.xyzen/credentials.json. With auth.scheme set to "jwt" the runner then
+authenticates with that JWT in an Authorization header, so the runner
+token itself never has to be stored in the config or sent on connect.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadLocal()
+		if err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
+		token, url := cfg.Token, cfg.URL
+		if v := os.Getenv("XYZEN_RUNNER_TOKEN"); v != "" {
+			token = v
+		}
+		if v := os.Getenv("XYZEN_RUNNER_URL"); v != "" {
+			url = v
+		}
+		if flagLoginToken != "" {
+			token = flagLoginToken
+		}
+		if flagLoginURL != "" {
+			url = flagLoginURL
+		}
+		if token == "" || url == "" {
+			return fmt.Errorf("runner token and server URL are required (--token/--url, environment, or config file)")
+		}
+
+		creds, err := auth.Mint(url, token)
+		if err != nil {
+			return err
+		}
+		if err := auth.SaveCredentials(config.StateDir(), creds); err != nil {
+			return err
+		}
+		ui.Success("Logged in to %s", url)
+		ui.KeyValue("Expires", creds.ExpiresAt.Local().Format(time.RFC1123))
+		if cfg.Auth.Scheme != auth.JWT {
+			ui.Info("Set %s in the config to connect with this login", ui.Dim("auth.scheme: jwt"))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/audit"
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagReportSession string
+	flagReportTop     int
+)
+
+func init() {
+	reportCmd.Flags().StringVar(&flagReportSession, "session", "", "Agent session to report on (default: list sessions)")
+	reportCmd.Flags().IntVar(&flagReportTop, "top", 30, "Number of paths to show")
+	rootCmd.AddCommand(reportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show which files agent sessions read and modified",
+	Long: `Reads the local audit log and shows a heat map of the paths an agent
+session accessed, to help review agent work and spot unexpected access.
+Without --session, lists the sessions in the log.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.LoadLocal()
+		if err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
+		if flagReportSession == "" {
+			return listSessions(cfg.Audit.Path)
+		}
+
+		heat := audit.NewHeatMap()
+		err = audit.Scan(cfg.Audit.Path, func(ev audit.Event) {
+			if ev.Session == flagReportSession {
+				heat.Add(ev)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		r := heat.Report()
+		if r.Requests == 0 {
+			return fmt.Errorf("no requests for session %q in %s", flagReportSession, cfg.Audit.Path)
+		}
+		ui.KeyValue("Session", flagReportSession)
+		ui.KeyValue("Period", r.Start.Local().Format(time.DateTime)+" – "+r.End.Local().Format(time.DateTime))
+		ui.KeyValue("Requests", fmt.Sprintf("%d (%d failed, %d commands)", r.Requests, r.Failures, r.Commands))
+		ui.Separator()
+		printHeat("Directories", r.Dirs)
+		ui.Separator()
+		printHeat("Paths", r.Paths)
+		return nil
+	},
+}
+
+// listSessions prints each session in the audit log with its request
+// count and last activity, most recent first.
+func listSessions(path string) error {
+	type session struct {
+		id       string
+		requests int
+		last     time.Time
+	}
+	byID := map[string]*session{}
+	err := audit.Scan(path, func(ev audit.Event) {
+		if ev.Session == "" {
+			return
+		}
+		s, ok := byID[ev.Session]
+		if !ok {
+			s = &session{id: ev.Session}
+			byID[ev.Session] = s
+		}
+		s.requests++
+		if ev.Time.After(s.last) {
+			s.last = ev.Time
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(byID) == 0 {
+		ui.Info("No agent sessions in %s", path)
+		return nil
+	}
+	list := make([]*session, 0, len(byID))
+	for _, s := range byID {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].last.After(list[j].last) })
+	for _, s := range list {
+		ui.KeyValue(s.id, fmt.Sprintf("%d requests, last %s", s.requests, s.last.Local().Format(time.DateTime)))
+	}
+	return nil
+}
+
+// printHeat prints the hottest entries of heat with a bar scaled to the
+// hottest one.
+func printHeat(title string, heat []audit.PathHeat) {
+	fmt.Println(title)
+	if len(heat) == 0 {
+		fmt.Println("  " + ui.Dim("(none)"))
+		return
+	}
+	const width = 20
+	top := heat[0].Total()
+	for i, h := range heat {
+		if i == flagReportTop {
+			fmt.Println("  " + ui.Dim(fmt.Sprintf("… %d more", len(heat)-i)))
+			break
+		}
+		n := max(1, h.Total()*width/top)
+		bar := strings.Repeat("█", n) + strings.Repeat(" ", width-n)
+		fmt.Printf("  %s %4dr %4dw %4dd  %s\n", bar, h.Reads, h.Writes, h.Deletes, h.Path)
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/ui"
+	"github.com/scienceol/xyzen/runner/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check this xyzen binary against the official release",
+	Long: `Hashes the running binary and compares it with the SHA-256 published
+in the signed release manifest for this version. A mismatch means the
+binary was modified or did not come from an official release.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := updater.VerifyBinary(version)
+		if v.Path != "" {
+			ui.KeyValue("Binary", v.Path)
+			ui.KeyValue("Version", v.Version+" ("+v.Platform+")")
+			ui.KeyValue("SHA-256", v.SHA256)
+		}
+		if err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+		ui.KeyValue("Expected", v.Expected)
+		if !v.Signed {
+			ui.Warn("This build has no release key; the manifest signature was not checked")
+		}
+		if !v.OK() {
+			ui.Error("Checksum mismatch: this binary does not match the official %s release", v.Version)
+			return fmt.Errorf("binary has been modified or is not an official release")
+		}
+		ui.Success("Binary matches the official %s release", v.Version)
+		return nil
+	},
+}
@@ -0,0 +1,137 @@
+package anomaly
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Event kinds passed to Observe.
+const (
+	KindExec   = "exec"
+	KindRead   = "read"
+	KindDelete = "delete"
+)
+
+// Event is a single agent action observed by the detector.
+type Event struct {
+	Kind    string
+	Path    string // for reads and deletes
+	Command string // for execs
+}
+
+// Finding describes detected anomalous behavior.
+type Finding struct {
+	Rule    string // "exfil_pattern", "delete_spike", "read_spread"
+	Message string
+}
+
+// Thresholds configures the detector. Zero values use the defaults.
+type Thresholds struct {
+	Window           time.Duration // sliding window (default 1m)
+	MaxDeletes       int           // deletes per window (default 20)
+	MaxReadDirs      int           // distinct directories read per window (default 50)
+	ExfilPatternsOff bool          // disable the built-in exfiltration patterns
+}
+
+// exfilPatterns match command lines that pipe remote content into a shell
+// or ship local data to a remote host.
+var exfilPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(?:curl|wget)\b[^|;&]*\|\s*(?:sudo\s+)?(?:ba|z|k|da)?sh\b`),
+	regexp.MustCompile(`\b(?:curl|wget)\b[^|;&]*\|\s*(?:python3?|perl|ruby|node)\b`),
+	regexp.MustCompile(`\bcurl\b.*(?:-d|--data(?:-binary|-raw)?|-F|--form|-T|--upload-file)\s+@`),
+	regexp.MustCompile(`\|\s*(?:nc|ncat|netcat)\s+\S+\s+\d+`),
+	regexp.MustCompile(`/dev/(?:tcp|udp)/`),
+	regexp.MustCompile(`\b(?:base64|xxd|gzip|tar)\b[^|]*\|\s*(?:curl|wget|nc|ncat)\b`),
+	regexp.MustCompile(`(?:~|\$HOME)/\.(?:ssh|aws|gnupg|kube|docker)/`),
+}
+
+// deleteCommand matches shell commands that delete files.
+var deleteCommand = regexp.MustCompile(`(?:^|[;&|]\s*|\bsudo\s+)(?:rm|rmdir|unlink|shred|del|Remove-Item|find\b.*-delete)\b`)
+
+// Detector keeps sliding-window statistics of agent actions.
+type Detector struct {
+	t Thresholds
+
+	mu      sync.Mutex
+	deletes []time.Time
+	reads   map[string]time.Time // directory → last read
+}
+
+// New creates a Detector with the given thresholds.
+func New(t Thresholds) *Detector {
+	if t.Window <= 0 {
+		t.Window = time.Minute
+	}
+	if t.MaxDeletes <= 0 {
+		t.MaxDeletes = 20
+	}
+	if t.MaxReadDirs <= 0 {
+		t.MaxReadDirs = 50
+	}
+	return &Detector{t: t, reads: make(map[string]time.Time)}
+}
+
+// Observe records an event and returns a Finding if it pushes behavior past
+// a threshold or matches a known-bad pattern.
+func (d *Detector) Observe(ev Event) *Finding {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+
+	switch ev.Kind {
+	case KindExec:
+		if !d.t.ExfilPatternsOff {
+			for _, re := range exfilPatterns {
+				if re.MatchString(ev.Command) {
+					return &Finding{Rule: "exfil_pattern", Message: fmt.Sprintf("command matches exfiltration pattern: %s", ev.Command)}
+				}
+			}
+		}
+		if deleteCommand.MatchString(ev.Command) {
+			return d.recordDelete(now)
+		}
+	case KindDelete:
+		return d.recordDelete(now)
+	case KindRead:
+		if ev.Path == "" {
+			return nil
+		}
+		dir := filepath.Dir(filepath.Clean(ev.Path))
+		d.reads[dir] = now
+		if len(d.reads) > d.t.MaxReadDirs {
+			n := len(d.reads)
+			d.reads = make(map[string]time.Time)
+			return &Finding{Rule: "read_spread", Message: fmt.Sprintf("%d distinct directories read within %s", n, d.t.Window)}
+		}
+	}
+	return nil
+}
+
+func (d *Detector) recordDelete(now time.Time) *Finding {
+	d.deletes = append(d.deletes, now)
+	if len(d.deletes) > d.t.MaxDeletes {
+		n := len(d.deletes)
+		d.deletes = nil
+		return &Finding{Rule: "delete_spike", Message: fmt.Sprintf("%d delete operations within %s", n, d.t.Window)}
+	}
+	return nil
+}
+
+// expire drops events older than the window. Must be called with d.mu held.
+func (d *Detector) expire(now time.Time) {
+	cutoff := now.Add(-d.t.Window)
+	i := 0
+	for i < len(d.deletes) && d.deletes[i].Before(cutoff) {
+		i++
+	}
+	d.deletes = d.deletes[i:]
+	for dir, t := range d.reads {
+		if t.Before(cutoff) {
+			delete(d.reads, dir)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event is a single audited request.
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target,omitempty"` // path or (redacted) command
+	// Session is the agent session the request was made on behalf of.
+	Session string `json:"session,omitempty"`
+	// Paths lists the files and directories the request operated on.
+	Paths      []string `json:"paths,omitempty"`
+	Success    bool     `json:"success"`
+	Error      string   `json:"error,omitempty"`
+	DurationMs int64    `json:"duration_ms"`
+	// Diff is the unified diff of the content a write changed, with
+	// secrets redacted; DiffTruncated is set if it was cut short.
+	Diff          string `json:"diff,omitempty"`
+	DiffTruncated bool   `json:"diff_truncated,omitempty"`
+	// DryRun marks a request that only described what it would do.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Summary aggregates events recorded since the last call to TakeSummary.
+type Summary struct {
+	Events   int            `json:"events"`
+	Failures int            `json:"failures"`
+	ByType   map[string]int `json:"by_type"`
+}
+
+// Logger appends events as JSON lines to a local file and keeps a running
+// summary for telemetry. A nil *Logger is valid and records nothing.
+//
+// Once the log would grow past its maximum size, it is rotated: the
+// current file becomes path.1, replacing any previous one, and a new file
+// is started. The log therefore takes at most twice its maximum size.
+type Logger struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	summary Summary
+}
+
+// Open opens (or creates) the audit log at path, rotated at maxSize bytes
+// (never if maxSize is 0).
+func Open(path string, maxSize int64) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create audit directory: %w", err)
+	}
+	l := &Logger{path: path, maxSize: maxSize, summary: Summary{ByType: make(map[string]int)}}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the current log to path.1 and starts a new one. l.mu must
+// be held.
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, rotatedPath(l.path)); err != nil {
+		// Keep appending rather than lose events.
+		return errors.Join(err, l.open())
+	}
+	return l.open()
+}
+
+// rotatedPath is where the previous audit log at path is kept.
+func rotatedPath(path string) string {
+	return path + ".1"
+}
+
+// Record appends an event to the log.
+func (l *Logger) Record(ev Event) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("Rotate audit log: %v", err)
+		}
+	}
+	if l.f != nil {
+		n, _ := l.f.Write(line)
+		l.size += int64(n)
+	}
+	l.summary.Events++
+	if !ev.Success {
+		l.summary.Failures++
+	}
+	l.summary.ByType[ev.Type]++
+}
+
+// TakeSummary returns the summary accumulated since the previous call and
+// resets it.
+func (l *Logger) TakeSummary() Summary {
+	if l == nil {
+		return Summary{ByType: map[string]int{}}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.summary
+	l.summary = Summary{ByType: make(map[string]int)}
+	return s
+}
+
+// Close closes the underlying file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
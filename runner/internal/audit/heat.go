@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// maxHeatPaths bounds how many paths a heat map lists.
+const maxHeatPaths = 200
+
+// Access kinds of request types, for heat maps.
+var (
+	readTypes = map[string]bool{
+		"read_file": true, "read_files": true, "read_file_bytes": true, "list_files": true,
+		"find_files": true, "search_in_files": true, "tail_file": true, "diff_files": true,
+		"diff_against_content": true, "share_file": true, "artifact_put": true, "run_track_artifact": true,
+		"archive_dir": true, "checksum": true, "manifest": true, "verify_manifest": true, "watch": true,
+		"read_symlink": true, "disk_usage": true,
+	}
+	writeTypes = map[string]bool{
+		"write_file": true, "write_file_bytes": true, "append_file": true, "apply_patch": true,
+		"copy_file": true, "move_file": true, "create_dir": true, "extract_archive": true,
+		"workspace_apply_template": true, "create_symlink": true, "undelete": true,
+	}
+	deleteTypes = map[string]bool{
+		"remove_dir": true,
+	}
+)
+
+// PathHeat counts the accesses to one path, or to everything under a
+// directory.
+type PathHeat struct {
+	Path    string    `json:"path"`
+	Reads   int       `json:"reads"`
+	Writes  int       `json:"writes"`
+	Deletes int       `json:"deletes"`
+	Last    time.Time `json:"last"`
+}
+
+// Total is the number of accesses counted.
+func (h PathHeat) Total() int {
+	return h.Reads + h.Writes + h.Deletes
+}
+
+// Report summarizes a set of events, such as those of one session.
+type Report struct {
+	Start, End time.Time
+	Requests   int
+	Failures   int
+	Commands   int
+	// Paths are the most accessed paths, hottest first; Dirs roll the
+	// same accesses up by parent directory.
+	Paths          []PathHeat
+	Dirs           []PathHeat
+	PathsTruncated bool
+}
+
+// HeatMap accumulates a Report from events.
+type HeatMap struct {
+	r     Report
+	paths map[string]*PathHeat
+	dirs  map[string]*PathHeat
+}
+
+// NewHeatMap returns an empty heat map.
+func NewHeatMap() *HeatMap {
+	return &HeatMap{paths: make(map[string]*PathHeat), dirs: make(map[string]*PathHeat)}
+}
+
+// Add counts one event. Events need not be in time order. Dry runs count
+// as requests but not as commands or accesses.
+func (m *HeatMap) Add(ev Event) {
+	if m.r.Start.IsZero() || ev.Time.Before(m.r.Start) {
+		m.r.Start = ev.Time
+	}
+	if ev.Time.After(m.r.End) {
+		m.r.End = ev.Time
+	}
+	m.r.Requests++
+	if !ev.Success {
+		m.r.Failures++
+	}
+	if ev.DryRun {
+		return
+	}
+	if ev.Type == "exec" || ev.Type == "job_start" || ev.Type == "run_script" {
+		m.r.Commands++
+	}
+	for _, p := range ev.Paths {
+		m.count(m.paths, p, ev)
+		m.count(m.dirs, path.Dir(p), ev)
+	}
+}
+
+func (m *HeatMap) count(into map[string]*PathHeat, p string, ev Event) {
+	h, ok := into[p]
+	if !ok {
+		h = &PathHeat{Path: p}
+		into[p] = h
+	}
+	switch {
+	case readTypes[ev.Type]:
+		h.Reads++
+	case writeTypes[ev.Type]:
+		h.Writes++
+	case deleteTypes[ev.Type]:
+		h.Deletes++
+	default:
+		return
+	}
+	if ev.Time.After(h.Last) {
+		h.Last = ev.Time
+	}
+}
+
+// Report returns the summary of the events added so far.
+func (m *HeatMap) Report() Report {
+	r := m.r
+	r.Paths, r.PathsTruncated = hottest(m.paths)
+	r.Dirs, _ = hottest(m.dirs)
+	return r
+}
+
+// hottest returns the accessed entries of heat, most accessed first,
+// at most maxHeatPaths.
+func hottest(heat map[string]*PathHeat) ([]PathHeat, bool) {
+	list := make([]PathHeat, 0, len(heat))
+	for _, h := range heat {
+		if h.Total() > 0 {
+			list = append(list, *h)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total() != list[j].Total() {
+			return list[i].Total() > list[j].Total()
+		}
+		return list[i].Path < list[j].Path
+	})
+	if len(list) > maxHeatPaths {
+		return list[:maxHeatPaths], true
+	}
+	return list, false
+}
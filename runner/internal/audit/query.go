@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxQueryResults bounds how many events one query returns.
+const MaxQueryResults = 1000
+
+// Query selects events from the log. Zero fields match everything.
+type Query struct {
+	Since, Until time.Time
+	Type         string
+	RequestID    string
+	Session      string
+	// Target matches events whose target is this path or lies under it.
+	Target string
+	// Limit bounds the result to the most recent matches. Default 100.
+	Limit int
+	// Diffs keeps the content diffs of write events.
+	Diffs bool
+}
+
+func (q Query) match(ev Event) bool {
+	switch {
+	case !q.Since.IsZero() && ev.Time.Before(q.Since),
+		!q.Until.IsZero() && !ev.Time.Before(q.Until),
+		q.Type != "" && ev.Type != q.Type,
+		q.RequestID != "" && ev.RequestID != q.RequestID,
+		q.Session != "" && ev.Session != q.Session:
+		return false
+	}
+	target := strings.TrimSuffix(q.Target, "/")
+	return target == "" || ev.Target == target || strings.HasPrefix(ev.Target, target+"/")
+}
+
+// Query returns the events in the log that match q, oldest first. If more
+// match than q.Limit, the most recent are returned and truncated is true.
+func (l *Logger) Query(q Query) (events []Event, truncated bool, err error) {
+	if l == nil {
+		return nil, false, errors.New("audit log is disabled")
+	}
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	q.Limit = min(q.Limit, MaxQueryResults)
+
+	err = Scan(l.path, func(ev Event) {
+		if !q.match(ev) {
+			return
+		}
+		if !q.Diffs {
+			ev.Diff, ev.DiffTruncated = "", false
+		}
+		if len(events) == q.Limit {
+			events = events[1:]
+			truncated = true
+		}
+		events = append(events, ev)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return events, truncated, nil
+}
+
+// Scan calls fn for each event in the audit log at path, oldest first,
+// starting with the log rotated out of it if there is one. Lines that
+// cannot be parsed, such as a partial last line, are skipped.
+func Scan(path string, fn func(Event)) error {
+	if err := scanFile(rotatedPath(path), fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return scanFile(path, fn)
+}
+
+func scanFile(path string, fn func(Event)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for sc.Scan() {
+		var ev Event
+		if json.Unmarshal(sc.Bytes(), &ev) == nil {
+			fn(ev)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	return nil
+}
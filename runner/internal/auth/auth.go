@@ -0,0 +1,129 @@
+// Package auth authenticates the runner's WebSocket handshake. Schemes:
+//
+//   - query:  the runner token as a ?token= query parameter (legacy; the
+//     token can end up in proxy and server access logs)
+//   - header: the runner token as an Authorization bearer header
+//   - jwt:    a short-lived JWT minted by `xyzen login`, refreshed from the
+//     runner token when one is configured
+//   - exec:   a bearer token printed by a credential helper command, e.g.
+//     a cloud IAM identity token
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Scheme names.
+const (
+	Query  = "query"
+	Header = "header"
+	JWT    = "jwt"
+	Exec   = "exec"
+)
+
+// Schemes lists the valid scheme names.
+var Schemes = []string{Query, Header, JWT, Exec}
+
+const execTimeout = 30 * time.Second
+
+// Options configures a scheme.
+type Options struct {
+	Scheme string
+	// Token is the runner token.
+	Token string
+	// URL is the WebSocket endpoint; jwt derives the token endpoint from it.
+	URL string
+	// HeaderName carries bearer credentials. Defaults to Authorization.
+	HeaderName string
+	// Command is the exec scheme's credential helper and its arguments.
+	Command []string
+	// StateDir holds cached credentials (~/.xyzen).
+	StateDir string
+}
+
+// Apply adds credentials for the handshake to u and h.
+func Apply(o Options, u *url.URL, h http.Header) error {
+	name := o.HeaderName
+	if name == "" {
+		name = "Authorization"
+	}
+	switch o.Scheme {
+	case Query, "":
+		q := u.Query()
+		q.Set("token", o.Token)
+		u.RawQuery = q.Encode()
+		return nil
+	case Header:
+		h.Set(name, "Bearer "+o.Token)
+		return nil
+	case JWT:
+		creds, err := jwtFor(o)
+		if err != nil {
+			return err
+		}
+		h.Set(name, "Bearer "+creds.AccessToken)
+		return nil
+	case Exec:
+		token, err := runHelper(o.Command)
+		if err != nil {
+			return err
+		}
+		h.Set(name, "Bearer "+token)
+		return nil
+	}
+	return fmt.Errorf("unknown auth scheme %q", o.Scheme)
+}
+
+// jwtFor returns cached credentials for o.URL, minting new ones from the
+// runner token when they are missing or about to expire.
+func jwtFor(o Options) (Credentials, error) {
+	creds, err := LoadCredentials(o.StateDir)
+	if err == nil && creds.URL == o.URL && creds.Valid() {
+		return creds, nil
+	}
+	if o.Token == "" {
+		if err == nil && creds.URL == o.URL {
+			return Credentials{}, fmt.Errorf("login expired at %s; run `xyzen login` again", creds.ExpiresAt.Local().Format(time.RFC1123))
+		}
+		return Credentials{}, fmt.Errorf("not logged in to %s; run `xyzen login`", o.URL)
+	}
+	creds, err = Mint(o.URL, o.Token)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if err := SaveCredentials(o.StateDir, creds); err != nil {
+		return Credentials{}, err
+	}
+	return creds, nil
+}
+
+// runHelper runs a credential helper and returns the token it prints.
+func runHelper(argv []string) (string, error) {
+	if len(argv) == 0 {
+		return "", fmt.Errorf("auth.command is required for the exec scheme")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("credential helper %s: %s", argv[0], msg)
+		}
+		return "", fmt.Errorf("credential helper %s: %w", argv[0], err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" || strings.ContainsAny(token, "\r\n") {
+		return "", fmt.Errorf("credential helper %s must print exactly one token", argv[0])
+	}
+	return token, nil
+}
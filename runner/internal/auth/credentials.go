@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CredentialsFile is the name of the cached login under the state dir.
+const CredentialsFile = "credentials.json"
+
+// refreshMargin is how long before expiry credentials are replaced.
+const refreshMargin = time.Minute
+
+const mintTimeout = 15 * time.Second
+
+// Credentials is a JWT minted for one server endpoint.
+type Credentials struct {
+	URL         string    `json:"url"`
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Valid reports whether the credentials are usable for at least
+// refreshMargin more.
+func (c Credentials) Valid() bool {
+	return c.AccessToken != "" && time.Until(c.ExpiresAt) > refreshMargin
+}
+
+// LoadCredentials reads the cached login.
+func LoadCredentials(stateDir string) (Credentials, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, CredentialsFile))
+	if err != nil {
+		return Credentials{}, err
+	}
+	var c Credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Credentials{}, fmt.Errorf("corrupt %s: %w", CredentialsFile, err)
+	}
+	return c, nil
+}
+
+// SaveCredentials caches a login, readable only by the user.
+func SaveCredentials(stateDir string, c Credentials) error {
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(c, "", "  ")
+	path := filepath.Join(stateDir, CredentialsFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("save credentials: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Mint exchanges a runner token for a short-lived JWT at the token
+// endpoint of the server behind wsURL.
+func Mint(wsURL, token string) (Credentials, error) {
+	endpoint, err := tokenEndpoint(wsURL)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: mintTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("mint token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Credentials{}, fmt.Errorf("mint token (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var r struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&r); err != nil {
+		return Credentials{}, fmt.Errorf("mint token: %w", err)
+	}
+	if r.AccessToken == "" || r.ExpiresIn <= 0 {
+		return Credentials{}, fmt.Errorf("mint token: server returned no token")
+	}
+	return Credentials{
+		URL:         wsURL,
+		AccessToken: r.AccessToken,
+		ExpiresAt:   time.Now().Add(time.Duration(r.ExpiresIn) * time.Second).UTC(),
+	}, nil
+}
+
+// tokenEndpoint maps the runner WebSocket URL
+// (wss://host/xyzen/ws/v1/runner) to the HTTPS token endpoint
+// (https://host/xyzen/api/v1/runners/auth/token).
+func tokenEndpoint(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	case "ws":
+		u.Scheme = "http"
+	}
+	prefix := ""
+	if i := strings.Index(u.Path, "/xyzen/"); i >= 0 {
+		prefix = u.Path[:i]
+	}
+	u.Path = prefix + "/xyzen/api/v1/runners/auth/token"
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
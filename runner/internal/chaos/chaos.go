@@ -0,0 +1,117 @@
+// Package chaos injects transport faults — latency, dropped frames and
+// forced reconnects — so that resume, replay and flow control can be
+// exercised without a flaky network. It is a developer tool enabled by
+// the hidden `xyzen connect --chaos` flag and must never be on in
+// production.
+package chaos
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures the injected faults. A nil *Options injects nothing.
+type Options struct {
+	// Latency is added to every frame, in each direction.
+	Latency time.Duration
+	// Jitter is a random extra delay of up to this much per frame.
+	Jitter time.Duration
+	// Drop is the probability (0–1) that a frame is silently discarded.
+	Drop float64
+	// Reconnect, if set, abruptly closes the connection after a random
+	// interval between half and one and a half times this long.
+	Reconnect time.Duration
+}
+
+// Defaults are used by a bare --chaos.
+var Defaults = Options{
+	Latency:   100 * time.Millisecond,
+	Jitter:    200 * time.Millisecond,
+	Drop:      0.01,
+	Reconnect: 2 * time.Minute,
+}
+
+// Parse reads a spec such as "latency=200ms,jitter=50ms,drop=0.05,reconnect=30s".
+// Keys left out take their value from Defaults; "on" (or "") means all
+// defaults and "off" disables chaos, returning nil.
+func Parse(spec string) (*Options, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "off" {
+		return nil, nil
+	}
+	o := Defaults
+	if spec == "" || spec == "on" {
+		return &o, nil
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("chaos: %q is not key=value", kv)
+		}
+		var err error
+		switch key {
+		case "latency":
+			o.Latency, err = time.ParseDuration(val)
+		case "jitter":
+			o.Jitter, err = time.ParseDuration(val)
+		case "reconnect":
+			o.Reconnect, err = time.ParseDuration(val)
+		case "drop":
+			o.Drop, err = strconv.ParseFloat(val, 64)
+			if err == nil && (o.Drop < 0 || o.Drop > 1) {
+				err = fmt.Errorf("must be between 0 and 1")
+			}
+		default:
+			return nil, fmt.Errorf("chaos: unknown key %q (want latency, jitter, drop or reconnect)", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chaos: %s: %w", key, err)
+		}
+		if o.Latency < 0 || o.Jitter < 0 || o.Reconnect < 0 {
+			return nil, fmt.Errorf("chaos: %s must not be negative", key)
+		}
+	}
+	return &o, nil
+}
+
+// Delay sleeps for the configured latency plus jitter.
+func (o *Options) Delay() {
+	if o == nil {
+		return
+	}
+	d := o.Latency
+	if o.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(o.Jitter)))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// DropFrame reports whether the next frame should be discarded.
+func (o *Options) DropFrame() bool {
+	return o != nil && o.Drop > 0 && rand.Float64() < o.Drop
+}
+
+// ReconnectAfter returns how long the next connection should live before
+// being cut, or 0 for no forced reconnect.
+func (o *Options) ReconnectAfter() time.Duration {
+	if o == nil || o.Reconnect <= 0 {
+		return 0
+	}
+	return o.Reconnect/2 + time.Duration(rand.Int63n(int64(o.Reconnect)))
+}
+
+func (o *Options) String() string {
+	if o == nil {
+		return "off"
+	}
+	s := fmt.Sprintf("latency=%s,jitter=%s,drop=%g", o.Latency, o.Jitter, o.Drop)
+	if o.Reconnect > 0 {
+		s += ",reconnect=" + o.Reconnect.String()
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Activity kinds reported in "activity" events.
+const (
+	activityFileCreated  = "file_created"
+	activityFileModified = "file_modified"
+	activityFileDeleted  = "file_deleted"
+	activityFileMoved    = "file_moved"
+	activityFileCopied   = "file_copied"
+	activityDirCreated   = "dir_created"
+	activityDirRemoved   = "dir_removed"
+	activityExtracted    = "archive_extracted"
+	activityCommandRun   = "command_run"
+	activityJobFinished  = "job_finished"
+)
+
+// activityTarget is the part of a request payload the activity feed
+// looks at.
+type activityTarget struct {
+	Path        string            `json:"path"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination"`
+	Command     string            `json:"command"`
+	Interpreter string            `json:"interpreter"`
+	Args        []string          `json:"args"`
+	Env         map[string]string `json:"env"`
+}
+
+// beginActivity records what an activity event for req will need to know
+// about the workspace before the request runs: whether the target file
+// already existed, to tell creations from modifications.
+func (c *Client) beginActivity(req protocol.Request) (t activityTarget, existed bool) {
+	if !c.cfg.Activity.IsEnabled() {
+		return t, false
+	}
+	_ = json.Unmarshal(req.Payload, &t)
+	switch req.Type {
+	case "write_file", "write_file_bytes", "append_file", "transfer_commit", "create_symlink":
+		existed = c.exec.Exists(t.Path)
+	}
+	return t, existed
+}
+
+// emitActivity sends "activity" events describing the workspace changes
+// or commands a handled request caused. Failed requests changed nothing
+// and are not reported, except commands, which ran either way.
+func (c *Client) emitActivity(req protocol.Request, resp protocol.Response, t activityTarget, existed bool, d time.Duration) {
+	if !c.cfg.Activity.IsEnabled() {
+		return
+	}
+	ev := protocol.ActivityPayload{RequestID: req.ID, DurationMs: d.Milliseconds()}
+	switch req.Type {
+	case "exec", "run_script":
+		r, ok := resp.Payload.(protocol.ExecResultPayload)
+		if !ok {
+			return
+		}
+		command := t.Command
+		if req.Type == "run_script" {
+			command = scriptTarget(t.Interpreter, t.Args)
+		}
+		exit := r.ExitCode
+		ev.Kind, ev.Command, ev.ExitCode = activityCommandRun, c.redactCommand(command, t.Env), &exit
+		c.sendActivity(ev)
+		return
+	}
+	if !resp.Success {
+		return
+	}
+	switch req.Type {
+	case "write_file", "write_file_bytes", "append_file", "transfer_commit":
+		if t.Path == "" {
+			return // upload kept in the transfer store
+		}
+		ev.Kind, ev.Path = activityFileModified, t.Path
+		if !existed {
+			ev.Kind = activityFileCreated
+		}
+	case "move_file":
+		ev.Kind, ev.Path, ev.From = activityFileMoved, t.Destination, t.Source
+	case "copy_file":
+		ev.Kind, ev.Path, ev.From = activityFileCopied, t.Destination, t.Source
+	case "create_symlink":
+		ev.Kind, ev.Path = activityFileCreated, t.Path
+		if existed {
+			ev.Kind = activityFileModified
+		}
+	case "create_dir":
+		ev.Kind, ev.Path = activityDirCreated, t.Path
+	case "remove_dir":
+		ev.Kind, ev.Path = activityDirRemoved, t.Path
+	case "undelete":
+		r, ok := resp.Payload.(protocol.UndeleteResult)
+		if !ok {
+			return
+		}
+		ev.Kind, ev.Path = activityFileCreated, r.Path
+	case "extract_archive":
+		ev.Kind, ev.Path, ev.From = activityExtracted, t.Destination, t.Source
+	case "apply_patch":
+		r, ok := resp.Payload.(protocol.ApplyPatchResult)
+		if !ok || !r.Applied || r.DryRun {
+			return
+		}
+		for _, f := range r.Files {
+			fe := ev
+			fe.Path = f.Path
+			switch f.Action {
+			case "create":
+				fe.Kind = activityFileCreated
+			case "delete":
+				fe.Kind = activityFileDeleted
+			case "rename":
+				fe.Kind, fe.From = activityFileMoved, f.OldPath
+			default:
+				fe.Kind = activityFileModified
+			}
+			c.sendActivity(fe)
+		}
+		return
+	default:
+		return
+	}
+	c.sendActivity(ev)
+}
+
+func (c *Client) sendActivity(ev protocol.ActivityPayload) {
+	ev.Time = time.Now().UTC().Format(time.RFC3339)
+	c.send(map[string]interface{}{"type": "activity", "payload": ev})
+}
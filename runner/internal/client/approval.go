@@ -0,0 +1,151 @@
+package client
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/anomaly"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// approvalGate holds the runner in approval mode after an anomaly until the
+// user approves resuming from the Xyzen UI ("approval_resume").
+type approvalGate struct {
+	mu     sync.Mutex
+	active bool
+	reason string
+}
+
+func (g *approvalGate) enter(reason string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active {
+		return false
+	}
+	g.active, g.reason = true, reason
+	return true
+}
+
+func (g *approvalGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	was := g.active
+	g.active, g.reason = false, ""
+	return was
+}
+
+func (g *approvalGate) isActive() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
+
+// exportTypes are the requests, besides mutating ones, refused in
+// approval mode: they open channels off the machine or read out file
+// contents in bulk, which is what an exfiltration finding must stop.
+var exportTypes = map[string]bool{
+	"tunnel_open":         true,
+	"display_open":        true,
+	"read_file":           true,
+	"read_files":          true,
+	"read_file_bytes":     true,
+	"tail_file":           true,
+	"search_in_files":     true,
+	"archive_dir":         true,
+	"transfer_read":       true,
+	"read_exec_output":    true,
+	"crash_fetch":         true,
+	"pty_recording_fetch": true,
+}
+
+// blocks reports whether a request of the given type must be refused, and why.
+func (g *approvalGate) blocks(reqType string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.active || !isMutating(reqType) && !exportTypes[reqType] {
+		return "", false
+	}
+	return g.reason, true
+}
+
+// observe feeds a request to the anomaly detector (if enabled) and enters
+// approval mode on a finding.
+func (c *Client) observe(req protocol.Request) {
+	if c.detector == nil {
+		return
+	}
+	var p struct {
+		Command string   `json:"command"`
+		Content string   `json:"content"`
+		Path    string   `json:"path"`
+		Root    string   `json:"root"`
+		Paths   []string `json:"paths"`
+		Old     string   `json:"old"`
+		New     string   `json:"new"`
+		// Destination and Overwrite are move_file's and copy_file's.
+		Destination string `json:"destination"`
+		Overwrite   bool   `json:"overwrite"`
+	}
+	_ = json.Unmarshal(req.Payload, &p)
+
+	var evs []anomaly.Event
+	switch req.Type {
+	case "exec", "job_start":
+		evs = []anomaly.Event{{Kind: anomaly.KindExec, Command: p.Command}}
+	case "run_script":
+		evs = []anomaly.Event{{Kind: anomaly.KindExec, Command: p.Content}}
+	case "read_file", "read_file_bytes", "list_files", "share_file", "artifact_put", "run_track_artifact", "tail_file", "diff_against_content", "archive_dir", "read_symlink", "disk_usage":
+		evs = []anomaly.Event{{Kind: anomaly.KindRead, Path: p.Path}}
+	case "diff_files":
+		evs = []anomaly.Event{{Kind: anomaly.KindRead, Path: p.Old}, {Kind: anomaly.KindRead, Path: p.New}}
+	case "read_files", "watch":
+		for _, path := range p.Paths {
+			evs = append(evs, anomaly.Event{Kind: anomaly.KindRead, Path: path})
+		}
+	case "find_files", "search_in_files":
+		evs = []anomaly.Event{{Kind: anomaly.KindRead, Path: p.Root}}
+	case "remove_dir":
+		evs = []anomaly.Event{{Kind: anomaly.KindDelete, Path: p.Path}}
+	case "move_file", "copy_file":
+		// A replaced destination is deleted (to the trash) as surely as
+		// a removed one.
+		if !p.Overwrite || !c.exec.Exists(p.Destination) {
+			return
+		}
+		evs = []anomaly.Event{{Kind: anomaly.KindDelete, Path: p.Destination}}
+	default:
+		return
+	}
+
+	var finding *anomaly.Finding
+	for _, ev := range evs {
+		if finding = c.detector.Observe(ev); finding != nil {
+			break
+		}
+	}
+	if finding == nil || !c.approval.enter(finding.Message) {
+		return
+	}
+	ui.Warn("Anomalous agent activity (%s): %s", finding.Rule, finding.Message)
+	ui.Warn("Side-effecting and bulk read requests are paused, and tunnels closed, until you approve resuming in Xyzen.")
+	c.tunnels.CloseAll()
+	c.send(map[string]interface{}{
+		"type": "security_alert",
+		"payload": protocol.SecurityAlertPayload{
+			Kind:     "anomaly",
+			Severity: "high",
+			Message:  "Runner entered approval mode: " + finding.Message,
+			Detail:   finding.Rule,
+			Time:     time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+func (c *Client) handleApprovalResume(req protocol.Request) protocol.Response {
+	if c.approval.resume() {
+		ui.Info("Approval granted — resuming normal operation")
+	}
+	return protocol.Response{ID: req.ID, Type: "approval_resume_result", Success: true, Payload: struct{}{}}
+}
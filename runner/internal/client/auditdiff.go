@@ -0,0 +1,131 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/audit"
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// maxAuditFile is the largest file whose content changes are diffed.
+	maxAuditFile = 1 << 20
+	// maxAuditDiff bounds the diff stored with one audit event.
+	maxAuditDiff = 64 << 10
+)
+
+// writeSnapshot is a file's content before a write, kept to diff against
+// afterwards.
+type writeSnapshot struct {
+	path   string
+	before []byte
+	err    error // why the content could not be read
+}
+
+// snapshotWrite captures the content of the file a write_file,
+// write_file_bytes or append_file request will change. It returns nil for
+// other requests, dry runs and when there is no audit log.
+func (c *Client) snapshotWrite(req protocol.Request) *writeSnapshot {
+	if c.audit == nil || c.dryRun(req) {
+		return nil
+	}
+	switch req.Type {
+	case "write_file", "write_file_bytes", "append_file":
+	default:
+		return nil
+	}
+	var p struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(req.Payload, &p) != nil || p.Path == "" {
+		return nil
+	}
+	before, err := c.exec.Contents(p.Path, maxAuditFile)
+	return &writeSnapshot{path: p.Path, before: before, err: err}
+}
+
+// auditDiff fills in the diff of what a successful write changed.
+// apply_patch requests record the patch they applied.
+func (c *Client) auditDiff(ev *audit.Event, req protocol.Request, snap *writeSnapshot) {
+	if !ev.Success {
+		return
+	}
+	var text string
+	switch {
+	case req.Type == "apply_patch":
+		var p protocol.ApplyPatchPayload
+		if json.Unmarshal(req.Payload, &p) != nil || p.DryRun {
+			return
+		}
+		text = p.Patch
+	case snap == nil:
+		return
+	case snap.err != nil:
+		text = fmt.Sprintf("(not diffed: %v)\n", snap.err)
+	default:
+		after, err := c.exec.Contents(snap.path, maxAuditFile)
+		switch {
+		case err != nil:
+			text = fmt.Sprintf("(not diffed: %v)\n", err)
+		case diff.IsBinary(snap.before) || diff.IsBinary(after):
+			text = fmt.Sprintf("Binary files a/%s and b/%s differ\n", snap.path, snap.path)
+		default:
+			oldName := "a/" + snap.path
+			if snap.before == nil {
+				oldName = diff.DevNull
+			}
+			text = diff.Unified(oldName, "b/"+snap.path, string(snap.before), string(after), 3)
+		}
+	}
+	text = string(c.auditRedactor.Redact([]byte(text)))
+	if len(text) > maxAuditDiff {
+		text = text[:maxAuditDiff]
+		text, ev.DiffTruncated = text[:strings.LastIndexByte(text, '\n')+1], true
+	}
+	ev.Diff = text
+}
+
+func (c *Client) handleAuditQuery(req protocol.Request) protocol.Response {
+	var p protocol.AuditQueryPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "audit_query_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	q := audit.Query{Type: p.Type, RequestID: p.RequestID, Target: p.Path, Limit: p.Limit, Diffs: p.IncludeDiffs}
+	for _, t := range []struct {
+		name string
+		in   string
+		out  *time.Time
+	}{{"since", p.Since, &q.Since}, {"until", p.Until, &q.Until}} {
+		if t.in == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.in)
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "audit_query_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("invalid %s: %v", t.name, err)}}
+		}
+		*t.out = parsed
+	}
+	events, truncated, err := c.audit.Query(q)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "audit_query_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result := protocol.AuditQueryResult{Events: make([]protocol.AuditEvent, len(events)), Truncated: truncated}
+	for i, ev := range events {
+		result.Events[i] = protocol.AuditEvent{
+			Time:          ev.Time.Format(time.RFC3339Nano),
+			RequestID:     ev.RequestID,
+			Type:          ev.Type,
+			Target:        ev.Target,
+			Success:       ev.Success,
+			Error:         ev.Error,
+			DurationMs:    ev.DurationMs,
+			Diff:          ev.Diff,
+			DiffTruncated: ev.DiffTruncated,
+		}
+	}
+	return protocol.Response{ID: req.ID, Type: "audit_query_result", Success: true, Payload: result}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// budgetExempt lists request types a session may still make after
+// exceeding its budget, so that it can be inspected and wound down.
+var budgetExempt = map[string]bool{
+	"session_start":       true,
+	"session_end":         true,
+	"session_summary":     true,
+	"limits_info":         true,
+	"describe":            true,
+	"status":              true,
+	"sensors_read":        true,
+	"hw_info":             true,
+	"approval_resume":     true,
+	"tail_cancel":         true,
+	"job_status":          true,
+	"job_list":            true,
+	"job_kill":            true,
+	"process_list":        true,
+	"read_exec_output":    true,
+	"unwatch":             true,
+	"pty_close":           true,
+	"pty_signal":          true,
+	"pty_detach":          true,
+	"pty_snapshot":        true,
+	"pty_recording_list":  true,
+	"pty_recording_fetch": true,
+	"tunnel_close":        true,
+}
+
+// budgets tracks what each agent session has used against the per-session
+// limits in the config.
+type budgets struct {
+	maxRequests int
+	maxExec     time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*budgetUsage
+}
+
+type budgetUsage struct {
+	requests int
+	exec     time.Duration
+	exceeded bool
+}
+
+func newBudgets(maxRequests int, maxExec time.Duration) *budgets {
+	return &budgets{maxRequests: maxRequests, maxExec: maxExec, sessions: make(map[string]*budgetUsage)}
+}
+
+// charge counts a request against its session's budget. It returns an
+// error if the session has used up its budget; first is true the first
+// time that happens.
+func (b *budgets) charge(session, reqType string) (first bool, err error) {
+	if session == "" || budgetExempt[reqType] {
+		return false, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u := b.usage(session)
+	reason := ""
+	switch {
+	case b.maxRequests > 0 && u.requests >= b.maxRequests:
+		reason = fmt.Sprintf("request limit of %d reached", b.maxRequests)
+	case b.maxExec > 0 && u.exec >= b.maxExec:
+		reason = fmt.Sprintf("command time limit of %s reached", b.maxExec)
+	default:
+		u.requests++
+		return false, nil
+	}
+	first = !u.exceeded
+	u.exceeded = true
+	return first, fmt.Errorf("session %s exceeded its budget: %s", session, reason)
+}
+
+// addExec adds the run time of a command to its session's usage.
+func (b *budgets) addExec(session string, d time.Duration) {
+	if session == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usage(session).exec += d
+}
+
+// usage returns a session's usage, creating it if needed. b.mu is held.
+func (b *budgets) usage(session string) *budgetUsage {
+	u, ok := b.sessions[session]
+	if !ok {
+		u = &budgetUsage{}
+		b.sessions[session] = u
+	}
+	return u
+}
+
+// reset starts a session's usage afresh.
+func (b *budgets) reset(session string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, session)
+}
+
+// report returns a session's usage and limits.
+func (b *budgets) report(session string) protocol.SessionBudget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := protocol.SessionBudget{MaxRequests: b.maxRequests, MaxExecSeconds: b.maxExec.Seconds()}
+	if u, ok := b.sessions[session]; ok {
+		r.Requests, r.ExecSeconds = u.requests, u.exec.Seconds()
+	}
+	return r
+}
+
+// checkBudget charges req to its session and, if the session is over
+// budget, returns the error response to send instead of handling it.
+func (c *Client) checkBudget(req protocol.Request) (protocol.Response, bool) {
+	first, err := c.budgets.charge(req.Session, req.Type)
+	if err == nil {
+		return protocol.Response{}, false
+	}
+	if first {
+		ui.Warn("Agent %s", err)
+		ui.Warn("Its requests are refused until the session is started again.")
+		c.send(map[string]interface{}{
+			"type": "session_budget_exceeded",
+			"payload": protocol.BudgetExceededPayload{
+				SessionID: req.Session,
+				Reason:    err.Error(),
+				Budget:    c.budgets.report(req.Session),
+			},
+		})
+	}
+	return protocol.Response{
+		ID:      req.ID,
+		Type:    req.Type + "_result",
+		Payload: protocol.ErrorPayload{Error: err.Error(), Code: "budget_exceeded"},
+	}, true
+}
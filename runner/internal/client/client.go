@@ -1,21 +1,42 @@
 package client
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/scienceol/xyzen/runner/internal/anomaly"
+	"github.com/scienceol/xyzen/runner/internal/audit"
+	"github.com/scienceol/xyzen/runner/internal/auth"
+	"github.com/scienceol/xyzen/runner/internal/canary"
 	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/display"
+	"github.com/scienceol/xyzen/runner/internal/e2e"
 	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/identity"
+	"github.com/scienceol/xyzen/runner/internal/index"
+	"github.com/scienceol/xyzen/runner/internal/policy"
 	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/redact"
+	"github.com/scienceol/xyzen/runner/internal/state"
+	"github.com/scienceol/xyzen/runner/internal/telemetry"
+	"github.com/scienceol/xyzen/runner/internal/transfer"
+	"github.com/scienceol/xyzen/runner/internal/tunnel"
 	"github.com/scienceol/xyzen/runner/internal/ui"
 )
 
@@ -24,40 +45,239 @@ import (
 // The client should NOT auto-reconnect in this case.
 var errReplaced = errors.New("replaced by new runner connection")
 
-const (
-	pingInterval   = 20 * time.Second
-	writeTimeout   = 10 * time.Second
-	writeChanSize  = 256
-)
-
 // Client manages the WebSocket connection to the Xyzen backend.
 type Client struct {
-	cfg    *config.Config
-	exec   *executor.Executor
-	ptyMgr *executor.PTYManager
+	cfg     *config.Config
+	exec    *executor.Executor
+	ptyMgr  *executor.PTYManager
+	tunnels *tunnel.Manager
+	// display is nil unless display forwarding is enabled.
+	display *display.Display
+	// ptyRedactor scrubs secrets from PTY output; nil when disabled.
+	// ptyStreams holds each session's redaction stream.
+	ptyRedactor  *redact.Redactor
+	ptyStreamsMu sync.Mutex
+	ptyStreams   map[string]*ptyStream
+	pool         *workerPool
+	detector     *anomaly.Detector
+	approval     approvalGate
+	// policy decides which commands may run; approvals holds the user's
+	// approvals of commands it refused.
+	policy    *policy.Policy
+	approvals policyApprovals
+	dedup     *dedupCache
+	metrics   *telemetry.Metrics
+	audit     *audit.Logger
+	// auditRedactor scrubs secrets from commands before they are audited.
+	auditRedactor *redact.Redactor
+	identity      *identity.Identity
+	gc            *gc.Collector
+	// state records long-lived resources; recovered is what recoverState
+	// found from a previous run, reported in every info message.
+	state     *state.Store
+	recovered []protocol.RecoveredItem
+	// transfers holds spilled responses; nil if the store is unavailable.
+	transfers *transfer.Store
+	tails     streamSet
+	watches   streamSet
+	// retries maps in-flight request IDs to their retry counters.
+	retries sync.Map
+	// workspaces maps temp workspace IDs to their directories.
+	workspaces sync.Map
+	budgets    *budgets
+	// presigns maps pending storage_presign IDs to their answer channels.
+	presigns sync.Map
+	// runs maps the IDs of open tracked runs to their *trackedRun.
+	runs sync.Map
+	// sensors is the latest sensor reading for heartbeats; nil until the
+	// first one, or if heartbeats carry none.
+	sensors atomic.Pointer[protocol.SensorsResult]
+	// hw is the hardware the info message reports, detected once.
+	hw     protocol.HWInfo
+	hwOnce sync.Once
+	// e2e is nil when end-to-end encryption is off.
+	e2e *e2e.Manager
+
+	notifyMu       sync.Mutex
+	notifyHandlers map[string][]NotifyHandler
 
 	mu          sync.Mutex
-	writeCh     chan interface{}
+	writeQs     *writeQueues
 	reconnector *Reconnector
 
 	stopCh chan struct{}
 	once   sync.Once
 }
 
-// New creates a new Client.
-func New(cfg *config.Config) *Client {
+// New creates a new Client. It fails if the command policy is invalid,
+// rather than run without it.
+func New(cfg *config.Config) (*Client, error) {
+	pol, err := newPolicy(cfg.Exec.Policy)
+	if err != nil {
+		return nil, err
+	}
+	exec := executor.New(cfg.WorkDir)
 	c := &Client{
 		cfg:         cfg,
-		exec:        executor.New(cfg.WorkDir),
-		ptyMgr:      executor.NewPTYManager(cfg.WorkDir),
+		policy:      pol,
+		exec:        exec,
+		ptyMgr:      executor.NewPTYManager(exec),
+		tunnels:     tunnel.NewManager(cfg.Tunnel.Allow),
 		reconnector: NewReconnector(),
 		stopCh:      make(chan struct{}),
+		dedup:       newDedupCache(cfg.Workers.DedupSize, cfg.Workers.DedupMaxBytes),
+		metrics:     telemetry.NewMetrics(),
+		tails:       streamSet{kind: "tail follower", max: 32},
+		watches:     streamSet{kind: "watch", max: 16},
+		budgets:     newBudgets(cfg.Sessions.MaxRequests, cfg.Sessions.MaxExecTime),
 	}
 
+	c.exec.Profiles, c.exec.ClassRules = execProfiles(cfg.Exec)
+	c.exec.GitHooks = gitHooksPolicy(cfg.Exec.GitHooks, cfg.WorkDir)
+	c.exec.Ripgrep = ripgrepPath(cfg.Search)
+	c.exec.WalkWorkers = cfg.Search.Workers
+	c.exec.Limits = executor.SearchLimits{
+		MaxFindResults:   cfg.Search.MaxFindResults,
+		MaxSearchResults: cfg.Search.MaxSearchResults,
+		MaxFileSize:      cfg.Search.MaxFileSize,
+	}
+	if cfg.Search.Index {
+		c.exec.Index = index.New()
+		go c.indexLoop()
+	}
+	c.exec.Quotas = &executor.Quotas{}
+	c.exec.CrashDir = gc.Dir(config.StateDir(), gc.Crashes)
+	// Snapshot copies left behind by a crash are collected with the temp
+	// workspaces.
+	c.exec.SnapshotDir = gc.Dir(config.StateDir(), gc.Workspaces)
+	c.exec.SnapshotMaxSize = cfg.Exec.SnapshotMaxSize
+	c.exec.ContainerRuntime = cfg.Exec.ContainerRuntime
+	c.exec.ResourceLimits = protocol.ResourceLimits{
+		CPUs:         cfg.Exec.Limits.CPUs,
+		MemoryBytes:  cfg.Exec.Limits.Memory,
+		MaxProcesses: cfg.Exec.Limits.MaxProcesses,
+	}
+	c.exec.Priority = cfg.Exec.Priority
+	c.exec.TrashDir = gc.TrashDir(cfg.WorkDir)
+	c.exec.Jobs = executor.NewJobManager(gc.Dir(config.StateDir(), gc.Jobs))
+	c.exec.Jobs.ExitFunc = c.onJobExit
+	c.exec.OutputDir = gc.Dir(config.StateDir(), gc.ExecOutput)
+	if cfg.Sensors.InHeartbeat() {
+		go c.sensorsLoop()
+	}
+	// Detection runs commands; start it now so the first info message
+	// need not wait long for it.
+	go c.hardware()
+	if cfg.Canary.Enabled {
+		t := canary.New(cfg.Canary.Dir, cfg.Canary.Paths)
+		if err := t.Plant(); err != nil {
+			ui.Warn("Failed to plant canary files: %v", err)
+		}
+		t.AlertFunc = c.sendCanaryAlert
+		c.exec.Tripwire = t
+	}
+	if cfg.Anomaly.Enabled {
+		c.detector = anomaly.New(anomaly.Thresholds{
+			Window:      cfg.Anomaly.Window,
+			MaxDeletes:  cfg.Anomaly.MaxDeletes,
+			MaxReadDirs: cfg.Anomaly.MaxReadDirs,
+		})
+	}
+	c.pool = newWorkerPool(cfg.Workers.Size, cfg.Workers.QueueSize, c.stopCh)
+	c.gc = &gc.Collector{
+		Areas: cfg.GCAreas(),
+		Keep: func(area, path string) bool {
+			switch area {
+			case gc.Jobs:
+				return c.exec.Jobs.Keep(path)
+			case gc.Workspaces:
+				return c.workspaceInUse(path)
+			}
+			return false
+		},
+	}
+	if cfg.GC.Interval > 0 {
+		go c.gc.Loop(cfg.GC.Interval, c.stopCh)
+	}
+
+	if cfg.Display.Enabled {
+		c.display = display.New(display.Options{
+			Mode:        cfg.Display.Mode,
+			Address:     cfg.Display.Address,
+			XpraDisplay: cfg.Display.XpraDisplay,
+			XpraPort:    cfg.Display.XpraPort,
+		})
+	}
+	if cfg.E2E.Mode != "off" {
+		c.e2e = e2e.NewManager()
+	}
+
+	c.registerNotifyHandlers()
+
+	c.tunnels.OutputFunc = c.sendTunnelData
+	c.tunnels.ClosedFunc = c.sendTunnelClosed
+
 	c.ptyMgr.OutputFunc = c.sendPTYOutput
-	c.ptyMgr.ExitFunc = c.sendPTYExit
+	c.ptyMgr.ExitFunc = c.onPTYExit
+	c.ptyMgr.ResizePolicy = executor.ResizePolicy{
+		Mode:     cfg.PTY.ResizeMode,
+		Debounce: cfg.PTY.ResizeDebounce,
+	}
+	c.ptyMgr.Record = cfg.PTY.Record
+	c.ptyMgr.IdleTimeout = cfg.PTY.IdleTimeout
+	c.ptyMgr.MaxSessions = cfg.PTY.MaxSessions
+	c.ptyMgr.OnLimit = cfg.PTY.OnLimit
+	c.ptyMgr.Backend = cfg.PTY.Backend
+
+	if id, err := identity.LoadOrCreate(config.StateDir()); err != nil {
+		ui.Warn("Runner identity unavailable: %v", err)
+	} else {
+		c.identity = id
+	}
+
+	if st, err := state.Open(filepath.Join(config.StateDir(), "state")); err != nil {
+		ui.Warn("State store unavailable, crash recovery disabled: %v", err)
+	} else {
+		c.state = st
+		c.recovered = c.recoverState()
+		if err := c.state.Put(state.Record{Kind: state.KindRunner, ID: strconv.Itoa(os.Getpid())}); err != nil {
+			log.Printf("Record runner state: %v", err)
+		}
+	}
 
-	return c
+	if ts, err := transfer.Open(gc.Dir(config.StateDir(), gc.Transfers)); err != nil {
+		ui.Warn("Transfer store unavailable, large responses will fail: %v", err)
+	} else {
+		ts.MaxParallel = cfg.Transport.MaxParallelChunks
+		c.transfers = ts
+	}
+
+	if cfg.Audit.IsEnabled() {
+		l, err := audit.Open(cfg.Audit.Path, cfg.Audit.MaxSize)
+		if err != nil {
+			ui.Warn("Audit log disabled: %v", err)
+		} else {
+			c.audit = l
+		}
+	}
+	// Patterns were validated by config.Load.
+	c.auditRedactor, _ = redact.New(cfg.Redact.Patterns)
+	c.auditRedactor.AddLiteral(cfg.Token)
+
+	if cfg.Redact.RedactPTYOutput() {
+		// Patterns were validated by config.Load.
+		r, err := redact.New(cfg.Redact.Patterns)
+		if err != nil {
+			log.Printf("PTY redaction disabled: %v", err)
+		} else {
+			r.AddLiteral(cfg.Token)
+			c.ptyRedactor = r
+			c.ptyStreams = make(map[string]*ptyStream)
+			c.ptyMgr.Redact = r.Redact
+		}
+	}
+
+	return c, nil
 }
 
 // Stop signals the client to shut down gracefully.
@@ -65,40 +285,75 @@ func (c *Client) Stop() {
 	c.once.Do(func() {
 		close(c.stopCh)
 		c.ptyMgr.CloseAll()
+		c.tunnels.CloseAll()
+		c.tails.cancelAll()
+		c.watches.cancelAll()
+		c.removeTempWorkspaces()
+		c.endSessions()
+		c.endTrackedRuns()
+		if c.display != nil {
+			c.display.Stop()
+		}
+		_ = c.audit.Close()
+		_ = c.state.Remove(state.KindRunner, strconv.Itoa(os.Getpid()))
 	})
 }
 
-// send enqueues a message for the write goroutine. Non-blocking — drops
-// the message if the buffer is full or no connection is active.
+// send enqueues a message for the write goroutine on the lane matching its
+// type. Non-blocking — drops the message if the lane's buffer is full or no
+// connection is active.
 func (c *Client) send(v interface{}) {
 	c.mu.Lock()
-	ch := c.writeCh
+	qs := c.writeQs
 	c.mu.Unlock()
-	if ch == nil {
+	if qs == nil {
 		return
 	}
 	select {
-	case ch <- v:
+	case qs[laneOf(v)] <- v:
 	default:
 		// Buffer full — drop to avoid blocking PTY/heartbeat goroutines.
 	}
 }
 
-// writeLoop is the single goroutine that writes to the WebSocket.
-func (c *Client) writeLoop(conn *websocket.Conn, ch <-chan interface{}, done <-chan struct{}) {
+// sendWait is like send but waits (up to the write timeout) for room in
+// the lane instead of dropping. Used for byte streams, where a dropped
+// frame would corrupt the data. Returns false if the message was dropped.
+func (c *Client) sendWait(v interface{}) bool {
+	c.mu.Lock()
+	qs := c.writeQs
+	c.mu.Unlock()
+	if qs == nil {
+		return false
+	}
+	timer := time.NewTimer(c.cfg.Transport.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case qs[laneOf(v)] <- v:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.stopCh:
+		return false
+	}
+}
+
+// writeLoop is the single goroutine that writes to the WebSocket,
+// highest-priority lane first.
+func (c *Client) writeLoop(conn *websocket.Conn, qs *writeQueues, done <-chan struct{}) {
 	for {
-		select {
-		case <-done:
+		msg, ok := qs.next(done)
+		if !ok {
+			return
+		}
+		c.cfg.Chaos.Delay()
+		if c.cfg.Chaos.DropFrame() {
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(c.cfg.Transport.WriteTimeout))
+		if err := conn.WriteJSON(c.seal(msg)); err != nil {
+			log.Printf("write error: %v", err)
 			return
-		case msg, ok := <-ch:
-			if !ok {
-				return
-			}
-			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-			if err := conn.WriteJSON(msg); err != nil {
-				log.Printf("write error: %v", err)
-				return
-			}
 		}
 	}
 }
@@ -140,11 +395,25 @@ func (c *Client) connectAndServe() error {
 		return fmt.Errorf("invalid URL: %w", err)
 	}
 
-	q := u.Query()
-	q.Set("token", c.cfg.Token)
-	u.RawQuery = q.Encode()
+	header := c.handshakeHeader()
+	if err := auth.Apply(auth.Options{
+		Scheme:     c.cfg.Auth.Scheme,
+		Token:      c.cfg.Token,
+		URL:        c.cfg.URL,
+		HeaderName: c.cfg.Auth.Header,
+		Command:    c.cfg.Auth.Command,
+		StateDir:   config.StateDir(),
+	}, u, header); err != nil {
+		return fmt.Errorf("authenticate: %w", err)
+	}
 
-	conn, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.cfg.Transport.HandshakeTimeout,
+		ReadBufferSize:   c.cfg.Transport.ReadBufferSize,
+		WriteBufferSize:  c.cfg.Transport.WriteBufferSize,
+	}
+	conn, resp, err := dialer.Dial(u.String(), header)
 	if err != nil {
 		// When the server rejects the WebSocket upgrade (e.g. bad token),
 		// it returns an HTTP error. Read the status to give users a
@@ -160,15 +429,15 @@ func (c *Client) connectAndServe() error {
 		return fmt.Errorf("dial failed: %w", err)
 	}
 
-	// Set up per-connection write channel + writer goroutine
-	writeCh := make(chan interface{}, writeChanSize)
+	// Set up per-connection write queues + writer goroutine
+	writeQs := newWriteQueues(c.cfg.Transport.SendQueueSize)
 	writeDone := make(chan struct{})
 
 	c.mu.Lock()
-	c.writeCh = writeCh
+	c.writeQs = writeQs
 	c.mu.Unlock()
 
-	go c.writeLoop(conn, writeCh, writeDone)
+	go c.writeLoop(conn, writeQs, writeDone)
 
 	defer func() {
 		close(writeDone)
@@ -180,7 +449,7 @@ func (c *Client) connectAndServe() error {
 		)
 		conn.Close()
 		c.mu.Lock()
-		c.writeCh = nil
+		c.writeQs = nil
 		c.mu.Unlock()
 	}()
 
@@ -205,15 +474,24 @@ func (c *Client) connectAndServe() error {
 	c.send(protocol.Response{
 		Type: "info",
 		Payload: protocol.InfoPayload{
-			OS:          fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
-			WorkDir:     c.cfg.WorkDir,
-			PTYSessions: activeSessions,
+			OS:               fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+			WorkDir:          c.cfg.WorkDir,
+			PTYSessions:      activeSessions,
+			Identity:         c.identityInfo(),
+			E2E:              c.cfg.E2E.Mode,
+			ContentEncodings: contentEncodings,
+			Recovered:        c.recovered,
+			DryRun:           c.cfg.DryRun,
+			Hardware:         c.hardware(),
 		},
 	})
 
 	// Start heartbeat
 	pingDone := make(chan struct{})
 	go c.heartbeatLoop(pingDone)
+	if c.cfg.Telemetry.Enabled {
+		go c.telemetryLoop(pingDone)
+	}
 
 	// Unblock conn.ReadMessage() immediately when stopCh fires
 	// by setting the read deadline to now.
@@ -224,6 +502,9 @@ func (c *Client) connectAndServe() error {
 		case <-pingDone:
 		}
 	}()
+	if d := c.cfg.Chaos.ReconnectAfter(); d > 0 {
+		go c.chaosDisconnect(conn, d, pingDone)
+	}
 
 	// Message loop (single reader — no concurrency issue on reads)
 	for {
@@ -245,45 +526,279 @@ func (c *Client) connectAndServe() error {
 			}
 			return fmt.Errorf("read error: %w", err)
 		}
+		c.cfg.Chaos.Delay()
+		if c.cfg.Chaos.DropFrame() {
+			continue
+		}
 
 		var req protocol.Request
 		if err := json.Unmarshal(raw, &req); err != nil {
 			log.Printf("Invalid message: %s", err)
 			continue
 		}
+		if req.Type != "ping" && req.Type != "pong" && req.Type != "notify" && (!c.unseal(&req) || !c.decodePayload(&req)) {
+			continue
+		}
 
 		switch req.Type {
 		case "ping":
 			c.send(map[string]string{"type": "pong"})
 		case "pong":
 			// Heartbeat ack — no action
+		case "notify":
+			go c.handleNotify(req.Payload)
+		case "storage_presign_result":
+			c.handlePresignResult(req)
+		case "tunnel_data":
+			// Handled inline to preserve byte order; Write never blocks.
+			c.handleTunnelData(req)
 		default:
-			go c.handleRequest(req)
+			c.dispatch(req)
 		}
 	}
 }
 
+// chaosDisconnect cuts the connection without a close frame after d, as a
+// network failure would (see package chaos).
+func (c *Client) chaosDisconnect(conn *websocket.Conn, d time.Duration, done <-chan struct{}) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		ui.Warn("Chaos: dropping connection")
+		_ = conn.UnderlyingConn().Close()
+	case <-done:
+	}
+}
+
+// dispatch routes a request to the worker pool. Terminal traffic and status
+// queries are cheap and latency-sensitive, so they bypass the pool and are
+// never stuck behind long-running execs.
+func (c *Client) dispatch(req protocol.Request) {
+	switch req.Type {
+	case "pty_create", "pty_input", "pty_resize", "pty_close", "pty_signal", "pty_attach", "pty_detach", "pty_snapshot", "status", "approval_resume", "policy_approve", "tunnel_open", "tunnel_close", "display_open", "e2e_init", "tail_cancel", "unwatch":
+		go c.handleRequest(req)
+		return
+	}
+	if !c.pool.Submit(func() { c.handleRequest(req) }) {
+		c.send(protocol.Response{
+			ID:      req.ID,
+			Type:    req.Type + "_result",
+			Success: false,
+			Payload: protocol.ErrorPayload{Error: fmt.Sprintf("runner busy: request queue full (%d pending)", c.pool.Depth())},
+		})
+	}
+}
+
 func (c *Client) handleRequest(req protocol.Request) {
+	c.observe(req)
+	if reason, blocked := c.approval.blocks(req.Type); blocked {
+		c.send(protocol.Response{
+			ID:      req.ID,
+			Type:    req.Type + "_result",
+			Payload: protocol.ErrorPayload{Error: "runner is in approval mode: " + reason, Code: "approval_required"},
+		})
+		return
+	}
+
+	// A retried side-effecting request gets the original response instead
+	// of running again; if the original is still in flight, wait for it.
+	if req.ID != "" && mutatingTypes[req.Type] {
+		entry, first := c.dedup.begin(req.ID)
+		if !first {
+			log.Printf("Duplicate request %s (%s) — replaying response", req.ID, req.Type)
+			if resp, ok := c.dedup.response(entry, c.stopCh); ok {
+				c.send(resp)
+			}
+			return
+		}
+		snap := c.snapshotWrite(req)
+		start := time.Now()
+		resp := compress(req, c.spill(c.process(req)))
+		c.record(req, resp, time.Since(start), snap)
+		c.dedup.finish(entry, resp)
+		c.send(resp)
+		return
+	}
+
+	snap := c.snapshotWrite(req)
+	start := time.Now()
+	resp := compress(req, c.spill(c.process(req)))
+	c.record(req, resp, time.Since(start), snap)
+	c.send(resp)
+}
+
+// execFor returns the executor to use for req, checking its commands
+// against the command policy and recording retries against the request.
+func (c *Client) execFor(req protocol.Request) *executor.Executor {
+	e := c.exec.WithCommandCheck(c.commandCheck(req))
+	if r, ok := c.retries.Load(req.ID); ok {
+		return e.WithRetries(r.(*executor.Retries))
+	}
+	return e
+}
+
+// process executes a request and returns its response. Requests on a
+// session that has exceeded its budget are refused, as are requests whose
+// commands the command policy refuses (see commandCheck). In dry-run
+// mode, side-effecting requests only describe what they would do.
+func (c *Client) process(req protocol.Request) protocol.Response {
+	if resp, refused := c.checkBudget(req); refused {
+		return resp
+	}
+	if c.dryRun(req) {
+		return c.planRequest(req)
+	}
+	refusal := &policyRefusal{}
+	req = req.WithContext(context.WithValue(req.Context(), policyRefusalKey{}, refusal))
+	target, existed := c.beginActivity(req)
+	start := time.Now()
+	var resp protocol.Response
+	if req.ID != "" {
+		retries := &executor.Retries{}
+		c.retries.Store(req.ID, retries)
+		defer func() { c.retries.Delete(req.ID) }()
+		resp = c.route(req)
+		resp.Retries = retries.Count()
+	} else {
+		resp = c.route(req)
+	}
+	if v := refusal.payload(); v != nil {
+		resp = protocol.Response{ID: req.ID, Type: req.Type + "_result", Payload: v}
+	}
+	if req.Type == "exec" || req.Type == "run_script" {
+		c.budgets.addExec(req.Session, time.Since(start))
+	}
+	c.emitActivity(req, resp, target, existed, time.Since(start))
+	return resp
+}
+
+// route sends a request to its handler by type.
+func (c *Client) route(req protocol.Request) protocol.Response {
 	var resp protocol.Response
 	resp.ID = req.ID
 
 	switch req.Type {
 	case "exec":
 		resp = c.handleExec(req)
+	case "run_script":
+		resp = c.handleRunScript(req)
+	case "read_exec_output":
+		resp = c.handleReadExecOutput(req)
+	case "policy_approve":
+		resp = c.handlePolicyApprove(req)
+	case "job_start":
+		resp = c.handleJobStart(req)
+	case "job_status":
+		resp = c.handleJobStatus(req)
+	case "job_logs":
+		resp = c.handleJobLogs(req)
+	case "job_kill":
+		resp = c.handleJobKill(req)
+	case "job_list":
+		resp = c.handleJobList(req)
+	case "process_list":
+		resp = c.handleProcessList(req)
+	case "process_kill":
+		resp = c.handleProcessKill(req)
 	case "read_file":
 		resp = c.handleReadFile(req)
+	case "read_files":
+		resp = c.handleReadFiles(req)
 	case "read_file_bytes":
 		resp = c.handleReadFileBytes(req)
 	case "write_file":
 		resp = c.handleWriteFile(req)
 	case "write_file_bytes":
 		resp = c.handleWriteFileBytes(req)
+	case "append_file":
+		resp = c.handleAppendFile(req)
+	case "diff_files":
+		resp = c.handleDiffFiles(req)
+	case "diff_against_content":
+		resp = c.handleDiffAgainstContent(req)
+	case "apply_patch":
+		resp = c.handleApplyPatch(req)
+	case "move_file":
+		resp = c.handleMoveFile(req)
+	case "copy_file":
+		resp = c.handleCopyFile(req)
+	case "create_dir":
+		resp = c.handleCreateDir(req)
+	case "remove_dir":
+		resp = c.handleRemoveDir(req)
+	case "undelete":
+		resp = c.handleUndelete(req)
+	case "create_symlink":
+		resp = c.handleCreateSymlink(req)
+	case "read_symlink":
+		resp = c.handleReadSymlink(req)
+	case "share_file":
+		resp = c.handleShareFile(req)
+	case "artifact_put":
+		resp = c.handleArtifactPut(req)
+	case "crash_fetch":
+		resp = c.handleCrashFetch(req)
+	case "sensors_read":
+		resp = c.handleSensorsRead(req)
+	case "hw_info":
+		resp = c.handleHWInfo(req)
+	case "wol_send":
+		resp = c.handleWolSend(req)
+	case "power_status":
+		resp = c.handlePowerStatus(req)
+	case "run_track_start":
+		resp = c.handleRunTrackStart(req)
+	case "run_track_log":
+		resp = c.handleRunTrackLog(req)
+	case "run_track_artifact":
+		resp = c.handleRunTrackArtifact(req)
+	case "run_track_end":
+		resp = c.handleRunTrackEnd(req)
+	case "tail_file":
+		resp = c.handleTailFile(req)
+	case "tail_cancel":
+		resp = c.handleTailCancel(req)
+	case "audit_query":
+		resp = c.handleAuditQuery(req)
+	case "session_start":
+		resp = c.handleSessionStart(req)
+	case "session_end":
+		resp = c.handleSessionEnd(req)
+	case "session_summary":
+		resp = c.handleSessionSummary(req)
+	case "limits_info":
+		resp = c.handleLimitsInfo(req)
+	case "describe":
+		resp = c.handleDescribe(req)
+	case "workspace_apply_template":
+		resp = c.handleApplyTemplate(req)
+	case "workspace_remove":
+		resp = c.handleWorkspaceRemove(req)
+	case "watch":
+		resp = c.handleWatch(req)
+	case "unwatch":
+		resp = c.handleUnwatch(req)
+	case "checksum":
+		resp = c.handleChecksum(req)
+	case "check_paths":
+		resp = c.handleCheckPaths(req)
 	case "list_files":
 		resp = c.handleListFiles(req)
+	case "disk_usage":
+		resp = c.handleDiskUsage(req)
 	case "find_files":
 		resp = c.handleFindFiles(req)
 	case "search_in_files":
 		resp = c.handleSearchInFiles(req)
+	case "extract_archive":
+		resp = c.handleExtractArchive(req)
+	case "archive_dir":
+		resp = c.handleArchiveDir(req)
+	case "manifest":
+		resp = c.handleManifest(req)
+	case "verify_manifest":
+		resp = c.handleVerifyManifest(req)
 	case "pty_create":
 		resp = c.handlePTYCreate(req)
 	case "pty_input":
@@ -292,13 +807,49 @@ func (c *Client) handleRequest(req protocol.Request) {
 		resp = c.handlePTYResize(req)
 	case "pty_close":
 		resp = c.handlePTYClose(req)
+	case "pty_signal":
+		resp = c.handlePTYSignal(req)
+	case "pty_attach":
+		resp = c.handlePTYAttach(req)
+	case "pty_detach":
+		resp = c.handlePTYDetach(req)
+	case "pty_snapshot":
+		resp = c.handlePTYSnapshot(req)
+	case "pty_recording_list":
+		resp = c.handlePTYRecordingList(req)
+	case "pty_recording_fetch":
+		resp = c.handlePTYRecordingFetch(req)
+	case "tunnel_open":
+		resp = c.handleTunnelOpen(req)
+	case "tunnel_close":
+		resp = c.handleTunnelClose(req)
+	case "display_open":
+		resp = c.handleDisplayOpen(req)
+	case "transfer_stat":
+		resp = c.handleTransferStat(req)
+	case "transfer_read":
+		resp = c.handleTransferRead(req)
+	case "transfer_delete":
+		resp = c.handleTransferDelete(req)
+	case "transfer_create":
+		resp = c.handleTransferCreate(req)
+	case "transfer_write":
+		resp = c.handleTransferWrite(req)
+	case "transfer_commit":
+		resp = c.handleTransferCommit(req)
+	case "status":
+		resp = c.handleStatus(req)
+	case "approval_resume":
+		resp = c.handleApprovalResume(req)
+	case "e2e_init":
+		resp = c.handleE2EInit(req)
 	default:
 		resp.Type = req.Type + "_result"
 		resp.Success = false
 		resp.Payload = protocol.ErrorPayload{Error: fmt.Sprintf("unknown request type: %s", req.Type)}
 	}
 
-	c.send(resp)
+	return resp
 }
 
 func (c *Client) handleExec(req protocol.Request) protocol.Response {
@@ -306,20 +857,67 @@ func (c *Client) handleExec(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "exec_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	result := c.exec.Exec(p.Command, p.Cwd, p.Timeout)
+	result := c.execWithProgress(req).Exec(p)
 	return protocol.Response{ID: req.ID, Type: "exec_result", Success: true, Payload: result}
 }
 
+// execWithProgress returns the executor for an exec or run_script
+// request, which checks its command against the command policy and
+// reports on it as "exec_progress" events.
+func (c *Client) execWithProgress(req protocol.Request) *executor.Executor {
+	e := c.exec.WithCommandCheck(c.commandCheck(req))
+	if c.cfg.Exec.ProgressInterval <= 0 {
+		return e
+	}
+	return e.WithProgress(c.cfg.Exec.ProgressInterval, func(p protocol.ExecProgressPayload) {
+		p.RequestID = req.ID
+		c.send(map[string]interface{}{"type": "exec_progress", "payload": p})
+	})
+}
+
+func (c *Client) handleRunScript(req protocol.Request) protocol.Response {
+	var p protocol.RunScriptPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_script_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result := c.execWithProgress(req).RunScript(p)
+	return protocol.Response{ID: req.ID, Type: "run_script_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleReadExecOutput(req protocol.Request) protocol.Response {
+	var p protocol.ReadExecOutputPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_exec_output_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.exec.ReadExecOutput(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_exec_output_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "read_exec_output_result", Success: true, Payload: result}
+}
+
 func (c *Client) handleReadFile(req protocol.Request) protocol.Response {
 	var p protocol.FilePayload
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "read_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	content, err := c.exec.ReadFile(p.Path)
+	result, err := c.execFor(req).ReadFile(p)
 	if err != nil {
 		return protocol.Response{ID: req.ID, Type: "read_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "read_file_result", Success: true, Payload: protocol.FileResult{Content: content}}
+	return protocol.Response{ID: req.ID, Type: "read_file_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleReadFiles(req protocol.Request) protocol.Response {
+	var p protocol.ReadFilesPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).ReadFiles(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "read_files_result", Success: true, Payload: result}
 }
 
 func (c *Client) handleReadFileBytes(req protocol.Request) protocol.Response {
@@ -327,11 +925,11 @@ func (c *Client) handleReadFileBytes(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "read_file_bytes_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	data, err := c.exec.ReadFileBytes(p.Path)
+	result, err := c.execFor(req).ReadFileBytes(p)
 	if err != nil {
 		return protocol.Response{ID: req.ID, Type: "read_file_bytes_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "read_file_bytes_result", Success: true, Payload: protocol.FileResult{Data: data}}
+	return protocol.Response{ID: req.ID, Type: "read_file_bytes_result", Success: true, Payload: result}
 }
 
 func (c *Client) handleWriteFile(req protocol.Request) protocol.Response {
@@ -339,33 +937,228 @@ func (c *Client) handleWriteFile(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "write_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	if err := c.exec.WriteFile(p.Path, p.Content); err != nil {
-		return protocol.Response{ID: req.ID, Type: "write_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	if err := c.execFor(req).WriteFile(p.Path, p.Content, p.SHA256, p.Mode, p.AtomicWrite()); err != nil {
+		return protocol.Response{ID: req.ID, Type: "write_file_result", Success: false, Payload: writeError(err)}
 	}
 	return protocol.Response{ID: req.ID, Type: "write_file_result", Success: true, Payload: struct{}{}}
 }
 
+func (c *Client) handleAppendFile(req protocol.Request) protocol.Response {
+	var p protocol.FilePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "append_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).AppendFile(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "append_file_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "append_file_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleDiffFiles(req protocol.Request) protocol.Response {
+	var p protocol.DiffFilesPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "diff_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).DiffFiles(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "diff_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "diff_files_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleDiffAgainstContent(req protocol.Request) protocol.Response {
+	var p protocol.DiffContentPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "diff_against_content_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).DiffAgainstContent(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "diff_against_content_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "diff_against_content_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleApplyPatch(req protocol.Request) protocol.Response {
+	var p protocol.ApplyPatchPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "apply_patch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).ApplyPatch(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "apply_patch_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "apply_patch_result", Success: true, Payload: result}
+}
+
 func (c *Client) handleWriteFileBytes(req protocol.Request) protocol.Response {
 	var p protocol.FilePayload
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "write_file_bytes_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	if err := c.exec.WriteFileBytes(p.Path, p.Data); err != nil {
-		return protocol.Response{ID: req.ID, Type: "write_file_bytes_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	if err := c.execFor(req).WriteFileBytes(p.Path, p.Data, p.SHA256, p.Mode, p.AtomicWrite()); err != nil {
+		return protocol.Response{ID: req.ID, Type: "write_file_bytes_result", Success: false, Payload: writeError(err)}
 	}
 	return protocol.Response{ID: req.ID, Type: "write_file_bytes_result", Success: true, Payload: struct{}{}}
 }
 
+func (c *Client) handleMoveFile(req protocol.Request) protocol.Response {
+	var p protocol.MoveFilePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "move_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.execFor(req).MoveFile(p.Source, p.Destination, p.Overwrite); err != nil {
+		return protocol.Response{ID: req.ID, Type: "move_file_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "move_file_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleCopyFile(req protocol.Request) protocol.Response {
+	var p protocol.MoveFilePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "copy_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.execFor(req).CopyFile(p.Source, p.Destination, p.Overwrite); err != nil {
+		return protocol.Response{ID: req.ID, Type: "copy_file_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "copy_file_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleCreateDir(req protocol.Request) protocol.Response {
+	var p protocol.CreateDirPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "create_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.execFor(req).CreateDir(p.Path, p.Mode, p.Parents); err != nil {
+		return protocol.Response{ID: req.ID, Type: "create_dir_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "create_dir_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleRemoveDir(req protocol.Request) protocol.Response {
+	var p protocol.RemoveDirPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "remove_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).RemoveDir(p.Path, p.Recursive)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "remove_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "remove_dir_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleUndelete(req protocol.Request) protocol.Response {
+	var p protocol.UndeletePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "undelete_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).Undelete(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "undelete_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "undelete_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleCreateSymlink(req protocol.Request) protocol.Response {
+	var p protocol.CreateSymlinkPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "create_symlink_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).CreateSymlink(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "create_symlink_result", Success: false, Payload: writeError(err)}
+	}
+	return protocol.Response{ID: req.ID, Type: "create_symlink_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleReadSymlink(req protocol.Request) protocol.Response {
+	var p protocol.ReadSymlinkPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_symlink_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).ReadSymlink(p.Path)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "read_symlink_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "read_symlink_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleShareFile(req protocol.Request) protocol.Response {
+	var p protocol.ShareFilePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "share_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.exec.ShareFile(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "share_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "share_file_result", Success: true, Payload: result}
+}
+
+// writeError builds the error payload for a failed write, flagging
+// checksum mismatches so the cloud knows a retry may succeed.
+func writeError(err error) protocol.ErrorPayload {
+	p := protocol.ErrorPayload{Error: err.Error()}
+	switch {
+	case errors.Is(err, executor.ErrChecksumMismatch):
+		p.Code = "checksum_mismatch"
+	case errors.Is(err, executor.ErrPathConflict):
+		p.Code = "path_conflict"
+	case errors.Is(err, executor.ErrQuotaExceeded):
+		p.Code = "quota_exceeded"
+	}
+	return p
+}
+
+func (c *Client) handleChecksum(req protocol.Request) protocol.Response {
+	var p protocol.ChecksumPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "checksum_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).Checksum(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "checksum_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "checksum_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleCheckPaths(req protocol.Request) protocol.Response {
+	var p protocol.CheckPathsPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "check_paths_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	conflicts, err := c.exec.CheckPaths(p.Root, p.Paths)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "check_paths_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if conflicts == nil {
+		conflicts = []protocol.PathConflict{}
+	}
+	return protocol.Response{ID: req.ID, Type: "check_paths_result", Success: true, Payload: protocol.CheckPathsResult{Conflicts: conflicts}}
+}
+
 func (c *Client) handleListFiles(req protocol.Request) protocol.Response {
 	var p protocol.ListFilesPayload
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "list_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	files, err := c.exec.ListFiles(p.Path)
+	result, err := c.execFor(req).ListFiles(p)
 	if err != nil {
 		return protocol.Response{ID: req.ID, Type: "list_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "list_files_result", Success: true, Payload: map[string]interface{}{"files": files}}
+	return protocol.Response{ID: req.ID, Type: "list_files_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleDiskUsage(req protocol.Request) protocol.Response {
+	var p protocol.DiskUsagePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "disk_usage_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.execFor(req).DiskUsage(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "disk_usage_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "disk_usage_result", Success: true, Payload: result}
 }
 
 func (c *Client) handleFindFiles(req protocol.Request) protocol.Response {
@@ -373,11 +1166,35 @@ func (c *Client) handleFindFiles(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "find_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	files, err := c.exec.FindFiles(p.Root, p.Pattern)
+	result, err := c.exec.FindFiles(p)
 	if err != nil {
 		return protocol.Response{ID: req.ID, Type: "find_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "find_files_result", Success: true, Payload: map[string]interface{}{"files": files}}
+	return protocol.Response{ID: req.ID, Type: "find_files_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleManifest(req protocol.Request) protocol.Response {
+	var p protocol.ManifestPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "manifest_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.exec.Manifest(p.Root)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "manifest_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "manifest_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleVerifyManifest(req protocol.Request) protocol.Response {
+	var p protocol.VerifyManifestPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "verify_manifest_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.exec.VerifyManifest(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "verify_manifest_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "verify_manifest_result", Success: true, Payload: result}
 }
 
 func (c *Client) handleSearchInFiles(req protocol.Request) protocol.Response {
@@ -385,15 +1202,33 @@ func (c *Client) handleSearchInFiles(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	matches, err := c.exec.SearchInFiles(p.Root, p.Pattern, p.Include)
+	if p.Stream {
+		summary, err := c.streamSearch(req, p)
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: true, Payload: summary}
+	}
+	result, err := c.exec.SearchInFiles(p)
 	if err != nil {
 		return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: true, Payload: map[string]interface{}{"matches": matches}}
+	return protocol.Response{ID: req.ID, Type: "search_in_files_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleStatus(req protocol.Request) protocol.Response {
+	return protocol.Response{ID: req.ID, Type: "status_result", Success: true, Payload: protocol.StatusPayload{
+		Workers:       c.pool.size,
+		ActiveWorkers: c.pool.Active(),
+		QueueDepth:    c.pool.Depth(),
+		QueueCapacity: cap(c.pool.queue),
+		PTYSessions:   len(c.ptyMgr.ListSessions()),
+		ApprovalMode:  c.approval.isActive(),
+	}}
 }
 
 func (c *Client) heartbeatLoop(done <-chan struct{}) {
-	ticker := time.NewTicker(pingInterval)
+	ticker := time.NewTicker(c.cfg.Transport.PingInterval)
 	defer ticker.Stop()
 
 	for {
@@ -403,7 +1238,7 @@ func (c *Client) heartbeatLoop(done <-chan struct{}) {
 		case <-c.stopCh:
 			return
 		case <-ticker.C:
-			c.send(map[string]string{"type": "ping"})
+			c.send(c.ping())
 		}
 	}
 }
@@ -415,10 +1250,22 @@ func (c *Client) handlePTYCreate(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_create_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	if err := c.ptyMgr.Create(p); err != nil {
+	if err := c.ptyMgr.Create(p, c.commandCheck(req)); err != nil {
+		var limitErr *executor.PTYLimitError
+		if errors.As(err, &limitErr) {
+			return protocol.Response{ID: req.ID, Type: "pty_create_result", Success: false, Payload: protocol.PTYLimitPayload{
+				Error:       err.Error(),
+				Code:        "pty_session_limit",
+				MaxSessions: limitErr.Max,
+				Sessions:    limitErr.Sessions,
+			}}
+		}
 		return protocol.Response{ID: req.ID, Type: "pty_create_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "pty_create_result", Success: true, Payload: struct{}{}}
+	if err := c.state.Put(state.Record{Kind: state.KindPTY, ID: p.SessionID, PID: c.ptyMgr.Pid(p.SessionID), Command: p.Command, Tmux: c.ptyMgr.Tmux(p.SessionID)}); err != nil {
+		log.Printf("PTY %s: record state: %v", p.SessionID, err)
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_create_result", Success: true, Payload: protocol.PTYCreateResult{RecordingID: c.ptyMgr.Recording(p.SessionID)}}
 }
 
 func (c *Client) handlePTYInput(req protocol.Request) protocol.Response {
@@ -426,7 +1273,7 @@ func (c *Client) handlePTYInput(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_input_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	if err := c.ptyMgr.Input(p.SessionID, p.Data); err != nil {
+	if err := c.ptyMgr.Input(p.SessionID, p.ViewerID, p.Data); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_input_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
 	return protocol.Response{ID: req.ID, Type: "pty_input_result", Success: true, Payload: struct{}{}}
@@ -437,7 +1284,7 @@ func (c *Client) handlePTYResize(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_resize_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	if err := c.ptyMgr.Resize(p.SessionID, p.Cols, p.Rows); err != nil {
+	if err := c.ptyMgr.Resize(p.SessionID, p.ViewerID, p.Cols, p.Rows); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_resize_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
 	return protocol.Response{ID: req.ID, Type: "pty_resize_result", Success: true, Payload: struct{}{}}
@@ -448,28 +1295,195 @@ func (c *Client) handlePTYClose(req protocol.Request) protocol.Response {
 	if err := json.Unmarshal(req.Payload, &p); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
+	if p.Detach {
+		info, err := c.ptyMgr.Disown(p.SessionID, c.commandCheck(req))
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		c.endPTYOutput(p.SessionID)
+		// A restarted runner adopts the job rather than reattaching the
+		// session.
+		_ = c.state.Remove(state.KindPTY, p.SessionID)
+		rec := state.Record{Kind: state.KindJob, ID: info.JobID, PID: info.PID, Path: c.exec.Jobs.LogPath(info.JobID), Command: info.Command}
+		if err := c.state.Put(rec); err != nil {
+			log.Printf("Job %s: record state: %v", info.JobID, err)
+		}
+		return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: true, Payload: protocol.PTYCloseResult{Job: &info}}
+	}
 	if err := c.ptyMgr.Close(p.SessionID); err != nil {
 		return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
 	}
-	return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: true, Payload: struct{}{}}
+	return protocol.Response{ID: req.ID, Type: "pty_close_result", Success: true, Payload: protocol.PTYCloseResult{}}
+}
+
+func (c *Client) handlePTYSignal(req protocol.Request) protocol.Response {
+	var p protocol.PTYSignalPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_signal_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.ptyMgr.Signal(p.SessionID, p.Signal); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_signal_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_signal_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handlePTYAttach(req protocol.Request) protocol.Response {
+	var p protocol.PTYAttachPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_attach_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.ptyMgr.Attach(p.SessionID, p.ViewerID, p.ReadOnly); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_attach_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_attach_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handlePTYDetach(req protocol.Request) protocol.Response {
+	var p protocol.PTYDetachPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_detach_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.ptyMgr.Detach(p.SessionID, p.ViewerID); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_detach_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_detach_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handlePTYSnapshot(req protocol.Request) protocol.Response {
+	var p protocol.PTYSnapshotPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_snapshot_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	snap, err := c.ptyMgr.Snapshot(p.SessionID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_snapshot_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_snapshot_result", Success: true, Payload: snap}
+}
+
+func (c *Client) handlePTYRecordingList(req protocol.Request) protocol.Response {
+	var p protocol.PTYRecordingListPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_recording_list_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	recordings, err := c.ptyMgr.ListRecordings(p.SessionID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_recording_list_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_recording_list_result", Success: true, Payload: protocol.PTYRecordingListResult{Recordings: recordings}}
+}
+
+func (c *Client) handlePTYRecordingFetch(req protocol.Request) protocol.Response {
+	var p protocol.PTYRecordingFetchPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_recording_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.ptyMgr.ReadRecording(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "pty_recording_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "pty_recording_fetch_result", Success: true, Payload: result}
+}
+
+// ptyRedactFlush is how long PTY output held back for redaction waits
+// for the rest of its line before it is sent anyway.
+const ptyRedactFlush = 50 * time.Millisecond
+
+// ptyStream is a PTY session's redaction stream, with the timer that
+// flushes what it holds back.
+type ptyStream struct {
+	mu     sync.Mutex
+	stream *redact.Stream
+	timer  *time.Timer
 }
 
 func (c *Client) sendPTYOutput(sessionID string, data []byte) {
+	// Check for canary tokens before redaction can mask them.
+	c.exec.Tripwire.CheckOutput("pty_output", data)
+	if c.ptyRedactor == nil {
+		c.emitPTYOutput(sessionID, data)
+		return
+	}
+	c.ptyStreamsMu.Lock()
+	s := c.ptyStreams[sessionID]
+	if s == nil {
+		s = &ptyStream{stream: c.ptyRedactor.Stream()}
+		c.ptyStreams[sessionID] = s
+	}
+	c.ptyStreamsMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if out := s.stream.Write(data); len(out) > 0 {
+		c.emitPTYOutput(sessionID, out)
+	}
+	if s.stream.Held() {
+		s.timer = time.AfterFunc(ptyRedactFlush, func() { c.flushPTYOutput(sessionID, s) })
+	}
+}
+
+// flushPTYOutput sends the output s holds back.
+func (c *Client) flushPTYOutput(sessionID string, s *ptyStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if out := s.stream.Flush(); len(out) > 0 {
+		c.emitPTYOutput(sessionID, out)
+	}
+}
+
+// endPTYOutput flushes and forgets an ended session's redaction stream.
+func (c *Client) endPTYOutput(sessionID string) {
+	c.ptyStreamsMu.Lock()
+	s := c.ptyStreams[sessionID]
+	delete(c.ptyStreams, sessionID)
+	c.ptyStreamsMu.Unlock()
+	if s != nil {
+		c.flushPTYOutput(sessionID, s)
+	}
+}
+
+func (c *Client) emitPTYOutput(sessionID string, data []byte) {
 	c.send(map[string]interface{}{
 		"type": "pty_output",
 		"payload": protocol.PTYOutputPayload{
 			SessionID: sessionID,
 			Data:      base64.StdEncoding.EncodeToString(data),
+			Viewers:   c.ptyMgr.Viewers(sessionID),
 		},
 	})
 }
 
-func (c *Client) sendPTYExit(sessionID string, exitCode int) {
+func (c *Client) sendPTYExit(sessionID string, exitCode int, signal, reason string) {
 	c.send(map[string]interface{}{
 		"type": "pty_exit",
 		"payload": protocol.PTYExitPayload{
 			SessionID: sessionID,
 			ExitCode:  exitCode,
+			Signaled:  signal != "",
+			Signal:    signal,
+			Reason:    reason,
+		},
+	})
+}
+
+func (c *Client) sendCanaryAlert(a canary.Alert) {
+	ui.Error("SECURITY: canary file %s touched (%s): %s", a.Path, a.Operation, a.Detail)
+	c.send(map[string]interface{}{
+		"type": "security_alert",
+		"payload": protocol.SecurityAlertPayload{
+			Kind:      "canary",
+			Severity:  "critical",
+			Message:   "A request touched a canary file planted outside the allowed paths",
+			Path:      a.Path,
+			Operation: a.Operation,
+			Detail:    a.Detail,
+			Time:      a.Time.UTC().Format(time.RFC3339),
 		},
 	})
 }
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// handleCrashFetch copies a crash artifact into a transfer, since core
+// dumps are usually far larger than one response.
+func (c *Client) handleCrashFetch(req protocol.Request) protocol.Response {
+	var p protocol.CrashFetchPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "crash_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "crash_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	f, err := c.exec.CrashArtifact(p.ID, p.Name)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "crash_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	defer f.Close()
+	contentType := "application/octet-stream"
+	if p.Name == executor.CrashLog {
+		contentType = "text/plain"
+	}
+	info, err := c.transfers.PutStream(contentType, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "crash_fetch_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("copy crash artifact: %v", err)}}
+	}
+	return protocol.Response{ID: req.ID, Type: "crash_fetch_result", Success: true, Payload: protocol.TransferInfo{
+		TransferID:  info.ID,
+		Size:        info.Size,
+		SHA256:      info.SHA256,
+		ContentType: info.ContentType,
+		Complete:    info.Complete,
+		MaxChunk:    c.maxTransferChunk(),
+		MaxParallel: c.cfg.Transport.MaxParallelChunks,
+	}}
+}
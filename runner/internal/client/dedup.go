@@ -0,0 +1,149 @@
+package client
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// mutatingTypes are request types with side effects (see also isMutating).
+// They are paused in approval mode and deduplicated by request ID, since
+// re-running them on a cloud retry would repeat the side effect.
+var mutatingTypes = map[string]bool{
+	"exec":                     true,
+	"run_script":               true,
+	"job_start":                true,
+	"job_kill":                 true,
+	"process_kill":             true,
+	"write_file":               true,
+	"write_file_bytes":         true,
+	"apply_patch":              true,
+	"append_file":              true,
+	"move_file":                true,
+	"copy_file":                true,
+	"create_dir":               true,
+	"remove_dir":               true,
+	"undelete":                 true,
+	"create_symlink":           true,
+	"share_file":               true,
+	"artifact_put":             true,
+	"wol_send":                 true,
+	"run_track_start":          true,
+	"run_track_log":            true,
+	"run_track_artifact":       true,
+	"run_track_end":            true,
+	"extract_archive":          true,
+	"transfer_commit":          true,
+	"workspace_apply_template": true,
+	"workspace_remove":         true,
+	"pty_create":               true,
+	"pty_signal":               true,
+}
+
+// isMutating reports whether requests of type t have side effects: the
+// mutatingTypes, and pty_input, which is not deduplicated as keystrokes
+// are never resent under the same ID.
+func isMutating(t string) bool {
+	return mutatingTypes[t] || t == "pty_input"
+}
+
+// maxDedupResponse is the largest response, in bytes of JSON payload, the
+// dedup cache keeps. A duplicate of a request with a larger response fails
+// with code "dedup_response_too_large", saying whether the request itself
+// succeeded; it is not run again.
+const maxDedupResponse = 64 << 10
+
+// dedupEntry is a request seen recently. done is closed once resp is set;
+// size is resp's payload size.
+type dedupEntry struct {
+	id   string
+	done chan struct{}
+	resp protocol.Response
+	size int64
+}
+
+// dedupCache is an LRU of recently seen request IDs and their responses,
+// giving at-least-once delivery from the cloud exactly-once side effects.
+// It keeps at most size entries and maxBytes of response payloads.
+type dedupCache struct {
+	mu       sync.Mutex
+	size     int
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newDedupCache(size int, maxBytes int64) *dedupCache {
+	return &dedupCache{size: size, maxBytes: maxBytes, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// begin registers a request ID. It returns the new entry and true for the
+// first delivery, or the existing entry and false for a duplicate.
+func (d *dedupCache) begin(id string) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[id]; ok {
+		d.ll.MoveToFront(el)
+		return el.Value.(*dedupEntry), false
+	}
+	e := &dedupEntry{id: id, done: make(chan struct{})}
+	d.items[id] = d.ll.PushFront(e)
+	d.evict()
+	return e, true
+}
+
+// evict drops the least recently used entries over the cache's bounds.
+// d.mu must be held.
+func (d *dedupCache) evict() {
+	for d.ll.Len() > d.size || d.bytes > d.maxBytes && d.ll.Len() > 0 {
+		oldest := d.ll.Back()
+		e := oldest.Value.(*dedupEntry)
+		d.ll.Remove(oldest)
+		delete(d.items, e.id)
+		d.bytes -= e.size
+	}
+}
+
+// finish records the response for an entry and wakes waiting duplicates.
+// A response larger than maxDedupResponse is replaced by an error.
+func (d *dedupCache) finish(e *dedupEntry, resp protocol.Response) {
+	var size int64
+	if data, err := json.Marshal(resp.Payload); err == nil {
+		size = int64(len(data))
+	}
+	if size > maxDedupResponse {
+		msg := "duplicate request: already handled and failed, but its response was too large to keep"
+		if resp.Success {
+			msg = "duplicate request: already handled and succeeded, but its response was too large to keep"
+		}
+		resp = protocol.Response{
+			ID:      resp.ID,
+			Type:    resp.Type,
+			Payload: protocol.ErrorPayload{Error: msg, Code: "dedup_response_too_large"},
+		}
+		size = 0
+	}
+	d.mu.Lock()
+	e.resp, e.size = resp, size
+	if _, ok := d.items[e.id]; ok {
+		d.bytes += size
+		d.evict()
+	}
+	d.mu.Unlock()
+	close(e.done)
+}
+
+// response returns the recorded response once it is available.
+func (d *dedupCache) response(e *dedupEntry, stopCh <-chan struct{}) (protocol.Response, bool) {
+	select {
+	case <-e.done:
+	case <-stopCh:
+		return protocol.Response{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return e.resp, true
+}
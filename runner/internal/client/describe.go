@@ -0,0 +1,116 @@
+package client
+
+import (
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// requestType pairs a request type with its payload and the payload of
+// its successful result; a nil payload means the request takes none.
+type requestType struct {
+	name            string
+	payload, result interface{}
+}
+
+// requestTypes lists the request types route handles, for describe. Keep
+// it in step with route.
+var requestTypes = []requestType{
+	{"exec", protocol.ExecPayload{}, protocol.ExecResultPayload{}},
+	{"run_script", protocol.RunScriptPayload{}, protocol.ExecResultPayload{}},
+	{"read_exec_output", protocol.ReadExecOutputPayload{}, protocol.ReadExecOutputResult{}},
+	{"policy_approve", protocol.PolicyApprovePayload{}, struct{}{}},
+	{"job_start", protocol.JobStartPayload{}, protocol.JobInfo{}},
+	{"job_status", protocol.JobPayload{}, protocol.JobInfo{}},
+	{"job_logs", protocol.JobLogsPayload{}, protocol.JobLogsResult{}},
+	{"job_kill", protocol.JobKillPayload{}, struct{}{}},
+	{"job_list", nil, protocol.JobListResult{}},
+	{"process_list", protocol.ProcessListPayload{}, protocol.ProcessListResult{}},
+	{"process_kill", protocol.ProcessKillPayload{}, struct{}{}},
+	{"read_file", protocol.FilePayload{}, protocol.FileResult{}},
+	{"read_files", protocol.ReadFilesPayload{}, protocol.ReadFilesResult{}},
+	{"read_file_bytes", protocol.FilePayload{}, protocol.FileResult{}},
+	{"write_file", protocol.FilePayload{}, struct{}{}},
+	{"write_file_bytes", protocol.FilePayload{}, struct{}{}},
+	{"append_file", protocol.FilePayload{}, protocol.FileResult{}},
+	{"diff_files", protocol.DiffFilesPayload{}, protocol.DiffResult{}},
+	{"diff_against_content", protocol.DiffContentPayload{}, protocol.DiffResult{}},
+	{"apply_patch", protocol.ApplyPatchPayload{}, protocol.ApplyPatchResult{}},
+	{"move_file", protocol.MoveFilePayload{}, struct{}{}},
+	{"copy_file", protocol.MoveFilePayload{}, struct{}{}},
+	{"create_dir", protocol.CreateDirPayload{}, struct{}{}},
+	{"remove_dir", protocol.RemoveDirPayload{}, protocol.RemoveDirResult{}},
+	{"undelete", protocol.UndeletePayload{}, protocol.UndeleteResult{}},
+	{"create_symlink", protocol.CreateSymlinkPayload{}, protocol.SymlinkResult{}},
+	{"read_symlink", protocol.ReadSymlinkPayload{}, protocol.SymlinkResult{}},
+	{"share_file", protocol.ShareFilePayload{}, protocol.ShareFileResult{}},
+	{"artifact_put", protocol.ArtifactPutPayload{}, protocol.StoredObject{}},
+	{"crash_fetch", protocol.CrashFetchPayload{}, protocol.TransferInfo{}},
+	{"sensors_read", nil, protocol.SensorsResult{}},
+	{"hw_info", nil, protocol.HWInfo{}},
+	{"wol_send", protocol.WolSendPayload{}, protocol.WolSendResult{}},
+	{"power_status", protocol.PowerStatusPayload{}, protocol.PowerStatusResult{}},
+	{"run_track_start", protocol.RunTrackStartPayload{}, protocol.TrackedRun{}},
+	{"run_track_log", protocol.RunTrackLogPayload{}, struct{}{}},
+	{"run_track_artifact", protocol.RunTrackArtifactPayload{}, protocol.RunArtifactResult{}},
+	{"run_track_end", protocol.RunTrackEndPayload{}, struct{}{}},
+	{"tail_file", protocol.TailFilePayload{}, protocol.TailFileResult{}},
+	{"tail_cancel", protocol.TailCancelPayload{}, struct{}{}},
+	{"audit_query", protocol.AuditQueryPayload{}, protocol.AuditQueryResult{}},
+	{"session_start", protocol.SessionPayload{}, protocol.SessionStartResult{}},
+	{"session_end", protocol.SessionPayload{}, struct{}{}},
+	{"session_summary", protocol.SessionPayload{}, protocol.SessionSummaryResult{}},
+	{"limits_info", nil, protocol.LimitsInfoResult{}},
+	{"describe", nil, protocol.DescribeResult{}},
+	{"workspace_apply_template", protocol.ApplyTemplatePayload{}, protocol.ApplyTemplateResult{}},
+	{"workspace_remove", protocol.WorkspaceRemovePayload{}, struct{}{}},
+	{"watch", protocol.WatchPayload{}, protocol.WatchResult{}},
+	{"unwatch", protocol.UnwatchPayload{}, struct{}{}},
+	{"checksum", protocol.ChecksumPayload{}, protocol.ChecksumResult{}},
+	{"check_paths", protocol.CheckPathsPayload{}, protocol.CheckPathsResult{}},
+	{"list_files", protocol.ListFilesPayload{}, protocol.ListFilesResult{}},
+	{"disk_usage", protocol.DiskUsagePayload{}, protocol.DiskUsageResult{}},
+	{"find_files", protocol.FindFilesPayload{}, protocol.FindFilesResult{}},
+	{"search_in_files", protocol.SearchPayload{}, protocol.SearchResult{}},
+	{"extract_archive", protocol.ExtractArchivePayload{}, protocol.ExtractStats{}},
+	{"archive_dir", protocol.ArchiveDirPayload{}, protocol.ArchiveDirResult{}},
+	{"manifest", protocol.ManifestPayload{}, protocol.ManifestResult{}},
+	{"verify_manifest", protocol.VerifyManifestPayload{}, protocol.VerifyManifestResult{}},
+	{"pty_create", protocol.PTYCreatePayload{}, protocol.PTYCreateResult{}},
+	{"pty_input", protocol.PTYInputPayload{}, struct{}{}},
+	{"pty_resize", protocol.PTYResizePayload{}, struct{}{}},
+	{"pty_close", protocol.PTYClosePayload{}, protocol.PTYCloseResult{}},
+	{"pty_signal", protocol.PTYSignalPayload{}, struct{}{}},
+	{"pty_attach", protocol.PTYAttachPayload{}, struct{}{}},
+	{"pty_detach", protocol.PTYDetachPayload{}, struct{}{}},
+	{"pty_snapshot", protocol.PTYSnapshotPayload{}, protocol.PTYSnapshotResult{}},
+	{"pty_recording_list", protocol.PTYRecordingListPayload{}, protocol.PTYRecordingListResult{}},
+	{"pty_recording_fetch", protocol.PTYRecordingFetchPayload{}, protocol.PTYRecordingFetchResult{}},
+	{"tunnel_open", protocol.TunnelOpenPayload{}, struct{}{}},
+	{"tunnel_close", protocol.TunnelClosePayload{}, struct{}{}},
+	{"display_open", protocol.DisplayOpenPayload{}, protocol.DisplayOpenResult{}},
+	{"transfer_stat", protocol.TransferPayload{}, protocol.TransferInfo{}},
+	{"transfer_read", protocol.TransferReadPayload{}, protocol.TransferChunk{}},
+	{"transfer_delete", protocol.TransferPayload{}, struct{}{}},
+	{"transfer_create", protocol.TransferCreatePayload{}, protocol.TransferInfo{}},
+	{"transfer_write", protocol.TransferWritePayload{}, struct{}{}},
+	{"transfer_commit", protocol.TransferCommitPayload{}, struct{}{}},
+	{"status", nil, protocol.StatusPayload{}},
+	{"approval_resume", nil, struct{}{}},
+	{"e2e_init", protocol.E2EInitPayload{}, protocol.E2EInitResult{}},
+}
+
+// handleDescribe returns the schemas of the request types the runner
+// handles, generated from their payload types, so the backend can build
+// agent tool definitions to match this runner's version. It takes no
+// payload.
+func (c *Client) handleDescribe(req protocol.Request) protocol.Response {
+	result := protocol.DescribeResult{Limits: c.limitsInfo(req.Session)}
+	for _, t := range requestTypes {
+		result.Requests = append(result.Requests, protocol.RequestSchema{
+			Type:     t.name,
+			Mutating: isMutating(t.name),
+			Payload:  protocol.SchemaOf(t.payload),
+			Result:   protocol.SchemaOf(t.result),
+		})
+	}
+	return protocol.Response{ID: req.ID, Type: "describe_result", Success: true, Payload: result}
+}
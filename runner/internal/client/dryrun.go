@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// dryRun reports whether req, if it has side effects, should only
+// describe them: the runner runs with --dry-run or the request asks to.
+func (c *Client) dryRun(req protocol.Request) bool {
+	return (c.cfg.DryRun || req.DryRun) && isMutating(req.Type)
+}
+
+// planRequest answers a side-effecting request in dry-run mode with what
+// it would do. Requests it cannot describe are refused rather than run.
+func (c *Client) planRequest(req protocol.Request) protocol.Response {
+	var (
+		result protocol.DryRunResult
+		err    error
+	)
+	switch req.Type {
+	case "exec":
+		var p protocol.ExecPayload
+		if err = json.Unmarshal(req.Payload, &p); err == nil {
+			result, err = c.exec.PlanExec(p)
+		}
+	case "run_script":
+		var p protocol.RunScriptPayload
+		if err = json.Unmarshal(req.Payload, &p); err == nil {
+			result, err = c.exec.PlanExec(protocol.ExecPayload{Command: p.Content, Cwd: p.Cwd, Timeout: p.Timeout, Env: p.Env})
+			result.Summary = fmt.Sprintf("would run a %s script in %s", p.Interpreter, result.Cwd)
+		}
+	case "job_start":
+		var p protocol.JobStartPayload
+		if err = json.Unmarshal(req.Payload, &p); err == nil {
+			result, err = c.exec.PlanExec(protocol.ExecPayload{Command: p.Command, Cwd: p.Cwd, Env: p.Env})
+			result.Summary = fmt.Sprintf("would start a background %s job in %s", result.Class, result.Cwd)
+			result.Timeout = p.Timeout
+		}
+	case "write_file", "write_file_bytes", "append_file":
+		var p protocol.FilePayload
+		if err = json.Unmarshal(req.Payload, &p); err == nil {
+			result, err = c.exec.PlanWrite(p, req.Type == "write_file_bytes", req.Type == "append_file")
+		}
+	case "remove_dir":
+		var p protocol.RemoveDirPayload
+		if err = json.Unmarshal(req.Payload, &p); err == nil {
+			result, err = c.exec.PlanRemoveDir(p.Path, p.Recursive)
+		}
+	default:
+		return protocol.Response{
+			ID:      req.ID,
+			Type:    req.Type + "_result",
+			Payload: protocol.ErrorPayload{Error: req.Type + " is not supported in dry-run mode", Code: "dry_run_unsupported"},
+		}
+	}
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: req.Type + "_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: req.Type + "_result", Success: true, Payload: result}
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/scienceol/xyzen/runner/internal/e2e"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// plaintextTypes are outgoing messages that stay readable by the relay even
+// when a key is negotiated: handshake, health and security signals the
+// backend itself acts on.
+var plaintextTypes = map[string]bool{
+	"info":             true,
+	"pong":             true,
+	"e2e_init_result":  true,
+	"status_result":    true,
+	"security_alert":   true,
+	"runner_telemetry": true,
+}
+
+// plaintextAllowed lists request types accepted unencrypted in "required"
+// mode. The frontend needs e2e_init to negotiate a key in the first place.
+var plaintextAllowed = map[string]bool{
+	"e2e_init": true,
+	"status":   true,
+}
+
+func (c *Client) handleE2EInit(req protocol.Request) protocol.Response {
+	var p protocol.E2EInitPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "e2e_init_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.e2e == nil {
+		return protocol.Response{ID: req.ID, Type: "e2e_init_result", Success: false, Payload: protocol.ErrorPayload{Error: "end-to-end encryption is disabled on this runner", Code: "e2e_disabled"}}
+	}
+	peer, err := base64.StdEncoding.DecodeString(p.PublicKey)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "e2e_init_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("invalid public key: %v", err)}}
+	}
+	pub, code, err := c.e2e.Init(p.KeyID, peer)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "e2e_init_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	ui.Info("End-to-end encryption established %s", ui.Dim("(verify code "+code+")"))
+	return protocol.Response{ID: req.ID, Type: "e2e_init_result", Success: true, Payload: protocol.E2EInitResult{
+		KeyID:      p.KeyID,
+		Scheme:     e2e.Scheme,
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		VerifyCode: code,
+	}}
+}
+
+// unseal decrypts req.Payload in place if it is encrypted, and enforces
+// "required" mode. On failure it replies with an error and returns false.
+func (c *Client) unseal(req *protocol.Request) bool {
+	if req.Encryption == "" {
+		if c.cfg.E2E.Mode == "required" && !plaintextAllowed[req.Type] {
+			c.send(protocol.Response{
+				ID:      req.ID,
+				Type:    req.Type + "_result",
+				Payload: protocol.ErrorPayload{Error: "runner requires end-to-end encrypted requests", Code: "e2e_required"},
+			})
+			return false
+		}
+		return true
+	}
+
+	var err error
+	if c.e2e == nil {
+		err = fmt.Errorf("end-to-end encryption is disabled on this runner")
+	} else if req.Encryption != e2e.Scheme {
+		err = fmt.Errorf("unsupported encryption scheme %q", req.Encryption)
+	} else {
+		var sealed string
+		if err = json.Unmarshal(req.Payload, &sealed); err == nil {
+			req.Payload, err = c.e2e.Open(req.KeyID, sealed)
+		}
+	}
+	if err != nil {
+		log.Printf("Rejected encrypted %s request %s: %v", req.Type, req.ID, err)
+		c.send(protocol.Response{
+			ID:      req.ID,
+			Type:    req.Type + "_result",
+			Payload: protocol.ErrorPayload{Error: err.Error(), Code: "e2e_decrypt_failed"},
+		})
+		return false
+	}
+	req.Encryption, req.KeyID = "", ""
+	return true
+}
+
+// seal encrypts the payload of an outgoing message once a key has been
+// negotiated. Messages in plaintextTypes and payload-less messages pass
+// through unchanged.
+func (c *Client) seal(msg interface{}) interface{} {
+	if c.e2e == nil || !c.e2e.Active() {
+		return msg
+	}
+	switch m := msg.(type) {
+	case protocol.Response:
+		if plaintextTypes[m.Type] || m.Payload == nil {
+			return m
+		}
+		keyID, sealed, ok := c.sealPayload(m.Payload)
+		if !ok {
+			return m
+		}
+		m.Payload, m.Encryption, m.KeyID = sealed, e2e.Scheme, keyID
+		return m
+	case map[string]interface{}:
+		t, _ := m["type"].(string)
+		payload, has := m["payload"]
+		if plaintextTypes[t] || !has {
+			return m
+		}
+		keyID, sealed, ok := c.sealPayload(payload)
+		if !ok {
+			return m
+		}
+		out := make(map[string]interface{}, len(m)+2)
+		for k, v := range m {
+			out[k] = v
+		}
+		out["payload"], out["encryption"], out["key_id"] = sealed, e2e.Scheme, keyID
+		return out
+	}
+	return msg
+}
+
+func (c *Client) sealPayload(payload interface{}) (keyID, sealed string, ok bool) {
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("e2e: marshal payload: %v", err)
+		return "", "", false
+	}
+	return c.e2e.Seal(plain)
+}
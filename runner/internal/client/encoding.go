@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// gzipMinSize is the smallest marshalled payload worth compressing.
+	gzipMinSize = 4 * 1024
+	// maxDecodedPayload bounds a decompressed request payload.
+	maxDecodedPayload = 64 * 1024 * 1024
+)
+
+// contentEncodings are the payload encodings advertised in the info
+// message, for servers without permessage-deflate.
+var contentEncodings = []string{protocol.EncodingGzip}
+
+// decodePayload expands req.Payload in place if it carries a
+// content_encoding. On failure it replies with an error and returns false.
+func (c *Client) decodePayload(req *protocol.Request) bool {
+	if req.ContentEncoding == "" {
+		return true
+	}
+	var err error
+	if req.ContentEncoding != protocol.EncodingGzip {
+		err = fmt.Errorf("unsupported content encoding %q", req.ContentEncoding)
+	} else {
+		req.Payload, err = gunzipPayload(req.Payload)
+	}
+	if err != nil {
+		log.Printf("Rejected %s request %s: %v", req.Type, req.ID, err)
+		c.send(protocol.Response{
+			ID:      req.ID,
+			Type:    req.Type + "_result",
+			Payload: protocol.ErrorPayload{Error: err.Error(), Code: "bad_content_encoding"},
+		})
+		return false
+	}
+	req.ContentEncoding = ""
+	return true
+}
+
+// compress gzips a response payload if the request accepted gzip and the
+// payload is large enough to benefit. The payload becomes a base64 string
+// and the response is marked with content_encoding.
+func compress(req protocol.Request, resp protocol.Response) protocol.Response {
+	if !acceptsEncoding(req.AcceptEncoding, protocol.EncodingGzip) || resp.Payload == nil {
+		return resp
+	}
+	data, err := json.Marshal(resp.Payload)
+	if err != nil || len(data) < gzipMinSize {
+		return resp
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return resp
+	}
+	if err := zw.Close(); err != nil {
+		return resp
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(encoded) >= len(data) {
+		return resp // incompressible
+	}
+	resp.Payload, resp.ContentEncoding = encoded, protocol.EncodingGzip
+	return resp
+}
+
+func gunzipPayload(payload json.RawMessage) (json.RawMessage, error) {
+	var encoded string
+	if err := json.Unmarshal(payload, &encoded); err != nil {
+		return nil, fmt.Errorf("gzip payload must be a base64 string: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("gzip payload: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("gzip payload: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, maxDecodedPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("gzip payload: %w", err)
+	}
+	if len(data) > maxDecodedPayload {
+		return nil, fmt.Errorf("gzip payload exceeds %d bytes decompressed", maxDecodedPayload)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("gzip payload is not valid JSON")
+	}
+	return data, nil
+}
+
+// acceptsEncoding reports whether a comma-separated accept_encoding list
+// includes enc.
+func acceptsEncoding(accept, enc string) bool {
+	for _, a := range strings.Split(accept, ",") {
+		if strings.EqualFold(strings.TrimSpace(a), enc) {
+			return true
+		}
+	}
+	return false
+}
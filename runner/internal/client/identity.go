@@ -0,0 +1,40 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/scienceol/xyzen/runner/internal/identity"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// handshakeHeader presents the runner identity alongside the token so the
+// backend can recognize the machine across token rotations.
+func (c *Client) handshakeHeader() http.Header {
+	h := http.Header{}
+	if c.identity == nil {
+		return h
+	}
+	ts, sig := c.identity.HandshakeProof()
+	h.Set("X-Xyzen-Runner-Key", c.identity.PublicKeyString())
+	h.Set("X-Xyzen-Runner-Timestamp", ts)
+	h.Set("X-Xyzen-Runner-Signature", sig)
+	if m := identity.MachineFingerprint(); m != "" {
+		h.Set("X-Xyzen-Runner-Machine", m)
+	}
+	if c.cfg.DeviceName != "" {
+		h.Set("X-Xyzen-Runner-Name", c.cfg.DeviceName)
+	}
+	return h
+}
+
+func (c *Client) identityInfo() *protocol.IdentityInfo {
+	if c.identity == nil {
+		return nil
+	}
+	return &protocol.IdentityInfo{
+		Fingerprint: c.identity.Fingerprint(),
+		PublicKey:   c.identity.PublicKeyString(),
+		Machine:     identity.MachineFingerprint(),
+		DeviceName:  c.cfg.DeviceName,
+	}
+}
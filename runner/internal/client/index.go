@@ -0,0 +1,68 @@
+package client
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/ignore"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// indexSaveInterval bounds how often index updates are written out.
+	indexSaveInterval = time.Minute
+	// indexRebuildInterval is how often the index is rescanned when the
+	// work dir is too large to watch.
+	indexRebuildInterval = 30 * time.Second
+)
+
+// indexLoop builds the content index, then keeps it current from a watch
+// of the work dir until the client stops. If the work dir cannot be
+// watched, the index is rebuilt periodically instead, which only re-reads
+// changed files.
+func (c *Client) indexLoop() {
+	start := time.Now()
+	if err := c.exec.BuildIndex(); err != nil {
+		log.Printf("Content index: %v", err)
+		return
+	}
+	log.Printf("Content index ready: %d files in %s", c.exec.Index.Len(), time.Since(start).Round(time.Millisecond))
+
+	exclude := []string{".xyzen"}
+	for _, pat := range ignore.Defaults {
+		exclude = append(exclude, strings.TrimSuffix(pat, "/"))
+	}
+	lastSave := time.Now()
+	err := c.exec.Watch(protocol.WatchPayload{
+		Paths:      []string{"."},
+		Exclude:    exclude,
+		IntervalMs: int(c.cfg.Search.IndexInterval.Milliseconds()),
+	}, c.stopCh, func(events []protocol.FSEvent) {
+		c.exec.UpdateIndex(events)
+		if time.Since(lastSave) > indexSaveInterval {
+			if err := c.exec.SaveIndex(); err != nil {
+				log.Printf("Content index: save: %v", err)
+			}
+			lastSave = time.Now()
+		}
+	})
+	if err == nil {
+		_ = c.exec.SaveIndex()
+		return
+	}
+
+	log.Printf("Content index: cannot watch for changes (%v); rescanning every %s", err, indexRebuildInterval)
+	ticker := time.NewTicker(indexRebuildInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if err := c.exec.BuildIndex(); err != nil {
+				log.Printf("Content index: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/state"
+)
+
+func (c *Client) handleJobStart(req protocol.Request) protocol.Response {
+	var p protocol.JobStartPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	info, err := c.execFor(req).StartJob(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	rec := state.Record{Kind: state.KindJob, ID: info.JobID, PID: info.PID, Path: c.exec.Jobs.LogPath(info.JobID), Command: p.Command}
+	if err := c.state.Put(rec); err != nil {
+		log.Printf("Job %s: record state: %v", info.JobID, err)
+	}
+	return protocol.Response{ID: req.ID, Type: "job_start_result", Success: true, Payload: info}
+}
+
+func (c *Client) handleJobStatus(req protocol.Request) protocol.Response {
+	var p protocol.JobPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_status_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	info, err := c.exec.Jobs.Status(p.JobID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_status_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "job_status_result", Success: true, Payload: info}
+}
+
+func (c *Client) handleJobList(req protocol.Request) protocol.Response {
+	return protocol.Response{ID: req.ID, Type: "job_list_result", Success: true, Payload: protocol.JobListResult{Jobs: c.exec.Jobs.List()}}
+}
+
+func (c *Client) handleJobKill(req protocol.Request) protocol.Response {
+	var p protocol.JobKillPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_kill_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.exec.Jobs.Kill(p.JobID, p.Signal); err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_kill_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "job_kill_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleJobLogs(req protocol.Request) protocol.Response {
+	var p protocol.JobLogsPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_logs_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.exec.Jobs.Logs(p.JobID, p.Offset, p.MaxBytes)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "job_logs_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if p.Follow {
+		stop, err := c.tails.add(req.ID)
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "job_logs_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		result.StreamID = req.ID
+		go c.followJob(req.ID, p.JobID, result.NextOffset, stop)
+	}
+	return protocol.Response{ID: req.ID, Type: "job_logs_result", Success: true, Payload: result}
+}
+
+// followJob streams a job's output as job_log messages until the job ends
+// or the stream is cancelled.
+func (c *Client) followJob(streamID, jobID string, offset int64, stop chan struct{}) {
+	info, ended, err := c.exec.Jobs.Follow(jobID, offset, stop, func(data string, offset int64) {
+		c.send(map[string]interface{}{
+			"type":    "job_log",
+			"payload": protocol.JobLogPayload{StreamID: streamID, JobID: jobID, Data: data, Offset: offset},
+		})
+	})
+	c.tails.cancel(streamID)
+	done := protocol.JobLogPayload{StreamID: streamID, JobID: jobID, Done: true}
+	if ended {
+		done.Job = &info
+	}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	c.send(map[string]interface{}{"type": "job_log", "payload": done})
+}
+
+// onJobExit clears an ended job's state record and reports it in the
+// activity feed.
+func (c *Client) onJobExit(info protocol.JobInfo, env map[string]string) {
+	_ = c.state.Remove(state.KindJob, info.JobID)
+	if !c.cfg.Activity.IsEnabled() {
+		return
+	}
+	ev := protocol.ActivityPayload{Kind: activityJobFinished, Command: c.redactCommand(info.Command, env), ExitCode: info.ExitCode}
+	started, err1 := time.Parse(time.RFC3339, info.StartedAt)
+	ended, err2 := time.Parse(time.RFC3339, info.EndedAt)
+	if err1 == nil && err2 == nil {
+		ev.DurationMs = ended.Sub(started).Milliseconds()
+	}
+	c.sendActivity(ev)
+}
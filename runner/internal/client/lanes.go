@@ -0,0 +1,86 @@
+package client
+
+import (
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// lane is a write priority class. The write loop always drains lower
+// lanes first, so keystroke echoes never queue behind large file payloads.
+type lane int
+
+const (
+	laneInteractive lane = iota // PTY output frames and PTY request results
+	laneControl                 // heartbeats, info, status
+	laneBulk                    // everything else (file contents, search, exec)
+	laneActivity                // activity feed events, served alongside bulk
+	numLanes
+)
+
+// writeQueues holds one buffered channel per lane for a single connection.
+type writeQueues [numLanes]chan interface{}
+
+func newWriteQueues(size int) *writeQueues {
+	var qs writeQueues
+	for i := range qs {
+		qs[i] = make(chan interface{}, size)
+	}
+	return &qs
+}
+
+// next blocks until a message is available (or done is closed) and
+// returns the highest-priority one.
+func (qs *writeQueues) next(done <-chan struct{}) (interface{}, bool) {
+	select {
+	case msg := <-qs[laneInteractive]:
+		return msg, true
+	default:
+	}
+	select {
+	case msg := <-qs[laneInteractive]:
+		return msg, true
+	case msg := <-qs[laneControl]:
+		return msg, true
+	default:
+	}
+	select {
+	case <-done:
+		return nil, false
+	case msg := <-qs[laneInteractive]:
+		return msg, true
+	case msg := <-qs[laneControl]:
+		return msg, true
+	case msg := <-qs[laneBulk]:
+		return msg, true
+	case msg := <-qs[laneActivity]:
+		return msg, true
+	}
+}
+
+// laneOf classifies an outgoing message by its type.
+func laneOf(v interface{}) lane {
+	var typ string
+	switch m := v.(type) {
+	case protocol.Response:
+		typ = m.Type
+	case map[string]interface{}:
+		typ, _ = m["type"].(string)
+	case map[string]string:
+		typ = m["type"]
+	}
+	switch {
+	case strings.HasPrefix(typ, "pty_recording_"):
+		// Recordings are bulk reads, not terminal traffic.
+		return laneBulk
+	case strings.HasPrefix(typ, "pty_"):
+		return laneInteractive
+	case typ == "ping", typ == "pong", typ == "info", typ == "status_result", typ == "security_alert":
+		return laneControl
+	case typ == "activity":
+		// Its own queue, so a burst of bulk results can't crowd out
+		// (and drop) timeline events.
+		return laneActivity
+	}
+	return laneBulk
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// NotifyHandler handles a "notify" message from the cloud. Notifications
+// carry no request ID and get no response.
+type NotifyHandler func(n protocol.NotifyPayload)
+
+// OnNotify registers a handler for a notification topic. Multiple handlers
+// may be registered for the same topic; they run in registration order.
+func (c *Client) OnNotify(topic string, h NotifyHandler) {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	if c.notifyHandlers == nil {
+		c.notifyHandlers = make(map[string][]NotifyHandler)
+	}
+	c.notifyHandlers[topic] = append(c.notifyHandlers[topic], h)
+}
+
+// registerNotifyHandlers installs the built-in notification handlers.
+func (c *Client) registerNotifyHandlers() {
+	c.OnNotify("token_rotation", func(n protocol.NotifyPayload) {
+		ui.Warn("Runner token rotation: %s", n.Message)
+	})
+	c.OnNotify("message", func(n protocol.NotifyPayload) {
+		ui.Info("%s", n.Message)
+	})
+}
+
+// handleNotify dispatches a notification to the handlers for its topic.
+func (c *Client) handleNotify(raw json.RawMessage) {
+	var n protocol.NotifyPayload
+	if err := json.Unmarshal(raw, &n); err != nil {
+		log.Printf("Invalid notify payload: %v", err)
+		return
+	}
+
+	c.notifyMu.Lock()
+	handlers := c.notifyHandlers[n.Topic]
+	c.notifyMu.Unlock()
+
+	if len(handlers) == 0 {
+		log.Printf("Unhandled notification (topic %q): %s", n.Topic, n.Message)
+		return
+	}
+	for _, h := range handlers {
+		h(n)
+	}
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/policy"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// policyApprovalTTL is how long the user has to approve a refused
+// command, and the agent to send it again once approved.
+const policyApprovalTTL = 10 * time.Minute
+
+// newPolicy builds the command policy from the config.
+func newPolicy(cfg config.PolicyConfig) (*policy.Policy, error) {
+	p, err := policy.New(policy.Rules{
+		Allow:        cfg.Allow,
+		Deny:         cfg.Deny,
+		Default:      cfg.Default,
+		SensitiveOff: !cfg.DenySensitive(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec.policy: %w", err)
+	}
+	return p, nil
+}
+
+// policyApproval is a refused command awaiting, or granted, the user's
+// approval. key identifies the command (see policyKey).
+type policyApproval struct {
+	key      string
+	expires  time.Time
+	approved bool
+}
+
+// policyApprovals tracks the approvals of refused commands.
+type policyApprovals struct {
+	mu sync.Mutex
+	m  map[string]*policyApproval
+}
+
+// request registers a refused command and returns its approval ID.
+func (a *policyApprovals) request(key string, now time.Time) (string, time.Time, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+	if a.m == nil {
+		a.m = make(map[string]*policyApproval)
+	}
+	expires := now.Add(policyApprovalTTL)
+	a.m[id] = &policyApproval{key: key, expires: expires}
+	return id, expires, nil
+}
+
+// approve grants the approval id.
+func (a *policyApprovals) approve(id string, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+	ap, ok := a.m[id]
+	if !ok {
+		return fmt.Errorf("unknown or expired approval %q", id)
+	}
+	ap.approved = true
+	ap.expires = now.Add(policyApprovalTTL)
+	return nil
+}
+
+// consume reports whether the command key was approved, using up the
+// approval.
+func (a *policyApprovals) consume(key string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+	for id, ap := range a.m {
+		if ap.approved && ap.key == key {
+			delete(a.m, id)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *policyApprovals) prune(now time.Time) {
+	for id, ap := range a.m {
+		if now.After(ap.expires) {
+			delete(a.m, id)
+		}
+	}
+}
+
+// policyRefusal is the policy violation, if any, that refused a command
+// of a request. process gives each request its own, in the request's
+// context under policyRefusalKey, and answers with it.
+type policyRefusal struct {
+	mu        sync.Mutex
+	violation interface{}
+}
+
+type policyRefusalKey struct{}
+
+// refuse records violation unless an earlier one was recorded.
+func (r *policyRefusal) refuse(violation interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.violation == nil {
+		r.violation = violation
+	}
+}
+
+func (r *policyRefusal) payload() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.violation
+}
+
+// policyKey identifies a command of a session for approvals.
+func policyKey(session string, cmd executor.Command) string {
+	return session + "\x00" + cmd.Kind + "\x00" + cmd.Line
+}
+
+// commandCheck returns the check of req's commands against the command
+// policy, which the executor makes before running any of them. A command
+// neither allowed nor approved by the user is refused, and the violation
+// recorded in req's policyRefusal for process to answer req with.
+func (c *Client) commandCheck(req protocol.Request) executor.CommandCheck {
+	return func(cmd executor.Command) error {
+		d := c.policy.Check(cmd.Line)
+		if d.Allowed {
+			return nil
+		}
+		redacted := c.redactCommand(cmd.Line, cmd.Env)
+		now := time.Now()
+		key := policyKey(req.Session, cmd)
+		if c.approvals.consume(key, now) {
+			log.Printf("Running approved command (policy rule %s): %s", d.Rule, redacted)
+			return nil
+		}
+		msg := fmt.Sprintf("command refused by policy rule %s: %s", d.Rule, d.Reason)
+		ui.Warn("Agent %s: %s", msg, redacted)
+		var violation interface{} = protocol.ErrorPayload{Error: msg, Code: "policy_violation"}
+		if id, expires, err := c.approvals.request(key, now); err == nil {
+			violation = protocol.PolicyViolationPayload{
+				Error:      msg,
+				Code:       "policy_violation",
+				Command:    redacted,
+				Rule:       d.Rule,
+				Reason:     d.Reason,
+				ApprovalID: id,
+				ExpiresAt:  expires.UTC().Format(time.RFC3339),
+			}
+		}
+		if r, ok := req.Context().Value(policyRefusalKey{}).(*policyRefusal); ok {
+			r.refuse(violation)
+		}
+		return errors.New(msg)
+	}
+}
+
+func (c *Client) handlePolicyApprove(req protocol.Request) protocol.Response {
+	var p protocol.PolicyApprovePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "policy_approve_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if p.ApprovalID == "" {
+		return protocol.Response{ID: req.ID, Type: "policy_approve_result", Success: false, Payload: protocol.ErrorPayload{Error: "approval_id is required"}}
+	}
+	if err := c.approvals.approve(p.ApprovalID, time.Now()); err != nil {
+		return protocol.Response{ID: req.ID, Type: "policy_approve_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	ui.Info("Command approved — the agent may run it once")
+	return protocol.Response{ID: req.ID, Type: "policy_approve_result", Success: true, Payload: struct{}{}}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPolicyApprovals grants an approval once, for its command only, and
+// until policyApprovalTTL after it was requested or approved.
+func TestPolicyApprovals(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name       string
+		approveAt  time.Duration // after the request; < 0 never approved
+		consumeAt  time.Duration // after the approval (or request)
+		consumeKey string
+		want       bool
+	}{
+		{"approved", 0, time.Second, "k", true},
+		{"not approved", -1, time.Second, "k", false},
+		{"other command", 0, time.Second, "other", false},
+		{"approved late", policyApprovalTTL + time.Second, 0, "k", false},
+		{"consumed late", time.Minute, policyApprovalTTL + time.Second, "k", false},
+		{"consumed in time", policyApprovalTTL - time.Second, policyApprovalTTL - time.Second, "k", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var a policyApprovals
+			id, expires, err := a.request("k", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := now.Add(policyApprovalTTL); !expires.Equal(want) {
+				t.Fatalf("expires = %v, want %v", expires, want)
+			}
+			at := now
+			if tc.approveAt >= 0 {
+				at = now.Add(tc.approveAt)
+				if err := a.approve(id, at); err != nil && tc.want {
+					t.Fatal(err)
+				}
+			}
+			if got := a.consume(tc.consumeKey, at.Add(tc.consumeAt)); got != tc.want {
+				t.Fatalf("consume = %v, want %v", got, tc.want)
+			}
+			if tc.want && a.consume(tc.consumeKey, at.Add(tc.consumeAt)) {
+				t.Fatal("approval consumed twice")
+			}
+		})
+	}
+}
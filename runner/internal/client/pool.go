@@ -0,0 +1,59 @@
+package client
+
+import (
+	"sync/atomic"
+)
+
+// workerPool runs request handlers on a fixed number of goroutines fed by
+// a bounded queue, so a burst of requests cannot spawn an unbounded number
+// of concurrent execs and file walks.
+type workerPool struct {
+	size   int
+	queue  chan func()
+	active atomic.Int32
+}
+
+// newWorkerPool starts size workers that run until stopCh is closed.
+func newWorkerPool(size, queueSize int, stopCh <-chan struct{}) *workerPool {
+	p := &workerPool{
+		size:  size,
+		queue: make(chan func(), queueSize),
+	}
+	for i := 0; i < size; i++ {
+		go p.worker(stopCh)
+	}
+	return p
+}
+
+func (p *workerPool) worker(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case task := <-p.queue:
+			p.active.Add(1)
+			task()
+			p.active.Add(-1)
+		}
+	}
+}
+
+// Submit enqueues a task. Returns false without blocking if the queue is full.
+func (p *workerPool) Submit(task func()) bool {
+	select {
+	case p.queue <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Depth returns the number of queued tasks not yet picked up by a worker.
+func (p *workerPool) Depth() int {
+	return len(p.queue)
+}
+
+// Active returns the number of workers currently running a task.
+func (p *workerPool) Active() int {
+	return int(p.active.Load())
+}
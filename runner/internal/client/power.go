@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/power"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// probeTimeout bounds one power_status probe of a machine.
+	probeTimeout = 2 * time.Second
+	// wolPollInterval is how often wol_send probes a waking machine.
+	wolPollInterval = 3 * time.Second
+	// maxWolWait bounds how long wol_send waits for a machine.
+	maxWolWait = 300
+)
+
+// machine returns a machine from the power config.
+func (c *Client) machine(name string) (config.MachineConfig, error) {
+	if len(c.cfg.Power.Machines) == 0 {
+		return config.MachineConfig{}, errors.New("no machines are configured (power.machines)")
+	}
+	m, ok := c.cfg.Power.Machines[name]
+	if !ok {
+		return config.MachineConfig{}, fmt.Errorf("machine %q is not configured", name)
+	}
+	return m, nil
+}
+
+func (c *Client) handleWolSend(req protocol.Request) protocol.Response {
+	var p protocol.WolSendPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "wol_send_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	m, err := c.machine(p.Machine)
+	if err == nil && p.Wait > 0 && m.Host == "" {
+		err = fmt.Errorf("machine %q has no host to probe", p.Machine)
+	}
+	if err == nil {
+		err = power.Wake(m.MAC, m.Broadcast)
+	}
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "wol_send_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result := protocol.WolSendResult{Machine: p.Machine, MAC: m.MAC}
+	if p.Wait > 0 {
+		status := c.awaitMachine(p.Machine, m, time.Duration(min(p.Wait, maxWolWait))*time.Second)
+		result.Status = &status
+	}
+	return protocol.Response{ID: req.ID, Type: "wol_send_result", Success: true, Payload: result}
+}
+
+// awaitMachine probes a machine until it is up or wait has passed.
+func (c *Client) awaitMachine(name string, m config.MachineConfig, wait time.Duration) protocol.PowerStatus {
+	deadline := time.Now().Add(wait)
+	for {
+		status := probeMachine(name, m)
+		if status.Up || time.Now().Add(wolPollInterval).After(deadline) {
+			return status
+		}
+		select {
+		case <-c.stopCh:
+			return status
+		case <-time.After(wolPollInterval):
+		}
+	}
+}
+
+func (c *Client) handlePowerStatus(req protocol.Request) protocol.Response {
+	var p protocol.PowerStatusPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "power_status_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	machines := map[string]config.MachineConfig{}
+	if p.Machine != "" {
+		m, err := c.machine(p.Machine)
+		if err == nil && m.Host == "" {
+			err = fmt.Errorf("machine %q has no host to probe", p.Machine)
+		}
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "power_status_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		machines[p.Machine] = m
+	} else {
+		for name, m := range c.cfg.Power.Machines {
+			if m.Host != "" {
+				machines[name] = m
+			}
+		}
+	}
+
+	result := protocol.PowerStatusResult{Machines: []protocol.PowerStatus{}}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, m := range machines {
+		wg.Add(1)
+		go func(name string, m config.MachineConfig) {
+			defer wg.Done()
+			status := probeMachine(name, m)
+			mu.Lock()
+			result.Machines = append(result.Machines, status)
+			mu.Unlock()
+		}(name, m)
+	}
+	wg.Wait()
+	sort.Slice(result.Machines, func(i, j int) bool { return result.Machines[i].Machine < result.Machines[j].Machine })
+	return protocol.Response{ID: req.ID, Type: "power_status_result", Success: true, Payload: result}
+}
+
+func probeMachine(name string, m config.MachineConfig) protocol.PowerStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	up, latency := power.Probe(ctx, m.Host, m.Ports)
+	status := protocol.PowerStatus{Machine: name, Host: m.Host, Up: up}
+	if up {
+		status.LatencyMs = max(latency.Milliseconds(), 1)
+	}
+	return status
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/process"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+func (c *Client) handleProcessList(req protocol.Request) protocol.Response {
+	var p protocol.ProcessListPayload
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return protocol.Response{ID: req.ID, Type: "process_list_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+	}
+	procs, err := c.processes()
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "process_list_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result := protocol.ProcessListResult{Processes: []protocol.ProcessInfo{}}
+	for _, info := range procs {
+		if (p.RunnerOnly && !info.StartedByRunner) || !strings.Contains(info.Command, p.Filter) {
+			continue
+		}
+		result.Processes = append(result.Processes, info)
+	}
+	return protocol.Response{ID: req.ID, Type: "process_list_result", Success: true, Payload: result}
+}
+
+func (c *Client) handleProcessKill(req protocol.Request) protocol.Response {
+	var p protocol.ProcessKillPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "process_kill_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.killProcess(p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "process_kill_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "process_kill_result", Success: true, Payload: struct{}{}}
+}
+
+// processes lists the machine's processes, marking the runner's: those
+// descended from it or from a running job, which is detached from it.
+func (c *Client) processes() ([]protocol.ProcessInfo, error) {
+	owned := map[int]string{os.Getpid(): ""}
+	for _, j := range c.exec.Jobs.List() {
+		if j.Status == executor.JobRunning {
+			owned[j.PID] = j.JobID
+		}
+	}
+	return process.List(context.Background(), owned)
+}
+
+func (c *Client) killProcess(p protocol.ProcessKillPayload) error {
+	if p.PID <= 1 || p.PID == os.Getpid() {
+		return fmt.Errorf("refusing to kill process %d", p.PID)
+	}
+	procs, err := c.processes()
+	if err != nil {
+		return err
+	}
+	for _, info := range procs {
+		if info.PID != p.PID {
+			continue
+		}
+		if !info.StartedByRunner && !p.Force {
+			return fmt.Errorf("process %d was not started by the runner; set force to kill it anyway", p.PID)
+		}
+		return process.Signal(p.PID, p.Signal)
+	}
+	return fmt.Errorf("no process %d", p.PID)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// execProfiles converts the exec section of the config into executor
+// profiles and classifier rules. Patterns were validated by config.Load.
+func execProfiles(cfg config.ExecConfig) (map[string]executor.Profile, []executor.ClassRule) {
+	profiles := make(map[string]executor.Profile, len(cfg.Profiles))
+	for class, p := range cfg.Profiles {
+		profile := executor.Profile{
+			Timeout: p.Timeout,
+			Network: p.Network,
+		}
+		if l := p.Limits; l != nil {
+			profile.Limits = &protocol.ResourceLimits{CPUs: l.CPUs, MemoryBytes: l.Memory, MaxProcesses: l.MaxProcesses}
+		}
+		if c := p.Container; c != nil {
+			spec := &protocol.ContainerSpec{Image: c.Image, Network: c.Network}
+			for _, m := range c.Mounts {
+				spec.Mounts = append(spec.Mounts, protocol.ContainerMount{Path: m.Path, Target: m.Target, ReadOnly: m.ReadOnly})
+			}
+			profile.Container = spec
+		}
+		profiles[class] = profile
+	}
+
+	// Sort classes so rule precedence is deterministic across runs.
+	classes := make([]string, 0, len(cfg.Classes))
+	for class := range cfg.Classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	var rules []executor.ClassRule
+	for _, class := range classes {
+		for _, p := range cfg.Classes[class] {
+			rules = append(rules, executor.ClassRule{Class: class, Pattern: regexp.MustCompile(p)})
+		}
+	}
+	return profiles, rules
+}
+
+// gitHooksPolicy converts the exec.git_hooks config section, resolving
+// repository roots relative to the work dir.
+func gitHooksPolicy(cfg config.GitHooksConfig, workDir string) executor.GitHooksPolicy {
+	g := executor.GitHooksPolicy{Default: cfg.Default}
+	if len(cfg.Repos) > 0 {
+		g.Repos = make(map[string]string, len(cfg.Repos))
+		for root, p := range cfg.Repos {
+			if !filepath.IsAbs(root) {
+				root = filepath.Join(workDir, root)
+			}
+			g.Repos[filepath.Clean(root)] = p
+		}
+	}
+	return g
+}
+
+// ripgrepPath returns the rg binary search_in_files should use, or "" for
+// the built-in search.
+func ripgrepPath(cfg config.SearchConfig) string {
+	if cfg.Backend == "builtin" {
+		return ""
+	}
+	rg := cfg.Ripgrep
+	if rg == "" {
+		rg = "rg"
+	}
+	path, err := exec.LookPath(rg)
+	if err != nil {
+		if cfg.Backend == "ripgrep" {
+			log.Printf("search.backend ripgrep: %v; using the built-in search", err)
+		}
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/state"
+	"github.com/scienceol/xyzen/runner/internal/ui"
+)
+
+// recoverState reconciles resources recorded by a previous runner process
+// that did not shut down cleanly, or kept PTY sessions in tmux. PTY
+// sessions still running in tmux are reattached; others cannot be
+// re-adopted (their terminal died with the old process) and are
+// terminated. Jobs that are still running are adopted; temp workspaces
+// and session scratch directories are removed.
+func (c *Client) recoverState() []protocol.RecoveredItem {
+	records, err := c.state.List()
+	if err != nil {
+		log.Printf("Recovery: list state: %v", err)
+		return nil
+	}
+
+	self := os.Getpid()
+	var items []protocol.RecoveredItem
+	for _, r := range records {
+		if r.Owner == self || state.Alive(r.Owner) {
+			continue // ours, or another live runner's
+		}
+		item := protocol.RecoveredItem{Kind: r.Kind, ID: r.ID, PID: r.PID, Path: r.Path}
+		switch r.Kind {
+		case state.KindPTY:
+			item.Action = "exited"
+			if r.Tmux != "" {
+				if err := c.ptyMgr.Adopt(r.ID, r.Tmux); err != nil {
+					log.Printf("Recovery: reattach PTY session %s: %v", r.ID, err)
+					_ = c.state.Remove(r.Kind, r.ID)
+				} else {
+					item.Action = "reattached"
+					r.PID = c.ptyMgr.Pid(r.ID)
+					_ = c.state.Put(r)
+				}
+				break
+			}
+			if state.Alive(r.PID) {
+				if err := state.Terminate(r.PID); err != nil {
+					item.Action = "orphaned"
+				} else {
+					item.Action = "terminated"
+				}
+			}
+			_ = c.state.Remove(r.Kind, r.ID)
+		case state.KindJob:
+			if state.Alive(r.PID) {
+				item.Action = "adopted"
+				_ = c.state.Put(r)
+				c.exec.Jobs.Adopt(r.ID, r.PID, r.Path, r.Command, r.Started)
+			} else {
+				item.Action = "exited"
+				_ = c.state.Remove(r.Kind, r.ID)
+			}
+		case state.KindWorkspace:
+			item.Action = "cleaned"
+			if err := removeWorkspace(r.Path); err != nil {
+				log.Printf("Recovery: remove workspace %s: %v", r.Path, err)
+				item.Action = "orphaned"
+			}
+			_ = c.state.Remove(r.Kind, r.ID)
+		case state.KindRunner:
+			_ = c.state.Remove(r.Kind, r.ID)
+			continue
+		case state.KindSession:
+			item.Action = "cleaned"
+			if err := removeScratch(c.cfg.WorkDir, r.Path); err != nil {
+				log.Printf("Recovery: remove session scratch %s: %v", r.Path, err)
+				item.Action = "orphaned"
+			}
+			_ = c.state.Remove(r.Kind, r.ID)
+		default:
+			continue
+		}
+		items = append(items, item)
+	}
+
+	if len(items) > 0 {
+		ui.Info("Recovered %d item(s) from a previous run", len(items))
+		for _, it := range items {
+			log.Printf("Recovery: %s %s (pid %d): %s", it.Kind, it.ID, it.PID, it.Action)
+		}
+	}
+	return items
+}
+
+// removeWorkspace deletes a temp workspace, refusing paths outside the
+// managed workspaces area.
+func removeWorkspace(path string) error {
+	if path == "" {
+		return nil
+	}
+	root := gc.Dir(config.StateDir(), gc.Workspaces)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return os.ErrPermission
+	}
+	return os.RemoveAll(path)
+}
+
+// onPTYExit clears the session's state record and notifies the cloud.
+func (c *Client) onPTYExit(sessionID string, exitCode int, signal, reason string) {
+	_ = c.state.Remove(state.KindPTY, sessionID)
+	c.endPTYOutput(sessionID)
+	c.sendPTYExit(sessionID, exitCode, signal, reason)
+}
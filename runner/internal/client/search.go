@@ -0,0 +1,86 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	defaultSearchBatchSize = 20
+	// searchFlushInterval bounds how long an early match can wait for its
+	// batch to fill before being sent.
+	searchFlushInterval = 250 * time.Millisecond
+)
+
+// streamSearch runs a search and delivers matches as "search_progress"
+// batches, returning a summary once the walk completes. Batches that
+// cannot be queued are counted in the summary's DroppedMatches.
+func (c *Client) streamSearch(req protocol.Request, p protocol.SearchPayload) (protocol.SearchSummaryResult, error) {
+	batchSize := p.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSearchBatchSize
+	}
+
+	var (
+		mu      sync.Mutex
+		pending []protocol.SearchMatchResult
+		summary = protocol.SearchSummaryResult{Streamed: true}
+	)
+	flush := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+		summary.Batches++
+		// Wait for room rather than drop: a dropped batch would make the
+		// summary look complete while matches are missing.
+		sent := c.sendWait(map[string]interface{}{
+			"type": "search_progress",
+			"payload": protocol.SearchProgressPayload{
+				RequestID: req.ID,
+				Batch:     summary.Batches,
+				Matches:   pending,
+			},
+		})
+		if !sent {
+			summary.DroppedMatches += len(pending)
+		}
+		pending = nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(searchFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+
+	truncated, err := c.exec.SearchInFilesFunc(p, func(m protocol.SearchMatchResult) bool {
+		mu.Lock()
+		pending = append(pending, m)
+		summary.TotalMatches++
+		full := len(pending) >= batchSize
+		mu.Unlock()
+		if full {
+			flush()
+		}
+		return true
+	})
+	close(done)
+	flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	summary.Truncated = truncated
+	return summary, err
+}
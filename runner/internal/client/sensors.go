@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/hwinfo"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/sensors"
+)
+
+// sensorsLoop takes a sensor reading every heartbeat interval for
+// heartbeats to carry. Reading runs commands such as nvidia-smi, so it is
+// kept off the heartbeat goroutine.
+func (c *Client) sensorsLoop() {
+	ticker := time.NewTicker(c.cfg.Transport.PingInterval)
+	defer ticker.Stop()
+	for {
+		r := sensors.Read(context.Background(), c.cfg.Sensors.MaxCelsius)
+		c.sensors.Store(&r)
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ping returns a heartbeat message, carrying the latest sensor reading
+// if there is one.
+func (c *Client) ping() interface{} {
+	r := c.sensors.Load()
+	if r == nil {
+		return map[string]string{"type": "ping"}
+	}
+	return map[string]interface{}{"type": "ping", "payload": protocol.PingPayload{Sensors: r}}
+}
+
+func (c *Client) handleSensorsRead(req protocol.Request) protocol.Response {
+	r := sensors.Read(context.Background(), c.cfg.Sensors.MaxCelsius)
+	return protocol.Response{ID: req.ID, Type: "sensors_read_result", Success: true, Payload: r}
+}
+
+// hardware returns the runner's GPUs, detecting them on first use.
+func (c *Client) hardware() *protocol.HWInfo {
+	c.hwOnce.Do(func() { c.hw = hwinfo.Detect(context.Background()) })
+	return &c.hw
+}
+
+func (c *Client) handleHWInfo(req protocol.Request) protocol.Response {
+	r := hwinfo.Detect(context.Background())
+	return protocol.Response{ID: req.ID, Type: "hw_info_result", Success: true, Payload: r}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/audit"
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/state"
+)
+
+// sessionsDir is where agent session scratch directories live, relative
+// to the work dir.
+const sessionsDir = ".xyzen/sessions"
+
+var sessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func (c *Client) handleSessionStart(req protocol.Request) protocol.Response {
+	var p protocol.SessionPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result, err := c.startSession(p.SessionID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "session_start_result", Success: true, Payload: result}
+}
+
+// startSession provisions the scratch directory of an agent session, puts
+// it under the scratch quota and starts its budget afresh.
+func (c *Client) startSession(id string) (protocol.SessionStartResult, error) {
+	if !sessionID.MatchString(id) {
+		return protocol.SessionStartResult{}, fmt.Errorf("invalid session id %q", id)
+	}
+	wire := protocol.JoinPath(sessionsDir, id)
+	dir := filepath.Join(c.cfg.WorkDir, filepath.FromSlash(wire))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return protocol.SessionStartResult{}, fmt.Errorf("create scratch directory: %w", err)
+	}
+	// Keep scratch files out of the user's version control.
+	ignore := filepath.Join(c.cfg.WorkDir, ".xyzen", ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		_ = os.WriteFile(ignore, []byte("*\n"), 0o644)
+	}
+	c.exec.Quotas.Set(dir, c.cfg.Sessions.ScratchQuota)
+	c.budgets.reset(id)
+	if err := c.state.Put(state.Record{Kind: state.KindSession, ID: id, Path: dir}); err != nil {
+		log.Printf("Session %s: record state: %v", id, err)
+	}
+	return protocol.SessionStartResult{
+		SessionID:  id,
+		ScratchDir: wire,
+		QuotaBytes: c.cfg.Sessions.ScratchQuota,
+		UsedBytes:  executor.Usage(dir),
+		Budget:     c.budgets.report(id),
+	}, nil
+}
+
+func (c *Client) handleSessionEnd(req protocol.Request) protocol.Response {
+	var p protocol.SessionPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_end_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if !sessionID.MatchString(p.SessionID) {
+		return protocol.Response{ID: req.ID, Type: "session_end_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("invalid session id %q", p.SessionID)}}
+	}
+	if err := c.endSession(filepath.Join(c.cfg.WorkDir, filepath.FromSlash(sessionsDir), p.SessionID)); err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_end_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	_ = c.state.Remove(state.KindSession, p.SessionID)
+	c.budgets.reset(p.SessionID)
+	return protocol.Response{ID: req.ID, Type: "session_end_result", Success: true, Payload: struct{}{}}
+}
+
+// endSession removes a session's scratch directory and its quota.
+func (c *Client) endSession(dir string) error {
+	c.exec.Quotas.Remove(dir)
+	if err := removeScratch(c.cfg.WorkDir, dir); err != nil {
+		return fmt.Errorf("remove scratch directory: %w", err)
+	}
+	return nil
+}
+
+// endSessions removes the scratch directories of every session this
+// runner started.
+func (c *Client) endSessions() {
+	records, err := c.state.List()
+	if err != nil {
+		return
+	}
+	for _, r := range records {
+		if r.Kind == state.KindSession && r.Owner == os.Getpid() {
+			if err := c.endSession(r.Path); err != nil {
+				log.Printf("Session %s: %v", r.ID, err)
+			}
+			_ = c.state.Remove(r.Kind, r.ID)
+		}
+	}
+}
+
+// removeScratch deletes a session scratch directory, refusing paths
+// outside workDir's sessions area.
+func removeScratch(workDir, path string) error {
+	root := filepath.Join(workDir, filepath.FromSlash(sessionsDir))
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") || strings.ContainsRune(rel, filepath.Separator) {
+		return os.ErrPermission
+	}
+	return os.RemoveAll(path)
+}
+
+func (c *Client) handleSessionSummary(req protocol.Request) protocol.Response {
+	var p protocol.SessionPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_summary_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if !sessionID.MatchString(p.SessionID) {
+		return protocol.Response{ID: req.ID, Type: "session_summary_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("invalid session id %q", p.SessionID)}}
+	}
+	if c.audit == nil {
+		return protocol.Response{ID: req.ID, Type: "session_summary_result", Success: false, Payload: protocol.ErrorPayload{Error: "audit log is disabled"}}
+	}
+	heat := audit.NewHeatMap()
+	err := audit.Scan(c.cfg.Audit.Path, func(ev audit.Event) {
+		if ev.Session == p.SessionID {
+			heat.Add(ev)
+		}
+	})
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "session_summary_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	r := heat.Report()
+	result := protocol.SessionSummaryResult{
+		SessionID:      p.SessionID,
+		Requests:       r.Requests,
+		Failures:       r.Failures,
+		Commands:       r.Commands,
+		Paths:          pathHeat(r.Paths),
+		Dirs:           pathHeat(r.Dirs),
+		PathsTruncated: r.PathsTruncated,
+	}
+	if r.Requests > 0 {
+		result.Start, result.End = r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339)
+	}
+	return protocol.Response{ID: req.ID, Type: "session_summary_result", Success: true, Payload: result}
+}
+
+func pathHeat(heat []audit.PathHeat) []protocol.PathHeat {
+	out := make([]protocol.PathHeat, len(heat))
+	for i, h := range heat {
+		out[i] = protocol.PathHeat{Path: h.Path, Reads: h.Reads, Writes: h.Writes, Deletes: h.Deletes, Last: h.Last.Format(time.RFC3339)}
+	}
+	return out
+}
+
+// handleLimitsInfo reports the limits that apply to the requesting
+// session's work. It takes no payload.
+func (c *Client) handleLimitsInfo(req protocol.Request) protocol.Response {
+	return protocol.Response{ID: req.ID, Type: "limits_info_result", Success: true, Payload: c.limitsInfo(req.Session)}
+}
+
+// limitsInfo returns the limits that apply to the work of an agent
+// session, or of requests made outside one if session is empty.
+func (c *Client) limitsInfo(session string) protocol.LimitsInfoResult {
+	result := c.exec.LimitsInfo()
+	result.ApprovalMode = c.approval.isActive()
+	result.MaxPayloadBytes = maxDecodedPayload
+	if sessionID.MatchString(session) {
+		wire := protocol.JoinPath(sessionsDir, session)
+		result.Session = &protocol.SessionLimits{
+			SessionID:  session,
+			ScratchDir: wire,
+			QuotaBytes: c.cfg.Sessions.ScratchQuota,
+			UsedBytes:  executor.Usage(filepath.Join(c.cfg.WorkDir, filepath.FromSlash(wire))),
+			Budget:     c.budgets.report(session),
+		}
+	}
+	return result
+}
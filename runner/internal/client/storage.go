@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/storage"
+)
+
+const (
+	// presignTimeout bounds how long the runner waits for the cloud to
+	// presign a storage URL.
+	presignTimeout = 30 * time.Second
+	// artifactTimeout bounds an artifact_put upload.
+	artifactTimeout = 30 * time.Minute
+)
+
+// storageFor returns the backend configured for a storage area.
+func (c *Client) storageFor(area string) (storage.Backend, error) {
+	switch c.cfg.Storage.BackendFor(area) {
+	case storage.BackendS3:
+		s3 := c.cfg.Storage.S3
+		access, secret, err := s3.Keys()
+		if err != nil {
+			return nil, err
+		}
+		return &storage.S3{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    path.Join(s3.Prefix, area),
+			AccessKey: access,
+			SecretKey: secret,
+			PathStyle: s3.PathStyle,
+		}, nil
+	case storage.BackendPresigned:
+		return &storage.Presigned{Presign: func(ctx context.Context, key, method string) (storage.Grant, error) {
+			return c.presign(ctx, area, key, method)
+		}}, nil
+	default:
+		return &storage.Local{Dir: gc.Dir(config.StateDir(), area)}, nil
+	}
+}
+
+// presign asks the cloud for a URL to apply method to key in area.
+func (c *Client) presign(ctx context.Context, area, key, method string) (storage.Grant, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return storage.Grant{}, err
+	}
+	id := "presign-" + hex.EncodeToString(b)
+	ch := make(chan protocol.PresignResult, 1)
+	c.presigns.Store(id, ch)
+	defer c.presigns.Delete(id)
+
+	c.send(map[string]interface{}{
+		"type":    "storage_presign",
+		"id":      id,
+		"payload": protocol.PresignPayload{Area: area, Key: key, Method: method},
+	})
+	ctx, cancel := context.WithTimeout(ctx, presignTimeout)
+	defer cancel()
+	select {
+	case r := <-ch:
+		if r.Error != "" {
+			return storage.Grant{}, errors.New(r.Error)
+		}
+		if r.URL == "" {
+			return storage.Grant{}, errors.New("cloud returned no URL")
+		}
+		return storage.Grant{URL: r.URL, Headers: r.Headers}, nil
+	case <-ctx.Done():
+		return storage.Grant{}, fmt.Errorf("no answer from the cloud: %w", ctx.Err())
+	case <-c.stopCh:
+		return storage.Grant{}, errors.New("runner is stopping")
+	}
+}
+
+// handlePresignResult delivers the cloud's answer to a pending presign.
+func (c *Client) handlePresignResult(req protocol.Request) {
+	ch, ok := c.presigns.Load(req.ID)
+	if !ok {
+		return // timed out
+	}
+	var r protocol.PresignResult
+	if err := json.Unmarshal(req.Payload, &r); err != nil {
+		r.Error = "invalid presign result: " + err.Error()
+	}
+	select {
+	case ch.(chan protocol.PresignResult) <- r:
+	default:
+	}
+}
+
+func (c *Client) handleArtifactPut(req protocol.Request) protocol.Response {
+	var p protocol.ArtifactPutPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "artifact_put_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	obj, err := c.putArtifact(p)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "artifact_put_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "artifact_put_result", Success: true, Payload: protocol.StoredObject(obj)}
+}
+
+// putArtifact stores a workspace file under a fresh key, <id>/<name>, so
+// that local artifacts are collected as units by gc.
+func (c *Client) putArtifact(p protocol.ArtifactPutPayload) (storage.Object, error) {
+	name := p.Name
+	if name == "" {
+		name = path.Base(p.Path)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return storage.Object{}, fmt.Errorf("generate artifact id: %w", err)
+	}
+	key := hex.EncodeToString(b) + "/" + name
+	if err := storage.ValidKey(key); err != nil {
+		return storage.Object{}, fmt.Errorf("invalid artifact name %q", name)
+	}
+	backend, err := c.storageFor(gc.Artifacts)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), artifactTimeout)
+	defer cancel()
+	return c.exec.StoreArtifact(ctx, p.Path, backend, key)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// streamSet tracks long-running streams (tail follows, watches) by ID so
+// they can be cancelled individually or all at once on shutdown.
+type streamSet struct {
+	kind string // for error messages
+	max  int
+
+	mu   sync.Mutex
+	stop map[string]chan struct{}
+}
+
+func (t *streamSet) add(id string) (chan struct{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop == nil {
+		t.stop = make(map[string]chan struct{})
+	}
+	if _, exists := t.stop[id]; exists {
+		return nil, fmt.Errorf("%s %s already exists", t.kind, id)
+	}
+	if len(t.stop) >= t.max {
+		return nil, fmt.Errorf("too many active %ss (max %d)", t.kind, t.max)
+	}
+	ch := make(chan struct{})
+	t.stop[id] = ch
+	return ch, nil
+}
+
+// cancel stops a stream; it reports whether the stream existed.
+func (t *streamSet) cancel(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch, ok := t.stop[id]
+	if ok {
+		close(ch)
+		delete(t.stop, id)
+	}
+	return ok
+}
+
+func (t *streamSet) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, ch := range t.stop {
+		close(ch)
+		delete(t.stop, id)
+	}
+}
+
+func (c *Client) handleTailFile(req protocol.Request) protocol.Response {
+	var p protocol.TailFilePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tail_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	lines, offset, err := c.exec.TailFile(p.Path, p.Lines)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "tail_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	result := protocol.TailFileResult{Lines: lines}
+	if p.Follow {
+		stop, err := c.tails.add(req.ID)
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "tail_file_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		result.TailID, result.Following = req.ID, true
+		go c.followFile(req.ID, p.Path, offset, stop)
+	}
+	return protocol.Response{ID: req.ID, Type: "tail_file_result", Success: true, Payload: result}
+}
+
+// followFile streams appended lines as file_tail messages until the tail
+// is cancelled or the file can no longer be read.
+func (c *Client) followFile(tailID, path string, offset int64, stop chan struct{}) {
+	err := c.exec.FollowFile(path, offset, stop, func(lines []string, reset bool) {
+		c.send(map[string]interface{}{
+			"type":    "file_tail",
+			"payload": protocol.FileTailPayload{TailID: tailID, Path: path, Lines: lines, Reset: reset},
+		})
+	})
+	c.tails.cancel(tailID)
+	done := protocol.FileTailPayload{TailID: tailID, Path: path, Done: true}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	c.send(map[string]interface{}{"type": "file_tail", "payload": done})
+}
+
+func (c *Client) handleTailCancel(req protocol.Request) protocol.Response {
+	var p protocol.TailCancelPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tail_cancel_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if !c.tails.cancel(p.TailID) {
+		return protocol.Response{ID: req.ID, Type: "tail_cancel_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("tail %s not found", p.TailID)}}
+	}
+	return protocol.Response{ID: req.ID, Type: "tail_cancel_result", Success: true, Payload: struct{}{}}
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/audit"
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// redactCommand scrubs secrets from a command before it is logged,
+// including the values of the environment variables it was run with.
+func (c *Client) redactCommand(command string, env map[string]string) string {
+	r := c.auditRedactor
+	if len(env) > 0 {
+		values := make([]string, 0, len(env))
+		for _, v := range env {
+			values = append(values, v)
+		}
+		r = r.With(values...)
+	}
+	return string(r.Redact([]byte(command)))
+}
+
+// scriptTarget describes a run_script command for the audit log and
+// activity feed, which do not record the script itself.
+func scriptTarget(interpreter string, args []string) string {
+	return strings.Join(append([]string{interpreter, "<script>"}, args...), " ")
+}
+
+// requestTarget extracts the path or command a request operates on, for
+// the audit log. Commands are redacted.
+func (c *Client) requestTarget(req protocol.Request) string {
+	var p struct {
+		Command   string            `json:"command"`
+		Interp    string            `json:"interpreter"`
+		Args      []string          `json:"args"`
+		Env       map[string]string `json:"env"`
+		Path      string            `json:"path"`
+		Root      string            `json:"root"`
+		Source    string            `json:"source"`
+		Dest      string            `json:"destination"`
+		SessionID string            `json:"session_id"`
+		RunID     string            `json:"run_id"`
+		Machine   string            `json:"machine"`
+		JobID     string            `json:"job_id"`
+		OutputID  string            `json:"output_id"`
+		Approval  string            `json:"approval_id"`
+		Recording string            `json:"recording_id"`
+		PID       int               `json:"pid"`
+		Paths     []string          `json:"paths"`
+		Old       string            `json:"old"`
+		New       string            `json:"new"`
+	}
+	_ = json.Unmarshal(req.Payload, &p)
+	switch {
+	case p.Command != "":
+		return c.redactCommand(p.Command, p.Env)
+	case p.Interp != "":
+		return c.redactCommand(scriptTarget(p.Interp, p.Args), p.Env)
+	case p.Path != "":
+		return p.Path
+	case p.Root != "":
+		return p.Root
+	case p.Source != "":
+		return p.Source + " -> " + p.Dest
+	case p.Old != "":
+		return p.Old + " -> " + p.New
+	case len(p.Paths) > 0:
+		return strings.Join(p.Paths, ", ")
+	case p.RunID != "":
+		return p.RunID
+	case p.Machine != "":
+		return p.Machine
+	case p.JobID != "":
+		return p.JobID
+	case p.OutputID != "":
+		return p.OutputID
+	case p.Approval != "":
+		return p.Approval
+	case p.Recording != "":
+		return p.Recording
+	case p.PID != 0:
+		return strconv.Itoa(p.PID)
+	default:
+		return p.SessionID
+	}
+}
+
+// requestPaths lists the paths a request operates on, for the audit log.
+func requestPaths(req protocol.Request) []string {
+	var p struct {
+		Path   string   `json:"path"`
+		Root   string   `json:"root"`
+		Source string   `json:"source"`
+		Dest   string   `json:"destination"`
+		Paths  []string `json:"paths"`
+		Old    string   `json:"old"`
+		New    string   `json:"new"`
+		Patch  string   `json:"patch"`
+	}
+	_ = json.Unmarshal(req.Payload, &p)
+	switch {
+	case p.Patch != "":
+		patches, _ := diff.Parse(p.Patch)
+		var paths []string
+		for _, fp := range patches {
+			if fp.OldPath != diff.DevNull {
+				paths = append(paths, protocol.JoinPath(p.Root, strings.TrimPrefix(fp.OldPath, "a/")))
+			}
+			// Renames touch two paths; edits one.
+			if name := protocol.JoinPath(p.Root, strings.TrimPrefix(fp.NewPath, "b/")); fp.NewPath != diff.DevNull && (len(paths) == 0 || paths[len(paths)-1] != name) {
+				paths = append(paths, name)
+			}
+		}
+		return paths
+	case p.Path != "":
+		return []string{p.Path}
+	case p.Source != "":
+		return []string{p.Source, p.Dest}
+	case p.Old != "":
+		return []string{p.Old, p.New}
+	case len(p.Paths) > 0:
+		return p.Paths
+	case p.Root != "":
+		return []string{p.Root}
+	}
+	return nil
+}
+
+// record updates metrics and the audit log for a handled request. snap is
+// the content a write request changed, from snapshotWrite.
+func (c *Client) record(req protocol.Request, resp protocol.Response, d time.Duration, snap *writeSnapshot) {
+	c.metrics.Observe(req.Type, resp.Success, d)
+
+	// Terminal keystrokes and status and sensor polls are too chatty to
+	// audit.
+	switch req.Type {
+	case "pty_input", "pty_resize", "status", "sensors_read", "hw_info", "limits_info", "describe", "job_status":
+		return
+	}
+	ev := audit.Event{
+		Time:       time.Now().UTC(),
+		RequestID:  req.ID,
+		Type:       req.Type,
+		Target:     c.requestTarget(req),
+		Session:    req.Session,
+		Paths:      requestPaths(req),
+		Success:    resp.Success,
+		DurationMs: d.Milliseconds(),
+		DryRun:     c.dryRun(req),
+	}
+	switch e := resp.Payload.(type) {
+	case protocol.ErrorPayload:
+		ev.Error = e.Error
+	case protocol.PolicyViolationPayload:
+		ev.Error = e.Error
+	case protocol.PTYLimitPayload:
+		ev.Error = e.Error
+	}
+	c.auditDiff(&ev, req, snap)
+	c.audit.Record(ev)
+}
+
+// telemetryLoop periodically uploads aggregated metrics and audit
+// summaries as "runner_telemetry" messages while connected.
+func (c *Client) telemetryLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(c.cfg.Telemetry.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			start, end, requests := c.metrics.Take()
+			summary := c.audit.TakeSummary()
+			c.send(map[string]interface{}{
+				"type": "runner_telemetry",
+				"payload": protocol.TelemetryPayload{
+					PeriodStart:   start.UTC().Format(time.RFC3339),
+					PeriodEnd:     end.UTC().Format(time.RFC3339),
+					Requests:      requests,
+					AuditEvents:   summary.Events,
+					AuditFailures: summary.Failures,
+					AuditByType:   summary.ByType,
+					QueueDepth:    c.pool.Depth(),
+					ActiveWorkers: c.pool.Active(),
+					PTYSessions:   len(c.ptyMgr.ListSessions()),
+				},
+			})
+		}
+	}
+}
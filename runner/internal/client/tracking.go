@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/tracking"
+)
+
+const (
+	// trackingTimeout bounds a call to the tracking server other than an
+	// artifact upload, which is bounded by artifactTimeout.
+	trackingTimeout = 30 * time.Second
+	// trackingStopTimeout bounds ending each open run at shutdown.
+	trackingStopTimeout = 5 * time.Second
+)
+
+// trackedRun is a run started with run_track_start. mu serializes the
+// requests that log to it, since the run carries backend state.
+type trackedRun struct {
+	mu  sync.Mutex
+	run *tracking.Run
+}
+
+// tracker returns the configured experiment tracking server.
+func (c *Client) tracker() (tracking.Tracker, error) {
+	t := c.cfg.Tracking
+	switch t.Backend {
+	case tracking.BackendMLflow:
+		token, username, password, err := t.MLflow.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		return &tracking.MLflow{URL: t.MLflow.URL, Token: token, Username: username, Password: password}, nil
+	case tracking.BackendWandb:
+		key, err := t.Wandb.Key()
+		if err != nil {
+			return nil, err
+		}
+		return &tracking.Wandb{URL: t.Wandb.URL, APIKey: key, Entity: t.Wandb.Entity}, nil
+	}
+	return nil, errors.New("run tracking is not configured (tracking.backend)")
+}
+
+// trackedRunFor returns a run started by this runner, locked.
+func (c *Client) trackedRunFor(id string) (*trackedRun, error) {
+	v, ok := c.runs.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("run %q not found", id)
+	}
+	tr := v.(*trackedRun)
+	tr.mu.Lock()
+	return tr, nil
+}
+
+func (c *Client) handleRunTrackStart(req protocol.Request) protocol.Response {
+	var p protocol.RunTrackStartPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	t, err := c.tracker()
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), trackingTimeout)
+	defer cancel()
+	run, err := t.StartRun(ctx, p.Experiment, p.Name, p.Tags)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_start_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	c.runs.Store(run.ID, &trackedRun{run: run})
+	if len(p.Params) > 0 {
+		if err := t.LogParams(ctx, run, p.Params); err != nil {
+			return protocol.Response{ID: req.ID, Type: "run_track_start_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("run %s started, but logging params failed: %v", run.ID, err)}}
+		}
+	}
+	return protocol.Response{ID: req.ID, Type: "run_track_start_result", Success: true, Payload: protocol.TrackedRun{
+		Backend:    t.Name(),
+		RunID:      run.ID,
+		Experiment: run.Experiment,
+		Name:       run.Name,
+		URL:        run.URL,
+	}}
+}
+
+func (c *Client) handleRunTrackLog(req protocol.Request) protocol.Response {
+	var p protocol.RunTrackLogPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_log_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.logRun(p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_log_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "run_track_log_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) logRun(p protocol.RunTrackLogPayload) error {
+	metrics := make([]tracking.Metric, 0, len(p.Metrics))
+	for _, m := range p.Metrics {
+		if m.Key == "" {
+			return errors.New("metric without a key")
+		}
+		mt := tracking.Metric{Key: m.Key, Value: m.Value, Step: m.Step}
+		if m.Timestamp != "" {
+			ts, err := time.Parse(time.RFC3339Nano, m.Timestamp)
+			if err != nil {
+				return fmt.Errorf("metric %q: invalid timestamp %q", m.Key, m.Timestamp)
+			}
+			mt.Timestamp = ts
+		}
+		metrics = append(metrics, mt)
+	}
+	t, err := c.tracker()
+	if err != nil {
+		return err
+	}
+	tr, err := c.trackedRunFor(p.RunID)
+	if err != nil {
+		return err
+	}
+	defer tr.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), trackingTimeout)
+	defer cancel()
+	if len(p.Params) > 0 {
+		if err := t.LogParams(ctx, tr.run, p.Params); err != nil {
+			return err
+		}
+	}
+	if len(metrics) > 0 {
+		return t.LogMetrics(ctx, tr.run, metrics)
+	}
+	return nil
+}
+
+func (c *Client) handleRunTrackArtifact(req protocol.Request) protocol.Response {
+	var p protocol.RunTrackArtifactPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_artifact_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	name := p.Name
+	if name == "" {
+		name = path.Base(p.Path)
+	}
+	size, err := c.logRunArtifact(req, p.RunID, p.Path, name)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_artifact_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "run_track_artifact_result", Success: true, Payload: protocol.RunArtifactResult{Name: name, Size: size}}
+}
+
+func (c *Client) logRunArtifact(req protocol.Request, runID, file, name string) (int64, error) {
+	if err := tracking.ValidArtifactName(name); err != nil {
+		return 0, err
+	}
+	t, err := c.tracker()
+	if err != nil {
+		return 0, err
+	}
+	tr, err := c.trackedRunFor(runID)
+	if err != nil {
+		return 0, err
+	}
+	defer tr.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), artifactTimeout)
+	defer cancel()
+	return c.execFor(req).LogRunArtifact(ctx, file, t, tr.run, name)
+}
+
+func (c *Client) handleRunTrackEnd(req protocol.Request) protocol.Response {
+	var p protocol.RunTrackEndPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_end_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	status := p.Status
+	if status == "" {
+		status = tracking.StatusFinished
+	}
+	if err := tracking.ValidStatus(status); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_end_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.endRun(p.RunID, status, trackingTimeout); err != nil {
+		return protocol.Response{ID: req.ID, Type: "run_track_end_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "run_track_end_result", Success: true, Payload: struct{}{}}
+}
+
+// endRun ends a run on the tracking server and forgets it.
+func (c *Client) endRun(id, status string, timeout time.Duration) error {
+	t, err := c.tracker()
+	if err != nil {
+		return err
+	}
+	tr, err := c.trackedRunFor(id)
+	if err != nil {
+		return err
+	}
+	defer tr.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := t.EndRun(ctx, tr.run, status); err != nil {
+		return err
+	}
+	c.runs.Delete(id)
+	return nil
+}
+
+// endTrackedRuns ends every run still open at shutdown as killed, so that
+// the tracking server does not show them running forever.
+func (c *Client) endTrackedRuns() {
+	c.runs.Range(func(id, _ interface{}) bool {
+		if err := c.endRun(id.(string), tracking.StatusKilled, trackingStopTimeout); err != nil {
+			log.Printf("Run %s: %v", id, err)
+		}
+		return true
+	})
+}
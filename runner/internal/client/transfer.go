@@ -0,0 +1,240 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/scienceol/xyzen/runner/internal/executor"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/transfer"
+)
+
+// maxTransferChunk bounds a single transfer_read so its response stays
+// well under the response size cap after base64 encoding.
+func (c *Client) maxTransferChunk() int {
+	return min(1<<20, c.cfg.Transport.MaxResponseSize/2)
+}
+
+// spill replaces an oversized response payload with a transfer handle so
+// no single message exceeds Transport.MaxResponseSize.
+func (c *Client) spill(resp protocol.Response) protocol.Response {
+	if resp.Type == "transfer_read_result" {
+		return resp // bounded by maxTransferChunk
+	}
+	data, err := json.Marshal(resp.Payload)
+	if err != nil || len(data) <= c.cfg.Transport.MaxResponseSize {
+		return resp
+	}
+	if c.transfers == nil {
+		err = fmt.Errorf("transfer store unavailable")
+	} else {
+		info, putErr := c.transfers.Put(data, "application/json")
+		if putErr == nil {
+			log.Printf("Response %s (%s, %d bytes) spilled to transfer %s", resp.ID, resp.Type, len(data), info.ID)
+			resp.Payload = protocol.SpilledPayload{
+				Spilled:     true,
+				TransferID:  info.ID,
+				Size:        info.Size,
+				SHA256:      info.SHA256,
+				ContentType: info.ContentType,
+			}
+			return resp
+		}
+		err = putErr
+	}
+	resp.Success = false
+	resp.Payload = protocol.ErrorPayload{
+		Error: fmt.Sprintf("response too large (%d bytes) and could not be spilled: %v", len(data), err),
+		Code:  "response_too_large",
+	}
+	return resp
+}
+
+func (c *Client) handleTransferStat(req protocol.Request) protocol.Response {
+	var p protocol.TransferPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_stat_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_stat_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	info, err := c.transfers.Stat(p.TransferID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_stat_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "transfer_stat_result", Success: true, Payload: protocol.TransferInfo{
+		TransferID:  info.ID,
+		Size:        info.Size,
+		SHA256:      info.SHA256,
+		ContentType: info.ContentType,
+		Complete:    info.Complete,
+		MaxChunk:    c.maxTransferChunk(),
+		MaxParallel: c.cfg.Transport.MaxParallelChunks,
+	}}
+}
+
+func (c *Client) handleTransferRead(req protocol.Request) protocol.Response {
+	var p protocol.TransferReadPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_read_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_read_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	length := p.Length
+	if length <= 0 || length > c.maxTransferChunk() {
+		length = c.maxTransferChunk()
+	}
+	data, eof, err := c.transfers.ReadAt(p.TransferID, p.Offset, length)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_read_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "transfer_read_result", Success: true, Payload: protocol.TransferChunk{
+		TransferID: p.TransferID,
+		Offset:     p.Offset,
+		Data:       base64.StdEncoding.EncodeToString(data),
+		EOF:        eof,
+		SHA256:     transfer.Checksum(data),
+	}}
+}
+
+func (c *Client) handleTransferCreate(req protocol.Request) protocol.Response {
+	var p protocol.TransferCreatePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_create_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_create_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	info, err := c.transfers.Create(p.Size, p.SHA256, p.ContentType)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_create_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "transfer_create_result", Success: true, Payload: protocol.TransferInfo{
+		TransferID:  info.ID,
+		Size:        info.Size,
+		SHA256:      info.SHA256,
+		ContentType: info.ContentType,
+		MaxChunk:    c.maxTransferChunk(),
+		MaxParallel: c.cfg.Transport.MaxParallelChunks,
+	}}
+}
+
+func (c *Client) handleTransferWrite(req protocol.Request) protocol.Response {
+	var p protocol.TransferWritePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("base64 decode: %v", err)}}
+	}
+	if len(data) > c.maxTransferChunk() {
+		return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("chunk of %d bytes exceeds max_chunk %d", len(data), c.maxTransferChunk())}}
+	}
+	if err := c.transfers.WriteAt(p.TransferID, p.Offset, data, p.SHA256); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "transfer_write_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleTransferCommit(req protocol.Request) protocol.Response {
+	var p protocol.TransferCommitPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	path, err := c.transfers.Commit(p.TransferID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if p.Path == "" {
+		return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: true, Payload: struct{}{}}
+	}
+	if err := c.exec.PlaceFile(path, p.Path, p.Overwrite); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: false, Payload: writeError(err)}
+	}
+	_ = c.transfers.Delete(p.TransferID)
+	return protocol.Response{ID: req.ID, Type: "transfer_commit_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleTransferDelete(req protocol.Request) protocol.Response {
+	var p protocol.TransferPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_delete_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_delete_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	if err := c.transfers.Delete(p.TransferID); err != nil {
+		return protocol.Response{ID: req.ID, Type: "transfer_delete_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "transfer_delete_result", Success: true, Payload: struct{}{}}
+}
+
+func (c *Client) handleArchiveDir(req protocol.Request) protocol.Response {
+	var p protocol.ArchiveDirPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "archive_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.transfers == nil {
+		return protocol.Response{ID: req.ID, Type: "archive_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+	}
+	var stats protocol.ArchiveStats
+	info, err := c.transfers.PutStream(executor.ArchiveContentType(p.Format), func(w io.Writer) (err error) {
+		stats, err = c.execFor(req).ArchiveDir(p, w)
+		return err
+	})
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "archive_dir_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "archive_dir_result", Success: true, Payload: protocol.ArchiveDirResult{
+		TransferInfo: protocol.TransferInfo{
+			TransferID:  info.ID,
+			Size:        info.Size,
+			SHA256:      info.SHA256,
+			ContentType: info.ContentType,
+			Complete:    info.Complete,
+			MaxChunk:    c.maxTransferChunk(),
+			MaxParallel: c.cfg.Transport.MaxParallelChunks,
+		},
+		ArchiveStats: stats,
+	}}
+}
+
+func (c *Client) handleExtractArchive(req protocol.Request) protocol.Response {
+	var p protocol.ExtractArchivePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if (p.TransferID == "") == (p.Source == "") {
+		return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: false, Payload: protocol.ErrorPayload{Error: "exactly one of transfer_id and source is required"}}
+	}
+	var hostSrc string
+	if p.TransferID != "" {
+		if c.transfers == nil {
+			return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: false, Payload: protocol.ErrorPayload{Error: "transfer store unavailable"}}
+		}
+		path, err := c.transfers.DataPath(p.TransferID)
+		if err != nil {
+			return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+		}
+		hostSrc = path
+	}
+	stats, err := c.execFor(req).ExtractArchive(p, hostSrc)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: false, Payload: writeError(err)}
+	}
+	if p.TransferID != "" {
+		_ = c.transfers.Delete(p.TransferID)
+	}
+	return protocol.Response{ID: req.ID, Type: "extract_archive_result", Success: true, Payload: stats}
+}
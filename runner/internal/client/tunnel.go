@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+func (c *Client) handleTunnelOpen(req protocol.Request) protocol.Response {
+	var p protocol.TunnelOpenPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tunnel_open_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.tunnels.Open(p.TunnelID, p.Target); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tunnel_open_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "tunnel_open_result", Success: true, Payload: struct{}{}}
+}
+
+// handleDisplayOpen forwards the local display over a new tunnel. The
+// target comes from the runner's config, so the tunnel allowlist does not
+// apply.
+func (c *Client) handleDisplayOpen(req protocol.Request) protocol.Response {
+	var p protocol.DisplayOpenPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "display_open_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if c.display == nil {
+		return protocol.Response{ID: req.ID, Type: "display_open_result", Success: false, Payload: protocol.ErrorPayload{Error: "display forwarding is disabled on this runner", Code: "display_disabled"}}
+	}
+	target, err := c.display.Target()
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "display_open_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.tunnels.OpenDirect(p.TunnelID, target); err != nil {
+		return protocol.Response{ID: req.ID, Type: "display_open_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "display_open_result", Success: true, Payload: protocol.DisplayOpenResult{
+		TunnelID: p.TunnelID,
+		Protocol: c.display.Protocol(),
+	}}
+}
+
+func (c *Client) handleTunnelClose(req protocol.Request) protocol.Response {
+	var p protocol.TunnelClosePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tunnel_close_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.tunnels.Close(p.TunnelID); err != nil {
+		return protocol.Response{ID: req.ID, Type: "tunnel_close_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "tunnel_close_result", Success: true, Payload: struct{}{}}
+}
+
+// handleTunnelData forwards cloud data into a tunnel. There is no
+// response; failures close the tunnel and are reported via tunnel_closed.
+// In approval mode tunnels are closed rather than written to.
+func (c *Client) handleTunnelData(req protocol.Request) {
+	var p protocol.TunnelDataPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		log.Printf("Invalid tunnel_data payload: %v", err)
+		return
+	}
+	if c.approval.isActive() {
+		_ = c.tunnels.Close(p.TunnelID)
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		log.Printf("Tunnel %s: decode data: %v", p.TunnelID, err)
+		return
+	}
+	if err := c.tunnels.Write(p.TunnelID, data); err != nil {
+		log.Printf("Tunnel write: %v", err)
+	}
+}
+
+// sendTunnelData forwards local data to the cloud. It blocks while the
+// send queue is full, applying backpressure to the local endpoint; if the
+// data cannot be queued the tunnel is closed rather than left corrupted.
+func (c *Client) sendTunnelData(tunnelID string, data []byte) {
+	ok := c.sendWait(map[string]interface{}{
+		"type": "tunnel_data",
+		"payload": protocol.TunnelDataPayload{
+			TunnelID: tunnelID,
+			Data:     base64.StdEncoding.EncodeToString(data),
+		},
+	})
+	if !ok {
+		log.Printf("Tunnel %s: send queue stalled, closing", tunnelID)
+		go c.tunnels.Close(tunnelID)
+	}
+}
+
+func (c *Client) sendTunnelClosed(tunnelID string, err error) {
+	p := protocol.TunnelClosedPayload{TunnelID: tunnelID}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	c.send(map[string]interface{}{"type": "tunnel_closed", "payload": p})
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+func (c *Client) handleWatch(req protocol.Request) protocol.Response {
+	var p protocol.WatchPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "watch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if len(p.Paths) == 0 {
+		return protocol.Response{ID: req.ID, Type: "watch_result", Success: false, Payload: protocol.ErrorPayload{Error: "no paths to watch"}}
+	}
+	stop, err := c.watches.add(req.ID)
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "watch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	go c.streamWatch(req.ID, p, stop)
+	return protocol.Response{ID: req.ID, Type: "watch_result", Success: true, Payload: protocol.WatchResult{WatchID: req.ID}}
+}
+
+// streamWatch sends fs_event messages until the watch is cancelled or
+// fails, then a final message with Done set.
+func (c *Client) streamWatch(watchID string, p protocol.WatchPayload, stop chan struct{}) {
+	err := c.exec.Watch(p, stop, func(events []protocol.FSEvent) {
+		c.send(map[string]interface{}{
+			"type":    "fs_event",
+			"payload": protocol.FSEventPayload{WatchID: watchID, Events: events},
+		})
+	})
+	c.watches.cancel(watchID)
+	done := protocol.FSEventPayload{WatchID: watchID, Done: true}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	c.send(map[string]interface{}{"type": "fs_event", "payload": done})
+}
+
+func (c *Client) handleUnwatch(req protocol.Request) protocol.Response {
+	var p protocol.UnwatchPayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "unwatch_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if !c.watches.cancel(p.WatchID) {
+		return protocol.Response{ID: req.ID, Type: "unwatch_result", Success: false, Payload: protocol.ErrorPayload{Error: fmt.Sprintf("watch %s not found", p.WatchID)}}
+	}
+	return protocol.Response{ID: req.ID, Type: "unwatch_result", Success: true, Payload: struct{}{}}
+}
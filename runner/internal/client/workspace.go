@@ -0,0 +1,129 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/scienceol/xyzen/runner/internal/config"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/state"
+)
+
+func (c *Client) handleApplyTemplate(req protocol.Request) protocol.Response {
+	var p protocol.ApplyTemplatePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "workspace_apply_template_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	var (
+		result protocol.ApplyTemplateResult
+		err    error
+	)
+	if p.Path != "" {
+		result, err = c.applyTemplateAt(req, p)
+	} else {
+		result, err = c.applyTemplateTemp(req, p)
+	}
+	if err != nil {
+		return protocol.Response{ID: req.ID, Type: "workspace_apply_template_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "workspace_apply_template_result", Success: true, Payload: result}
+}
+
+// applyTemplateAt materializes a template into a directory of the work
+// dir, which must be new or empty unless p.Overwrite is set.
+func (c *Client) applyTemplateAt(req protocol.Request, p protocol.ApplyTemplatePayload) (protocol.ApplyTemplateResult, error) {
+	root, err := protocol.CleanPath(p.Path)
+	if err != nil {
+		return protocol.ApplyTemplateResult{}, err
+	}
+	exec := c.execFor(req)
+	if !p.Overwrite {
+		if listing, err := exec.ListFiles(protocol.ListFilesPayload{Path: root, Limit: 1}); err == nil && listing.Total > 0 {
+			return protocol.ApplyTemplateResult{}, fmt.Errorf("%q is not empty (set overwrite to apply the template anyway)", root)
+		}
+	}
+	result, err := exec.ApplyTemplate(root, p.Template, c.cfg.Templates.Secret)
+	result.Root = root
+	return result, err
+}
+
+// applyTemplateTemp materializes a template into a new temp workspace
+// under the state dir. The workspace is recorded so that it is removed
+// at shutdown, or at the next startup if the runner crashes.
+func (c *Client) applyTemplateTemp(req protocol.Request, p protocol.ApplyTemplatePayload) (protocol.ApplyTemplateResult, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return protocol.ApplyTemplateResult{}, fmt.Errorf("generate workspace id: %w", err)
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(gc.Dir(config.StateDir(), gc.Workspaces), id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return protocol.ApplyTemplateResult{}, fmt.Errorf("create workspace: %w", err)
+	}
+	c.workspaces.Store(id, dir)
+	if err := c.state.Put(state.Record{Kind: state.KindWorkspace, ID: id, Path: dir}); err != nil {
+		log.Printf("Workspace %s: record state: %v", id, err)
+	}
+	result, err := c.exec.WithCommandCheck(c.commandCheck(req)).WithWorkDir(dir).ApplyTemplate(".", p.Template, c.cfg.Templates.Secret)
+	if err != nil {
+		_ = c.removeTempWorkspace(id)
+		return result, err
+	}
+	result.WorkspaceID, result.Root = id, dir
+	return result, nil
+}
+
+func (c *Client) handleWorkspaceRemove(req protocol.Request) protocol.Response {
+	var p protocol.WorkspaceRemovePayload
+	if err := json.Unmarshal(req.Payload, &p); err != nil {
+		return protocol.Response{ID: req.ID, Type: "workspace_remove_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	if err := c.removeTempWorkspace(p.WorkspaceID); err != nil {
+		return protocol.Response{ID: req.ID, Type: "workspace_remove_result", Success: false, Payload: protocol.ErrorPayload{Error: err.Error()}}
+	}
+	return protocol.Response{ID: req.ID, Type: "workspace_remove_result", Success: true, Payload: struct{}{}}
+}
+
+// removeTempWorkspace deletes a temp workspace this runner created.
+func (c *Client) removeTempWorkspace(id string) error {
+	dir, ok := c.workspaces.Load(id)
+	if !ok {
+		return fmt.Errorf("workspace %s not found", id)
+	}
+	if err := removeWorkspace(dir.(string)); err != nil {
+		return fmt.Errorf("remove workspace: %w", err)
+	}
+	c.workspaces.Delete(id)
+	return c.state.Remove(state.KindWorkspace, id)
+}
+
+// workspaceInUse reports whether path, an entry of the workspaces GC area,
+// is a temp workspace this runner created and has not removed, or a
+// snapshot copy or script a running command uses.
+func (c *Client) workspaceInUse(path string) bool {
+	inUse := c.exec.InUse(path)
+	c.workspaces.Range(func(_, dir interface{}) bool {
+		if filepath.Clean(dir.(string)) == filepath.Clean(path) {
+			inUse = true
+			return false
+		}
+		return true
+	})
+	return inUse
+}
+
+// removeTempWorkspaces deletes every temp workspace this runner created.
+func (c *Client) removeTempWorkspaces() {
+	c.workspaces.Range(func(id, _ interface{}) bool {
+		if err := c.removeTempWorkspace(id.(string)); err != nil {
+			log.Printf("Workspace %s: %v", id, err)
+		}
+		return true
+	})
+}
@@ -2,9 +2,18 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/scienceol/xyzen/runner/internal/auth"
+	"github.com/scienceol/xyzen/runner/internal/chaos"
+	"github.com/scienceol/xyzen/runner/internal/gc"
+	"github.com/scienceol/xyzen/runner/internal/power"
 	"gopkg.in/yaml.v3"
 )
 
@@ -13,18 +22,792 @@ type Config struct {
 	URL       string `yaml:"url"`
 	WorkDir   string `yaml:"work_dir"`
 	KeepAwake bool   `yaml:"keep_awake"`
+	// DryRun makes side-effecting requests report what they would do
+	// instead of doing it, for demos and auditing agent plans. Also set
+	// by --dry-run.
+	DryRun bool `yaml:"dry_run"`
+	// DeviceName is shown in the Xyzen UI for this machine. Defaults to
+	// the hostname.
+	DeviceName string `yaml:"device_name"`
+
+	Redact    RedactConfig    `yaml:"redact"`
+	Workers   WorkersConfig   `yaml:"workers"`
+	PTY       PTYConfig       `yaml:"pty"`
+	Transport TransportConfig `yaml:"transport"`
+	Exec      ExecConfig      `yaml:"exec"`
+	Canary    CanaryConfig    `yaml:"canary"`
+	Anomaly   AnomalyConfig   `yaml:"anomaly"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	Tunnel    TunnelConfig    `yaml:"tunnel"`
+	E2E       E2EConfig       `yaml:"e2e"`
+	Display   DisplayConfig   `yaml:"display"`
+	GC        GCConfig        `yaml:"gc"`
+	Activity  ActivityConfig  `yaml:"activity"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Templates TemplatesConfig `yaml:"templates"`
+	Sessions  SessionsConfig  `yaml:"sessions"`
+	Search    SearchConfig    `yaml:"search"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Tracking  TrackingConfig  `yaml:"tracking"`
+	Sensors   SensorsConfig   `yaml:"sensors"`
+	Power     PowerConfig     `yaml:"power"`
+
+	// Chaos injects transport faults for resilience testing. It is set
+	// only by the hidden --chaos flag, never from the config file.
+	Chaos *chaos.Options `yaml:"-"`
 }
 
-// Load resolves configuration from flags > env > config file.
-func Load(flagToken, flagURL, flagWorkDir string, flagKeepAwake bool) (*Config, error) {
+// AuthConfig selects how the runner authenticates the WebSocket handshake
+// (see package auth).
+type AuthConfig struct {
+	// Scheme is "query" (default), "header", "jwt" or "exec".
+	Scheme string `yaml:"scheme"`
+	// Header carries bearer credentials. Defaults to Authorization.
+	Header string `yaml:"header"`
+	// Command is the exec scheme's credential helper, e.g.
+	// ["gcloud", "auth", "print-identity-token"].
+	Command []string `yaml:"command"`
+}
+
+// SearchConfig controls find_files and search_in_files.
+type SearchConfig struct {
+	// Backend is "auto" (default: ripgrep if rg is on PATH, else the
+	// built-in search), "ripgrep" or "builtin".
+	Backend string `yaml:"backend"`
+	// Ripgrep is the rg binary to use. Default: rg found on PATH.
+	Ripgrep string `yaml:"ripgrep"`
+	// Workers bounds the goroutines the built-in find_files and
+	// search_in_files use to walk and scan. Default GOMAXPROCS.
+	Workers int `yaml:"workers"`
+	// Index keeps a trigram index of the work dir under .xyzen/index,
+	// updated by polling for changes, that search_in_files consults to
+	// skip files that cannot match. Off by default.
+	Index bool `yaml:"index"`
+	// IndexInterval is how often the index polls for changes. Default 2s.
+	IndexInterval time.Duration `yaml:"index_interval"`
+	// MaxFindResults, MaxSearchResults and MaxFileSize (bytes) are the
+	// most files find_files, matches search_in_files, and file size
+	// search_in_files scans, that a request may ask for. Defaults 10000,
+	// 2000 and 100 MB.
+	MaxFindResults   int   `yaml:"max_find_results"`
+	MaxSearchResults int   `yaml:"max_search_results"`
+	MaxFileSize      int64 `yaml:"max_file_size"`
+}
+
+// StorageConfig selects where the runner keeps the objects it produces,
+// by storage area: "recordings", "snapshots" and "artifacts".
+type StorageConfig struct {
+	// Backend is "local" (default: under ~/.xyzen, subject to gc),
+	// "s3" (an S3-compatible bucket) or "presigned" (URLs the Xyzen
+	// backend presigns per object).
+	Backend string `yaml:"backend"`
+	// Areas overrides Backend for individual areas.
+	Areas map[string]string `yaml:"areas"`
+	S3    S3Config          `yaml:"s3"`
+}
+
+// StorageAreas are the areas whose backend can be configured.
+var StorageAreas = []string{gc.Recordings, gc.Snapshots, gc.Artifacts}
+
+// BackendFor returns the storage backend configured for area.
+func (s StorageConfig) BackendFor(area string) string {
+	if b, ok := s.Areas[area]; ok {
+		return b
+	}
+	return s.Backend
+}
+
+func (s *StorageConfig) validate() error {
+	names := map[string]string{"storage.backend": s.Backend}
+	for area, b := range s.Areas {
+		known := false
+		for _, a := range StorageAreas {
+			known = known || a == area
+		}
+		if !known {
+			return fmt.Errorf("unknown storage area %q (want one of %s)", area, strings.Join(StorageAreas, ", "))
+		}
+		names["storage.areas."+area] = b
+	}
+	usesS3 := false
+	for name, b := range names {
+		switch b {
+		case "local", "presigned":
+		case "s3":
+			usesS3 = true
+		default:
+			return fmt.Errorf("invalid %s %q (want \"local\", \"s3\" or \"presigned\")", name, b)
+		}
+	}
+	if usesS3 {
+		if s.S3.Endpoint == "" || s.S3.Bucket == "" {
+			return fmt.Errorf("storage.s3 needs an endpoint and a bucket")
+		}
+		if !validSecretRef(s.S3.AccessKey) || !validSecretRef(s.S3.SecretKey) {
+			return fmt.Errorf("storage.s3: invalid key reference (want \"env:VAR\" or \"file:PATH\")")
+		}
+	}
+	return nil
+}
+
+// S3Config configures the s3 storage backend.
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://nas.local:9000.
+	Endpoint string `yaml:"endpoint"`
+	// Region defaults to us-east-1.
+	Region string `yaml:"region"`
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to every object key.
+	Prefix string `yaml:"prefix"`
+	// AccessKey and SecretKey are secret references ("env:VAR" or
+	// "file:PATH"). Default env:AWS_ACCESS_KEY_ID and
+	// env:AWS_SECRET_ACCESS_KEY.
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// PathStyle addresses the bucket as endpoint/bucket, as most
+	// self-hosted servers require.
+	PathStyle bool `yaml:"path_style"`
+}
+
+// Keys reads the access and secret keys.
+func (s S3Config) Keys() (access, secret string, err error) {
+	if access, err = readSecret(s.AccessKey); err != nil {
+		return "", "", fmt.Errorf("storage.s3.access_key: %w", err)
+	}
+	if secret, err = readSecret(s.SecretKey); err != nil {
+		return "", "", fmt.Errorf("storage.s3.secret_key: %w", err)
+	}
+	return access, secret, nil
+}
+
+// TrackingConfig connects run_track requests to an experiment tracking
+// server.
+type TrackingConfig struct {
+	// Backend is "mlflow" or "wandb"; empty, the default, disables run
+	// tracking.
+	Backend string       `yaml:"backend"`
+	MLflow  MLflowConfig `yaml:"mlflow"`
+	Wandb   WandbConfig  `yaml:"wandb"`
+}
+
+// MLflowConfig configures the mlflow tracking backend.
+type MLflowConfig struct {
+	// URL is the tracking server, e.g. http://localhost:5000.
+	URL string `yaml:"url"`
+	// Token, or else Username and Password, are optional secret
+	// references ("env:VAR" or "file:PATH") for servers that require
+	// authentication.
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// WandbConfig configures the wandb tracking backend.
+type WandbConfig struct {
+	// URL is the API server. Defaults to http://localhost:8080, a local
+	// W&B Server.
+	URL string `yaml:"url"`
+	// APIKey is a secret reference. Default env:WANDB_API_KEY.
+	APIKey string `yaml:"api_key"`
+	// Entity is the user or team that owns the runs; empty selects the
+	// API key's default entity.
+	Entity string `yaml:"entity"`
+}
+
+func (t *TrackingConfig) validate() error {
+	switch t.Backend {
+	case "":
+	case "mlflow":
+		if t.MLflow.URL == "" {
+			return fmt.Errorf("tracking.mlflow needs a url")
+		}
+		for _, ref := range []string{t.MLflow.Token, t.MLflow.Username, t.MLflow.Password} {
+			if ref != "" && !validSecretRef(ref) {
+				return fmt.Errorf("tracking.mlflow: invalid credential reference (want \"env:VAR\" or \"file:PATH\")")
+			}
+		}
+	case "wandb":
+		if !validSecretRef(t.Wandb.APIKey) {
+			return fmt.Errorf("tracking.wandb: invalid api_key reference (want \"env:VAR\" or \"file:PATH\")")
+		}
+	default:
+		return fmt.Errorf("invalid tracking.backend %q (want \"mlflow\" or \"wandb\")", t.Backend)
+	}
+	return nil
+}
+
+// Credentials reads the configured MLflow credentials; unset ones are
+// empty.
+func (m MLflowConfig) Credentials() (token, username, password string, err error) {
+	read := func(name, ref string) (string, error) {
+		if ref == "" {
+			return "", nil
+		}
+		v, err := readSecret(ref)
+		if err != nil {
+			return "", fmt.Errorf("tracking.mlflow.%s: %w", name, err)
+		}
+		return v, nil
+	}
+	if token, err = read("token", m.Token); err != nil {
+		return "", "", "", err
+	}
+	if username, err = read("username", m.Username); err != nil {
+		return "", "", "", err
+	}
+	if password, err = read("password", m.Password); err != nil {
+		return "", "", "", err
+	}
+	return token, username, password, nil
+}
+
+// Key reads the W&B API key.
+func (w WandbConfig) Key() (string, error) {
+	key, err := readSecret(w.APIKey)
+	if err != nil {
+		return "", fmt.Errorf("tracking.wandb.api_key: %w", err)
+	}
+	return key, nil
+}
+
+// SessionsConfig controls the scratch directories provisioned for agent
+// sessions under <work_dir>/.xyzen/sessions and the budgets that bound
+// what one session may do.
+type SessionsConfig struct {
+	// ScratchQuota bounds what file operations may store in one session's
+	// scratch directory, in bytes. Default 1 GiB.
+	ScratchQuota int64 `yaml:"scratch_quota"`
+	// MaxRequests bounds how many requests one session may make. 0, the
+	// default, means no limit.
+	MaxRequests int `yaml:"max_requests"`
+	// MaxExecTime bounds the total time one session's commands may run.
+	// 0, the default, means no limit.
+	MaxExecTime time.Duration `yaml:"max_exec_time"`
+}
+
+// TemplatesConfig controls workspace_apply_template.
+type TemplatesConfig struct {
+	// Secrets maps the secret names templates may reference in env files
+	// to where the runner reads them: "env:VAR" (the runner's environment)
+	// or "file:PATH" (a local file, trailing newline trimmed). Templates
+	// can only use secrets listed here.
+	Secrets map[string]string `yaml:"secrets"`
+}
+
+// Secret returns the value of a named secret.
+func (t TemplatesConfig) Secret(name string) (string, error) {
+	ref, ok := t.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q is not configured (templates.secrets)", name)
+	}
+	v, err := readSecret(ref)
+	if err != nil {
+		return "", fmt.Errorf("secret %q: %w", name, err)
+	}
+	return v, nil
+}
+
+// readSecret resolves a secret reference: "env:VAR" or "file:PATH".
+func readSecret(ref string) (string, error) {
+	kind, arg, _ := strings.Cut(ref, ":")
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return v, nil
+	case "file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", fmt.Errorf("invalid reference %q", ref)
+}
+
+// validSecretRef reports whether ref is a well-formed secret reference.
+func validSecretRef(ref string) bool {
+	kind, arg, _ := strings.Cut(ref, ":")
+	return (kind == "env" || kind == "file") && arg != ""
+}
+
+func (t *TemplatesConfig) validate() error {
+	for name, ref := range t.Secrets {
+		if !validSecretRef(ref) {
+			return fmt.Errorf("templates.secrets.%s: invalid reference %q (want \"env:VAR\" or \"file:PATH\")", name, ref)
+		}
+	}
+	return nil
+}
+
+// ActivityConfig controls the "activity" event feed of workspace changes
+// and commands shown in the Xyzen UI timeline.
+type ActivityConfig struct {
+	// Enabled defaults to true.
+	Enabled *bool `yaml:"enabled"`
+}
+
+// IsEnabled reports whether activity events are sent.
+func (a ActivityConfig) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// SensorsConfig controls hardware sensor readings (see package sensors).
+type SensorsConfig struct {
+	// Heartbeat includes the latest reading in heartbeats. Defaults to
+	// true.
+	Heartbeat *bool `yaml:"heartbeat"`
+	// MaxCelsius is the temperature at which a reading is reported hot,
+	// whatever the sensor's own thresholds. Default 90.
+	MaxCelsius float64 `yaml:"max_celsius"`
+}
+
+// InHeartbeat reports whether heartbeats carry sensor readings.
+func (s SensorsConfig) InHeartbeat() bool {
+	return s.Heartbeat == nil || *s.Heartbeat
+}
+
+// PowerConfig lists the lab machines this runner may wake with wol_send
+// and check with power_status, keyed by the name requests use. Machines
+// not listed cannot be targeted.
+type PowerConfig struct {
+	Machines map[string]MachineConfig `yaml:"machines"`
+}
+
+// MachineConfig is a machine on this runner's network.
+type MachineConfig struct {
+	// MAC is the address of the network interface that wakes the
+	// machine, e.g. "00:11:22:33:44:55".
+	MAC string `yaml:"mac"`
+	// Broadcast is the host:port magic packets are sent to. Default
+	// 255.255.255.255:9; on a gateway with several networks, use the
+	// machine's subnet broadcast address, e.g. 192.168.1.255:9.
+	Broadcast string `yaml:"broadcast"`
+	// Host is the machine's hostname or IP address, which power_status
+	// probes. Without it only wol_send works.
+	Host string `yaml:"host"`
+	// Ports are the TCP ports probed; the machine is up if any of them
+	// accepts or refuses a connection. Default [22].
+	Ports []int `yaml:"ports"`
+}
+
+func (p *PowerConfig) validate() error {
+	for name, m := range p.Machines {
+		if _, err := power.MagicPacket(m.MAC); err != nil {
+			return fmt.Errorf("power.machines.%s: %v", name, err)
+		}
+		if m.Broadcast != "" {
+			if _, _, err := net.SplitHostPort(m.Broadcast); err != nil {
+				return fmt.Errorf("power.machines.%s: invalid broadcast %q: %v", name, m.Broadcast, err)
+			}
+		}
+		for _, port := range m.Ports {
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("power.machines.%s: invalid port %d", name, port)
+			}
+		}
+	}
+	return nil
+}
+
+// GCConfig controls garbage collection of runner-managed storage under
+// ~/.xyzen (see package gc for the areas).
+type GCConfig struct {
+	// Interval between background runs. Default 1h; negative disables
+	// background collection (`xyzen gc` still works).
+	Interval time.Duration `yaml:"interval"`
+	// Retention overrides the default policy per area. A zero field keeps
+	// the default; a negative one removes the limit.
+	Retention map[string]RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig bounds one storage area.
+type RetentionConfig struct {
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxSize is the total size in bytes.
+	MaxSize int64 `yaml:"max_size"`
+}
+
+// defaultRetention is the built-in policy per GC area.
+var defaultRetention = map[string]gc.Policy{
+	gc.Trash:      {MaxAge: 7 * 24 * time.Hour, MaxSize: 5 << 30},
+	gc.Snapshots:  {MaxAge: 14 * 24 * time.Hour, MaxSize: 5 << 30},
+	gc.Recordings: {MaxAge: 30 * 24 * time.Hour, MaxSize: 2 << 30},
+	gc.Jobs:       {MaxAge: 7 * 24 * time.Hour, MaxSize: 1 << 30},
+	gc.Artifacts:  {MaxAge: 30 * 24 * time.Hour, MaxSize: 5 << 30},
+	gc.Cache:      {MaxAge: 30 * 24 * time.Hour, MaxSize: 10 << 30},
+	gc.Workspaces: {MaxAge: 24 * time.Hour},
+	gc.Transfers:  {MaxAge: 24 * time.Hour, MaxSize: 5 << 30},
+	gc.Crashes:    {MaxAge: 7 * 24 * time.Hour, MaxSize: 5 << 30},
+	gc.ExecOutput: {MaxAge: 24 * time.Hour, MaxSize: 2 << 30},
+}
+
+// GCAreas returns the managed storage areas with their effective
+// retention policies. The trash is the work dir's (see gc.TrashDir).
+func (c *Config) GCAreas() []gc.Area {
+	areas := make([]gc.Area, 0, len(gc.AreaNames))
+	for _, name := range gc.AreaNames {
+		p := defaultRetention[name]
+		if o, ok := c.GC.Retention[name]; ok {
+			if o.MaxAge != 0 {
+				p.MaxAge = max(o.MaxAge, 0)
+			}
+			if o.MaxSize != 0 {
+				p.MaxSize = max(o.MaxSize, 0)
+			}
+		}
+		dir := gc.Dir(StateDir(), name)
+		if name == gc.Trash && c.WorkDir != "" {
+			dir = gc.TrashDir(c.WorkDir)
+		}
+		areas = append(areas, gc.Area{Name: name, Dir: dir, Policy: p})
+	}
+	return areas
+}
+
+// DisplayConfig controls experimental forwarding of the local graphical
+// display (VNC or Xpra) to the cloud UI. Off by default.
+type DisplayConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode is "vnc" (attach to a running VNC server, default) or "xpra"
+	// (start an Xpra server on demand).
+	Mode string `yaml:"mode"`
+	// Address of the VNC server. Default tcp://127.0.0.1:5900.
+	Address string `yaml:"address"`
+	// XpraDisplay is the X display Xpra serves. Default ":100".
+	XpraDisplay string `yaml:"xpra_display"`
+	// XpraPort is the loopback port Xpra listens on. Default 14500.
+	XpraPort int `yaml:"xpra_port"`
+}
+
+// E2EConfig controls end-to-end encryption of payload bodies between the
+// agent frontend and the runner.
+type E2EConfig struct {
+	// Mode is "off", "optional" (default; encrypt once the frontend
+	// negotiates a key) or "required" (reject plaintext requests).
+	Mode string `yaml:"mode"`
+}
+
+// TunnelConfig controls forwarding of local sockets and named pipes.
+type TunnelConfig struct {
+	// Allow lists the targets the cloud may open, as exact addresses or
+	// globs, e.g. "unix:///var/run/docker.sock" or "npipe://docker_engine".
+	// They match a target's canonical form: a socket path is cleaned, and
+	// targets with escapes, queries or users are refused. Empty disables
+	// tunneling.
+	Allow []string `yaml:"allow"`
+}
+
+// AuditConfig controls the local audit log of handled requests.
+type AuditConfig struct {
+	// Enabled defaults to true.
+	Enabled *bool `yaml:"enabled"`
+	// Path defaults to ~/.xyzen/audit.log.
+	Path string `yaml:"path"`
+	// MaxSize rotates the log to <path>.1 once it reaches this many
+	// bytes, so the log takes at most twice that. Default 64 MB.
+	MaxSize int64 `yaml:"max_size"`
+}
+
+// IsEnabled reports whether the audit log should be written.
+func (a AuditConfig) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// TelemetryConfig controls the opt-in upload of aggregated metrics and
+// audit summaries to the backend.
+type TelemetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval between uploads. Default 5m.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// AnomalyConfig controls the local detector that pauses side-effecting
+// requests when agent behavior looks suspicious.
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is the sliding window for rate-based rules. Default 1m.
+	Window time.Duration `yaml:"window"`
+	// MaxDeletes is the number of deletes per window that triggers
+	// approval mode, counting destinations a move or copy replaces.
+	// Default 20.
+	MaxDeletes int `yaml:"max_deletes"`
+	// MaxReadDirs is the number of distinct directories read per window
+	// that triggers approval mode. Default 50.
+	MaxReadDirs int `yaml:"max_read_dirs"`
+}
+
+// CanaryConfig controls tripwire files holding fake credentials. Any
+// request that touches them raises a security alert locally and in the
+// cloud.
+type CanaryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir holds the default canary set. Defaults to ~/.xyzen/canary.
+	Dir string `yaml:"dir"`
+	// Paths plants canaries at explicit locations instead of the default set.
+	Paths []string `yaml:"paths"`
+}
+
+// validate refuses a default canary set inside the work dir or in one of
+// its ancestors: requests would trip it by listing their own workspace,
+// or not be able to reach the workspace at all.
+func (c CanaryConfig) validate(workDir string) error {
+	if !c.Enabled || len(c.Paths) > 0 {
+		return nil
+	}
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid canary.dir: %w", err)
+	}
+	for _, pair := range [][2]string{{workDir, dir}, {dir, workDir}} {
+		if rel, err := filepath.Rel(pair[0], pair[1]); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("canary.dir %s overlaps the work dir %s; set canary.dir outside it", dir, workDir)
+		}
+	}
+	return nil
+}
+
+// ExecConfig controls how commands from the cloud are executed.
+type ExecConfig struct {
+	// Profiles maps command classes (build, test, package-install,
+	// network-tools, default) to execution profiles. Configure "default"
+	// to set the policy for commands no rule matches.
+	Profiles map[string]ExecProfile `yaml:"profiles"`
+	// Classes adds classifier rules: class name → regular expressions
+	// matched against the command line. These take precedence over the
+	// built-in rules.
+	Classes map[string][]string `yaml:"classes"`
+	// GitHooks controls whether git commands run by agents execute
+	// repository hooks.
+	GitHooks GitHooksConfig `yaml:"git_hooks"`
+	// SnapshotMaxSize bounds the bytes of files copied to run a command
+	// with execute_in: snapshot. Default 2 GB.
+	SnapshotMaxSize int64 `yaml:"snapshot_max_size"`
+	// ContainerRuntime is the docker or podman binary that runs commands
+	// requested with a container. Default: docker, else podman, found on
+	// PATH.
+	ContainerRuntime string `yaml:"container_runtime"`
+	// ProgressInterval is how often an exec_progress message reports on
+	// a command still running. Default 10s; negative disables them.
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	// Limits caps the resources of every command, so that one agent's
+	// test run cannot starve a shared machine. Requests may lower them.
+	Limits ResourceLimitsConfig `yaml:"limits"`
+	// Priority runs every command at "normal" (the default), "low" or
+	// "idle" CPU and IO priority, so agent work on a shared machine
+	// never makes the user's own session sluggish. Requests may lower it.
+	Priority string `yaml:"priority"`
+	// Policy decides which commands agents may run without the user's
+	// approval. It does not see what is typed into a PTY session, so it
+	// does not bind agents that may open one.
+	Policy PolicyConfig `yaml:"policy"`
+}
+
+// PolicyConfig is the command policy of exec, run_script, job_start,
+// template setup commands, the programs pty_create starts and those
+// pty_close detaches into jobs (see package policy). Rules are regular
+// expressions matched against the command line (for run_script, the
+// script). A denied command fails with a policy violation the user can
+// approve from the Xyzen UI.
+//
+// The policy is not applied to input typed into a PTY session: a shell
+// started with pty_create runs whatever it is sent. To keep agents to
+// the policy, deny the shells themselves, e.g. with default "deny".
+type PolicyConfig struct {
+	// Deny refuses matching commands, whatever else matches.
+	Deny []string `yaml:"deny"`
+	// Allow lets matching commands run, including sensitive ones.
+	Allow []string `yaml:"allow"`
+	// Default is "allow" (the default) or "deny" for commands no rule
+	// matches.
+	Default string `yaml:"default"`
+	// Sensitive denies built-in dangerous commands such as rm -rf /,
+	// curl | sh and dd to a disk unless an allow rule matches.
+	// Defaults to true.
+	Sensitive *bool `yaml:"sensitive"`
+}
+
+// DenySensitive reports whether the built-in sensitive rules apply.
+func (p PolicyConfig) DenySensitive() bool {
+	return p.Sensitive == nil || *p.Sensitive
+}
+
+// ResourceLimitsConfig bounds a command's resources, through cgroups v2
+// on Linux when the runner's cgroup can be delegated to, and ulimit
+// otherwise. Windows enforces none.
+type ResourceLimitsConfig struct {
+	// CPUs is the share of CPU time, e.g. 2 for two CPUs. cgroups only.
+	CPUs float64 `yaml:"cpus"`
+	// Memory is the memory limit in bytes.
+	Memory int64 `yaml:"memory"`
+	// MaxProcesses bounds the processes a command runs at once. Under
+	// ulimit this counts all of the user's processes, so set it well
+	// above what the user normally runs.
+	MaxProcesses int `yaml:"max_processes"`
+}
+
+// GitHooksConfig sets the git hooks policy: "run" or "bypass" (as if
+// every git command had --no-verify). Requests may override it.
+type GitHooksConfig struct {
+	// Default applies outside the listed repositories. Default "run".
+	Default string `yaml:"default"`
+	// Repos sets the policy per repository, keyed by its root directory
+	// (absolute, or relative to the work dir).
+	Repos map[string]string `yaml:"repos"`
+}
+
+// ExecProfile is the execution environment for one class of commands.
+type ExecProfile struct {
+	// Timeout is the default timeout in seconds for this class.
+	Timeout int `yaml:"timeout"`
+	// Network is "allow" (default) or "deny".
+	Network string `yaml:"network"`
+	// Limits caps the resources of this class's commands, used when a
+	// request sets none. They may lower exec.limits but not raise them.
+	Limits *ResourceLimitsConfig `yaml:"limits"`
+	// Container runs this class's commands in a container, used when a
+	// request does not ask for one.
+	Container *ContainerConfig `yaml:"container"`
+}
+
+// ContainerConfig is the container a class of commands runs in.
+type ContainerConfig struct {
+	// Image is the container image, e.g. "python:3.12".
+	Image string `yaml:"image"`
+	// Network is "none" (default) or "bridge".
+	Network string `yaml:"network"`
+	// Mounts bind-mount work dir paths into the container, whose work
+	// dir is always mounted at /workspace.
+	Mounts []ContainerMountConfig `yaml:"mounts"`
+}
+
+// ContainerMountConfig bind-mounts Path, a work dir path, at Target, an
+// absolute path in the container.
+type ContainerMountConfig struct {
+	Path     string `yaml:"path"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+// TransportConfig tunes the WebSocket connection. The defaults suit normal
+// broadband; high-latency links (e.g. satellite) may need longer timeouts
+// and larger buffers.
+type TransportConfig struct {
+	// PingInterval is how often the runner sends a heartbeat. Default 20s.
+	PingInterval time.Duration `yaml:"ping_interval"`
+	// WriteTimeout bounds a single WebSocket write. Default 10s.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// HandshakeTimeout bounds the WebSocket upgrade. Default 45s.
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout"`
+	// ReadBufferSize and WriteBufferSize are the dialer's I/O buffer sizes
+	// in bytes. Default 4096 each.
+	ReadBufferSize  int `yaml:"read_buffer_size"`
+	WriteBufferSize int `yaml:"write_buffer_size"`
+	// SendQueueSize is the number of outgoing messages buffered per
+	// priority lane before new ones are dropped. Default 256.
+	SendQueueSize int `yaml:"send_queue_size"`
+	// MaxParallelChunks bounds concurrent chunk reads/writes per chunked
+	// transfer. Default 4.
+	MaxParallelChunks int `yaml:"max_parallel_chunks"`
+	// MaxResponseSize caps the encoded payload of a single response in
+	// bytes. Larger results are spilled to a local transfer and replaced
+	// by a handle fetched with transfer_read. Default 4 MiB.
+	MaxResponseSize int `yaml:"max_response_size"`
+}
+
+// PTYConfig holds terminal session settings.
+type PTYConfig struct {
+	// ResizeMode is "latest" (apply each viewer's size as it arrives) or
+	// "largest" (use the largest geometry across attached viewers).
+	ResizeMode string `yaml:"resize_mode"`
+	// ResizeDebounce merges bursts of resize requests. Defaults to 50ms;
+	// a negative value disables debouncing.
+	ResizeDebounce time.Duration `yaml:"resize_debounce"`
+	// Record records terminal sessions to asciicast v2 files under
+	// <work_dir>/.xyzen/recordings, unless pty_create says otherwise.
+	Record bool `yaml:"record"`
+	// IdleTimeout closes sessions with no input or output for this long,
+	// so those the cloud never closes do not linger. Defaults to 24h; a
+	// negative value disables it.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// MaxSessions caps the concurrent sessions, so a runaway agent cannot
+	// open shells without end. Defaults to 32; a negative value removes
+	// the cap. OnLimit is "reject" (the default: pty_create fails) or
+	// "evict_lru" (close the least recently active session instead).
+	MaxSessions int    `yaml:"max_sessions"`
+	OnLimit     string `yaml:"on_limit"`
+	// Backend is "direct" (the default: sessions end with the runner) or
+	// "tmux", which keeps each session's program in tmux so it survives
+	// runner restarts and upgrades; the runner reattaches on startup.
+	// tmux must be installed, and it is not supported on Windows.
+	Backend string `yaml:"backend"`
+}
+
+// WorkersConfig sizes the pool that handles requests from the cloud.
+type WorkersConfig struct {
+	// Size is the number of requests handled concurrently.
+	// Defaults to the number of CPUs, with a minimum of 4.
+	Size int `yaml:"size"`
+	// QueueSize is the number of requests that may wait for a free worker
+	// before new requests are rejected. Defaults to 256.
+	QueueSize int `yaml:"queue_size"`
+	// DedupSize is the number of recent side-effecting request IDs whose
+	// responses are kept to answer cloud retries. Defaults to 512.
+	DedupSize int `yaml:"dedup_size"`
+	// DedupMaxBytes bounds the memory those responses take. Responses
+	// over 64 KB are kept as their status only. Defaults to 32 MB.
+	DedupMaxBytes int64 `yaml:"dedup_max_bytes"`
+}
+
+// RedactConfig controls scrubbing of secrets from data leaving the machine.
+type RedactConfig struct {
+	// PTYOutput enables redaction of terminal output. Defaults to true.
+	PTYOutput *bool `yaml:"pty_output"`
+	// Patterns are extra regular expressions whose matches are redacted.
+	Patterns []string `yaml:"patterns"`
+	// Workspaces overrides the settings above per work directory, keyed by
+	// absolute path. Override patterns are added to the global ones.
+	Workspaces map[string]RedactConfig `yaml:"workspaces"`
+}
+
+// RedactPTYOutput reports whether PTY output should be redacted.
+func (r RedactConfig) RedactPTYOutput() bool {
+	return r.PTYOutput == nil || *r.PTYOutput
+}
+
+// LoadLocal reads the config file for commands that only manage local
+// state and need no server credentials (e.g. `xyzen gc`).
+func LoadLocal() (*Config, error) {
 	cfg := &Config{}
+	readConfigFile(cfg)
+	if v := os.Getenv("XYZEN_RUNNER_WORK_DIR"); v != "" {
+		cfg.WorkDir = v
+	}
+	cfg.applyDefaults()
+	if err := cfg.GC.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	// 1. Load config file as base
+func readConfigFile(cfg *Config) {
 	if cfgPath := configFilePath(); cfgPath != "" {
 		if data, err := os.ReadFile(cfgPath); err == nil {
 			_ = yaml.Unmarshal(data, cfg)
 		}
 	}
+}
+
+// Load resolves configuration from flags > env > config file.
+func Load(flagToken, flagURL, flagWorkDir string, flagKeepAwake bool) (*Config, error) {
+	cfg := &Config{}
+
+	// 1. Load config file as base
+	readConfigFile(cfg)
 
 	// 2. Environment variables override config file
 	if v := os.Getenv("XYZEN_RUNNER_TOKEN"); v != "" {
@@ -36,11 +819,23 @@ func Load(flagToken, flagURL, flagWorkDir string, flagKeepAwake bool) (*Config,
 	if v := os.Getenv("XYZEN_RUNNER_WORK_DIR"); v != "" {
 		cfg.WorkDir = v
 	}
+	if v := os.Getenv("XYZEN_RUNNER_DEVICE_NAME"); v != "" {
+		cfg.DeviceName = v
+	}
+	if v := os.Getenv("XYZEN_RUNNER_E2E"); v != "" {
+		cfg.E2E.Mode = v
+	}
+	if v := os.Getenv("XYZEN_RUNNER_AUTH"); v != "" {
+		cfg.Auth.Scheme = v
+	}
 
 	// 2b. Environment variable for keep_awake
 	if v := os.Getenv("XYZEN_RUNNER_KEEP_AWAKE"); v == "1" || v == "true" {
 		cfg.KeepAwake = true
 	}
+	if v := os.Getenv("XYZEN_RUNNER_DRY_RUN"); v == "1" || v == "true" {
+		cfg.DryRun = true
+	}
 
 	// 3. CLI flags override everything
 	if flagToken != "" {
@@ -57,12 +852,12 @@ func Load(flagToken, flagURL, flagWorkDir string, flagKeepAwake bool) (*Config,
 	}
 
 	// Validate required fields
-	if cfg.Token == "" {
-		return nil, fmt.Errorf("runner token is required (--token, XYZEN_RUNNER_TOKEN, or config file)")
-	}
 	if cfg.URL == "" {
 		return nil, fmt.Errorf("server URL is required (--url, XYZEN_RUNNER_URL, or config file)")
 	}
+	if err := cfg.Auth.validate(cfg.Token); err != nil {
+		return nil, err
+	}
 
 	// Default working directory to cwd
 	if cfg.WorkDir == "" {
@@ -80,17 +875,333 @@ func Load(flagToken, flagURL, flagWorkDir string, flagKeepAwake bool) (*Config,
 	}
 	cfg.WorkDir = abs
 
+	if err := cfg.resolveRedact(); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+	if cfg.PTY.ResizeMode != "latest" && cfg.PTY.ResizeMode != "largest" {
+		return nil, fmt.Errorf("invalid pty.resize_mode %q (want \"latest\" or \"largest\")", cfg.PTY.ResizeMode)
+	}
+	if cfg.PTY.OnLimit != "reject" && cfg.PTY.OnLimit != "evict_lru" {
+		return nil, fmt.Errorf("invalid pty.on_limit %q (want \"reject\" or \"evict_lru\")", cfg.PTY.OnLimit)
+	}
+	switch cfg.PTY.Backend {
+	case "direct":
+	case "tmux":
+		if runtime.GOOS == "windows" {
+			return nil, fmt.Errorf("pty.backend \"tmux\" is not supported on Windows")
+		}
+	default:
+		return nil, fmt.Errorf("invalid pty.backend %q (want \"direct\" or \"tmux\")", cfg.PTY.Backend)
+	}
+	if err := cfg.Canary.validate(cfg.WorkDir); err != nil {
+		return nil, err
+	}
+	if err := cfg.Exec.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.GC.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Templates.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Storage.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Tracking.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Power.validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Search.Backend {
+	case "auto", "ripgrep", "builtin":
+	default:
+		return nil, fmt.Errorf("invalid search.backend %q (want \"auto\", \"ripgrep\" or \"builtin\")", cfg.Search.Backend)
+	}
+	if cfg.Display.Mode != "vnc" && cfg.Display.Mode != "xpra" {
+		return nil, fmt.Errorf("invalid display.mode %q (want \"vnc\" or \"xpra\")", cfg.Display.Mode)
+	}
+	switch cfg.E2E.Mode {
+	case "off", "optional", "required":
+	default:
+		return nil, fmt.Errorf("invalid e2e.mode %q (want \"off\", \"optional\" or \"required\")", cfg.E2E.Mode)
+	}
+
 	return cfg, nil
 }
 
-func configFilePath() string {
+func (a *AuthConfig) validate(token string) error {
+	if a.Scheme == "" {
+		a.Scheme = auth.Query
+	}
+	switch a.Scheme {
+	case auth.Query, auth.Header:
+		if token == "" {
+			return fmt.Errorf("runner token is required (--token, XYZEN_RUNNER_TOKEN, or config file)")
+		}
+	case auth.JWT:
+		if token == "" {
+			if _, err := auth.LoadCredentials(StateDir()); err != nil {
+				return fmt.Errorf("auth.scheme jwt needs a runner token or a prior `xyzen login`")
+			}
+		}
+	case auth.Exec:
+		if len(a.Command) == 0 {
+			return fmt.Errorf("auth.command is required for auth.scheme exec")
+		}
+	default:
+		return fmt.Errorf("invalid auth.scheme %q (want one of %s)", a.Scheme, strings.Join(auth.Schemes, ", "))
+	}
+	return nil
+}
+
+// applyDefaults fills in zero-valued tunables.
+func (c *Config) applyDefaults() {
+	if c.Workers.Size <= 0 {
+		c.Workers.Size = runtime.NumCPU()
+		if c.Workers.Size < 4 {
+			c.Workers.Size = 4
+		}
+	}
+	if c.Workers.QueueSize <= 0 {
+		c.Workers.QueueSize = 256
+	}
+	if c.Workers.DedupSize <= 0 {
+		c.Workers.DedupSize = 512
+	}
+	if c.Workers.DedupMaxBytes <= 0 {
+		c.Workers.DedupMaxBytes = 32 << 20
+	}
+	if c.Transport.PingInterval <= 0 {
+		c.Transport.PingInterval = 20 * time.Second
+	}
+	if c.Transport.WriteTimeout <= 0 {
+		c.Transport.WriteTimeout = 10 * time.Second
+	}
+	if c.Transport.HandshakeTimeout <= 0 {
+		c.Transport.HandshakeTimeout = 45 * time.Second
+	}
+	if c.Transport.ReadBufferSize <= 0 {
+		c.Transport.ReadBufferSize = 4096
+	}
+	if c.Transport.WriteBufferSize <= 0 {
+		c.Transport.WriteBufferSize = 4096
+	}
+	if c.Transport.SendQueueSize <= 0 {
+		c.Transport.SendQueueSize = 256
+	}
+	if c.Transport.MaxParallelChunks <= 0 {
+		c.Transport.MaxParallelChunks = 4
+	}
+	if c.Transport.MaxResponseSize <= 0 {
+		c.Transport.MaxResponseSize = 4 << 20
+	}
+	if c.DeviceName == "" {
+		c.DeviceName, _ = os.Hostname()
+	}
+	if c.Audit.Path == "" {
+		c.Audit.Path = filepath.Join(StateDir(), "audit.log")
+	}
+	if c.Audit.MaxSize <= 0 {
+		c.Audit.MaxSize = 64 << 20
+	}
+	if c.Search.Backend == "" {
+		c.Search.Backend = "auto"
+	}
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = "local"
+	}
+	if c.Storage.S3.AccessKey == "" {
+		c.Storage.S3.AccessKey = "env:AWS_ACCESS_KEY_ID"
+	}
+	if c.Storage.S3.SecretKey == "" {
+		c.Storage.S3.SecretKey = "env:AWS_SECRET_ACCESS_KEY"
+	}
+	if c.Sensors.MaxCelsius <= 0 {
+		c.Sensors.MaxCelsius = 90
+	}
+	for name, m := range c.Power.Machines {
+		if m.Broadcast == "" {
+			m.Broadcast = power.DefaultBroadcast
+		}
+		if len(m.Ports) == 0 {
+			m.Ports = []int{22}
+		}
+		c.Power.Machines[name] = m
+	}
+	if c.Tracking.Wandb.URL == "" {
+		c.Tracking.Wandb.URL = "http://localhost:8080"
+	}
+	if c.Tracking.Wandb.APIKey == "" {
+		c.Tracking.Wandb.APIKey = "env:WANDB_API_KEY"
+	}
+	if c.Search.IndexInterval <= 0 {
+		c.Search.IndexInterval = 2 * time.Second
+	}
+	if c.Search.MaxFindResults <= 0 {
+		c.Search.MaxFindResults = 10000
+	}
+	if c.Search.MaxSearchResults <= 0 {
+		c.Search.MaxSearchResults = 2000
+	}
+	if c.Search.MaxFileSize <= 0 {
+		c.Search.MaxFileSize = 100 << 20
+	}
+	if c.Sessions.ScratchQuota <= 0 {
+		c.Sessions.ScratchQuota = 1 << 30
+	}
+	if c.Telemetry.Interval <= 0 {
+		c.Telemetry.Interval = 5 * time.Minute
+	}
+	if c.Canary.Dir == "" {
+		c.Canary.Dir = filepath.Join(StateDir(), "canary")
+	}
+	if c.PTY.ResizeMode == "" {
+		c.PTY.ResizeMode = "latest"
+	}
+	if c.E2E.Mode == "" {
+		c.E2E.Mode = "optional"
+	}
+	if c.Exec.ProgressInterval == 0 {
+		c.Exec.ProgressInterval = 10 * time.Second
+	}
+	if c.GC.Interval == 0 {
+		c.GC.Interval = time.Hour
+	}
+	if c.Display.Mode == "" {
+		c.Display.Mode = "vnc"
+	}
+	if c.Display.Address == "" {
+		c.Display.Address = "tcp://127.0.0.1:5900"
+	}
+	if c.Display.XpraDisplay == "" {
+		c.Display.XpraDisplay = ":100"
+	}
+	if c.Display.XpraPort <= 0 {
+		c.Display.XpraPort = 14500
+	}
+	if c.PTY.ResizeDebounce == 0 {
+		c.PTY.ResizeDebounce = 50 * time.Millisecond
+	}
+	if c.PTY.IdleTimeout == 0 {
+		c.PTY.IdleTimeout = 24 * time.Hour
+	}
+	if c.PTY.MaxSessions == 0 {
+		c.PTY.MaxSessions = 32
+	}
+	if c.PTY.OnLimit == "" {
+		c.PTY.OnLimit = "reject"
+	}
+	if c.PTY.Backend == "" {
+		c.PTY.Backend = "direct"
+	}
+}
+
+// resolveRedact applies the workspace-specific redaction override for
+// WorkDir (if any) and validates the resulting patterns.
+func (c *Config) resolveRedact() error {
+	for dir, ws := range c.Redact.Workspaces {
+		abs, err := filepath.Abs(dir)
+		if err != nil || abs != c.WorkDir {
+			continue
+		}
+		if ws.PTYOutput != nil {
+			c.Redact.PTYOutput = ws.PTYOutput
+		}
+		c.Redact.Patterns = append(c.Redact.Patterns, ws.Patterns...)
+	}
+	c.Redact.Workspaces = nil
+
+	if v := os.Getenv("XYZEN_RUNNER_REDACT_PTY"); v != "" {
+		enabled := v == "1" || v == "true"
+		c.Redact.PTYOutput = &enabled
+	}
+
+	for _, p := range c.Redact.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// StateDir returns the runner's per-user state directory (~/.xyzen),
+// falling back to a directory under the system temp dir if the home
+// directory is unknown.
+func StateDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return ""
+		return filepath.Join(os.TempDir(), "xyzen")
 	}
-	p := filepath.Join(home, ".xyzen", "config.yaml")
+	return filepath.Join(home, ".xyzen")
+}
+
+func configFilePath() string {
+	p := filepath.Join(StateDir(), "config.yaml")
 	if _, err := os.Stat(p); err == nil {
 		return p
 	}
 	return ""
 }
+
+func (g *GCConfig) validate() error {
+	for area := range g.Retention {
+		if _, ok := defaultRetention[area]; !ok {
+			return fmt.Errorf("gc.retention: unknown area %q", area)
+		}
+	}
+	return nil
+}
+
+func (e *ExecConfig) validate() error {
+	if p := e.GitHooks.Default; p != "" && p != "run" && p != "bypass" {
+		return fmt.Errorf("exec.git_hooks.default: invalid policy %q (want \"run\" or \"bypass\")", p)
+	}
+	for repo, p := range e.GitHooks.Repos {
+		if p != "run" && p != "bypass" {
+			return fmt.Errorf("exec.git_hooks.repos.%s: invalid policy %q (want \"run\" or \"bypass\")", repo, p)
+		}
+	}
+	if e.Limits.CPUs < 0 || e.Limits.Memory < 0 || e.Limits.MaxProcesses < 0 {
+		return fmt.Errorf("exec.limits: limits must not be negative")
+	}
+	if p := e.Policy.Default; p != "" && p != "allow" && p != "deny" {
+		return fmt.Errorf("exec.policy.default: invalid default %q (want \"allow\" or \"deny\")", p)
+	}
+	for kind, patterns := range map[string][]string{"allow": e.Policy.Allow, "deny": e.Policy.Deny} {
+		for _, p := range patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("exec.policy.%s: invalid pattern %q: %w", kind, p, err)
+			}
+		}
+	}
+	if p := e.Priority; p != "" && p != "normal" && p != "low" && p != "idle" {
+		return fmt.Errorf("exec.priority: invalid priority %q (want \"normal\", \"low\" or \"idle\")", p)
+	}
+	for class, p := range e.Profiles {
+		if p.Network != "" && p.Network != "allow" && p.Network != "deny" {
+			return fmt.Errorf("exec.profiles.%s: invalid network %q (want \"allow\" or \"deny\")", class, p.Network)
+		}
+		if l := p.Limits; l != nil && (l.CPUs < 0 || l.Memory < 0 || l.MaxProcesses < 0) {
+			return fmt.Errorf("exec.profiles.%s.limits: limits must not be negative", class)
+		}
+		if c := p.Container; c != nil {
+			if c.Image == "" {
+				return fmt.Errorf("exec.profiles.%s.container: image is required", class)
+			}
+			if c.Network != "" && c.Network != "none" && c.Network != "bridge" {
+				return fmt.Errorf("exec.profiles.%s.container: invalid network %q (want \"none\" or \"bridge\")", class, c.Network)
+			}
+		}
+	}
+	for class, patterns := range e.Classes {
+		for _, p := range patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("exec.classes.%s: invalid pattern %q: %w", class, p, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HunkResult reports how one hunk applied. Offset is how many lines from
+// its stated position it matched; Fuzz is how many context lines at each
+// end had to be ignored.
+type HunkResult struct {
+	Applied bool
+	Line    int
+	Offset  int
+	Fuzz    int
+	Err     string
+}
+
+// Apply applies hunks to content, locating each hunk near its stated
+// line and tolerating up to fuzz mismatched leading/trailing context
+// lines. It returns the patched content and a result per hunk; ok is
+// false if any hunk failed, in which case the content is unusable.
+// Line endings (LF or CRLF) of the original are preserved.
+func Apply(content string, hunks []Hunk, fuzz int) (string, []HunkResult, bool) {
+	crlf := strings.Contains(content, "\r\n")
+	eol := content == "" || strings.HasSuffix(content, "\n")
+	lines := splitLines(content)
+
+	results := make([]HunkResult, len(hunks))
+	ok := true
+	// delta tracks line-count changes from earlier hunks; shift is the
+	// offset at which the previous hunk matched, carried forward as patch
+	// does so context-free insertions land in the right place.
+	delta, shift, floor := 0, 0, 0
+	for i, h := range hunks {
+		old := h.old()
+		base := h.OldStart - 1 + delta
+		if h.OldLines == 0 {
+			// Pure insertion: the position is after line OldStart.
+			base = h.OldStart + delta
+		}
+		want := base + shift
+		applied := false
+		for f, trimmed := 0, -1; f <= fuzz; f++ {
+			head, tail := contextTrim(h, f)
+			if head+tail == trimmed {
+				break // no more context to ignore
+			}
+			trimmed = head + tail
+			block := old[head : len(old)-tail]
+			if len(block) == 0 && len(old) > 0 {
+				break
+			}
+			at := find(lines, block, want+head, floor)
+			if at < 0 {
+				continue
+			}
+			repl := trimNew(h, head, tail)
+			lines = splice(lines, at, len(block), repl)
+			shift = at - head - base
+			results[i] = HunkResult{Applied: true, Line: max(at-head, 0) + 1, Offset: shift, Fuzz: f}
+			delta += len(repl) - len(block)
+			floor = at + len(repl)
+			if floor >= len(lines) {
+				if h.NewNoEOL {
+					eol = false
+				} else if h.OldNoEOL {
+					eol = true
+				}
+			}
+			applied = true
+			break
+		}
+		if !applied {
+			ok = false
+			results[i] = HunkResult{Line: h.OldStart, Err: fmt.Sprintf("hunk #%d at line %d does not match", i+1, h.OldStart)}
+		}
+	}
+
+	sep := "\n"
+	if crlf {
+		sep = "\r\n"
+	}
+	out := strings.Join(lines, sep)
+	if eol && len(lines) > 0 {
+		out += sep
+	}
+	return out, results, ok
+}
+
+// contextTrim returns how many leading and trailing lines of h's old side
+// to ignore at fuzz level f. Only context lines are ever ignored.
+func contextTrim(h Hunk, f int) (head, tail int) {
+	for head < f && head < len(h.Lines) && h.Lines[head][0] == ' ' {
+		head++
+	}
+	for tail < f && tail < len(h.Lines)-head && h.Lines[len(h.Lines)-1-tail][0] == ' ' {
+		tail++
+	}
+	return head, tail
+}
+
+// trimNew returns h's new side without head leading and tail trailing
+// context lines.
+func trimNew(h Hunk, head, tail int) []string {
+	repl := h.new()
+	return repl[head : len(repl)-tail]
+}
+
+// find returns the index at or after floor where block occurs in lines,
+// searching outward from want, or -1.
+func find(lines, block []string, want, floor int) int {
+	last := len(lines) - len(block)
+	if last < floor {
+		return -1
+	}
+	want = min(max(want, floor), last)
+	for d := 0; want-d >= floor || want+d <= last; d++ {
+		if at := want - d; at >= floor && at <= last && matches(lines[at:], block) {
+			return at
+		}
+		if at := want + d; d > 0 && at <= last && at >= floor && matches(lines[at:], block) {
+			return at
+		}
+	}
+	return -1
+}
+
+func matches(lines, block []string) bool {
+	for i, b := range block {
+		if lines[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+func splice(lines []string, at, n int, repl []string) []string {
+	out := make([]string, 0, len(lines)-n+len(repl))
+	out = append(out, lines[:at]...)
+	out = append(out, repl...)
+	return append(out, lines[at+n:]...)
+}
+
+// splitLines splits content into lines without terminators.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -0,0 +1,176 @@
+// Package diff parses and applies unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the path diff uses for the missing side of a created or
+// deleted file.
+const DevNull = "/dev/null"
+
+// FilePatch is the set of hunks for one file. OldPath or NewPath is
+// DevNull when the file is created or deleted.
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+// Hunk is one "@@ -a,b +c,d @@" section. Lines keep their ' ', '-' or '+'
+// prefix. OldNoEOL / NewNoEOL record a "\ No newline at end of file"
+// marker on the old or new side.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []string
+	OldNoEOL, NewNoEOL bool
+}
+
+// old returns the lines the hunk expects to find (context and removals).
+func (h Hunk) old() []string { return h.side('-') }
+
+// new returns the lines the hunk leaves behind (context and additions).
+func (h Hunk) new() []string { return h.side('+') }
+
+func (h Hunk) side(keep byte) []string {
+	var out []string
+	for _, l := range h.Lines {
+		if l[0] == ' ' || l[0] == keep {
+			out = append(out, l[1:])
+		}
+	}
+	return out
+}
+
+// Parse parses a unified diff (plain or git-style) into per-file patches.
+// Lines outside file sections, such as "diff --git" and "index" headers,
+// are ignored.
+func Parse(text string) ([]FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var patches []FilePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		fp := FilePatch{OldPath: headerPath(lines[i][4:]), NewPath: headerPath(lines[i+1][4:])}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			fp.Hunks = append(fp.Hunks, h)
+			i = next
+		}
+		if len(fp.Hunks) == 0 {
+			return nil, fmt.Errorf("patch for %s has no hunks", fp.NewPath)
+		}
+		patches = append(patches, fp)
+		i--
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file patches found")
+	}
+	return patches, nil
+}
+
+// headerPath extracts the path from a ---/+++ header, dropping any
+// timestamp and quoting.
+func headerPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if unq, err := strconv.Unquote(s); err == nil {
+		s = unq
+	}
+	return s
+}
+
+// parseHunk parses the hunk whose header is lines[i] and returns it with
+// the index of the first line after it.
+func parseHunk(lines []string, i int) (Hunk, int, error) {
+	var h Hunk
+	header := lines[i]
+	end := strings.Index(header[3:], " @@")
+	if end < 0 {
+		return h, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+	ranges := strings.Fields(header[3 : 3+end])
+	if len(ranges) != 2 || ranges[0][0] != '-' || ranges[1][0] != '+' {
+		return h, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(ranges[0][1:]); err != nil {
+		return h, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+	if h.NewStart, h.NewLines, err = parseRange(ranges[1][1:]); err != nil {
+		return h, 0, fmt.Errorf("malformed hunk header %q", header)
+	}
+
+	oldLeft, newLeft := h.OldLines, h.NewLines
+	i++
+	for ; i < len(lines) && (oldLeft > 0 || newLeft > 0); i++ {
+		l := lines[i]
+		if l == "" {
+			// Editors often strip the space from blank context lines.
+			l = " "
+		}
+		switch l[0] {
+		case ' ':
+			oldLeft--
+			newLeft--
+		case '-':
+			oldLeft--
+		case '+':
+			newLeft--
+		case '\\':
+			h.markNoEOL()
+			continue
+		default:
+			return h, 0, fmt.Errorf("hunk %q: unexpected line %q", header, l)
+		}
+		h.Lines = append(h.Lines, l)
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return h, 0, fmt.Errorf("hunk %q is truncated", header)
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+		h.markNoEOL()
+		i++
+	}
+	return h, i, nil
+}
+
+// markNoEOL applies a "\ No newline at end of file" marker to the side of
+// the line it follows.
+func (h *Hunk) markNoEOL() {
+	if len(h.Lines) == 0 {
+		return
+	}
+	switch h.Lines[len(h.Lines)-1][0] {
+	case '-':
+		h.OldNoEOL = true
+	case '+':
+		h.NewNoEOL = true
+	default:
+		h.OldNoEOL, h.NewNoEOL = true, true
+	}
+}
+
+func parseRange(s string) (start, n int, err error) {
+	a, b, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(a); err != nil {
+		return 0, 0, err
+	}
+	n = 1
+	if ok {
+		if n, err = strconv.Atoi(b); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, n, nil
+}
@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of context lines around each change.
+const DefaultContext = 3
+
+// maxEditDistance bounds the Myers search. Past it the remaining middle
+// section is reported as one replacement; the diff is still correct,
+// just not minimal.
+const maxEditDistance = 4000
+
+// IsBinary reports whether data looks binary, using git's heuristic of a
+// NUL byte in the first 8000 bytes.
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	a, b int // line indexes in old and new before this op
+	text string
+}
+
+// Unified returns a unified diff turning old into new, labelled oldName
+// and newName (use DevNull for a missing side), or "" if they are equal.
+func Unified(oldName, newName, old, new string, context int) string {
+	if old == new {
+		return ""
+	}
+	a, b := splitKeep(old), splitKeep(new)
+	ops := editScript(a, b)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change and extend the hunk while changes are
+		// within 2*context equal lines of each other.
+		first := start
+		for first < len(ops) && ops[first].kind == opEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first + 1; i < len(ops); i++ {
+			if ops[i].kind == opEqual {
+				continue
+			}
+			if i-last-1 > 2*context {
+				break
+			}
+			last = i
+		}
+		from, to := max(first-context, start), min(last+context+1, len(ops))
+		writeHunk(&sb, ops[from:to])
+		start = to
+	}
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, ops []op) {
+	var oldN, newN int
+	for _, o := range ops {
+		if o.kind != opInsert {
+			oldN++
+		}
+		if o.kind != opDelete {
+			newN++
+		}
+	}
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(ops[0].a, oldN), hunkRange(ops[0].b, newN))
+	for _, o := range ops {
+		sb.WriteByte(byte(o.kind))
+		if strings.HasSuffix(o.text, "\n") {
+			sb.WriteString(o.text)
+		} else {
+			sb.WriteString(o.text)
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats a hunk side as GNU diff does: the 1-based first line,
+// or the line before an empty range, with the count omitted when 1.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
+
+// splitKeep splits s into lines, each keeping its "\n" so that a final
+// line without one compares unequal to the same text with one.
+func splitKeep(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript returns the line operations turning a into b.
+func editScript(a, b []string) []op {
+	// Trim the common prefix and suffix; Myers only sees the middle.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, op{opEqual, i, i, a[i]})
+	}
+	for _, o := range myers(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		o.a += pre
+		o.b += pre
+		ops = append(ops, o)
+	}
+	for i := suf; i > 0; i-- {
+		ai, bi := len(a)-i, len(b)-i
+		ops = append(ops, op{opEqual, ai, bi, a[ai]})
+	}
+	return ops
+}
+
+// myers computes a minimal edit script with Myers' O(ND) algorithm,
+// falling back to a single replacement beyond maxEditDistance.
+func myers(a, b []string) []op {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEditDistance)
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d)
+			}
+		}
+	}
+	ops := make([]op, 0, n+m)
+	for i := range a {
+		ops = append(ops, op{opDelete, i, 0, a[i]})
+	}
+	for j := range b {
+		ops = append(ops, op{opInsert, n, j, b[j]})
+	}
+	return ops
+}
+
+// backtrack walks the saved V arrays from the end to recover the path.
+// trace[d] holds V[-d-1 .. d+1] as it was before step d.
+func backtrack(a, b []string, trace [][]int, dEnd int) []op {
+	var rev []op
+	x, y := len(a), len(b)
+	for d := dEnd; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if d == 0 {
+			prevK = 0
+		} else if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, op{opEqual, x, y, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				rev = append(rev, op{opInsert, x, y, b[y]})
+			} else {
+				x--
+				rev = append(rev, op{opDelete, x, y, a[x]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	ops := make([]op, len(rev))
+	for i, o := range rev {
+		ops[len(rev)-1-i] = o
+	}
+	return ops
+}
@@ -0,0 +1,141 @@
+// Package display exposes the runner's graphical display to the cloud UI
+// so agents can drive GUI-only tools. The byte stream itself is carried
+// by a tunnel; this package only locates or starts the display server.
+package display
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Modes.
+const (
+	// ModeVNC attaches to an already running VNC server.
+	ModeVNC = "vnc"
+	// ModeXpra starts an Xpra server on demand and bridges to it.
+	ModeXpra = "xpra"
+)
+
+const startTimeout = 15 * time.Second
+
+// Options configures a Display.
+type Options struct {
+	Mode string
+	// Address is the VNC server ("tcp://127.0.0.1:5900") in vnc mode.
+	Address string
+	// XpraDisplay and XpraPort configure the server started in xpra mode.
+	XpraDisplay string
+	XpraPort    int
+}
+
+// Display resolves the local endpoint for display forwarding.
+type Display struct {
+	opts Options
+
+	mu   sync.Mutex
+	xpra *exec.Cmd
+}
+
+// New creates a Display. No server is contacted until Target is called.
+func New(opts Options) *Display {
+	return &Display{opts: opts}
+}
+
+// Protocol returns the wire protocol spoken on the forwarded stream, so
+// the cloud UI can pick a viewer.
+func (d *Display) Protocol() string {
+	if d.opts.Mode == ModeXpra {
+		return "xpra"
+	}
+	return "rfb"
+}
+
+// Target returns a tunnel target for the display, starting the Xpra
+// server first if needed.
+func (d *Display) Target() (string, error) {
+	switch d.opts.Mode {
+	case ModeVNC:
+		u, err := url.Parse(d.opts.Address)
+		if err != nil || u.Scheme != "tcp" {
+			return "", fmt.Errorf("invalid display address %q (want tcp://host:port)", d.opts.Address)
+		}
+		if err := probe(u.Host, time.Second); err != nil {
+			return "", fmt.Errorf("no VNC server at %s: %w", u.Host, err)
+		}
+		return d.opts.Address, nil
+	case ModeXpra:
+		addr := fmt.Sprintf("127.0.0.1:%d", d.opts.XpraPort)
+		if err := d.startXpra(addr); err != nil {
+			return "", err
+		}
+		return "tcp://" + addr, nil
+	default:
+		return "", fmt.Errorf("unsupported display mode %q", d.opts.Mode)
+	}
+}
+
+// startXpra launches the Xpra server unless one is already listening on
+// addr, then waits for it to accept connections.
+func (d *Display) startXpra(addr string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if probe(addr, 200*time.Millisecond) == nil {
+		return nil // already running (ours or the user's)
+	}
+	if d.xpra == nil {
+		path, err := exec.LookPath("xpra")
+		if err != nil {
+			return fmt.Errorf("xpra not found: %w", err)
+		}
+		cmd := exec.Command(path, "start", d.opts.XpraDisplay,
+			"--bind-tcp="+addr,
+			"--daemon=no",
+			"--html=off",
+			"--mdns=no",
+		)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start xpra: %w", err)
+		}
+		d.xpra = cmd
+		go func() {
+			_ = cmd.Wait()
+			d.mu.Lock()
+			if d.xpra == cmd {
+				d.xpra = nil
+			}
+			d.mu.Unlock()
+		}()
+	}
+
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		if probe(addr, 200*time.Millisecond) == nil {
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return fmt.Errorf("xpra did not start listening on %s within %s", addr, startTimeout)
+}
+
+// Stop terminates an Xpra server started by this Display.
+func (d *Display) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.xpra != nil && d.xpra.Process != nil {
+		_ = d.xpra.Process.Kill()
+		d.xpra = nil
+	}
+}
+
+func probe(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,156 @@
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Scheme identifies the envelope format in Request/Response.Encryption.
+const Scheme = "x25519-aes256gcm-v1"
+
+// maxSessions bounds how many negotiated keys are kept, so a frontend can
+// rotate keys without racing in-flight messages.
+const maxSessions = 4
+
+// session holds the per-direction keys derived for one key ID.
+type session struct {
+	keyID string
+	in    cipher.AEAD // frontend → runner
+	out   cipher.AEAD // runner → frontend
+}
+
+// Manager negotiates end-to-end keys with the agent frontend and
+// encrypts/decrypts payload bodies. The relay only ever sees public keys
+// and ciphertext.
+type Manager struct {
+	mu       sync.RWMutex
+	sessions map[string]*session
+	order    []string
+	current  *session
+}
+
+// NewManager returns a Manager with no negotiated keys.
+func NewManager() *Manager {
+	return &Manager{sessions: make(map[string]*session)}
+}
+
+// Init performs the runner side of the key agreement for peerPublic (a raw
+// X25519 public key from the frontend). It returns the runner's public key
+// and a short verification code both sides can display so users can
+// detect a relay substituting keys.
+func (m *Manager) Init(keyID string, peerPublic []byte) (public []byte, code string, err error) {
+	if keyID == "" {
+		return nil, "", errors.New("key_id is required")
+	}
+	curve := ecdh.X25519()
+	peer, err := curve.NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid peer public key: %w", err)
+	}
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("generate key: %w", err)
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, "", fmt.Errorf("key agreement: %w", err)
+	}
+	public = priv.PublicKey().Bytes()
+
+	in, err := newAEAD(derive("frontend-to-runner", shared, peerPublic, public))
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := newAEAD(derive("runner-to-frontend", shared, peerPublic, public))
+	if err != nil {
+		return nil, "", err
+	}
+	s := &session{keyID: keyID, in: in, out: out}
+
+	m.mu.Lock()
+	if _, exists := m.sessions[keyID]; !exists {
+		m.order = append(m.order, keyID)
+	}
+	m.sessions[keyID] = s
+	m.current = s
+	for len(m.order) > maxSessions {
+		delete(m.sessions, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.mu.Unlock()
+
+	verify := derive("verification", shared, peerPublic, public)
+	return public, hex.EncodeToString(verify[:3]), nil
+}
+
+// Active reports whether a key has been negotiated.
+func (m *Manager) Active() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current != nil
+}
+
+// Open decrypts a sealed payload from the frontend.
+func (m *Manager) Open(keyID, sealed string) ([]byte, error) {
+	m.mu.RLock()
+	s, ok := m.sessions[keyID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown e2e key %q", keyID)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("decode sealed payload: %w", err)
+	}
+	ns := s.in.NonceSize()
+	if len(raw) < ns {
+		return nil, errors.New("sealed payload too short")
+	}
+	plain, err := s.in.Open(nil, raw[:ns], raw[ns:], []byte(Scheme+"|"+keyID))
+	if err != nil {
+		return nil, errors.New("decrypt payload: authentication failed")
+	}
+	return plain, nil
+}
+
+// Seal encrypts plaintext for the frontend with the most recently
+// negotiated key. ok is false if no key has been negotiated.
+func (m *Manager) Seal(plaintext []byte) (keyID, sealed string, ok bool) {
+	m.mu.RLock()
+	s := m.current
+	m.mu.RUnlock()
+	if s == nil {
+		return "", "", false
+	}
+	nonce := make([]byte, s.out.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", false
+	}
+	ct := s.out.Seal(nonce, nonce, plaintext, []byte(Scheme+"|"+s.keyID))
+	return s.keyID, base64.StdEncoding.EncodeToString(ct), true
+}
+
+func derive(label string, shared, frontendPub, runnerPub []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("xyzen-e2e-v1|" + label + "|"))
+	h.Write(shared)
+	h.Write(frontendPub)
+	h.Write(runnerPub)
+	return h.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("init cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
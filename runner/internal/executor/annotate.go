@@ -0,0 +1,143 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const blameTimeout = 10 * time.Second
+
+// annotate splits data read from resolved into numbered lines, starting at
+// line first, and attaches git blame metadata if blame is set. Blame
+// failures (not a repository, git missing) are reported, not fatal.
+func annotate(resolved string, data []byte, first int, blame bool) ([]protocol.AnnotatedLine, string) {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" && len(data) == 0 {
+		return []protocol.AnnotatedLine{}, ""
+	}
+	parts := strings.Split(text, "\n")
+	lines := make([]protocol.AnnotatedLine, len(parts))
+	for i, l := range parts {
+		lines[i] = protocol.AnnotatedLine{Number: first + i, Text: strings.TrimSuffix(l, "\r")}
+	}
+	if !blame {
+		return lines, ""
+	}
+	info, err := gitBlame(resolved, first, first+len(lines)-1)
+	if err != nil {
+		return lines, err.Error()
+	}
+	for i := range lines {
+		if b, ok := info[lines[i].Number]; ok {
+			lines[i].Commit, lines[i].Author, lines[i].Time, lines[i].Summary = b.Commit, b.Author, b.Time, b.Summary
+		}
+	}
+	return lines, ""
+}
+
+// gitBlame runs git blame on lines start..end of a file and returns the
+// last-modifying commit of each line. Uncommitted lines have no commit.
+func gitBlame(resolved string, start, end int) (map[int]protocol.AnnotatedLine, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), blameTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "blame", "--porcelain",
+		"-L", fmt.Sprintf("%d,%d", start, end), "--", filepath.Base(resolved))
+	cmd.Dir = filepath.Dir(resolved)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git blame: %s", msg)
+		}
+		return nil, fmt.Errorf("git blame: %w", err)
+	}
+	return parseBlame(bytes.NewReader(out))
+}
+
+// parseBlame parses `git blame --porcelain` output. Commit details are
+// only given on a commit's first appearance, so they are cached by hash.
+func parseBlame(r io.Reader) (map[int]protocol.AnnotatedLine, error) {
+	commits := map[string]*protocol.AnnotatedLine{}
+	result := map[int]protocol.AnnotatedLine{}
+	var cur *protocol.AnnotatedLine
+	var curLine int
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			if cur != nil {
+				result[curLine] = *cur
+			}
+			cur = nil
+		case cur == nil:
+			// Header: <sha> <orig-line> <final-line> [<count>]
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("git blame: unexpected line %q", line)
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("git blame: unexpected line %q", line)
+			}
+			curLine = n
+			c, ok := commits[fields[0]]
+			if !ok {
+				c = &protocol.AnnotatedLine{}
+				if strings.Trim(fields[0], "0") != "" {
+					c.Commit = fields[0]
+				}
+				commits[fields[0]] = c
+			}
+			cur = c
+		default:
+			key, val, _ := strings.Cut(line, " ")
+			if cur.Commit == "" {
+				continue
+			}
+			switch key {
+			case "author":
+				cur.Author = val
+			case "author-time":
+				if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+					cur.Time = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+				}
+			case "summary":
+				cur.Summary = val
+			}
+		}
+	}
+	return result, sc.Err()
+}
+
+// lineAt returns the 1-based number of the line containing byte offset
+// off.
+func lineAt(f *os.File, off int64) (int, error) {
+	n := 1
+	buf := make([]byte, 32*1024)
+	r := io.NewSectionReader(f, 0, off)
+	for {
+		k, err := r.Read(buf)
+		n += bytes.Count(buf[:k], []byte{'\n'})
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package executor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Archive formats.
+const (
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// ArchiveContentType returns the MIME type of an archive format.
+func ArchiveContentType(format string) string {
+	if format == FormatZip {
+		return "application/zip"
+	}
+	return "application/gzip"
+}
+
+// ArchiveDir writes a compressed archive of the directory p.Path to w.
+// Entries are relative to p.Path. Anything matching one of p.Exclude is
+// skipped: a pattern containing "/" is matched against the relative path,
+// otherwise against each name, so "node_modules" prunes every such
+// directory. Symlinks are stored as links, not followed.
+func (e *Executor) ArchiveDir(p protocol.ArchiveDirPayload, w io.Writer) (protocol.ArchiveStats, error) {
+	format := p.Format
+	if format == "" {
+		format = FormatTarGz
+	}
+	if format != FormatTarGz && format != FormatZip {
+		return protocol.ArchiveStats{}, fmt.Errorf("unsupported archive format %q (want %q or %q)", format, FormatTarGz, FormatZip)
+	}
+	for _, pat := range p.Exclude {
+		if _, err := path.Match(pat, ""); err != nil {
+			return protocol.ArchiveStats{}, fmt.Errorf("invalid exclude pattern %q: %w", pat, err)
+		}
+	}
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.ArchiveStats{}, err
+	}
+	if info, err := os.Stat(resolved); err != nil {
+		return protocol.ArchiveStats{}, fmt.Errorf("archive: %w", err)
+	} else if !info.IsDir() {
+		return protocol.ArchiveStats{}, fmt.Errorf("%q is not a directory", p.Path)
+	}
+
+	var aw archiveWriter
+	if format == FormatZip {
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+
+	stats := protocol.ArchiveStats{Format: format}
+	err = filepath.WalkDir(resolved, func(full string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if full == resolved {
+			return nil
+		}
+		rel, err := filepath.Rel(resolved, full)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if excluded(rel, p.Exclude) {
+			stats.Excluded++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.Tripwire.CheckPath("file_access", full) {
+			return fmt.Errorf("access to %q denied", protocol.JoinPath(p.Path, rel))
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(full); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // sockets, devices, pipes
+		}
+		if err := aw.add(rel, info, link, full); err != nil {
+			return fmt.Errorf("archive %s: %w", rel, err)
+		}
+		if info.Mode().IsRegular() {
+			stats.Files++
+			stats.Bytes += info.Size()
+		}
+		return nil
+	})
+	if cerr := aw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return protocol.ArchiveStats{}, fmt.Errorf("archive: %w", err)
+	}
+	return stats, nil
+}
+
+// excluded reports whether rel, or any of its names, matches a pattern.
+func excluded(rel string, patterns []string) bool {
+	base := path.Base(rel)
+	for _, pat := range patterns {
+		target := base
+		if strings.Contains(pat, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(strings.TrimSuffix(pat, "/"), target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type archiveWriter interface {
+	add(name string, info fs.FileInfo, link, full string) error
+	Close() error
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) add(name string, info fs.FileInfo, link, full string) error {
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// Owner names are meaningless on the receiving machine.
+	hdr.Uname, hdr.Gname, hdr.Uid, hdr.Gid = "", "", 0, 0
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return copyInto(a.tw, full)
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(name string, info fs.FileInfo, link, full string) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case link != "":
+		// Info-ZIP convention: a symlink entry's content is its target.
+		_, err = io.WriteString(w, link)
+		return err
+	case info.Mode().IsRegular():
+		return copyInto(w, full)
+	}
+	return nil
+}
+
+func (a *zipArchive) Close() error { return a.zw.Close() }
+
+func copyInto(w io.Writer, full string) error {
+	f, err := os.Open(full)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
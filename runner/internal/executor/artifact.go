@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/scienceol/xyzen/runner/internal/storage"
+	"github.com/scienceol/xyzen/runner/internal/tracking"
+)
+
+// StoreArtifact streams the workspace file at path to b under key.
+func (e *Executor) StoreArtifact(ctx context.Context, path string, b storage.Backend, key string) (storage.Object, error) {
+	f, size, err := e.openArtifact(path)
+	if err != nil {
+		return storage.Object{}, err
+	}
+	defer f.Close()
+	return b.Put(ctx, key, f, size)
+}
+
+// LogRunArtifact streams the workspace file at path to t as the artifact
+// name of run, and returns its size.
+func (e *Executor) LogRunArtifact(ctx context.Context, path string, t tracking.Tracker, run *tracking.Run, name string) (int64, error) {
+	f, size, err := e.openArtifact(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return size, t.LogArtifact(ctx, run, name, f, size)
+}
+
+// openArtifact opens the regular workspace file at path.
+func (e *Executor) openArtifact(path string) (*os.File, int64, error) {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, fmt.Errorf("%q is not a regular file", path)
+	}
+	return f, info.Size(), nil
+}
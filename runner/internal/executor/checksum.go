@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// checksumAlgorithms are the hashes Checksum can compute.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// Checksum hashes a file with each of p.Algorithms (default sha256) in a
+// single pass. If p.Expected is set, Match reports whether the digest of
+// the first algorithm equals it.
+func (e *Executor) Checksum(p protocol.ChecksumPayload) (protocol.ChecksumResult, error) {
+	algos := p.Algorithms
+	if len(algos) == 0 {
+		algos = []string{"sha256"}
+	}
+	hashes := make(map[string]hash.Hash, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, a := range algos {
+		a = strings.ToLower(a)
+		newHash, ok := checksumAlgorithms[a]
+		if !ok {
+			return protocol.ChecksumResult{}, fmt.Errorf("unsupported algorithm %q (supported: %s)", a, strings.Join(supportedChecksums(), ", "))
+		}
+		if _, dup := hashes[a]; !dup {
+			hashes[a] = newHash()
+			writers = append(writers, hashes[a])
+		}
+	}
+
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.ChecksumResult{}, err
+	}
+	var f *os.File
+	err = e.retry(func() (err error) {
+		f, err = os.Open(resolved)
+		return err
+	})
+	if err != nil {
+		return protocol.ChecksumResult{}, fmt.Errorf("checksum: %w", err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return protocol.ChecksumResult{}, fmt.Errorf("checksum: %w", err)
+	} else if info.IsDir() {
+		return protocol.ChecksumResult{}, fmt.Errorf("%q is a directory", p.Path)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), f)
+	if err != nil {
+		return protocol.ChecksumResult{}, fmt.Errorf("checksum: %w", err)
+	}
+
+	result := protocol.ChecksumResult{Path: p.Path, Size: n, Checksums: make(map[string]string, len(hashes))}
+	for a, h := range hashes {
+		result.Checksums[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	if p.Expected != "" {
+		match := strings.EqualFold(result.Checksums[strings.ToLower(algos[0])], strings.TrimSpace(p.Expected))
+		result.Match = &match
+	}
+	return result, nil
+}
+
+func supportedChecksums() []string {
+	names := make([]string, 0, len(checksumAlgorithms))
+	for a := range checksumAlgorithms {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	return names
+}
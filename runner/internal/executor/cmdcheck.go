@@ -0,0 +1,46 @@
+package executor
+
+// Kinds of Command.
+const (
+	CommandExec   = "exec"
+	CommandScript = "script"
+	CommandJob    = "job"
+	CommandPTY    = "pty"
+)
+
+// Command is a command about to run, as passed to a CommandCheck.
+type Command struct {
+	// Kind is CommandExec (including template setup commands),
+	// CommandScript, CommandJob (including PTY sessions detached into
+	// jobs) or CommandPTY.
+	Kind string
+	// Line is the command line, or a script's content.
+	Line string
+	Env  map[string]string
+}
+
+// CommandCheck decides whether a command may run, returning an error to
+// refuse it.
+type CommandCheck func(Command) error
+
+// WithCommandCheck returns a copy of e that consults check before it runs
+// any command: in Exec, RunScript and StartJob, and in the PTYManager
+// methods passed it.
+func (e *Executor) WithCommandCheck(check CommandCheck) *Executor {
+	cp := *e
+	cp.check = check
+	return &cp
+}
+
+// checkCommand consults e's CommandCheck, if any.
+func (e *Executor) checkCommand(c Command) error {
+	return e.check.allow(c)
+}
+
+// allow consults check, allowing every command if check is nil.
+func (check CommandCheck) allow(c Command) error {
+	if check == nil {
+		return nil
+	}
+	return check(c)
+}
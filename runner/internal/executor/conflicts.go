@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// ErrPathConflict is returned when a write would silently land on an
+// existing entry whose name differs only in case, as happens on the
+// case-insensitive filesystems of macOS and Windows.
+var ErrPathConflict = errors.New("path conflict")
+
+// Conflict kinds.
+const (
+	// ConflictCase: two incoming paths differ only in case.
+	ConflictCase = "case_collision"
+	// ConflictCaseRename: an incoming path matches an existing entry
+	// that differs only in case; writing it would keep the old name.
+	ConflictCaseRename = "case_rename"
+	// ConflictReservedName: the name is reserved on Windows (CON, NUL…).
+	ConflictReservedName = "reserved_name"
+	// ConflictInvalidName: the name contains characters, or ends with a
+	// dot or space, that Windows cannot store.
+	ConflictInvalidName = "invalid_name"
+)
+
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CheckPaths reports the conflicts a set of canonical paths under root
+// would cause if written here: case-only collisions among the paths and
+// with existing entries, and names this OS cannot represent. Nothing is
+// written.
+func (e *Executor) CheckPaths(root string, paths []string) ([]protocol.PathConflict, error) {
+	if _, err := e.resolvePath(root); err != nil {
+		return nil, err
+	}
+	var conflicts []protocol.PathConflict
+	seen := make(map[string]string, len(paths))
+	for _, p := range paths {
+		full := protocol.JoinPath(root, p)
+		if _, err := protocol.CleanPath(full); err != nil {
+			return nil, err
+		}
+		folded := strings.ToLower(full)
+		if other, ok := seen[folded]; ok && other != full {
+			conflicts = append(conflicts, protocol.PathConflict{Path: p, Kind: ConflictCase, Other: other})
+			continue
+		}
+		seen[folded] = full
+
+		if kind := nameConflict(path.Base(full)); kind != "" {
+			conflicts = append(conflicts, protocol.PathConflict{Path: p, Kind: kind})
+			continue
+		}
+		resolved, err := e.resolvePath(full)
+		if err != nil {
+			return nil, err
+		}
+		if existing := caseVariant(resolved); existing != "" {
+			conflicts = append(conflicts, protocol.PathConflict{Path: p, Kind: ConflictCaseRename, Other: existing})
+		}
+	}
+	return conflicts, nil
+}
+
+// checkCaseConflict fails if resolved exists only under a name differing
+// in case.
+func (e *Executor) checkCaseConflict(resolved string) error {
+	if existing := caseVariant(resolved); existing != "" {
+		return fmt.Errorf("%w: %q already exists as %q (names differ only in case)", ErrPathConflict, filepath.Base(resolved), existing)
+	}
+	return nil
+}
+
+// caseVariant returns the on-disk name of resolved's entry if it exists
+// under a name that differs only in case, or "".
+func caseVariant(resolved string) string {
+	if runtime.GOOS == "linux" {
+		// Case-sensitive: a differently cased name is a different file.
+		return ""
+	}
+	if _, err := os.Lstat(resolved); err != nil {
+		return ""
+	}
+	name := filepath.Base(resolved)
+	entries, err := os.ReadDir(filepath.Dir(resolved))
+	if err != nil {
+		return ""
+	}
+	var variant string
+	for _, e := range entries {
+		if e.Name() == name {
+			return ""
+		}
+		if strings.EqualFold(e.Name(), name) {
+			variant = e.Name()
+		}
+	}
+	return variant
+}
+
+// nameConflict returns the conflict kind for a name Windows cannot store,
+// or "". It applies on every OS since workspaces are synced across them.
+func nameConflict(name string) string {
+	if strings.ContainsAny(name, `<>:"|?*`) || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return ConflictInvalidName
+	}
+	stem, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(stem)] {
+		return ConflictReservedName
+	}
+	return ""
+}
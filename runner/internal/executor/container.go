@@ -0,0 +1,165 @@
+package executor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// containerWorkDir is where a container sees the work dir.
+const containerWorkDir = "/workspace"
+
+// Container networks (protocol.ContainerSpec.Network).
+const (
+	ContainerNetworkNone   = "none"
+	ContainerNetworkBridge = "bridge"
+)
+
+// containerCommand returns the argv and environment that run p.Command in
+// the container p.Container describes, in dir (a directory in the work
+// dir) and under limits, and the container's name, for removing it should the command
+// time out. The runtime's own environment carries the values of the
+// variables passed into the container, so they stay off its command line.
+func (e *Executor) containerCommand(p protocol.ExecPayload, dir string, profile Profile, hooks string, limits protocol.ResourceLimits) (argv, env []string, name string, err error) {
+	spec := p.Container
+	if spec.Image == "" || strings.HasPrefix(spec.Image, "-") {
+		return nil, nil, "", fmt.Errorf("invalid container image %q", spec.Image)
+	}
+	if p.CollectCrash {
+		return nil, nil, "", errors.New("collect_crash is not supported in a container")
+	}
+	network := spec.Network
+	switch network {
+	case "", ContainerNetworkNone:
+		network = ContainerNetworkNone
+	case ContainerNetworkBridge:
+		if profile.Network == NetworkDeny {
+			return nil, nil, "", errors.New("the command's profile denies the network")
+		}
+	default:
+		return nil, nil, "", fmt.Errorf("invalid container network %q (want %s or %s)", network, ContainerNetworkNone, ContainerNetworkBridge)
+	}
+	bin, err := e.containerRuntime()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	workDir, err := filepath.EvalSymlinks(e.workDir)
+	if err != nil {
+		workDir = e.workDir
+	}
+	cwd := containerWorkDir
+	if rel, err := filepath.Rel(workDir, dir); err == nil && within(workDir, dir) && rel != "." {
+		cwd = path.Join(containerWorkDir, filepath.ToSlash(rel))
+	}
+	var id [6]byte
+	_, _ = rand.Read(id[:])
+	name = "xyzen-" + hex.EncodeToString(id[:])
+
+	argv = []string{bin, "run", "--rm", "--name", name, "--network", network, "-w", cwd}
+	if runtime.GOOS != "windows" {
+		// Files the command creates in the work dir belong to the user
+		// running the runner, not to root.
+		if strings.Contains(filepath.Base(bin), "podman") {
+			argv = append(argv, "--userns=keep-id")
+		} else {
+			argv = append(argv, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+		}
+	}
+	if limits.CPUs > 0 {
+		argv = append(argv, "--cpus", strconv.FormatFloat(limits.CPUs, 'f', -1, 64))
+	}
+	if limits.MemoryBytes > 0 {
+		// A swap limit equal to the memory limit allows no swap.
+		m := strconv.FormatInt(limits.MemoryBytes, 10)
+		argv = append(argv, "--memory", m, "--memory-swap", m)
+	}
+	if limits.MaxProcesses > 0 {
+		argv = append(argv, "--pids-limit", strconv.Itoa(limits.MaxProcesses))
+	}
+	if shares, ok := containerCPUShares[e.priorityFor(p.Priority)]; ok {
+		argv = append(argv, "--cpu-shares", shares)
+	}
+	mount, err := bindMount(workDir, containerWorkDir, false)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	argv = append(argv, "--mount", mount)
+	for _, m := range spec.Mounts {
+		source, err := e.resolvePath(m.Path)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		mount, err := bindMount(source, m.Target, m.ReadOnly)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		argv = append(argv, "--mount", mount)
+	}
+
+	env = commandEnv(p.Env, false)
+	forward := make([]string, 0, len(p.Env))
+	for k := range p.Env {
+		forward = append(forward, k)
+	}
+	if hooks == GitHooksBypass {
+		env = bypassGitHooks(env)
+		for _, kv := range env {
+			if k, _, _ := strings.Cut(kv, "="); strings.HasPrefix(k, "GIT_CONFIG_") {
+				forward = append(forward, k)
+			}
+		}
+	}
+	sort.Strings(forward)
+	for _, k := range forward {
+		argv = append(argv, "-e", k)
+	}
+	argv = append(argv, spec.Image, "sh", "-c", p.Command)
+	return argv, env, name, nil
+}
+
+// containerRuntime returns the container runtime binary to use.
+func (e *Executor) containerRuntime() (string, error) {
+	if e.ContainerRuntime != "" {
+		return e.ContainerRuntime, nil
+	}
+	for _, name := range []string{"docker", "podman"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			return bin, nil
+		}
+	}
+	return "", errors.New("no container runtime found: install Docker or Podman, or set exec.container_runtime")
+}
+
+// bindMount returns the --mount option that mounts source at target.
+func bindMount(source, target string, readOnly bool) (string, error) {
+	if !path.IsAbs(target) || path.Clean(target) == "/" {
+		return "", fmt.Errorf("invalid container mount target %q: want an absolute path below /", target)
+	}
+	if strings.ContainsAny(source+target, ",\"") {
+		return "", fmt.Errorf("container mount %q -> %q: paths may not contain commas or quotes", source, target)
+	}
+	opt := "type=bind,source=" + source + ",target=" + path.Clean(target)
+	if readOnly {
+		opt += ",readonly"
+	}
+	return opt, nil
+}
+
+// removeContainer force-removes the container name, which the runtime
+// leaves running when its client is killed.
+func (e *Executor) removeContainer(name string) {
+	if bin, err := e.containerRuntime(); err == nil {
+		_ = exec.Command(bin, "rm", "-f", name).Run()
+	}
+}
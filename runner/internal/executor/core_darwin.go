@@ -0,0 +1,24 @@
+//go:build darwin
+
+package executor
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// collectCore copies the core dump of a crash at since from /cores, where
+// macOS writes them as core.<pid> when /cores is writable, to dest. Only
+// the core dump of the process leading group, the command's, is known to
+// be the command's: macOS cores do not record process groups.
+func collectCore(_ string, group int, since time.Time, dest string) (int64, string) {
+	if group <= 0 {
+		return 0, "the command did not run in a process group of its own"
+	}
+	return copyCore("/cores", "core.", since, dest, func(path string) bool {
+		pid, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "core."))
+		return err == nil && pid == group
+	})
+}
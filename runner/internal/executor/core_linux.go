@@ -0,0 +1,154 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// coredumpctlTimeout bounds extracting a core dump from the journal.
+	coredumpctlTimeout = 30 * time.Second
+	// maxCoredumpctlTries bounds the journal's core dumps examined for
+	// the command's.
+	maxCoredumpctlTries = 5
+)
+
+// collectCore finds the core dump of a crash at since of the programs in
+// process group group and copies it to dest, following
+// kernel.core_pattern: an absolute file pattern, or a pipe to
+// systemd-coredump, which coredumpctl reads back. A core dump is the
+// command's if its process, or process group, is group. Core dumps in
+// workDir are not collected, as files there are the user's.
+func collectCore(workDir string, group int, since time.Time, dest string) (int64, string) {
+	if group <= 0 {
+		return 0, "the command did not run in a process group of its own"
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/core_pattern")
+	if err != nil {
+		return 0, fmt.Sprintf("read core_pattern: %v", err)
+	}
+	pattern := strings.TrimSpace(string(data))
+	if helper, ok := strings.CutPrefix(pattern, "|"); ok {
+		if strings.Contains(helper, "systemd-coredump") {
+			return coredumpctl(group, since, dest)
+		}
+		if f := strings.Fields(helper); len(f) > 0 {
+			helper = f[0]
+		}
+		return 0, fmt.Sprintf("core dumps are piped to %s", helper)
+	}
+	if !filepath.IsAbs(pattern) {
+		return 0, fmt.Sprintf("core dumps are written to the crashing program's working directory (core_pattern %q); set kernel.core_pattern to an absolute path or systemd-coredump to collect them", pattern)
+	}
+	if rel, err := filepath.Rel(workDir, pattern); err == nil && !strings.HasPrefix(rel, "..") {
+		return 0, fmt.Sprintf("core dumps are written to the work dir (core_pattern %q)", pattern)
+	}
+	dir, base := filepath.Split(pattern)
+	prefix, _, _ := strings.Cut(base, "%")
+	if prefix == "" || strings.Contains(dir, "%") {
+		return 0, fmt.Sprintf("unsupported core_pattern %q", pattern)
+	}
+	return copyCore(dir, prefix, since, dest, func(path string) bool {
+		pid, pgrp, ok := coreProcess(path)
+		return ok && (pid == group || pgrp == group)
+	})
+}
+
+func coredumpctl(group int, since time.Time, dest string) (int64, string) {
+	path, err := exec.LookPath("coredumpctl")
+	if err != nil {
+		return 0, "core dumps go to systemd-coredump, but coredumpctl is not installed"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), coredumpctlTimeout)
+	defer cancel()
+	sinceArg := fmt.Sprintf("--since=@%d", since.Add(-time.Second).Unix())
+	out, err := exec.CommandContext(ctx, path, "--no-pager", "--quiet", "--json=short", "list", sinceArg).Output()
+	if err != nil {
+		return 0, "no core dump of the command in the journal"
+	}
+	var dumps []struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(out, &dumps); err != nil {
+		return 0, fmt.Sprintf("coredumpctl list: %v", err)
+	}
+	// Newest last; each is dumped to be checked, as the journal does not
+	// record process groups.
+	tmp := dest + ".tmp"
+	defer os.Remove(tmp)
+	for i := len(dumps) - 1; i >= 0 && i >= len(dumps)-maxCoredumpctlTries; i-- {
+		pid := strconv.Itoa(dumps[i].PID)
+		if err := exec.CommandContext(ctx, path, "--no-pager", "--quiet", "dump", sinceArg, "--output="+tmp, pid).Run(); err != nil {
+			continue
+		}
+		if p, pgrp, ok := coreProcess(tmp); !ok || (p != group && pgrp != group) {
+			continue
+		}
+		if err := os.Rename(tmp, dest); err != nil {
+			return 0, fmt.Sprintf("coredumpctl: %v", err)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			return 0, fmt.Sprintf("coredumpctl: %v", err)
+		}
+		return info.Size(), ""
+	}
+	return 0, "no core dump of the command in the journal"
+}
+
+// ntPRPSInfo is the ELF note type of a core's process information.
+const ntPRPSInfo = 3
+
+// coreProcess returns the process ID and process group recorded in the
+// NT_PRPSINFO note of the 64-bit ELF core dump at path.
+func coreProcess(path string) (pid, pgrp int, ok bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE || f.Class != elf.ELFCLASS64 {
+		return 0, 0, false
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE || prog.Filesz > 1<<20 {
+			continue
+		}
+		notes, err := io.ReadAll(prog.Open())
+		if err != nil {
+			continue
+		}
+		for len(notes) >= 12 {
+			namesz := int(f.ByteOrder.Uint32(notes))
+			descsz := int(f.ByteOrder.Uint32(notes[4:]))
+			typ := f.ByteOrder.Uint32(notes[8:])
+			descOff := 12 + (namesz+3)&^3
+			end := descOff + (descsz+3)&^3
+			if namesz < 0 || descsz < 0 || descOff+descsz > len(notes) {
+				break
+			}
+			// struct elf_prpsinfo: 4 state bytes, padding, pr_flag,
+			// pr_uid and pr_gid, then pr_pid, pr_ppid and pr_pgrp.
+			if typ == ntPRPSInfo && descsz >= 36 {
+				desc := notes[descOff:]
+				return int(int32(f.ByteOrder.Uint32(desc[24:]))), int(int32(f.ByteOrder.Uint32(desc[32:]))), true
+			}
+			if end > len(notes) {
+				break
+			}
+			notes = notes[end:]
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !linux
+
+package executor
+
+import "time"
+
+func collectCore(string, int, time.Time, string) (int64, string) {
+	return 0, "core dumps are not collected on this platform"
+}
@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/problems"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Names of the artifacts collected for a crash.
+const (
+	CrashLog  = "crash.log"
+	CrashCore = "core"
+)
+
+// crashReason returns why the command of r crashed, or "" if it did not:
+// a fatal signal, a Windows exception, or a Go or Rust panic.
+func crashReason(r protocol.ExecResultPayload) string {
+	if crashSignals[r.Signal] {
+		return r.Signal
+	}
+	if reason := exitCodeCrash(r.ExitCode); reason != "" {
+		return reason
+	}
+	for _, oe := range r.Errors {
+		if oe.Kind == problems.KindRuntime && (oe.Language == "go" || oe.Language == "rust") {
+			return oe.Language + " panic"
+		}
+	}
+	return ""
+}
+
+// collectCrash keeps the crash log and core dump of a crashed command
+// under e.CrashDir/<id>. It returns nil if the command did not crash or
+// nothing could be kept. cwd is where the command ran, start when, and
+// group the process group it led.
+func (e *Executor) collectCrash(p protocol.ExecPayload, r protocol.ExecResultPayload, cwd string, start time.Time, group int) *protocol.CrashReport {
+	reason := crashReason(r)
+	if reason == "" {
+		return nil
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil
+	}
+	id := start.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+	dir := filepath.Join(e.CrashDir, id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil
+	}
+	report := &protocol.CrashReport{ID: id, Reason: reason, Log: CrashLog}
+	if err := os.WriteFile(filepath.Join(dir, CrashLog), crashLog(p, r, cwd, reason, start), 0o600); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil
+	}
+	if strings.HasSuffix(reason, " panic") {
+		// Panics exit normally unless GOTRACEBACK=crash or panic=abort.
+		report.Note = "panics do not dump core"
+		return report
+	}
+	size, note := collectCore(e.workDir, group, start, filepath.Join(dir, CrashCore))
+	if note != "" {
+		report.Note = note
+	} else {
+		report.Core, report.CoreSize = CrashCore, size
+	}
+	return report
+}
+
+func crashLog(p protocol.ExecPayload, r protocol.ExecResultPayload, cwd, reason string, start time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "command: %s\n", p.Command)
+	fmt.Fprintf(&b, "cwd: %s\n", cwd)
+	fmt.Fprintf(&b, "started: %s\n", start.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "exit code: %d\n", r.ExitCode)
+	if r.Signal != "" {
+		fmt.Fprintf(&b, "signal: %s\n", r.Signal)
+	}
+	fmt.Fprintf(&b, "reason: %s\n", reason)
+	fmt.Fprintf(&b, "\n--- stderr ---\n%s\n--- stdout ---\n%s", r.Stderr, r.Stdout)
+	return []byte(b.String())
+}
+
+// CrashArtifact opens an artifact of a collected crash.
+func (e *Executor) CrashArtifact(id, name string) (*os.File, error) {
+	if e.CrashDir == "" {
+		return nil, fmt.Errorf("crash collection is not enabled")
+	}
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid crash id %q", id)
+	}
+	if name != CrashLog && name != CrashCore {
+		return nil, fmt.Errorf("invalid crash artifact %q (want %s or %s)", name, CrashLog, CrashCore)
+	}
+	f, err := os.Open(filepath.Join(e.CrashDir, id, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("crash %s has no %s", id, name)
+	}
+	return f, err
+}
+
+// copyCore copies to dest the newest file in dir named with prefix and
+// written since since that ours accepts as the command's core dump, and
+// returns its size. Core dumps are copied, never moved: the file is not
+// the runner's to take.
+func copyCore(dir, prefix string, since time.Time, dest string, ours func(path string) bool) (int64, string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Sprintf("no core dump: %v", err)
+	}
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	var found []candidate
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		// Allow for coarse filesystem timestamps.
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since.Add(-time.Second)) {
+			continue
+		}
+		found = append(found, candidate{filepath.Join(dir, entry.Name()), info})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].info.ModTime().After(found[j].info.ModTime()) })
+	for _, core := range found {
+		if !ours(core.path) {
+			continue
+		}
+		if err := copyFile(core.path, dest); err != nil {
+			return 0, fmt.Sprintf("copy core dump %s: %v", core.path, err)
+		}
+		return core.info.Size(), ""
+	}
+	return 0, fmt.Sprintf("no core dump of the command in %s (core dumps may be disabled)", dir)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// signalNames names the signals a command is commonly killed by.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGSYS:  "SIGSYS",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGPIPE: "SIGPIPE",
+}
+
+// crashSignals are the signals that mean a program crashed.
+var crashSignals = map[string]bool{
+	"SIGSEGV": true, "SIGBUS": true, "SIGABRT": true, "SIGFPE": true,
+	"SIGILL": true, "SIGTRAP": true, "SIGSYS": true,
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// exitSignal names the signal that killed a process, if one did.
+func exitSignal(ps *os.ProcessState) string {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return signalName(ws.Signal())
+	}
+	return ""
+}
+
+// exitCodeCrash recognizes the shell's report of a child killed by a
+// crash signal, exit code 128+n.
+func exitCodeCrash(code int) string {
+	if code <= 128 {
+		return ""
+	}
+	if name := signalName(syscall.Signal(code - 128)); crashSignals[name] {
+		return name
+	}
+	return ""
+}
+
+// ownGroup makes a command the leader of a process group of its own, so
+// that the core dumps of the programs it runs can be told from others'.
+func ownGroup(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Setpgid = true
+	return attr
+}
+
+// enableCoreDumps prefixes a shell command so that the programs it runs
+// may dump core, raising the soft core size limit to the hard one.
+func enableCoreDumps(command string) string {
+	return `ulimit -S -c "$(ulimit -H -c)" 2>/dev/null; ` + command
+}
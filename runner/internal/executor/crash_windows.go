@@ -0,0 +1,32 @@
+//go:build windows
+
+package executor
+
+import (
+	"os"
+	"syscall"
+)
+
+// crashExceptions names the NTSTATUS codes a crashed process exits with.
+var crashExceptions = map[uint32]string{
+	0xC0000005: "EXCEPTION_ACCESS_VIOLATION",
+	0xC00000FD: "EXCEPTION_STACK_OVERFLOW",
+	0xC000001D: "EXCEPTION_ILLEGAL_INSTRUCTION",
+	0xC0000094: "EXCEPTION_INT_DIVIDE_BY_ZERO",
+	0xC0000374: "STATUS_HEAP_CORRUPTION",
+	0xC0000409: "STATUS_STACK_BUFFER_OVERRUN",
+	0x80000003: "EXCEPTION_BREAKPOINT",
+}
+
+// crashSignals is empty: Windows processes are not killed by signals.
+var crashSignals = map[string]bool{}
+
+func exitSignal(*os.ProcessState) string { return "" }
+
+func exitCodeCrash(code int) string {
+	return crashExceptions[uint32(code)]
+}
+
+func enableCoreDumps(command string) string { return command }
+
+func ownGroup(attr *syscall.SysProcAttr) *syscall.SysProcAttr { return attr }
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const maxDiffSize = 8 * 1024 * 1024
+
+// DiffFiles returns a unified diff from p.Old to p.New. A missing side is
+// diffed as /dev/null, so the result also describes creations and
+// deletions in a form apply_patch accepts.
+func (e *Executor) DiffFiles(p protocol.DiffFilesPayload) (protocol.DiffResult, error) {
+	oldData, oldOK, err := e.diffSide(p.Old)
+	if err != nil {
+		return protocol.DiffResult{}, err
+	}
+	newData, newOK, err := e.diffSide(p.New)
+	if err != nil {
+		return protocol.DiffResult{}, err
+	}
+	if !oldOK && !newOK {
+		return protocol.DiffResult{}, fmt.Errorf("neither %q nor %q exists", p.Old, p.New)
+	}
+	return unifiedResult(p.Old, p.New, oldData, newData, oldOK, newOK, p.Context), nil
+}
+
+// DiffAgainstContent returns a unified diff from the file at p.Path to
+// p.Content, e.g. to preview a write before making it.
+func (e *Executor) DiffAgainstContent(p protocol.DiffContentPayload) (protocol.DiffResult, error) {
+	if len(p.Content) > maxDiffSize {
+		return protocol.DiffResult{}, fmt.Errorf("content too large to diff (%d bytes, max %d)", len(p.Content), maxDiffSize)
+	}
+	oldData, oldOK, err := e.diffSide(p.Path)
+	if err != nil {
+		return protocol.DiffResult{}, err
+	}
+	return unifiedResult(p.Path, p.Path, oldData, []byte(p.Content), oldOK, true, p.Context), nil
+}
+
+// diffSide reads one side of a diff, reporting whether it exists.
+func (e *Executor) diffSide(path string) ([]byte, bool, error) {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := os.Stat(resolved)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("diff: %w", err)
+	}
+	if info.IsDir() {
+		return nil, false, fmt.Errorf("%q is a directory", path)
+	}
+	if info.Size() > maxDiffSize {
+		return nil, false, fmt.Errorf("%q too large to diff (%d bytes, max %d)", path, info.Size(), maxDiffSize)
+	}
+	var data []byte
+	err = e.retry(func() (err error) {
+		data, err = os.ReadFile(resolved)
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("diff: %w", err)
+	}
+	return data, true, nil
+}
+
+func unifiedResult(oldPath, newPath string, oldData, newData []byte, oldOK, newOK bool, context *int) protocol.DiffResult {
+	oldName, newName := "a/"+oldPath, "b/"+newPath
+	if !oldOK {
+		oldName = diff.DevNull
+	}
+	if !newOK {
+		newName = diff.DevNull
+	}
+	if oldOK == newOK && string(oldData) == string(newData) {
+		return protocol.DiffResult{Identical: true}
+	}
+	if diff.IsBinary(oldData) || diff.IsBinary(newData) {
+		return protocol.DiffResult{Binary: true, Diff: fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)}
+	}
+	n := diff.DefaultContext
+	if context != nil && *context >= 0 {
+		n = *context
+	}
+	return protocol.DiffResult{Diff: diff.Unified(oldName, newName, string(oldData), string(newData), n)}
+}
@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// defaultDirMode is used when create_dir gives no mode.
+const defaultDirMode fs.FileMode = 0o755
+
+// CreateDir creates a directory. mode is an octal permission string such
+// as "0750" (default 0755, subject to the umask); with parents set,
+// missing parents are created too and an existing directory is not an
+// error, like mkdir -p.
+func (e *Executor) CreateDir(path, mode string, parents bool) error {
+	perm, err := parseMode(mode, defaultDirMode)
+	if err != nil {
+		return err
+	}
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if err := e.checkCaseConflict(resolved); err != nil {
+		return err
+	}
+	if parents {
+		err = os.MkdirAll(resolved, perm)
+	} else {
+		err = os.Mkdir(resolved, perm)
+	}
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	return nil
+}
+
+// RemoveDir removes a directory. Without recursive the directory must be
+// empty; with it, the directory is moved to the trash (see trash) and the
+// result names its entry. The working directory itself cannot be removed.
+func (e *Executor) RemoveDir(path string, recursive bool) (protocol.RemoveDirResult, error) {
+	if canonical, err := protocol.CleanPath(path); err == nil && canonical == "." {
+		return protocol.RemoveDirResult{}, fmt.Errorf("refusing to remove the working directory")
+	}
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return protocol.RemoveDirResult{}, err
+	}
+	info, err := os.Lstat(resolved)
+	if err != nil {
+		return protocol.RemoveDirResult{}, fmt.Errorf("remove directory: %w", err)
+	}
+	if !info.IsDir() {
+		return protocol.RemoveDirResult{}, fmt.Errorf("remove directory: %q is not a directory", path)
+	}
+	if !recursive {
+		if err := e.retry(func() error { return os.Remove(resolved) }); err != nil {
+			return protocol.RemoveDirResult{}, fmt.Errorf("remove directory: %w", err)
+		}
+		return protocol.RemoveDirResult{}, nil
+	}
+	id, err := e.trash(resolved, path)
+	if err != nil {
+		return protocol.RemoveDirResult{}, fmt.Errorf("remove directory: %w", err)
+	}
+	return protocol.RemoveDirResult{TrashID: id}, nil
+}
+
+// parseMode parses an octal permission string, returning def for "".
+func parseMode(mode string, def fs.FileMode) (fs.FileMode, error) {
+	if mode == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || v > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q (want octal permission bits, e.g. \"0755\")", mode)
+	}
+	return fs.FileMode(v), nil
+}
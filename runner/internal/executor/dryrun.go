@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Dry-run effect actions (protocol.DryRunEffect.Action).
+const (
+	EffectCreate    = "create"
+	EffectOverwrite = "overwrite"
+	EffectAppend    = "append"
+	EffectDelete    = "delete"
+)
+
+// PlanExec describes what Exec would do with p, after the checks that
+// would refuse it, without running anything.
+func (e *Executor) PlanExec(p protocol.ExecPayload) (protocol.DryRunResult, error) {
+	if e.Tripwire.CheckCommand(p.Command) {
+		return protocol.DryRunResult{}, fmt.Errorf("command blocked: references a protected path")
+	}
+	cwd := p.Cwd
+	if cwd == "" {
+		cwd = "."
+	} else if _, err := e.resolvePath(cwd); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	if err := validEnv(p.Env); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	if err := validRetries(p); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	class := e.Classify(p.Command)
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = e.profileFor(class).Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	summary := fmt.Sprintf("would run a %s command in %s", class, cwd)
+	if p.Container != nil {
+		summary += " in a " + p.Container.Image + " container"
+	}
+	if p.Retries > 0 {
+		summary += fmt.Sprintf(", retrying it up to %d times", p.Retries)
+	}
+	return protocol.DryRunResult{
+		DryRun:  true,
+		Summary: summary,
+		Command: p.Command,
+		Cwd:     cwd,
+		Class:   class,
+		Timeout: timeout,
+	}, nil
+}
+
+// PlanWrite describes what writing p (write_file, or write_file_bytes if
+// binary) or, with appending, appending it would change, after the checks
+// that would refuse it.
+func (e *Executor) PlanWrite(p protocol.FilePayload, binary, appending bool) (protocol.DryRunResult, error) {
+	if _, err := parseMode(p.Mode, 0); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	data := []byte(p.Content)
+	if binary || (appending && p.Data != "") {
+		if data, err = base64.StdEncoding.DecodeString(p.Data); err != nil {
+			return protocol.DryRunResult{}, fmt.Errorf("base64 decode: %w", err)
+		}
+	}
+	if err := verifyChecksum(data, p.SHA256); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	if err := e.checkCaseConflict(resolved); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	if err := e.Quotas.check(resolved, int64(len(data)), appending); err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	action := EffectCreate
+	if info, err := os.Stat(resolved); err == nil {
+		if info.IsDir() {
+			return protocol.DryRunResult{}, fmt.Errorf("%q is a directory", p.Path)
+		}
+		action = EffectOverwrite
+		if appending {
+			action = EffectAppend
+		}
+	}
+	verb := action
+	if appending {
+		verb = "append to"
+	}
+	return protocol.DryRunResult{
+		DryRun:  true,
+		Summary: fmt.Sprintf("would %s %s (%d bytes)", verb, p.Path, len(data)),
+		Effects: []protocol.DryRunEffect{{Path: p.Path, Action: action, Bytes: int64(len(data))}},
+	}, nil
+}
+
+// PlanRemoveDir describes what RemoveDir would delete, after the checks
+// that would refuse it.
+func (e *Executor) PlanRemoveDir(path string, recursive bool) (protocol.DryRunResult, error) {
+	if canonical, err := protocol.CleanPath(path); err == nil && canonical == "." {
+		return protocol.DryRunResult{}, fmt.Errorf("refusing to remove the working directory")
+	}
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return protocol.DryRunResult{}, err
+	}
+	info, err := os.Lstat(resolved)
+	if err != nil {
+		return protocol.DryRunResult{}, fmt.Errorf("remove directory: %w", err)
+	}
+	if !info.IsDir() {
+		return protocol.DryRunResult{}, fmt.Errorf("remove directory: %q is not a directory", path)
+	}
+	if !recursive {
+		if entries, err := os.ReadDir(resolved); err == nil && len(entries) > 0 {
+			return protocol.DryRunResult{}, fmt.Errorf("remove directory: %q is not empty", path)
+		}
+	}
+	var size int64
+	files := 0
+	_ = filepath.WalkDir(resolved, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		files++
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	summary := fmt.Sprintf("would delete %s (%d files, %d bytes)", path, files, size)
+	if recursive && e.TrashDir != "" {
+		summary += ", moving it to the trash"
+	}
+	return protocol.DryRunResult{
+		DryRun:  true,
+		Summary: summary,
+		Effects: []protocol.DryRunEffect{{Path: path, Action: EffectDelete, Bytes: size, Files: files}},
+	}, nil
+}
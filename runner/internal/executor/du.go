@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// maxDiskUsageEntries bounds the entries a disk_usage result lists.
+const maxDiskUsageEntries = 200
+
+// DiskUsage reports the capacity of the volume holding p.Path (default
+// the working directory) and the size of each entry directly under it,
+// largest first. Sizes are apparent sizes summed over the regular files
+// in a tree, like du --apparent-size; ignore files do not apply, and
+// symlinks are not followed.
+func (e *Executor) DiskUsage(p protocol.DiskUsagePayload) (protocol.DiskUsageResult, error) {
+	path := p.Path
+	if path == "" {
+		path = "."
+	}
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return protocol.DiskUsageResult{}, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return protocol.DiskUsageResult{}, fmt.Errorf("disk usage: %w", err)
+	}
+	if !info.IsDir() {
+		return protocol.DiskUsageResult{}, fmt.Errorf("%q is not a directory", path)
+	}
+	result := protocol.DiskUsageResult{Path: path}
+	if result.Total, result.Used, result.Free, err = volumeSpace(resolved); err != nil {
+		return protocol.DiskUsageResult{}, fmt.Errorf("disk usage: %w", err)
+	}
+
+	entries, err := os.ReadDir(resolved)
+	if err != nil {
+		return protocol.DiskUsageResult{}, fmt.Errorf("disk usage: %w", err)
+	}
+	byName := make(map[string]*protocol.DirUsage, len(entries))
+	for _, d := range entries {
+		u := &protocol.DirUsage{Name: d.Name(), IsDir: d.IsDir()}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				u.Size, u.Files = info.Size(), 1
+			}
+		}
+		byName[d.Name()] = u
+	}
+	var mu sync.Mutex
+	err = e.walkParallel(resolved, func(path string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(resolved, path)
+		if err != nil {
+			return nil
+		}
+		top, _, nested := strings.Cut(rel, string(filepath.Separator))
+		if !nested {
+			return nil // counted above
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		mu.Lock()
+		if u := byName[top]; u != nil {
+			u.Size += info.Size()
+			u.Files++
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return protocol.DiskUsageResult{}, fmt.Errorf("disk usage: %w", err)
+	}
+
+	for _, u := range byName {
+		result.Size += u.Size
+		result.Entries = append(result.Entries, *u)
+	}
+	sort.Slice(result.Entries, func(i, j int) bool {
+		a, b := result.Entries[i], result.Entries[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Name < b.Name
+	})
+	if len(result.Entries) > maxDiskUsageEntries {
+		result.Entries, result.Truncated = result.Entries[:maxDiskUsageEntries], true
+	}
+	return result, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package executor
+
+import "syscall"
+
+// volumeSpace returns the size of the volume holding path, the bytes in
+// use on it and the bytes available to this user, which excludes any
+// space reserved for root.
+func volumeSpace(path string) (total, used, free int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	bsize := int64(st.Bsize)
+	return int64(st.Blocks) * bsize, int64(st.Blocks-st.Bfree) * bsize, int64(st.Bavail) * bsize, nil
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package executor
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// volumeSpace returns the size of the volume holding path, the bytes in
+// use on it and the bytes available to this user, which excludes space
+// beyond the user's disk quota.
+func volumeSpace(path string) (total, used, free int64, err error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var avail, size, totalFree uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return 0, 0, 0, err
+	}
+	return int64(size), int64(size - totalFree), int64(avail), nil
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/process"
+)
+
+// validEnv checks the variable names of an exec request's Env.
+func validEnv(env map[string]string) error {
+	for k, v := range env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+		if strings.ContainsRune(v, 0) {
+			return fmt.Errorf("environment variable %s contains a NUL byte", k)
+		}
+	}
+	return nil
+}
+
+// commandEnv returns the environment a command runs with: the runner's
+// own, or none with clear, overlaid with vars, and process.RunnerEnv to
+// mark what the command starts as the runner's. It is never nil, as a
+// nil exec.Cmd.Env inherits the runner's.
+func commandEnv(vars map[string]string, clear bool) []string {
+	env := []string{}
+	if !clear {
+		env = os.Environ()
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = setEnv(env, k, vars[k])
+	}
+	return setEnv(env, process.RunnerEnv, strconv.Itoa(os.Getpid()))
+}
+
+// setEnv sets key in env, replacing any existing value.
+func setEnv(env []string, key, value string) []string {
+	out := env[:0:0]
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); !envKeyEqual(k, key) {
+			out = append(out, kv)
+		}
+	}
+	return append(out, key+"="+value)
+}
+
+// lookupEnv returns the value of key in env.
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, _ := strings.Cut(env[i], "="); envKeyEqual(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// envKeyEqual compares variable names, which are case-insensitive on
+// Windows.
+func envKeyEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
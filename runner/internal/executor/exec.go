@@ -4,30 +4,173 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/scienceol/xyzen/runner/internal/canary"
+	"github.com/scienceol/xyzen/runner/internal/index"
+	"github.com/scienceol/xyzen/runner/internal/problems"
 	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/textenc"
 )
 
 const (
-	defaultTimeout = 300 // seconds
+	defaultTimeout = 300     // seconds
 	maxOutputBytes = 1 << 20 // 1 MB
 )
 
 // Executor handles command execution and file operations within a work directory.
 type Executor struct {
 	workDir string
+
+	// Profiles maps command classes (see Classify) to execution profiles.
+	// A "default" entry applies to unclassified commands.
+	Profiles map[string]Profile
+	// ClassRules are consulted before the built-in classifier rules.
+	ClassRules []ClassRule
+	// Tripwire, if set, flags and blocks requests that touch canary files.
+	Tripwire *canary.Tripwire
+	// WalkWorkers bounds the goroutines find_files and search_in_files
+	// use to walk and scan; 0 means GOMAXPROCS.
+	WalkWorkers int
+	// Ripgrep is the path of the rg binary used for search_in_files; empty
+	// selects the built-in search.
+	Ripgrep string
+	// Limits caps the result and file size limits of find_files and
+	// search_in_files requests.
+	Limits SearchLimits
+	// Index, if set and ready, shortlists the files search_in_files scans.
+	Index *index.Index
+	// GitHooks decides whether git commands run repository hooks.
+	GitHooks GitHooksPolicy
+	// Quotas, if set, bounds what file writes may store under some
+	// directories.
+	Quotas *Quotas
+	// CrashDir, if set, is where Exec keeps the crash artifacts of
+	// commands run with CollectCrash, one directory per crash.
+	CrashDir string
+	// SnapshotDir is where Exec copies the work dir for commands run in a
+	// snapshot and RunScript writes its scripts (default: the system temp
+	// dir), and SnapshotMaxSize the most bytes of files Exec copies
+	// (default 2 GB).
+	SnapshotDir     string
+	SnapshotMaxSize int64
+	// ContainerRuntime is the docker or podman binary that runs commands
+	// requested with a container; empty looks for either on PATH.
+	ContainerRuntime string
+	// ResourceLimits are the default and maximum resource limits of exec
+	// commands.
+	ResourceLimits protocol.ResourceLimits
+	// Priority is the highest priority exec commands and jobs run at (see
+	// PriorityLow); requests may only lower it.
+	Priority string
+	// TrashDir, if set, is where deleted files and directories are moved
+	// so that undelete can restore them; otherwise deletions are final.
+	TrashDir string
+	// Jobs, if set, runs background jobs (see StartJob).
+	Jobs *JobManager
+	// OutputDir, if set, is where Exec keeps the full output of commands
+	// that exceed the output limit, for ReadExecOutput; otherwise the
+	// middle of such output is discarded.
+	OutputDir string
+
+	retries *Retries
+	// check, if set, decides whether commands may run (see
+	// WithCommandCheck).
+	check CommandCheck
+	// scratch holds the snapshot copies and scripts under SnapshotDir
+	// that running commands use (see InUse).
+	scratch *sync.Map
+	// progress, if set, is called every progressEvery while a command runs.
+	progress      func(protocol.ExecProgressPayload)
+	progressEvery time.Duration
 }
 
 // New creates a new Executor rooted at the given directory.
 func New(workDir string) *Executor {
-	return &Executor{workDir: workDir}
+	return &Executor{workDir: workDir, scratch: &sync.Map{}}
+}
+
+// WithWorkDir returns a copy of e rooted at workDir, sharing its profiles,
+// class rules and tripwire.
+func (e *Executor) WithWorkDir(workDir string) *Executor {
+	cp := *e
+	cp.workDir = workDir
+	return &cp
 }
 
-// Exec runs a shell command and returns the result.
-func (e *Executor) Exec(command, cwd string, timeoutSec int) protocol.ExecResultPayload {
+// Exec runs a shell command and returns the result. The command is
+// classified and run under the matching execution profile. With
+// p.SnapshotOnFailure, a failed result carries an environment snapshot.
+// Errors and stack traces in a failed command's output are extracted
+// into the result's Errors, and references to workspace files in any
+// output are collected into its Links. With p.CollectCrash, a crashed
+// command's crash log and core dump are kept under e.CrashDir. With
+// p.ExecuteIn "snapshot", the command runs in a copy of the work dir
+// instead (see execInSnapshot). A command e's CommandCheck refuses is not
+// run.
+func (e *Executor) Exec(p protocol.ExecPayload) protocol.ExecResultPayload {
+	if err := e.checkCommand(Command{Kind: CommandExec, Line: p.Command, Env: p.Env}); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error()}
+	}
+	// Checked once: the snapshot copy's Exec must not check again.
+	e = e.WithCommandCheck(nil)
+	switch p.ExecuteIn {
+	case "", ExecInWorkDir:
+	case ExecInSnapshot:
+		return e.execInSnapshot(p)
+	default:
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("invalid execute_in %q (want %s or %s)", p.ExecuteIn, ExecInWorkDir, ExecInSnapshot)}
+	}
+	if err := validRetries(p); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error()}
+	}
+	start := time.Now()
+	r, group := e.runWithRetries(p)
+	dir := e.snapshotDir(p.Cwd)
+	output := r.Stderr + "\n" + r.StderrTail + "\n" + r.Stdout + "\n" + r.StdoutTail
+	r.Links = e.links(output, dir)
+	if r.ExitCode != 0 {
+		r.Errors = problems.Extract(output)
+		e.linkErrors(r.Errors, dir)
+	}
+	// A container's environment is its image's, not the host's.
+	if p.SnapshotOnFailure && r.ExitCode != 0 && p.Container == nil {
+		r.Environment = e.snapshot(dir, p.Cwd, commandEnv(p.Env, p.EnvClear))
+	}
+	if p.CollectCrash && e.CrashDir != "" && r.ExitCode != 0 {
+		r.Crash = e.collectCrash(p, r, dir, start, group)
+	}
+	return r
+}
+
+// run runs p once. With p.CollectCrash, the command leads a process group
+// of its own, which run returns (0 otherwise) so that its core dumps can
+// be found.
+func (e *Executor) run(p protocol.ExecPayload) (protocol.ExecResultPayload, int) {
+	command, cwd := p.Command, p.Cwd
+	if e.Tripwire.CheckCommand(command) {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: "command blocked: references a protected path"}, 0
+	}
+	class := e.Classify(command)
+	profile := e.profileFor(class)
+	if p.Limits == nil {
+		p.Limits = profile.Limits
+	}
+	if p.Container == nil && profile.Container != nil {
+		spec := *profile.Container
+		p.Container = &spec
+	}
+
+	timeoutSec := p.Timeout
+	if timeoutSec <= 0 {
+		timeoutSec = profile.Timeout
+	}
 	if timeoutSec <= 0 {
 		timeoutSec = defaultTimeout
 	}
@@ -40,51 +183,201 @@ func (e *Executor) Exec(command, cwd string, timeoutSec int) protocol.ExecResult
 	if cwd != "" {
 		resolved, err := e.resolvePath(cwd)
 		if err != nil {
-			return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error()}
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
 		}
 		dir = resolved
 	}
 
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		shell := findPowerShell()
-		cmd = exec.CommandContext(ctx, shell, "-NoProfile", "-NonInteractive", "-Command", command)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	if p.CollectCrash {
+		command = enableCoreDumps(command)
 	}
+	if err := textenc.Valid(p.OutputEncoding); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+	}
+	if err := validLimits(p.Limits); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+	}
+	if err := ValidPriority(p.Priority); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+	}
+	limits := e.limitsFor(p.Limits)
+	var (
+		limitedBy string
+		attr      *syscall.SysProcAttr
+		release   = func() {}
+	)
+	if p.Container == nil {
+		var err error
+		command, limits, limitedBy, attr, release, err = limitCommand(command, limits)
+		if err != nil {
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+		}
+	}
+	defer release()
+	argv := shellArgv(command)
+	if profile.Network == NetworkDeny && p.Container == nil {
+		prefix, err := networkDenyPrefix()
+		if err != nil {
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("profile %q: %v", class, err), Class: class}, 0
+		}
+		argv = append(prefix, argv...)
+	}
+
+	if p.GitHooks != "" {
+		if err := ValidGitHooks(p.GitHooks); err != nil {
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+		}
+	}
+	if err := validEnv(p.Env); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+	}
+	hooks := e.GitHooks.For(dir, p.GitHooks)
+	env := commandEnv(p.Env, p.EnvClear)
+	if hooks == GitHooksBypass {
+		env = bypassGitHooks(env)
+	}
+	var container string
+	if p.Container != nil {
+		var err error
+		if argv, env, container, err = e.containerCommand(p, dir, profile, hooks, limits); err != nil {
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error(), Class: class}, 0
+		}
+		limitedBy = LimitedByContainer
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
 	cmd.Dir = dir
+	cmd.Env = env
+	cmd.SysProcAttr = attr
+	grouped := p.CollectCrash && container == ""
+	if grouped {
+		cmd.SysProcAttr = ownGroup(cmd.SysProcAttr)
+	}
+	prio := e.priorityFor(p.Priority)
+	if container == "" {
+		prio = prioritize(cmd, prio)
+	}
+	var applied *protocol.ResourceLimits
+	if limitedBy != "" {
+		applied = &limits
+	}
 
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &limitedWriter{w: &stdout, limit: maxOutputBytes}
-	cmd.Stderr = &limitedWriter{w: &stderr, limit: maxOutputBytes}
+	var spill *outputSpill
+	if e.OutputDir != "" {
+		spill = &outputSpill{parent: e.OutputDir}
+	}
+	stdoutW := &limitedWriter{w: &stdout, limit: maxOutputBytes, spill: spill, stream: "stdout"}
+	stderrW := &limitedWriter{w: &stderr, limit: maxOutputBytes, spill: spill, stream: "stderr"}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
 
-	err := cmd.Run()
+	start := time.Now()
+	err := cmd.Start()
+	group := 0
+	if err == nil && grouped {
+		group = cmd.Process.Pid
+	}
+	if err == nil {
+		stop := e.reportProgress(cmd.Process.Pid, start, stdoutW, stderrW, container == "")
+		err = cmd.Wait()
+		stop()
+	}
+	stdoutW.closeSpill()
+	stderrW.closeSpill()
 
-	exitCode := 0
+	r := protocol.ExecResultPayload{
+		Class:      class,
+		GitHooks:   hooks,
+		Limits:     applied,
+		LimitedBy:  limitedBy,
+		Priority:   prio,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	// A container's usage is not its runtime client's.
+	if ps := cmd.ProcessState; ps != nil && container == "" {
+		r.UserCPUMs, r.SystemCPUMs = ps.UserTime().Milliseconds(), ps.SystemTime().Milliseconds()
+		r.MaxRSSBytes = maxRSS(ps)
+	}
+	r.StdoutTruncated, r.StderrTruncated = stdoutW.truncated, stderrW.truncated
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
+			r.ExitCode, r.Signal = exitErr.ExitCode(), exitSignal(exitErr.ProcessState)
 		} else if ctx.Err() == context.DeadlineExceeded {
-			return protocol.ExecResultPayload{
-				ExitCode: -1,
-				Stdout:   stdout.String(),
-				Stderr:   fmt.Sprintf("command timed out after %ds\n%s", timeoutSec, stderr.String()),
+			if container != "" {
+				e.removeContainer(container)
 			}
+			r.ExitCode = -1
+			decodeOutput(&r, stdoutW, stderrW, p.OutputEncoding)
+			r.OutputID = spill.save(r)
+			r.Stderr = fmt.Sprintf("command timed out after %ds\n%s", timeoutSec, r.Stderr)
+			return r, group
 		} else {
-			exitCode = -1
+			r.ExitCode = -1
 			if stderr.Len() == 0 {
 				stderr.WriteString(err.Error())
 			}
 		}
 	}
 
-	return protocol.ExecResultPayload{
-		ExitCode: exitCode,
-		Stdout:   stdout.String(),
-		Stderr:   stderr.String(),
+	e.Tripwire.CheckOutput("exec", stdout.Bytes())
+	e.Tripwire.CheckOutput("exec", stdoutW.tailBytes())
+
+	decodeOutput(&r, stdoutW, stderrW, p.OutputEncoding)
+	r.OutputID = spill.save(r)
+	return r, group
+}
+
+// decodeOutput sets r's Stdout and Stderr, and for truncated streams
+// their tails and sizes, to the command's output converted to UTF-8 from
+// enc (see textenc.Decode), noting the encodings other than UTF-8 it
+// found and the invalid sequences it replaced. A tail is decoded from
+// the encoding found in its stream's head.
+func decodeOutput(r *protocol.ExecResultPayload, stdout, stderr *limitedWriter, enc string) {
+	decode := func(w *limitedWriter) (head, tail, found string, bad int) {
+		b := w.w.Bytes()
+		if !w.truncated {
+			head, found, bad = textenc.Decode(b, enc)
+		} else {
+			// The cuts may have split characters.
+			head, found, bad = textenc.Decode(trimPartialRune(b[:min(len(b), spillHeadBytes)]), enc)
+			t := w.tailBytes()
+			switch found {
+			case textenc.UTF8:
+				t = trimLeadingPartialRune(t)
+			case textenc.UTF16LE, textenc.UTF16BE:
+				if (w.total.Load()-int64(len(t)))%2 != 0 {
+					t = t[1:]
+				}
+			}
+			var tailBad int
+			tail, _, tailBad = textenc.Decode(t, found)
+			bad += tailBad
+		}
+		if found == textenc.UTF8 {
+			found = ""
+		}
+		return head, tail, found, bad
+	}
+	var outBad, errBad int
+	r.Stdout, r.StdoutTail, r.StdoutEncoding, outBad = decode(stdout)
+	r.Stderr, r.StderrTail, r.StderrEncoding, errBad = decode(stderr)
+	r.InvalidSequences = outBad + errBad
+	if stdout.truncated {
+		r.StdoutSize = stdout.total.Load()
+	}
+	if stderr.truncated {
+		r.StderrSize = stderr.total.Load()
 	}
 }
 
+// shellArgv returns the argv that runs command through the platform shell.
+func shellArgv(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{findPowerShell(), "-NoProfile", "-NonInteractive", "-Command", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
 // findPowerShell returns the path to the best available PowerShell
 // executable: pwsh.exe (PowerShell 7+) if present, otherwise the
 // built-in powershell.exe.
@@ -95,22 +388,40 @@ func findPowerShell() string {
 	return "powershell.exe"
 }
 
-// limitedWriter wraps an io.Writer and stops writing after limit bytes.
+// limitedWriter buffers a stream's first limit bytes, noting in truncated
+// that there were more. Past the limit it keeps the stream's tail and,
+// with a spill, writes the whole stream to the spill's file for stream.
+// total counts all bytes written to it, and may be read while it is in
+// use.
 type limitedWriter struct {
-	w       *bytes.Buffer
-	limit   int
-	written int
+	w         *bytes.Buffer
+	limit     int
+	written   int
+	truncated bool
+	total     atomic.Int64
+
+	spill  *outputSpill
+	stream string
+	file   *os.File
+	tail   []byte
 }
 
 func (lw *limitedWriter) Write(p []byte) (int, error) {
-	remaining := lw.limit - lw.written
-	if remaining <= 0 {
-		return len(p), nil // Discard silently
+	lw.total.Add(int64(len(p)))
+	rest := p
+	if n := min(len(p), lw.limit-lw.written); n > 0 {
+		lw.w.Write(p[:n])
+		lw.written += n
+		rest = p[n:]
 	}
-	if len(p) > remaining {
-		p = p[:remaining]
+	if len(rest) > 0 {
+		if !lw.truncated {
+			lw.truncated = true
+			lw.startSpill()
+		}
+		lw.spillMore(rest)
 	}
-	n, err := lw.w.Write(p)
-	lw.written += n
-	return n, err
+	// Report the whole write as done: a short count would fail the
+	// command's output copy with io.ErrShortWrite.
+	return len(p), nil
 }
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// maxExecRetries bounds ExecPayload.Retries.
+const maxExecRetries = 5
+
+// execRetryDelay is the wait before a command's first retry; it doubles
+// for each retry after, up to maxExecRetryDelay.
+var (
+	execRetryDelay    = time.Second
+	maxExecRetryDelay = 30 * time.Second
+)
+
+func validRetries(p protocol.ExecPayload) error {
+	if p.Retries < 0 || p.Retries > maxExecRetries {
+		return fmt.Errorf("invalid retries %d (want 0 to %d)", p.Retries, maxExecRetries)
+	}
+	for _, code := range p.RetryOnExitCodes {
+		if code == 0 {
+			return fmt.Errorf("invalid retry_on_exit_codes: 0 is success")
+		}
+	}
+	return nil
+}
+
+// shouldRetry reports whether a command that finished with r is retried:
+// by default if it exited with a failure, otherwise if its exit code is
+// one of p.RetryOnExitCodes.
+func shouldRetry(p protocol.ExecPayload, r protocol.ExecResultPayload) bool {
+	if len(p.RetryOnExitCodes) == 0 {
+		return r.ExitCode > 0
+	}
+	for _, code := range p.RetryOnExitCodes {
+		if r.ExitCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// runWithRetries runs p, retrying it up to p.Retries times with backoff
+// while shouldRetry, and returns the last attempt's result with its
+// process group (see run).
+func (e *Executor) runWithRetries(p protocol.ExecPayload) (protocol.ExecResultPayload, int) {
+	r, group := e.run(p)
+	if p.Retries == 0 {
+		return r, group
+	}
+	delay := execRetryDelay
+	attempts := 1
+	for ; attempts <= p.Retries && shouldRetry(p, r); attempts++ {
+		time.Sleep(delay)
+		delay = min(delay*2, maxExecRetryDelay)
+		r, group = e.run(p)
+	}
+	r.Attempts = attempts
+	return r, group
+}
@@ -0,0 +1,229 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Where exec runs a command (ExecPayload.ExecuteIn).
+const (
+	ExecInWorkDir  = "workdir"
+	ExecInSnapshot = "snapshot"
+)
+
+const (
+	// defaultSnapshotMaxSize bounds the work dir copied for a snapshot
+	// run when e.SnapshotMaxSize is unset.
+	defaultSnapshotMaxSize = 2 << 30
+	// maxSnapshotChanges bounds the changes a snapshot run reports.
+	maxSnapshotChanges = 1000
+)
+
+// snapshotFile is a regular file or symlink of a tree being compared.
+type snapshotFile struct {
+	mode    fs.FileMode
+	size    int64
+	modTime int64
+}
+
+// execInSnapshot runs a command in a throwaway copy of the work dir,
+// made under e.SnapshotDir, and reports what it changed there. The copy
+// keeps the work dir's base name and file modification times, so build
+// tools see the tree as they would the real one.
+func (e *Executor) execInSnapshot(p protocol.ExecPayload) protocol.ExecResultPayload {
+	src, err := filepath.EvalSymlinks(e.workDir)
+	if err != nil {
+		src = filepath.Clean(e.workDir)
+	}
+	if e.SnapshotDir != "" {
+		if err := os.MkdirAll(e.SnapshotDir, 0o700); err != nil {
+			return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("snapshot: %v", err)}
+		}
+	}
+	tmp, err := os.MkdirTemp(e.SnapshotDir, "exec-")
+	if err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("snapshot: %v", err)}
+	}
+	defer os.RemoveAll(tmp)
+	defer e.useScratch(tmp)()
+	root := filepath.Join(tmp, filepath.Base(src))
+	maxSize := e.SnapshotMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultSnapshotMaxSize
+	}
+	if err := copySnapshot(src, root, maxSize); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("snapshot: %v", err)}
+	}
+
+	p.ExecuteIn = ""
+	r := e.WithWorkDir(root).Exec(p)
+	r.Changes, r.ChangesTruncated = snapshotChanges(src, root)
+	return r
+}
+
+// useScratch marks path, under SnapshotDir, as in use until the returned
+// func is called.
+func (e *Executor) useScratch(path string) func() {
+	e.scratch.Store(filepath.Clean(path), struct{}{})
+	return func() { e.scratch.Delete(filepath.Clean(path)) }
+}
+
+// InUse reports whether path, an entry of SnapshotDir, is a snapshot copy
+// or script that a running command uses, which garbage collection must
+// spare.
+func (e *Executor) InUse(path string) bool {
+	_, ok := e.scratch.Load(filepath.Clean(path))
+	return ok
+}
+
+// copySnapshot copies the tree at src to dst as copyTree does, keeping
+// file modification times. It fails once the files copied exceed maxSize
+// bytes.
+func copySnapshot(src, dst string, maxSize int64) error {
+	var size int64
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if size += info.Size(); size > maxSize {
+				return fmt.Errorf("work dir is larger than %d bytes", maxSize)
+			}
+			if err := copyRegular(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		default:
+			return nil // sockets, devices, pipes
+		}
+	})
+}
+
+// snapshotChanges compares the snapshot at root with the tree at src it
+// was copied from. Files whose size and modification time are unchanged
+// are taken to be unchanged.
+func snapshotChanges(src, root string) ([]protocol.SnapshotChange, bool) {
+	before, after := snapshotFiles(src), snapshotFiles(root)
+	var paths []string
+	for rel := range before {
+		paths = append(paths, rel)
+	}
+	for rel := range after {
+		if _, ok := before[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	var (
+		changes   []protocol.SnapshotChange
+		diffBytes int
+	)
+	for _, rel := range paths {
+		old, oldOK := before[rel]
+		cur, curOK := after[rel]
+		if oldOK && curOK && old == cur {
+			continue
+		}
+		oldData, oldRead := readSnapshotFile(filepath.Join(src, rel), old, oldOK)
+		newData, newRead := readSnapshotFile(filepath.Join(root, rel), cur, curOK)
+		if oldOK && curOK && oldRead && newRead && old.mode == cur.mode && bytes.Equal(oldData, newData) {
+			continue
+		}
+		if len(changes) == maxSnapshotChanges {
+			return changes, true
+		}
+		c := protocol.SnapshotChange{Path: filepath.ToSlash(rel), Status: "modified"}
+		switch {
+		case !oldOK:
+			c.Status = "added"
+		case !curOK:
+			c.Status = "deleted"
+		}
+		switch {
+		case !oldRead || !newRead: // too large to diff
+		case diff.IsBinary(oldData) || diff.IsBinary(newData):
+			c.Binary = true
+		default:
+			if d := unifiedResult(c.Path, c.Path, oldData, newData, oldOK, curOK, nil); diffBytes+len(d.Diff) <= maxOutputBytes {
+				c.Diff = d.Diff
+				diffBytes += len(d.Diff)
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes, false
+}
+
+// snapshotFiles lists the regular files and symlinks under root, by
+// slash-separated relative path, skipping .git directories.
+func snapshotFiles(root string) map[string]snapshotFile {
+	files := map[string]snapshotFile{}
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		files[rel] = snapshotFile{mode: info.Mode(), size: info.Size(), modTime: info.ModTime().UnixNano()}
+		return nil
+	})
+	return files
+}
+
+// readSnapshotFile returns the content of a file being compared, or a
+// symlink's target; a missing file reads as empty. It reports false if
+// the file is too large to diff or cannot be read.
+func readSnapshotFile(path string, f snapshotFile, ok bool) ([]byte, bool) {
+	switch {
+	case !ok:
+		return nil, true
+	case f.mode&fs.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		return []byte(link), err == nil
+	case f.size > maxDiffSize:
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	return data, err == nil
+}
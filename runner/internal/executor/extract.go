@@ -0,0 +1,348 @@
+package executor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	maxExtractEntries = 200000
+	maxExtractBytes   = 16 << 30
+)
+
+// ExtractArchive unpacks a tar.gz or zip archive into the directory
+// p.Destination. The archive is the host file hostSrc (an uploaded
+// transfer) or, if hostSrc is empty, the workspace file p.Source. The
+// format is detected from the content.
+//
+// Every entry must stay inside the destination: absolute names, ".."
+// escapes and symlinks pointing outside are rejected, and entries are
+// never written through links. Existing files are only replaced with
+// p.Overwrite.
+func (e *Executor) ExtractArchive(p protocol.ExtractArchivePayload, hostSrc string) (protocol.ExtractStats, error) {
+	if hostSrc == "" {
+		resolved, err := e.resolvePath(p.Source)
+		if err != nil {
+			return protocol.ExtractStats{}, err
+		}
+		hostSrc = resolved
+	}
+	dest, err := protocol.CleanPath(p.Destination)
+	if err != nil {
+		return protocol.ExtractStats{}, err
+	}
+	destResolved, err := e.resolvePath(dest)
+	if err != nil {
+		return protocol.ExtractStats{}, err
+	}
+	if err := os.MkdirAll(destResolved, 0o755); err != nil {
+		return protocol.ExtractStats{}, fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.Open(hostSrc)
+	if err != nil {
+		return protocol.ExtractStats{}, fmt.Errorf("extract: %w", err)
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return protocol.ExtractStats{}, fmt.Errorf("extract: archive too short")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return protocol.ExtractStats{}, err
+	}
+
+	x := &extractor{e: e, dest: dest, destResolved: destResolved, overwrite: p.Overwrite}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		x.stats.Format = FormatTarGz
+		err = x.tarGz(f)
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		x.stats.Format = FormatZip
+		var info fs.FileInfo
+		if info, err = f.Stat(); err == nil {
+			err = x.zip(f, info.Size())
+		}
+	default:
+		return protocol.ExtractStats{}, fmt.Errorf("extract: not a tar.gz or zip archive")
+	}
+	if err != nil {
+		return x.stats, fmt.Errorf("extract: %w", err)
+	}
+	return x.stats, nil
+}
+
+type extractor struct {
+	e    *Executor
+	dest string
+	// destResolved is dest's host path.
+	destResolved string
+	overwrite    bool
+	stats        protocol.ExtractStats
+}
+
+func (x *extractor) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(hdr.Name, hdr.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.file(hdr.Name, hdr.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			err = x.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = x.hardlink(hdr.Name, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			x.stats.Skipped++ // devices, fifos
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.dir(zf.Name, mode)
+		case mode&fs.ModeSymlink != 0:
+			err = x.zipSymlink(zf)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = zf.Open(); err == nil {
+				err = x.file(zf.Name, mode, rc)
+				rc.Close()
+			}
+		default:
+			x.stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipSymlink(zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(zf.Name, string(target))
+}
+
+// entry validates an archive entry name and returns its wire path and
+// resolved host path.
+func (x *extractor) entry(name string) (string, string, error) {
+	if x.stats.Files+x.stats.Dirs+x.stats.Links >= maxExtractEntries {
+		return "", "", fmt.Errorf("more than %d entries", maxExtractEntries)
+	}
+	rel, err := protocol.CleanPath(strings.TrimSuffix(name, "/"))
+	if err != nil || rel == "." {
+		return "", "", fmt.Errorf("unsafe entry name %q", name)
+	}
+	wire := protocol.JoinPath(x.dest, rel)
+	resolved, err := x.e.resolvePath(wire)
+	if err != nil {
+		return "", "", fmt.Errorf("entry %q: %w", name, err)
+	}
+	return wire, resolved, nil
+}
+
+// place prepares resolved to receive a new entry: parents are created and
+// an existing file is removed if overwriting is allowed.
+func (x *extractor) place(wire, resolved string) error {
+	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+		return err
+	}
+	// Re-resolve now the parents exist, so a link created by an earlier
+	// entry can't redirect this one.
+	if _, err := x.e.resolvePath(wire); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(resolved); err == nil {
+		if !x.overwrite {
+			return fmt.Errorf("%q already exists", wire)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%q is a directory", wire)
+		}
+		if err := os.Remove(resolved); err != nil {
+			return err
+		}
+	}
+	return x.e.checkCaseConflict(resolved)
+}
+
+func (x *extractor) dir(name string, mode fs.FileMode) error {
+	_, resolved, err := x.entry(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(resolved, 0o755|mode.Perm()); err != nil {
+		return err
+	}
+	x.stats.Dirs++
+	return nil
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	wire, resolved, err := x.entry(name)
+	if err != nil {
+		return err
+	}
+	if err := x.place(wire, resolved); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(resolved, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, maxExtractBytes-x.stats.Bytes+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	x.stats.Bytes += n
+	if err == nil && x.stats.Bytes > maxExtractBytes {
+		err = fmt.Errorf("archive expands to more than %d bytes", int64(maxExtractBytes))
+	}
+	if err != nil {
+		return err
+	}
+	x.stats.Files++
+	return nil
+}
+
+func (x *extractor) symlink(name, target string) error {
+	wire, resolved, err := x.entry(name)
+	if err != nil {
+		return err
+	}
+	if path.IsAbs(target) || strings.Contains(target, `\`) {
+		return fmt.Errorf("symlink %q has unsafe target %q", name, target)
+	}
+	if err := x.symlinkInside(resolved, target); err != nil {
+		return fmt.Errorf("symlink %q: %w", name, err)
+	}
+	if err := x.place(wire, resolved); err != nil {
+		return err
+	}
+	if err := os.Symlink(filepath.FromSlash(target), resolved); err != nil {
+		return err
+	}
+	x.stats.Links++
+	return nil
+}
+
+// symlinkInside checks that a link at resolved to target stays inside the
+// destination. The target is resolved against the link's real parent
+// directory, as symlinkResult does, so that links extracted earlier (e.g.
+// "a" -> ".") cannot make a lexically safe target escape.
+func (x *extractor) symlinkInside(resolved, target string) error {
+	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+		return err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(resolved))
+	if err != nil {
+		return err
+	}
+	dest, err := filepath.EvalSymlinks(x.destResolved)
+	if err != nil {
+		return err
+	}
+	result, err := x.e.WithWorkDir(dest).symlinkResult(filepath.Join(parent, filepath.Base(resolved)), filepath.FromSlash(target))
+	if err != nil {
+		return err
+	}
+	if result.Outside {
+		return fmt.Errorf("points outside %q", x.dest)
+	}
+	return nil
+}
+
+func (x *extractor) hardlink(name, target string) error {
+	wire, resolved, err := x.entry(name)
+	if err != nil {
+		return err
+	}
+	rel, err := protocol.CleanPath(target)
+	if err != nil {
+		return fmt.Errorf("hard link %q has unsafe target %q", name, target)
+	}
+	src, err := x.e.resolvePath(protocol.JoinPath(x.dest, rel))
+	if err != nil {
+		return err
+	}
+	if err := x.linkedInside(src); err != nil {
+		return fmt.Errorf("hard link %q: %w", name, err)
+	}
+	if err := x.place(wire, resolved); err != nil {
+		return err
+	}
+	if err := os.Link(src, resolved); err != nil {
+		return err
+	}
+	x.stats.Links++
+	return nil
+}
+
+// linkedInside checks that the file a hard link to src would share is
+// inside the destination, as symlinkInside does for symlinks, so that an
+// archive cannot link to, and then overwrite, other files of the work
+// dir. os.Link does not follow a symlink at src, so only its parent is
+// resolved.
+func (x *extractor) linkedInside(src string) error {
+	parent, err := filepath.EvalSymlinks(filepath.Dir(src))
+	if err != nil {
+		return err
+	}
+	dest, err := filepath.EvalSymlinks(x.destResolved)
+	if err != nil {
+		return err
+	}
+	if !within(dest, filepath.Join(parent, filepath.Base(src))) {
+		return fmt.Errorf("points outside %q", x.dest)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// writeTarGz writes a tar.gz of the given entries, which have no content,
+// to path.
+func writeTarGz(t *testing.T, path string, headers ...*tar.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExtractChainedSymlinkEscape extracts a tar whose second link is
+// lexically inside the destination but, through the first, points out of
+// it.
+func TestExtractChainedSymlinkEscape(t *testing.T) {
+	workDir := t.TempDir()
+	writeTarGz(t, filepath.Join(workDir, "x.tar.gz"),
+		&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		&tar.Header{Name: "a/esc", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+	)
+
+	e := New(workDir)
+	_, err := e.ExtractArchive(protocol.ExtractArchivePayload{Source: "x.tar.gz", Destination: "out"}, "")
+	if err == nil {
+		t.Fatal("ExtractArchive succeeded; want the escaping link refused")
+	}
+	if _, err := os.Lstat(filepath.Join(workDir, "out", "esc")); !os.IsNotExist(err) {
+		t.Fatalf("out/esc was created (Lstat error %v)", err)
+	}
+}
+
+// TestExtractHardlink extracts hard links to files inside the destination,
+// and refuses one that reaches another file of the work dir through a
+// link already in the destination.
+func TestExtractHardlink(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		linkname string
+		ok       bool
+	}{
+		{"inside", "f", true},
+		{"through link", "up/secret", false},
+		{"dotdot", "../secret", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(workDir, "secret"), []byte("s"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(workDir, "out"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("..", filepath.Join(workDir, "out", "up")); err != nil {
+				t.Fatal(err)
+			}
+			writeTarGz(t, filepath.Join(workDir, "x.tar.gz"),
+				&tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0o644},
+				&tar.Header{Name: "l", Typeflag: tar.TypeLink, Linkname: tc.linkname},
+			)
+
+			e := New(workDir)
+			_, err := e.ExtractArchive(protocol.ExtractArchivePayload{Source: "x.tar.gz", Destination: "out"}, "")
+			if tc.ok && err != nil {
+				t.Fatalf("ExtractArchive: %v", err)
+			}
+			if !tc.ok {
+				if err == nil {
+					t.Fatal("ExtractArchive succeeded; want the link refused")
+				}
+				if _, err := os.Lstat(filepath.Join(workDir, "out", "l")); !os.IsNotExist(err) {
+					t.Fatalf("out/l was created (Lstat error %v)", err)
+				}
+			}
+		})
+	}
+}
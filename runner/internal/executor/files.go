@@ -1,54 +1,195 @@
 package executor
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/scienceol/xyzen/runner/internal/protocol"
 )
 
-// ReadFile reads a text file and returns its content.
-func (e *Executor) ReadFile(path string) (string, error) {
-	resolved, err := e.resolvePath(path)
+// ReadFile reads a text file, or the part of it selected by p.Offset /
+// p.Length or p.LineRange, and returns the content and its SHA-256. With
+// p.LineNumbers or p.Blame the content is returned as annotated Lines
+// instead.
+func (e *Executor) ReadFile(p protocol.FilePayload) (protocol.FileResult, error) {
+	data, result, err := e.readRange(p)
 	if err != nil {
-		return "", err
+		return protocol.FileResult{}, err
+	}
+	if !p.LineNumbers && !p.Blame {
+		result.Content = string(data)
+		return result, nil
+	}
+	first := 1
+	if result.LineRange != nil {
+		first = result.LineRange.Start
+	} else if p.Offset > 0 {
+		if first, err = e.lineAt(p.Path, p.Offset); err != nil {
+			return protocol.FileResult{}, err
+		}
 	}
-	data, err := os.ReadFile(resolved)
+	resolved, err := e.resolvePath(p.Path)
 	if err != nil {
-		return "", fmt.Errorf("read file: %w", err)
+		return protocol.FileResult{}, err
 	}
-	return string(data), nil
+	result.Lines, result.BlameError = annotate(resolved, data, first, p.Blame)
+	return result, nil
 }
 
-// ReadFileBytes reads a file and returns base64-encoded content.
-func (e *Executor) ReadFileBytes(path string) (string, error) {
+// lineAt returns the line number containing byte offset off of a file.
+func (e *Executor) lineAt(path string, off int64) (int, error) {
 	resolved, err := e.resolvePath(path)
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return 0, fmt.Errorf("read file: %w", err)
+	}
+	defer f.Close()
+	return lineAt(f, off)
+}
+
+// ReadFileBytes is like ReadFile but returns base64-encoded content. The
+// SHA-256 is of the raw bytes.
+func (e *Executor) ReadFileBytes(p protocol.FilePayload) (protocol.FileResult, error) {
+	data, result, err := e.readRange(p)
+	if err != nil {
+		return protocol.FileResult{}, err
+	}
+	result.Data = base64.StdEncoding.EncodeToString(data)
+	return result, nil
+}
+
+// readRange reads the selected part of a file. The result's SHA256, Size,
+// Offset, EOF and LineRange are filled in.
+func (e *Executor) readRange(p protocol.FilePayload) ([]byte, protocol.FileResult, error) {
+	if p.LineRange != nil && (p.Offset != 0 || p.Length != 0) {
+		return nil, protocol.FileResult{}, fmt.Errorf("line_range cannot be combined with offset/length")
 	}
-	data, err := os.ReadFile(resolved)
+	if p.Offset < 0 || p.Length < 0 {
+		return nil, protocol.FileResult{}, fmt.Errorf("offset and length must not be negative")
+	}
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return nil, protocol.FileResult{}, err
+	}
+	var f *os.File
+	err = e.retry(func() (err error) {
+		f, err = os.Open(resolved)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("read file: %w", err)
+		return nil, protocol.FileResult{}, fmt.Errorf("read file: %w", err)
 	}
-	return base64.StdEncoding.EncodeToString(data), nil
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, protocol.FileResult{}, fmt.Errorf("read file: %w", err)
+	}
+	result := protocol.FileResult{Size: info.Size(), Offset: p.Offset}
+
+	var data []byte
+	if p.LineRange != nil {
+		data, result.LineRange, result.EOF, err = readLines(f, *p.LineRange)
+		result.Offset = 0
+	} else {
+		length := info.Size() - p.Offset
+		if p.Length > 0 && p.Length < length {
+			length = p.Length
+		}
+		length = max(length, 0)
+		data = make([]byte, length)
+		var n int
+		n, err = f.ReadAt(data, p.Offset)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		data = data[:n]
+		result.EOF = p.Offset+int64(n) >= info.Size()
+	}
+	if err != nil {
+		return nil, protocol.FileResult{}, fmt.Errorf("read file: %w", err)
+	}
+	result.SHA256 = checksum(data)
+	return data, result, nil
+}
+
+// readLines returns lines r.Start..r.End (1-based, inclusive; End 0 means
+// to the end of the file) with their line endings, the range actually
+// returned (nil if no lines), and whether the file ended within the range.
+func readLines(f *os.File, r protocol.LineRange) ([]byte, *protocol.LineRange, bool, error) {
+	if r.Start < 1 {
+		r.Start = 1
+	}
+	if r.End != 0 && r.End < r.Start {
+		return nil, nil, false, fmt.Errorf("invalid line_range %d-%d", r.Start, r.End)
+	}
+	br := bufio.NewReader(f)
+	var out []byte
+	got := protocol.LineRange{Start: r.Start}
+	for line := 1; r.End == 0 || line <= r.End; line++ {
+		text, err := br.ReadBytes('\n')
+		if len(text) > 0 && line >= r.Start {
+			out = append(out, text...)
+			got.End = line
+		}
+		if errors.Is(err, io.EOF) {
+			if got.End == 0 {
+				return out, nil, true, nil
+			}
+			return out, &got, true, nil
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	_, err := br.Peek(1)
+	return out, &got, errors.Is(err, io.EOF), nil
 }
 
 // WriteFile writes text content to a file, creating parent directories.
-func (e *Executor) WriteFile(path, content string) error {
+// If sum is non-empty the content must match it. mode, an octal
+// permission string, is set on the file if non-empty; otherwise an
+// existing file keeps its mode and a new one gets 0644. With atomic the
+// content goes to a temp file that is renamed into place, so readers and
+// a dropped connection never leave a truncated file.
+func (e *Executor) WriteFile(path, content, sum, mode string, atomic bool) error {
+	perm, err := parseMode(mode, 0)
+	if err != nil {
+		return err
+	}
 	resolved, err := e.resolvePath(path)
 	if err != nil {
 		return err
 	}
+	if err := verifyChecksum([]byte(content), sum); err != nil {
+		return err
+	}
+	if err := e.checkCaseConflict(resolved); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
 		return fmt.Errorf("create directory: %w", err)
 	}
-	return os.WriteFile(resolved, []byte(content), 0o644)
+	return e.write(resolved, []byte(content), perm, atomic)
 }
 
-// WriteFileBytes writes base64-decoded data to a file.
-func (e *Executor) WriteFileBytes(path, data string) error {
+// WriteFileBytes writes base64-decoded data to a file. If sum is non-empty
+// the decoded data must match it. mode and atomic are as for WriteFile.
+func (e *Executor) WriteFileBytes(path, data, sum, mode string, atomic bool) error {
+	perm, err := parseMode(mode, 0)
+	if err != nil {
+		return err
+	}
 	resolved, err := e.resolvePath(path)
 	if err != nil {
 		return err
@@ -57,49 +198,213 @@ func (e *Executor) WriteFileBytes(path, data string) error {
 	if err != nil {
 		return fmt.Errorf("base64 decode: %w", err)
 	}
+	if err := verifyChecksum(raw, sum); err != nil {
+		return err
+	}
+	if err := e.checkCaseConflict(resolved); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
 		return fmt.Errorf("create directory: %w", err)
 	}
-	return os.WriteFile(resolved, raw, 0o644)
+	return e.write(resolved, raw, perm, atomic)
 }
 
-// ListFiles returns entries in a directory.
-func (e *Executor) ListFiles(path string) ([]protocol.FileInfoResult, error) {
-	resolved, err := e.resolvePath(path)
+// write stores data at resolved, in place or atomically, with permissions
+// perm, or if perm is 0, those of the file it replaces (0644 for a new
+// file).
+func (e *Executor) write(resolved string, data []byte, perm os.FileMode, atomic bool) error {
+	if err := e.Quotas.check(resolved, int64(len(data)), false); err != nil {
+		return err
+	}
+	if !atomic {
+		return e.retry(func() error {
+			if err := os.WriteFile(resolved, data, 0o644); err != nil || perm == 0 {
+				return err
+			}
+			return os.Chmod(resolved, perm)
+		})
+	}
+	// Replace a symlink's target, not the link itself; resolvePath has
+	// already checked the target is inside the working directory.
+	target := resolved
+	if real, err := filepath.EvalSymlinks(resolved); err == nil {
+		target = real
+	}
+	mode := perm
+	if mode == 0 {
+		mode = existingMode(target)
+	}
+	return e.retry(func() error { return writeAtomic(target, data, mode) })
+}
+
+// existingMode returns the permissions of the file at path, or 0644 if
+// there is none.
+func existingMode(path string) os.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0o644
+}
+
+// writeAtomic writes data to a temp file beside path, syncs it and
+// renames it over path.
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".xyzen-tmp-*")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	name := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(name, mode)
+	}
+	if err == nil {
+		err = os.Rename(name, path)
 	}
-	entries, err := os.ReadDir(resolved)
 	if err != nil {
-		return nil, fmt.Errorf("list directory: %w", err)
+		_ = os.Remove(name)
 	}
+	return err
+}
 
-	var results []protocol.FileInfoResult
-	for _, entry := range entries {
-		info, err := entry.Info()
-		var size *int64
-		if err == nil {
-			s := info.Size()
-			size = &s
+// AppendFile appends content (or base64 Data) to a file with O_APPEND,
+// creating it and its parent directories if needed, and sets p.Mode on it
+// if given. The bytes go out in a single write, so concurrent appenders
+// never interleave within an entry. The result gives the offset the data
+// landed at and the new size.
+func (e *Executor) AppendFile(p protocol.FilePayload) (protocol.FileResult, error) {
+	perm, err := parseMode(p.Mode, 0)
+	if err != nil {
+		return protocol.FileResult{}, err
+	}
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.FileResult{}, err
+	}
+	data := []byte(p.Content)
+	if p.Data != "" {
+		if p.Content != "" {
+			return protocol.FileResult{}, fmt.Errorf("content and data are mutually exclusive")
 		}
-		results = append(results, protocol.FileInfoResult{
-			Name:  entry.Name(),
-			Path:  filepath.Join(path, entry.Name()),
-			IsDir: entry.IsDir(),
-			Size:  size,
-		})
+		if data, err = base64.StdEncoding.DecodeString(p.Data); err != nil {
+			return protocol.FileResult{}, fmt.Errorf("base64 decode: %w", err)
+		}
+	}
+	if err := verifyChecksum(data, p.SHA256); err != nil {
+		return protocol.FileResult{}, err
+	}
+	if err := e.checkCaseConflict(resolved); err != nil {
+		return protocol.FileResult{}, err
+	}
+	if err := e.Quotas.check(resolved, int64(len(data)), true); err != nil {
+		return protocol.FileResult{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
+		return protocol.FileResult{}, fmt.Errorf("create directory: %w", err)
+	}
+	// Only the open is retried: retrying a write could append twice.
+	var f *os.File
+	err = e.retry(func() (err error) {
+		f, err = os.OpenFile(resolved, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		return err
+	})
+	if err != nil {
+		return protocol.FileResult{}, fmt.Errorf("append file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return protocol.FileResult{}, fmt.Errorf("append file: %w", err)
+	}
+	if perm != 0 {
+		if err := f.Chmod(perm); err != nil {
+			return protocol.FileResult{}, fmt.Errorf("append file: %w", err)
+		}
+	}
+	// With O_APPEND the file offset is left at the end of our write.
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return protocol.FileResult{}, fmt.Errorf("append file: %w", err)
 	}
-	return results, nil
+	info, err := f.Stat()
+	if err != nil {
+		return protocol.FileResult{}, fmt.Errorf("append file: %w", err)
+	}
+	return protocol.FileResult{
+		SHA256: checksum(data),
+		Offset: end - int64(len(data)),
+		Size:   info.Size(),
+	}, nil
+}
+
+// ErrChecksumMismatch is returned when written content does not match the
+// checksum supplied with it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksum returns the hex SHA-256 of data.
+func checksum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
 }
 
-// resolvePath resolves a path relative to workDir and validates it stays within bounds.
+// verifyChecksum reports a mismatch between data and an expected hex
+// SHA-256. An empty sum is not checked.
+func verifyChecksum(data []byte, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	if got := checksum(data); !strings.EqualFold(got, sum) {
+		return fmt.Errorf("%w: content has sha256 %s, expected %s (payload corrupted in transit?)", ErrChecksumMismatch, got, sum)
+	}
+	return nil
+}
+
+// Exists reports whether path exists (without following a final symlink).
+func (e *Executor) Exists(path string) bool {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Lstat(resolved)
+	return err == nil
+}
+
+// Contents returns the contents of the file at path, or nil if there is
+// none. It fails for files larger than max bytes.
+func (e *Executor) Contents(path string, max int64) ([]byte, error) {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolved)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() > max {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, max)
+	}
+	return os.ReadFile(resolved)
+}
+
+// resolvePath translates a canonical wire path (see protocol.CleanPath) to
+// a host path under workDir and validates it stays within bounds.
 func (e *Executor) resolvePath(path string) (string, error) {
-	var resolved string
-	if filepath.IsAbs(path) {
-		resolved = filepath.Clean(path)
-	} else {
-		resolved = filepath.Join(e.workDir, path)
+	canonical, err := protocol.CleanPath(path)
+	if err != nil {
+		return "", err
 	}
+	resolved := filepath.Join(e.workDir, filepath.FromSlash(canonical))
 
 	// Resolve symlinks for security check
 	real, err := filepath.EvalSymlinks(resolved)
@@ -116,6 +421,10 @@ func (e *Executor) resolvePath(path string) (string, error) {
 		}
 	}
 
+	if e.Tripwire.CheckPath("file_access", real) || e.Tripwire.CheckPath("file_access", resolved) {
+		return "", fmt.Errorf("access to %q denied", path)
+	}
+
 	// Ensure the resolved path is under workDir
 	workDirReal, err := filepath.EvalSymlinks(e.workDir)
 	if err != nil {
@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Git hook policies.
+const (
+	GitHooksRun    = "run"
+	GitHooksBypass = "bypass"
+)
+
+// GitHooksPolicy decides whether git commands run by Exec execute the
+// repository's hooks (pre-commit, pre-push, ...). Bypassing points
+// core.hooksPath at an empty location through git's environment config,
+// which has the effect of --no-verify on every git command in the shell
+// line without rewriting it.
+type GitHooksPolicy struct {
+	// Default is GitHooksRun or GitHooksBypass; empty means run.
+	Default string
+	// Repos overrides Default by repository root (absolute host path).
+	Repos map[string]string
+}
+
+// For returns the policy for a command run in dir. An explicit request
+// value wins over the per-repository and default settings.
+func (g GitHooksPolicy) For(dir, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if len(g.Repos) > 0 {
+		if root := gitRoot(dir); root != "" {
+			if p, ok := g.Repos[root]; ok {
+				return p
+			}
+		}
+	}
+	if g.Default != "" {
+		return g.Default
+	}
+	return GitHooksRun
+}
+
+// ValidGitHooks checks a policy value.
+func ValidGitHooks(p string) error {
+	if p != GitHooksRun && p != GitHooksBypass {
+		return fmt.Errorf("invalid git hooks policy %q (want %q or %q)", p, GitHooksRun, GitHooksBypass)
+	}
+	return nil
+}
+
+// gitRoot returns the repository root enclosing dir, or "".
+func gitRoot(dir string) string {
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// bypassGitHooks returns env with a git config entry that disables hooks,
+// appended after any GIT_CONFIG_* entries already present.
+func bypassGitHooks(env []string) []string {
+	n := 0
+	out := make([]string, 0, len(env)+3)
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "GIT_CONFIG_COUNT="); ok {
+			n, _ = strconv.Atoi(v)
+			continue
+		}
+		out = append(out, kv)
+	}
+	noHooks := filepath.Join(os.TempDir(), "xyzen-no-git-hooks") // never created
+	return append(out,
+		"GIT_CONFIG_COUNT="+strconv.Itoa(n+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=core.hooksPath", n),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, noHooks),
+	)
+}
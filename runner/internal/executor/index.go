@@ -0,0 +1,202 @@
+package executor
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/ignore"
+	"github.com/scienceol/xyzen/runner/internal/index"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// IndexFile is where the content index is saved, relative to the work dir.
+const IndexFile = ".xyzen/index/trigrams.gob"
+
+// BuildIndex brings e.Index up to date with the files under the work dir:
+// it loads the saved index if e.Index is empty, re-reads the files whose size or mtime
+// changed, indexes new ones and drops those that are gone, then marks the
+// index ready and saves it. Ignored files (see gitignore) and the .xyzen
+// directory are left out.
+func (e *Executor) BuildIndex() error {
+	x := e.Index
+	saved := filepath.Join(e.workDir, filepath.FromSlash(IndexFile))
+	if x.Len() == 0 {
+		_ = x.Load(saved) // a missing or outdated index is rebuilt from scratch
+	}
+
+	root := filepath.Clean(e.workDir)
+	ign := e.gitignore(root, true)
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]bool)
+	)
+	err := e.walkParallel(root, func(full string, d os.DirEntry) error {
+		if ign.ignored(full, d, root) || full == filepath.Join(root, ".xyzen") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, full)
+		if err != nil {
+			return nil
+		}
+		if e.indexFile(filepath.ToSlash(rel), full) {
+			mu.Lock()
+			seen[filepath.ToSlash(rel)] = true
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range x.Paths() {
+		if !seen[p] {
+			x.Remove(p)
+		}
+	}
+	x.SetReady(true)
+	return x.Save(saved)
+}
+
+// SaveIndex writes e.Index to disk, to speed up the next BuildIndex.
+func (e *Executor) SaveIndex() error {
+	return e.Index.Save(filepath.Join(e.workDir, filepath.FromSlash(IndexFile)))
+}
+
+// indexFile indexes the file at full unless it is already indexed as is,
+// and reports whether it belongs in the index: binary files, files over
+// index.MaxFileSize and anything but regular files do not.
+func (e *Executor) indexFile(rel, full string) bool {
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > index.MaxFileSize {
+		e.Index.Remove(rel)
+		return false
+	}
+	if e.Index.Fresh(rel, info.Size(), info.ModTime()) {
+		return true
+	}
+	data, err := os.ReadFile(full)
+	if err != nil || diff.IsBinary(data) {
+		e.Index.Remove(rel)
+		return false
+	}
+	e.Index.Add(rel, info.Size(), info.ModTime(), data)
+	return true
+}
+
+// UpdateIndex applies changes reported by a watch of the work dir to
+// e.Index.
+func (e *Executor) UpdateIndex(events []protocol.FSEvent) {
+	for _, ev := range events {
+		if ev.OldPath != "" {
+			e.Index.Remove(ev.OldPath)
+		}
+		if ev.Op == protocol.FSDelete {
+			e.Index.Remove(ev.Path)
+			continue
+		}
+		if e.indexIgnored(ev.Path, ev.IsDir) {
+			continue
+		}
+		full := filepath.Join(e.workDir, filepath.FromSlash(ev.Path))
+		if !ev.IsDir {
+			e.indexFile(ev.Path, full)
+			continue
+		}
+		// A directory created or moved in: index what is in it.
+		ign := e.gitignore(full, true)
+		_ = e.walkParallel(full, func(p string, d os.DirEntry) error {
+			if ign.ignored(p, d, full) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				if rel, err := filepath.Rel(e.workDir, p); err == nil {
+					e.indexFile(filepath.ToSlash(rel), p)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// indexIgnored reports whether the work dir path rel, or a directory
+// above it, is left out of the index.
+func (e *Executor) indexIgnored(rel string, isDir bool) bool {
+	if rel == ".xyzen" || strings.HasPrefix(rel, ".xyzen/") {
+		return true
+	}
+	ign := e.gitignore(filepath.Join(e.workDir, filepath.FromSlash(path.Dir(rel))), true)
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		full := filepath.Join(e.workDir, filepath.FromSlash(strings.Join(parts[:i+1], "/")))
+		if ign.m.Match(full, isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndexed runs a search over the files the content index
+// shortlists. It returns false, having done nothing, if the index cannot
+// be used for p: when it is not ready, when ignored files are to be
+// searched too, when files larger than it indexes are to be searched, or
+// when the pattern does not narrow the files down.
+// Files changed within the last watch interval may be missed.
+func (e *Executor) searchIndexed(p protocol.SearchPayload, resolved string, re *regexp.Regexp, include *ignore.Glob, fn func(protocol.SearchMatchResult) bool) (bool, error) {
+	if !e.Index.Ready() || !p.IgnoreEnabled() || p.MaxFileSize > index.MaxFileSize {
+		return false, nil
+	}
+	q := index.RegexpQuery(p.Pattern)
+	if p.Mode == SearchLiteral {
+		q = index.LiteralQuery(p.Pattern)
+	}
+	candidates, ok := e.Index.Candidates(q)
+	if !ok {
+		return false, nil
+	}
+	root := filepath.Clean(e.workDir)
+	prefix, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false, nil
+	}
+	prefix = filepath.ToSlash(prefix)
+
+	exclude := excludes(resolved, p.Exclude)
+	for _, rel := range candidates {
+		under := rel
+		if prefix != "." {
+			var ok bool
+			if under, ok = strings.CutPrefix(rel, prefix+"/"); !ok && rel != prefix {
+				continue
+			} else if !ok {
+				under = path.Base(rel) // the root is the file itself
+			}
+		}
+		if include != nil && !include.Match(under) {
+			continue
+		}
+		file := filepath.Join(root, filepath.FromSlash(rel))
+		if excludedPath(exclude, resolved, file) {
+			continue
+		}
+		for _, m := range searchFile(file, re, p.Root, resolved) {
+			if !fn(m) {
+				return true, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,489 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/state"
+)
+
+// Job statuses (protocol.JobInfo.Status).
+const (
+	JobRunning  = "running"
+	JobExited   = "exited"
+	JobKilled   = "killed"
+	JobTimedOut = "timed_out"
+)
+
+const (
+	// maxRunningJobs bounds the background jobs running at once.
+	maxRunningJobs = 32
+	// maxEndedJobs bounds the ended jobs a JobManager remembers.
+	maxEndedJobs = 100
+	// adoptedPollInterval is how often an adopted job is checked for exit.
+	adoptedPollInterval = 2 * time.Second
+)
+
+// job is one background job.
+type job struct {
+	info protocol.JobInfo
+	log  string
+	env  map[string]string
+	// ending is the status to report when a job being killed ends.
+	ending string
+	timer  *time.Timer
+	done   chan struct{}
+}
+
+// JobManager runs background jobs: commands detached from the request
+// that started them, whose output goes to a log file in its directory.
+type JobManager struct {
+	dir string
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// order lists job IDs in start order.
+	order []string
+
+	// ExitFunc, if set, is called when a job ends, with the variables it
+	// was started with so that they can be redacted from its command.
+	ExitFunc func(info protocol.JobInfo, env map[string]string)
+}
+
+// NewJobManager creates a job manager keeping job logs in dir.
+func NewJobManager(dir string) *JobManager {
+	return &JobManager{dir: dir, jobs: make(map[string]*job)}
+}
+
+// StartJob starts a background job in e.Jobs. The command is run as exec
+// runs it (classified, under its profile's network policy and the git
+// hooks policy, once e's CommandCheck allows it), but with no timeout
+// unless p.Timeout is set.
+func (e *Executor) StartJob(p protocol.JobStartPayload) (protocol.JobInfo, error) {
+	if e.Jobs == nil {
+		return protocol.JobInfo{}, errors.New("background jobs are unavailable")
+	}
+	if strings.TrimSpace(p.Command) == "" {
+		return protocol.JobInfo{}, errors.New("command is required")
+	}
+	if e.Tripwire.CheckCommand(p.Command) {
+		return protocol.JobInfo{}, errors.New("command blocked: references a protected path")
+	}
+	if err := e.checkCommand(Command{Kind: CommandJob, Line: p.Command, Env: p.Env}); err != nil {
+		return protocol.JobInfo{}, err
+	}
+	if err := validEnv(p.Env); err != nil {
+		return protocol.JobInfo{}, err
+	}
+	if err := ValidPriority(p.Priority); err != nil {
+		return protocol.JobInfo{}, err
+	}
+	dir := e.workDir
+	if p.Cwd != "" {
+		resolved, err := e.resolvePath(p.Cwd)
+		if err != nil {
+			return protocol.JobInfo{}, err
+		}
+		dir = resolved
+	}
+	class := e.Classify(p.Command)
+	argv := shellArgv(p.Command)
+	if e.profileFor(class).Network == NetworkDeny {
+		prefix, err := networkDenyPrefix()
+		if err != nil {
+			return protocol.JobInfo{}, fmt.Errorf("profile %q: %v", class, err)
+		}
+		argv = append(prefix, argv...)
+	}
+	env := commandEnv(p.Env, p.EnvClear)
+	if e.GitHooks.For(dir, "") == GitHooksBypass {
+		env = bypassGitHooks(env)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir, cmd.Env = dir, env
+	detachJob(cmd)
+	prioritize(cmd, e.priorityFor(p.Priority))
+	return e.Jobs.start(cmd, p)
+}
+
+// newLog creates the log of a job about to start, if another may run.
+// m.mu must be held.
+func (m *JobManager) newLog() (*os.File, error) {
+	running := 0
+	for _, j := range m.jobs {
+		if j.info.Status == JobRunning {
+			running++
+		}
+	}
+	if running >= maxRunningJobs {
+		return nil, fmt.Errorf("too many running jobs (max %d)", maxRunningJobs)
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create job log directory: %w", err)
+	}
+	f, err := os.CreateTemp(m.dir, "job-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("create job log: %w", err)
+	}
+	return f, nil
+}
+
+func (m *JobManager) start(cmd *exec.Cmd, p protocol.JobStartPayload) (protocol.JobInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.newLog()
+	if err != nil {
+		return protocol.JobInfo{}, err
+	}
+	// The job writes to its log directly, so its output is kept even if
+	// the runner exits first.
+	cmd.Stdout, cmd.Stderr = f, f
+	err = cmd.Start()
+	f.Close()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return protocol.JobInfo{}, fmt.Errorf("start job: %w", err)
+	}
+
+	j := &job{
+		info: protocol.JobInfo{
+			JobID:     strings.TrimSuffix(filepath.Base(f.Name()), ".log"),
+			Command:   p.Command,
+			Cwd:       p.Cwd,
+			PID:       cmd.Process.Pid,
+			Status:    JobRunning,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		log:  f.Name(),
+		env:  p.Env,
+		done: make(chan struct{}),
+	}
+	m.add(j)
+	if p.Timeout > 0 {
+		j.timer = time.AfterFunc(time.Duration(p.Timeout)*time.Second, func() {
+			_ = m.signal(j, "kill", JobTimedOut)
+		})
+	}
+	go func() {
+		_ = cmd.Wait()
+		code := cmd.ProcessState.ExitCode()
+		var exitCode *int
+		if code >= 0 {
+			exitCode = &code
+		}
+		m.end(j, exitCode, exitSignal(cmd.ProcessState))
+	}()
+	return j.info, nil
+}
+
+// track makes a job of the running process pid, which the runner started
+// some other way and reports the end of itself (see end). The caller
+// writes its output to the returned log and closes it.
+func (m *JobManager) track(pid int, command string) (*job, *os.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.newLog()
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &job{
+		info: protocol.JobInfo{
+			JobID:     strings.TrimSuffix(filepath.Base(f.Name()), ".log"),
+			Command:   command,
+			PID:       pid,
+			Status:    JobRunning,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		log:  f.Name(),
+		done: make(chan struct{}),
+	}
+	m.add(j)
+	return j, f, nil
+}
+
+// Adopt takes over a job started by a previous runner process, whose
+// output goes to the log at path. Its exit status cannot be known.
+func (m *JobManager) Adopt(id string, pid int, path, command string, started time.Time) {
+	j := &job{
+		info: protocol.JobInfo{
+			JobID:     id,
+			Command:   command,
+			PID:       pid,
+			Status:    JobRunning,
+			StartedAt: started.UTC().Format(time.RFC3339),
+			Adopted:   true,
+		},
+		log:  path,
+		done: make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.add(j)
+	m.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(adoptedPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !state.Alive(pid) {
+				m.end(j, nil, "")
+				return
+			}
+		}
+	}()
+}
+
+// add registers j, forgetting the oldest ended jobs beyond maxEndedJobs.
+// m.mu must be held.
+func (m *JobManager) add(j *job) {
+	m.jobs[j.info.JobID] = j
+	m.order = append(m.order, j.info.JobID)
+	ended := 0
+	for i := len(m.order) - 1; i >= 0; i-- {
+		id := m.order[i]
+		if m.jobs[id].info.Status == JobRunning {
+			continue
+		}
+		if ended++; ended > maxEndedJobs {
+			delete(m.jobs, id)
+			m.order = append(m.order[:i], m.order[i+1:]...)
+		}
+	}
+}
+
+// end records that j has ended.
+func (m *JobManager) end(j *job, exitCode *int, signal string) {
+	m.mu.Lock()
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.info.Status = JobExited
+	if j.ending != "" {
+		j.info.Status = j.ending
+	}
+	j.info.ExitCode, j.info.Signal = exitCode, signal
+	j.info.EndedAt = time.Now().UTC().Format(time.RFC3339)
+	info := j.info
+	close(j.done)
+	m.mu.Unlock()
+	if m.ExitFunc != nil {
+		m.ExitFunc(m.withLogSize(info, j.log), j.env)
+	}
+}
+
+// Status returns the state of a job.
+func (m *JobManager) Status(id string) (protocol.JobInfo, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return protocol.JobInfo{}, err
+	}
+	m.mu.Lock()
+	info := j.info
+	m.mu.Unlock()
+	return m.withLogSize(info, j.log), nil
+}
+
+// List returns the running jobs and the recently ended ones, oldest
+// first.
+func (m *JobManager) List() []protocol.JobInfo {
+	m.mu.Lock()
+	jobs := make([]*job, 0, len(m.order))
+	infos := make([]protocol.JobInfo, 0, len(m.order))
+	for _, id := range m.order {
+		jobs = append(jobs, m.jobs[id])
+		infos = append(infos, m.jobs[id].info)
+	}
+	m.mu.Unlock()
+	for i := range infos {
+		infos[i] = m.withLogSize(infos[i], jobs[i].log)
+	}
+	return infos
+}
+
+// Kill signals a running job's process group with "term" (default),
+// "int" or "kill".
+func (m *JobManager) Kill(id, sig string) error {
+	if sig == "" {
+		sig = "term"
+	}
+	switch sig {
+	case "term", "int", "kill":
+	default:
+		return fmt.Errorf("invalid signal %q (want term, int or kill)", sig)
+	}
+	j, err := m.get(id)
+	if err != nil {
+		return err
+	}
+	return m.signal(j, sig, JobKilled)
+}
+
+// signal sends sig to j, to end with status ending.
+func (m *JobManager) signal(j *job, sig, ending string) error {
+	m.mu.Lock()
+	if j.info.Status != JobRunning {
+		m.mu.Unlock()
+		return fmt.Errorf("job %s has already ended", j.info.JobID)
+	}
+	if j.ending == "" {
+		j.ending = ending
+	}
+	pid := j.info.PID
+	m.mu.Unlock()
+	if err := signalJob(pid, sig); err != nil {
+		return fmt.Errorf("signal job %s: %w", j.info.JobID, err)
+	}
+	return nil
+}
+
+// Logs reads up to maxBytes (default and max maxOutputBytes) of a job's
+// output from offset.
+func (m *JobManager) Logs(id string, offset int64, maxBytes int) (protocol.JobLogsResult, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return protocol.JobLogsResult{}, err
+	}
+	if maxBytes <= 0 || maxBytes > maxOutputBytes {
+		maxBytes = maxOutputBytes
+	}
+	m.mu.Lock()
+	info := j.info
+	m.mu.Unlock()
+	data, size, err := readJobLog(j.log, offset, maxBytes, info.Status != JobRunning)
+	if err != nil {
+		return protocol.JobLogsResult{}, err
+	}
+	info.LogBytes = size
+	return protocol.JobLogsResult{Job: info, Data: string(data), NextOffset: offset + int64(len(data)), Size: size}, nil
+}
+
+// Follow polls a job's log from offset and calls fn with each chunk of
+// output written to it, until the job has ended and its log is read to
+// the end, or stop is closed. It returns the job's final state, or false
+// if it was stopped first.
+func (m *JobManager) Follow(id string, offset int64, stop <-chan struct{}, fn func(data string, offset int64)) (protocol.JobInfo, bool, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return protocol.JobInfo{}, false, err
+	}
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		ended := false
+		select {
+		case <-stop:
+			return protocol.JobInfo{}, false, nil
+		case <-j.done:
+			ended = true
+		case <-ticker.C:
+		}
+		for {
+			data, _, err := readJobLog(j.log, offset, maxFollowChunk, ended)
+			if err != nil {
+				return protocol.JobInfo{}, false, err
+			}
+			if len(data) == 0 {
+				break
+			}
+			fn(string(data), offset)
+			offset += int64(len(data))
+		}
+		if ended {
+			info, err := m.Status(id)
+			return info, err == nil, err
+		}
+	}
+}
+
+// LogPath returns the path of a job's log, or "" for an unknown job.
+func (m *JobManager) LogPath(id string) string {
+	j, err := m.get(id)
+	if err != nil {
+		return ""
+	}
+	return j.log
+}
+
+// Keep reports whether path is the log of a running job, which garbage
+// collection must leave alone.
+func (m *JobManager) Keep(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range m.jobs {
+		if j.log == path && j.info.Status == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *JobManager) get(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", id)
+	}
+	return j, nil
+}
+
+// withLogSize returns info with the current size of the job log at path.
+func (m *JobManager) withLogSize(info protocol.JobInfo, path string) protocol.JobInfo {
+	if fi, err := os.Stat(path); err == nil {
+		info.LogBytes = fi.Size()
+	}
+	return info
+}
+
+// readJobLog reads up to n bytes of a job log from offset, and returns
+// them with the log's size. Unless final is set, a trailing partial
+// UTF-8 sequence is left for the next read.
+func readJobLog(path string, offset int64, n int, final bool) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open job log: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat job log: %w", err)
+	}
+	if offset < 0 || offset > info.Size() {
+		return nil, info.Size(), fmt.Errorf("offset %d is outside the log (%d bytes)", offset, info.Size())
+	}
+	buf := make([]byte, min(int64(n), info.Size()-offset))
+	read, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, info.Size(), fmt.Errorf("read job log: %w", err)
+	}
+	buf = buf[:read]
+	if !final || read == n {
+		buf = trimPartialRune(buf)
+	}
+	return buf, info.Size(), nil
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			return b // ASCII: nothing pending
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			return b
+		}
+	}
+	return b
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachJob starts a job's command as the leader of a new session, so it
+// outlives the runner's terminal and its whole process group can be
+// signalled.
+func detachJob(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// signalJob sends sig ("term", "int" or "kill") to the process group led
+// by pid.
+func signalJob(pid int, sig string) error {
+	s := syscall.SIGTERM
+	switch sig {
+	case "int":
+		s = syscall.SIGINT
+	case "kill":
+		s = syscall.SIGKILL
+	}
+	return syscall.Kill(-pid, s)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachJob starts a job's command in a new process group, so console
+// interrupts aimed at the runner do not reach it.
+func detachJob(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalJob kills the process pid: Windows has no signals to send it, so
+// sig is ignored.
+func signalJob(pid int, sig string) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	return p.Kill()
+}
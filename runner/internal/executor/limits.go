@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"sort"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// LimitsInfo reports the limits the executor enforces: per-class exec
+// timeouts and network access, output and snapshot caps, search defaults
+// and ceilings, and the fixed limits of file requests.
+func (e *Executor) LimitsInfo() protocol.LimitsInfoResult {
+	execLimits := protocol.ExecLimits{
+		TimeoutSeconds:   map[string]int{ClassDefault: defaultTimeout},
+		MaxOutputBytes:   maxOutputBytes,
+		SnapshotMaxBytes: e.SnapshotMaxSize,
+	}
+	if execLimits.SnapshotMaxBytes <= 0 {
+		execLimits.SnapshotMaxBytes = defaultSnapshotMaxSize
+	}
+	if e.ResourceLimits != (protocol.ResourceLimits{}) {
+		resources := e.ResourceLimits
+		execLimits.Resources = &resources
+	}
+	for class, profile := range e.Profiles {
+		if profile.Timeout > 0 {
+			execLimits.TimeoutSeconds[class] = profile.Timeout
+		}
+		if profile.Network == NetworkDeny {
+			execLimits.NetworkDenied = append(execLimits.NetworkDenied, class)
+		}
+	}
+	sort.Strings(execLimits.NetworkDenied)
+
+	return protocol.LimitsInfoResult{
+		Exec: execLimits,
+		Search: protocol.SearchLimits{
+			DefaultFindResults:   int(limit(0, defaultFindResults, int64(e.Limits.MaxFindResults))),
+			MaxFindResults:       e.Limits.MaxFindResults,
+			DefaultSearchResults: int(limit(0, defaultSearchResults, int64(e.Limits.MaxSearchResults))),
+			MaxSearchResults:     e.Limits.MaxSearchResults,
+			DefaultFileSize:      limit(0, defaultSearchFileSize, e.Limits.MaxFileSize),
+			MaxFileSize:          e.Limits.MaxFileSize,
+		},
+		Files: protocol.FileLimits{
+			MaxReadFiles:    maxReadFiles,
+			MaxListEntries:  maxListEntries,
+			MaxDiffBytes:    maxDiffSize,
+			MaxTailBytes:    maxTailBytes,
+			MaxTailLines:    maxTailLines,
+			MaxWatchPaths:   maxWatchPaths,
+			MaxExtractBytes: maxExtractBytes,
+		},
+	}
+}
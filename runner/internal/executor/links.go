@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/problems"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// links returns the references in text that name existing files in the
+// working directory. Relative references are tried against each of dirs
+// in turn, then against workDir.
+func (e *Executor) links(text string, dirs ...string) []protocol.FileLink {
+	var links []protocol.FileLink
+	for _, ref := range problems.References(text) {
+		if p, ok := e.linkPath(ref.File, dirs...); ok {
+			links = append(links, protocol.FileLink{Text: ref.Text, Path: p, Line: ref.Line, Column: ref.Column})
+		}
+	}
+	return links
+}
+
+// linkPath returns file, as printed by a tool running in dirs, as a
+// canonical path relative to workDir. It reports false if file is not a
+// regular file inside the working directory.
+func (e *Executor) linkPath(file string, dirs ...string) (string, bool) {
+	file = filepath.FromSlash(strings.ReplaceAll(file, `\`, "/"))
+	var candidates []string
+	if filepath.IsAbs(file) {
+		candidates = []string{file}
+	} else {
+		for _, dir := range append(dirs, e.workDir) {
+			candidates = append(candidates, filepath.Join(dir, file))
+		}
+	}
+	roots := []string{filepath.Clean(e.workDir)}
+	if real, err := filepath.EvalSymlinks(e.workDir); err == nil && real != roots[0] {
+		roots = append(roots, real)
+	}
+	for _, c := range candidates {
+		for _, root := range roots {
+			rel, err := filepath.Rel(root, c)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			wire := filepath.ToSlash(rel)
+			resolved, err := e.resolvePath(wire)
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(resolved); err == nil && info.Mode().IsRegular() {
+				return wire, true
+			}
+		}
+	}
+	return "", false
+}
+
+// linkErrors sets the workspace paths of errs and their frames.
+func (e *Executor) linkErrors(errs []protocol.OutputError, dir string) {
+	for i := range errs {
+		if errs[i].File != "" {
+			errs[i].Path, _ = e.linkPath(errs[i].File, dir)
+		}
+		for j := range errs[i].Frames {
+			errs[i].Frames[j].Path, _ = e.linkPath(errs[i].Frames[j].File, dir)
+		}
+	}
+}
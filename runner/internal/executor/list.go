@@ -0,0 +1,200 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// defaultListDepth bounds recursive list_files when no depth is given.
+	defaultListDepth = 10
+	// defaultListEntries bounds recursive list_files when no limit is given.
+	defaultListEntries = 1000
+	// maxListEntries bounds a recursive list_files response.
+	maxListEntries = 10000
+)
+
+// list_files sort keys.
+const (
+	SortName  = "name"
+	SortSize  = "size"
+	SortMtime = "mtime"
+)
+
+// ListFiles returns the entries of a directory. With p.Recursive it
+// descends into subdirectories up to p.MaxDepth levels (1 lists only the
+// directory itself) and stops after p.MaxEntries entries, setting
+// Truncated. Entries are returned in walk order, or nested under their
+// directory's Children with p.Tree. Symlinked directories are not
+// followed.
+//
+// p.Pattern keeps only entries whose name matches the glob (a tree keeps
+// every directory so that matches can be reached). p.SortBy orders the
+// whole flat listing, or each directory of a tree; p.Offset and p.Limit
+// then page through the top-level result, with HasMore set if entries
+// remain past the page.
+func (e *Executor) ListFiles(p protocol.ListFilesPayload) (protocol.ListFilesResult, error) {
+	switch p.SortBy {
+	case "", SortName, SortSize, SortMtime:
+	default:
+		return protocol.ListFilesResult{}, fmt.Errorf("invalid sort_by %q (want %s, %s or %s)", p.SortBy, SortName, SortSize, SortMtime)
+	}
+	if _, err := path.Match(p.Pattern, ""); err != nil {
+		return protocol.ListFilesResult{}, fmt.Errorf("invalid pattern %q: %w", p.Pattern, err)
+	}
+	if p.Offset < 0 || p.Limit < 0 {
+		return protocol.ListFilesResult{}, fmt.Errorf("offset and limit must not be negative")
+	}
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.ListFilesResult{}, err
+	}
+	depth, limit := 1, 0 // a plain listing is not capped
+	if p.Recursive {
+		depth = defaultListDepth
+		if p.MaxDepth > 0 {
+			depth = p.MaxDepth
+		}
+		limit = defaultListEntries
+		if p.MaxEntries > 0 {
+			limit = min(p.MaxEntries, maxListEntries)
+		}
+	}
+	l := &lister{e: e, depth: depth, limit: limit, tree: p.Tree, pattern: p.Pattern}
+	files, err := l.list(resolved, p.Path, 1)
+	if err != nil {
+		return protocol.ListFilesResult{}, err
+	}
+	sortFiles(files, p.SortBy, p.Reverse, p.Tree)
+
+	result := protocol.ListFilesResult{Truncated: l.truncated, Total: len(files)}
+	if p.Offset >= len(files) {
+		files = nil
+	} else {
+		files = files[p.Offset:]
+	}
+	if p.Limit > 0 && len(files) > p.Limit {
+		files, result.HasMore = files[:p.Limit], true
+	}
+	result.Files = files
+	return result, nil
+}
+
+// lister carries the limits of one list_files walk.
+type lister struct {
+	e         *Executor
+	depth     int
+	limit     int // 0 means unlimited
+	tree      bool
+	pattern   string
+	count     int
+	truncated bool
+}
+
+func (l *lister) list(resolved, wire string, level int) ([]protocol.FileInfoResult, error) {
+	var entries []os.DirEntry
+	err := l.e.retry(func() (err error) {
+		entries, err = os.ReadDir(resolved)
+		return err
+	})
+	if err != nil {
+		if level > 1 {
+			return nil, nil // unreadable subdirectory
+		}
+		return nil, fmt.Errorf("list directory: %w", err)
+	}
+
+	var results []protocol.FileInfoResult
+	for _, entry := range entries {
+		if l.limit > 0 && l.count >= l.limit {
+			l.truncated = true
+			break
+		}
+		keep := true
+		if l.pattern != "" {
+			keep, _ = path.Match(l.pattern, entry.Name())
+			keep = keep || (l.tree && entry.IsDir())
+		}
+		descend := entry.IsDir() && level < l.depth
+		if !keep && !descend {
+			continue
+		}
+		result := protocol.FileInfoResult{
+			Name:  entry.Name(),
+			Path:  protocol.JoinPath(wire, entry.Name()),
+			IsDir: entry.IsDir(),
+		}
+		if info, err := entry.Info(); err == nil {
+			s := info.Size()
+			result.Size = &s
+			result.ModTime = info.ModTime().UTC().Format(time.RFC3339Nano)
+		}
+		if keep {
+			l.count++
+		}
+		var children []protocol.FileInfoResult
+		if descend {
+			if children, err = l.list(filepath.Join(resolved, entry.Name()), result.Path, level+1); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case l.tree:
+			result.Children = children
+			results = append(results, result)
+		case keep:
+			results = append(results, result)
+			fallthrough
+		default:
+			results = append(results, children...)
+		}
+	}
+	return results, nil
+}
+
+// sortFiles orders a listing by key (name order is the walk order, so it
+// is left alone unless reversed). Trees are sorted level by level.
+func sortFiles(files []protocol.FileInfoResult, by string, reverse, tree bool) {
+	if tree {
+		for i := range files {
+			sortFiles(files[i].Children, by, reverse, true)
+		}
+	}
+	var less func(i, j int) bool
+	switch by {
+	case SortSize:
+		size := func(f protocol.FileInfoResult) int64 {
+			if f.Size == nil {
+				return 0
+			}
+			return *f.Size
+		}
+		less = func(i, j int) bool { return size(files[i]) < size(files[j]) }
+	case SortMtime:
+		// RFC 3339 strings do not order reliably (trailing zeros are
+		// trimmed), so parse once up front.
+		mtimes := make(map[string]time.Time, len(files))
+		for _, f := range files {
+			mtimes[f.Path], _ = time.Parse(time.RFC3339Nano, f.ModTime)
+		}
+		less = func(i, j int) bool { return mtimes[files[i].Path].Before(mtimes[files[j].Path]) }
+	default:
+		if reverse {
+			for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+				files[i], files[j] = files[j], files[i]
+			}
+		}
+		return
+	}
+	if reverse {
+		forward := less
+		less = func(i, j int) bool { return forward(j, i) }
+	}
+	sort.SliceStable(files, less)
+}
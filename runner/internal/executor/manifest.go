@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// maxManifestEntries bounds the size of a single manifest.
+const maxManifestEntries = 200000
+
+// Manifest walks root and returns the size and SHA-256 of every regular
+// file beneath it.
+func (e *Executor) Manifest(root string) (protocol.ManifestResult, error) {
+	resolved, err := e.resolvePath(root)
+	if err != nil {
+		return protocol.ManifestResult{}, err
+	}
+
+	result := protocol.ManifestResult{Root: root}
+	err = filepath.WalkDir(resolved, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if len(result.Entries) >= maxManifestEntries {
+			return fmt.Errorf("more than %d files", maxManifestEntries)
+		}
+		rel, err := filepath.Rel(resolved, path)
+		if err != nil {
+			return err
+		}
+		entry, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		entry.Path = filepath.ToSlash(rel)
+		result.Entries = append(result.Entries, entry)
+		result.Bytes += entry.Size
+		return nil
+	})
+	if err != nil {
+		return protocol.ManifestResult{}, fmt.Errorf("build manifest: %w", err)
+	}
+	result.Files = len(result.Entries)
+	result.Digest = ManifestDigest(result.Entries)
+	return result, nil
+}
+
+// VerifyManifest checks the files under p.Root against the expected
+// entries. Files on disk that are not in the manifest are ignored.
+func (e *Executor) VerifyManifest(p protocol.VerifyManifestPayload) (protocol.VerifyManifestResult, error) {
+	if _, err := e.resolvePath(p.Root); err != nil {
+		return protocol.VerifyManifestResult{}, err
+	}
+	digest := ManifestDigest(p.Entries)
+	if p.Digest != "" && p.Digest != digest {
+		return protocol.VerifyManifestResult{}, fmt.Errorf("manifest digest mismatch: got %s, expected %s", digest, p.Digest)
+	}
+
+	result := protocol.VerifyManifestResult{Digest: digest}
+	for _, want := range p.Entries {
+		result.Checked++
+		path, err := e.resolvePath(protocol.JoinPath(p.Root, want.Path))
+		if err != nil {
+			return protocol.VerifyManifestResult{}, err
+		}
+		got, err := hashFile(path)
+		if os.IsNotExist(err) {
+			result.Missing = append(result.Missing, want.Path)
+			continue
+		}
+		if err != nil {
+			return protocol.VerifyManifestResult{}, fmt.Errorf("verify %s: %w", want.Path, err)
+		}
+		got.Path = want.Path
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			result.Mismatched = append(result.Mismatched, protocol.ManifestMismatch{Path: want.Path, Expected: want, Actual: got})
+		}
+	}
+	result.OK = len(result.Missing) == 0 && len(result.Mismatched) == 0
+	return result, nil
+}
+
+// ManifestDigest returns the SHA-256 of the canonical form of entries.
+func ManifestDigest(entries []protocol.ManifestEntry) string {
+	sorted := make([]protocol.ManifestEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	h := sha256.New()
+	for _, e := range sorted {
+		io.WriteString(h, e.Path+"\x00"+strconv.FormatInt(e.Size, 10)+"\x00"+e.SHA256+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the size and SHA-256 of a file.
+func hashFile(path string) (protocol.ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return protocol.ManifestEntry{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return protocol.ManifestEntry{}, err
+	}
+	return protocol.ManifestEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MoveFile moves or renames a file or directory. Both paths must be inside
+// the working directory. An existing destination is replaced only if
+// overwrite is set, and goes to the trash.
+func (e *Executor) MoveFile(src, dst string, overwrite bool) error {
+	from, to, err := e.resolvePair(src, dst, overwrite)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := e.replaceDest(to, dst, overwrite); err != nil {
+		return err
+	}
+	if err := e.retry(func() error { return os.Rename(from, to) }); err != nil {
+		var linkErr *os.LinkError
+		if !errors.As(err, &linkErr) {
+			return fmt.Errorf("move: %w", err)
+		}
+		// Rename fails across filesystems; fall back to copy + delete.
+		if err := copyTree(from, to); err != nil {
+			_ = os.RemoveAll(to)
+			return fmt.Errorf("move: %w", err)
+		}
+		if err := e.retry(func() error { return os.RemoveAll(from) }); err != nil {
+			return fmt.Errorf("move: remove source: %w", err)
+		}
+	}
+	return nil
+}
+
+// PlaceFile moves a file from outside the workspace (e.g. an assembled
+// upload) to dst.
+func (e *Executor) PlaceFile(hostSrc, dst string, overwrite bool) error {
+	to, err := e.resolvePath(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(to); err == nil && !overwrite {
+		return fmt.Errorf("destination %q already exists", dst)
+	}
+	// Keep the mode of a file being replaced, as write_file does.
+	mode := existingMode(to)
+	if err := e.checkCaseConflict(to); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.Rename(hostSrc, to); err != nil {
+		if err := copyRegular(hostSrc, to, mode); err != nil {
+			return fmt.Errorf("place file: %w", err)
+		}
+		_ = os.Remove(hostSrc)
+		return nil
+	}
+	// Match the permissions write_file would have used.
+	return os.Chmod(to, mode)
+}
+
+// CopyFile copies a file, or a directory recursively. Symlinks are copied
+// as links, not followed. An existing destination is replaced only if
+// overwrite is set, and goes to the trash.
+func (e *Executor) CopyFile(src, dst string, overwrite bool) error {
+	from, to, err := e.resolvePair(src, dst, overwrite)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := e.replaceDest(to, dst, overwrite); err != nil {
+		return err
+	}
+	if err := copyTree(from, to); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	return nil
+}
+
+// replaceDest moves an existing destination to the trash, if overwrite is
+// set, so that undelete can restore it.
+func (e *Executor) replaceDest(to, dst string, overwrite bool) error {
+	if !overwrite {
+		return nil
+	}
+	if _, err := os.Lstat(to); err != nil {
+		return nil
+	}
+	if _, err := e.trash(to, dst); err != nil {
+		return fmt.Errorf("replace destination: %w", err)
+	}
+	return nil
+}
+
+// resolvePair validates the source and destination of a move or copy.
+func (e *Executor) resolvePair(src, dst string, overwrite bool) (string, string, error) {
+	from, err := e.resolvePath(src)
+	if err != nil {
+		return "", "", err
+	}
+	to, err := e.resolvePath(dst)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := os.Lstat(from); err != nil {
+		return "", "", fmt.Errorf("source: %w", err)
+	}
+	if from == to {
+		return "", "", fmt.Errorf("source and destination are the same")
+	}
+	if rel, err := filepath.Rel(from, to); err == nil && !strings.HasPrefix(rel, "..") {
+		return "", "", fmt.Errorf("cannot move or copy %q into itself", src)
+	}
+	if _, err := os.Lstat(to); err == nil && !overwrite {
+		return "", "", fmt.Errorf("destination %q already exists", dst)
+	}
+	if !strings.EqualFold(from, to) {
+		// A case-only rename of src itself is fine; anything else landing
+		// on a differently cased entry is not.
+		if err := e.checkCaseConflict(to); err != nil {
+			return "", "", err
+		}
+	}
+	return from, to, nil
+}
+
+// copyTree copies src to dst, recursing into directories.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyRegular(path, target, info.Mode().Perm())
+		default:
+			return nil // sockets, devices, pipes
+		}
+	})
+}
+
+func copyRegular(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
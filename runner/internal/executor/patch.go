@@ -0,0 +1,208 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/diff"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	defaultPatchFuzz = 2
+	maxPatchFuzz     = 3
+)
+
+// pendingFile is the patched state of one file before it is written. A
+// nil content means the file is to be deleted.
+type pendingFile struct {
+	resolved string
+	content  *string
+	mode     os.FileMode
+}
+
+// ApplyPatch applies a unified diff to files under p.Root. Every hunk is
+// located near its stated line, tolerating moved code and up to p.Fuzz
+// mismatched context lines. Nothing is written unless every hunk of
+// every file applies, and nothing at all with p.DryRun. Deleted and
+// renamed-away files are moved to the trash.
+func (e *Executor) ApplyPatch(p protocol.ApplyPatchPayload) (protocol.ApplyPatchResult, error) {
+	fuzz := defaultPatchFuzz
+	if p.Fuzz != nil {
+		fuzz = *p.Fuzz
+	}
+	if fuzz < 0 || fuzz > maxPatchFuzz {
+		return protocol.ApplyPatchResult{}, fmt.Errorf("fuzz must be between 0 and %d", maxPatchFuzz)
+	}
+	patches, err := diff.Parse(p.Patch)
+	if err != nil {
+		return protocol.ApplyPatchResult{}, fmt.Errorf("parse patch: %w", err)
+	}
+
+	result := protocol.ApplyPatchResult{Applied: true, DryRun: p.DryRun}
+	pending := map[string]*pendingFile{}
+	var order []string
+	for _, fp := range patches {
+		fr, err := e.patchFile(p.Root, fp, fuzz, pending, &order)
+		if err != nil {
+			fr.Error = err.Error()
+		}
+		if !fr.Applied {
+			result.Applied = false
+		}
+		result.Files = append(result.Files, fr)
+	}
+	if !result.Applied || p.DryRun {
+		return result, nil
+	}
+
+	for _, path := range order {
+		pf := pending[path]
+		if pf.content == nil {
+			if _, err = os.Lstat(pf.resolved); err == nil {
+				_, err = e.trash(pf.resolved, path)
+			} else if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			if err = os.MkdirAll(filepath.Dir(pf.resolved), 0o755); err == nil {
+				err = e.retry(func() error { return writeAtomic(pf.resolved, []byte(*pf.content), pf.mode) })
+			}
+		}
+		if err != nil {
+			return protocol.ApplyPatchResult{}, fmt.Errorf("apply patch: %s: %w", path, err)
+		}
+	}
+	return result, nil
+}
+
+// patchFile applies one file's hunks against its current (or already
+// pending) content and records the outcome in pending.
+func (e *Executor) patchFile(root string, fp diff.FilePatch, fuzz int, pending map[string]*pendingFile, order *[]string) (protocol.PatchFileResult, error) {
+	oldPath, newPath := patchPath(root, fp.OldPath, "a/"), patchPath(root, fp.NewPath, "b/")
+	fr := protocol.PatchFileResult{Path: newPath, Action: "modify", Hunks: []protocol.PatchHunkResult{}}
+	switch {
+	case fp.OldPath == diff.DevNull:
+		fr.Action = "create"
+	case fp.NewPath == diff.DevNull:
+		fr.Path, fr.Action = oldPath, "delete"
+	case oldPath != newPath:
+		fr.OldPath, fr.Action = oldPath, "rename"
+	}
+
+	source := oldPath
+	if fr.Action == "create" {
+		source = newPath
+	}
+	content, mode, exists, err := e.patchSource(source, pending)
+	if err != nil {
+		return fr, err
+	}
+	if fr.Action == "create" && exists {
+		return fr, fmt.Errorf("%s already exists", newPath)
+	}
+	if fr.Action != "create" && !exists {
+		return fr, fmt.Errorf("%s does not exist", source)
+	}
+
+	out, hunks, ok := diff.Apply(content, fp.Hunks, fuzz)
+	for _, h := range hunks {
+		fr.Hunks = append(fr.Hunks, protocol.PatchHunkResult{Applied: h.Applied, Line: h.Line, Offset: h.Offset, Fuzz: h.Fuzz, Error: h.Err})
+	}
+	if !ok {
+		return fr, nil
+	}
+	if fr.Action == "delete" && out != "" {
+		return fr, fmt.Errorf("%s is not empty after removing the patched lines", oldPath)
+	}
+
+	// A case-only rename must remove the old name first: on a
+	// case-insensitive filesystem both names are the same file.
+	caseRename := fr.Action == "rename" && strings.EqualFold(oldPath, newPath)
+	if caseRename {
+		if err := e.stageDelete(pending, order, oldPath); err != nil {
+			return fr, err
+		}
+	}
+	if fr.Action != "delete" {
+		resolved, err := e.resolvePath(newPath)
+		if err != nil {
+			return fr, err
+		}
+		if _, seen := pending[newPath]; !seen && !caseRename {
+			if err := e.checkCaseConflict(resolved); err != nil {
+				return fr, err
+			}
+		}
+		stage(pending, order, newPath, &pendingFile{resolved: resolved, content: &out, mode: mode})
+	}
+	if fr.Action == "delete" || (fr.Action == "rename" && !caseRename) {
+		if err := e.stageDelete(pending, order, oldPath); err != nil {
+			return fr, err
+		}
+	}
+	fr.Applied = true
+	return fr, nil
+}
+
+// patchSource returns the content a patch applies to: an earlier pending
+// result for the same path, or the file on disk.
+func (e *Executor) patchSource(path string, pending map[string]*pendingFile) (string, os.FileMode, bool, error) {
+	if pf, ok := pending[path]; ok {
+		if pf.content == nil {
+			return "", 0o644, false, nil
+		}
+		return *pf.content, pf.mode, true, nil
+	}
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return "", 0, false, err
+	}
+	info, err := os.Stat(resolved)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", 0o644, false, nil
+	}
+	if err != nil {
+		return "", 0, false, fmt.Errorf("read file: %w", err)
+	}
+	if info.IsDir() {
+		return "", 0, false, fmt.Errorf("%s is a directory", path)
+	}
+	var data []byte
+	err = e.retry(func() (err error) {
+		data, err = os.ReadFile(resolved)
+		return err
+	})
+	if err != nil {
+		return "", 0, false, fmt.Errorf("read file: %w", err)
+	}
+	return string(data), info.Mode().Perm(), true, nil
+}
+
+func (e *Executor) stageDelete(pending map[string]*pendingFile, order *[]string, path string) error {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	stage(pending, order, path, &pendingFile{resolved: resolved})
+	return nil
+}
+
+func stage(pending map[string]*pendingFile, order *[]string, path string, pf *pendingFile) {
+	if _, ok := pending[path]; !ok {
+		*order = append(*order, path)
+	}
+	pending[path] = pf
+}
+
+// patchPath maps a diff header path to a wire path under root, dropping
+// git's a/ or b/ prefix.
+func patchPath(root, path, prefix string) string {
+	if path == diff.DevNull {
+		return ""
+	}
+	return protocol.JoinPath(root, strings.TrimPrefix(path, prefix))
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Priorities agent commands run at, from highest to lowest. Low and idle
+// lower both CPU and IO priority where the platform allows, so agent work
+// yields to the user's own processes.
+const (
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+	PriorityIdle   = "idle"
+)
+
+var priorityRank = map[string]int{"": 0, PriorityNormal: 0, PriorityLow: 1, PriorityIdle: 2}
+
+// ValidPriority checks a priority name.
+func ValidPriority(p string) error {
+	if _, ok := priorityRank[p]; !ok {
+		return fmt.Errorf("invalid priority %q (want %s, %s or %s)", p, PriorityNormal, PriorityLow, PriorityIdle)
+	}
+	return nil
+}
+
+// priorityFor returns the priority a command runs at: the one requested,
+// but never above e.Priority. Normal is returned as "".
+func (e *Executor) priorityFor(req string) string {
+	p := e.Priority
+	if priorityRank[req] > priorityRank[p] {
+		p = req
+	}
+	if p == PriorityNormal {
+		return ""
+	}
+	return p
+}
+
+// containerCPUShares are the docker --cpu-shares (default 1024) of
+// containers run at a lowered priority.
+var containerCPUShares = map[string]string{PriorityLow: "256", PriorityIdle: "2"}
+
+// prioritize makes cmd, not yet started, run at priority prio, and
+// returns the priority it will run at: "" if prio is normal or the
+// platform's tools to lower it are missing.
+func prioritize(cmd *exec.Cmd, prio string) string {
+	if prio == "" {
+		return ""
+	}
+	return setPriority(cmd, prio)
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// setPriority runs cmd under nice, and on Linux ionice (best effort at
+// the lowest level for low, the idle class for idle). On macOS idle runs
+// it under taskpolicy -b instead, which throttles both CPU and IO.
+// Children inherit the priority.
+func setPriority(cmd *exec.Cmd, prio string) string {
+	var prefix []string
+	if runtime.GOOS == "darwin" && prio == PriorityIdle {
+		if path, err := exec.LookPath("taskpolicy"); err == nil {
+			prefix = []string{path, "-b"}
+		}
+	}
+	if prefix == nil {
+		path, err := exec.LookPath("nice")
+		if err != nil {
+			return ""
+		}
+		prefix = []string{path, "-n", "10"}
+		if prio == PriorityIdle {
+			prefix[2] = "19"
+		}
+	}
+	if runtime.GOOS == "linux" {
+		if path, err := exec.LookPath("ionice"); err == nil {
+			if prio == PriorityIdle {
+				prefix = append(prefix, path, "-c", "3")
+			} else {
+				prefix = append(prefix, path, "-c", "2", "-n", "7")
+			}
+		}
+	}
+	cmd.Args = append(append(prefix, cmd.Path), cmd.Args[1:]...)
+	cmd.Path = prefix[0]
+	return prio
+}
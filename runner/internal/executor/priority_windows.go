@@ -0,0 +1,28 @@
+//go:build windows
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Process priority classes (CreateProcess creation flags).
+const (
+	belowNormalPriorityClass = 0x00004000
+	idlePriorityClass        = 0x00000040
+)
+
+// setPriority starts cmd in a lower priority class, which its children
+// inherit. Windows has no per-process IO priority to set at creation.
+func setPriority(cmd *exec.Cmd, prio string) string {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if prio == PriorityIdle {
+		cmd.SysProcAttr.CreationFlags |= idlePriorityClass
+	} else {
+		cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+	}
+	return prio
+}
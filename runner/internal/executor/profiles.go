@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Command classes recognized by the built-in classifier.
+const (
+	ClassPackageInstall = "package-install"
+	ClassTest           = "test"
+	ClassBuild          = "build"
+	ClassNetworkTools   = "network-tools"
+	ClassDefault        = "default"
+)
+
+// Network policies for Profile.Network.
+const (
+	NetworkAllow = "allow"
+	NetworkDeny  = "deny"
+)
+
+// Profile is the execution environment applied to a class of commands.
+type Profile struct {
+	// Timeout is the default timeout in seconds for commands in this class,
+	// used when the request does not specify one.
+	Timeout int
+	// Network is NetworkAllow (default) or NetworkDeny.
+	Network string
+	// Limits caps the resources of commands in this class, used when the
+	// request sets no limits. Like a request's, they may lower the
+	// executor's ResourceLimits but not raise them.
+	Limits *protocol.ResourceLimits
+	// Container runs commands in this class in a container, used when
+	// the request does not ask for one.
+	Container *protocol.ContainerSpec
+}
+
+// ClassRule assigns Class to commands matching Pattern.
+type ClassRule struct {
+	Class   string
+	Pattern *regexp.Regexp
+}
+
+// cmdStart matches the start of a command or of a chained sub-command.
+const cmdStart = `(?:^|[;&|(]\s*|\bsudo\s+)`
+
+// builtinClassRules are consulted after any configured rules. Order
+// matters: the first match wins.
+var builtinClassRules = []ClassRule{
+	{ClassPackageInstall, regexp.MustCompile(cmdStart + `(?:pip3?|uv\s+pip|uv|poetry|npm|yarn|pnpm|apt|apt-get|brew|conda|mamba|cargo|go|gem|bundle)\s+(?:install|add|get|sync)\b`)},
+	{ClassTest, regexp.MustCompile(cmdStart + `(?:pytest|python3?\s+-m\s+pytest|go\s+test|cargo\s+test|npm\s+(?:run\s+)?test|yarn\s+test|pnpm\s+test|jest|vitest|mocha|tox|nox|ctest|make\s+test)\b`)},
+	{ClassBuild, regexp.MustCompile(cmdStart + `(?:make|cmake|ninja|go\s+build|cargo\s+build|npm\s+run\s+build|yarn\s+build|pnpm\s+build|gradle|gradlew|mvn|bazel|tsc|webpack|vite\s+build)\b`)},
+	{ClassNetworkTools, regexp.MustCompile(`\b(?:curl|wget|ssh|scp|sftp|rsync|nc|ncat|netcat|telnet|ftp|nmap)\b`)},
+}
+
+// Classify returns the class of a shell command, checking e.ClassRules
+// before the built-in rules. Unmatched commands are ClassDefault.
+func (e *Executor) Classify(command string) string {
+	for _, rules := range [][]ClassRule{e.ClassRules, builtinClassRules} {
+		for _, r := range rules {
+			if r.Pattern.MatchString(command) {
+				return r.Class
+			}
+		}
+	}
+	return ClassDefault
+}
+
+// profileFor returns the profile for a class, falling back to the
+// "default" profile and then to the zero Profile.
+func (e *Executor) profileFor(class string) Profile {
+	if p, ok := e.Profiles[class]; ok {
+		return p
+	}
+	return e.Profiles[ClassDefault]
+}
+
+// networkDenyPrefix returns the argv prefix that runs a command without
+// network access on this platform.
+func networkDenyPrefix() ([]string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		// Unprivileged user + network namespace: only loopback is visible.
+		path, err := exec.LookPath("unshare")
+		if err != nil {
+			return nil, fmt.Errorf("network isolation requires unshare: %w", err)
+		}
+		return []string{path, "--user", "--map-root-user", "--net"}, nil
+	case "darwin":
+		path, err := exec.LookPath("sandbox-exec")
+		if err != nil {
+			return nil, fmt.Errorf("network isolation requires sandbox-exec: %w", err)
+		}
+		return []string{path, "-p", "(version 1)(allow default)(deny network*)"}, nil
+	default:
+		return nil, fmt.Errorf("network isolation is not supported on %s", runtime.GOOS)
+	}
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"context"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/process"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// WithProgress returns a copy of e whose commands report their progress
+// to fn every interval while they run.
+func (e *Executor) WithProgress(interval time.Duration, fn func(protocol.ExecProgressPayload)) *Executor {
+	cp := *e
+	cp.progress, cp.progressEvery = fn, interval
+	return &cp
+}
+
+// reportProgress reports on the running command pid, started at start and
+// writing to stdout and stderr, until the returned func is called. With
+// cpu unset, as for a container's runtime client, CPU use is not
+// reported.
+func (e *Executor) reportProgress(pid int, start time.Time, stdout, stderr *limitedWriter, cpu bool) (stop func()) {
+	if e.progress == nil || e.progressEvery <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(e.progressEvery)
+		defer ticker.Stop()
+		var lastOutput int64
+		var lastCPU time.Duration
+		lastTime := start
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				p := protocol.ExecProgressPayload{
+					ElapsedMs:   now.Sub(start).Milliseconds(),
+					StdoutBytes: stdout.total.Load(),
+					StderrBytes: stderr.total.Load(),
+				}
+				p.RecentOutputBytes = p.StdoutBytes + p.StderrBytes - lastOutput
+				lastOutput += p.RecentOutputBytes
+				if cpu {
+					if used, err := process.CPUTime(ctx, pid); err == nil {
+						// Descendants that exit take their CPU time with
+						// them.
+						pct := max(float64(used-lastCPU)/float64(now.Sub(lastTime))*100, 0)
+						p.CPUPercent = &pct
+						lastCPU, lastTime = used, now
+					}
+				}
+				if ctx.Err() == nil {
+					e.progress(p)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -4,12 +4,17 @@ package executor
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/creack/pty"
 	"github.com/scienceol/xyzen/runner/internal/protocol"
@@ -30,6 +35,20 @@ type PTYSession struct {
 	cmd  *exec.Cmd
 	ptmx *os.File
 	done chan struct{} // closed when the process exits
+	// flushed is closed once readLoop has passed on the last output.
+	flushed chan struct{}
+
+	resizer *resizer
+	viewers *viewerSet
+	rec     *castRecorder // nil unless the session is recorded
+	screen  *vtScreen     // nil with the tmux backend, which keeps its own
+	idle    *idleTimer
+	tmux    string // the tmux session's name, with the tmux backend
+	// killed is set once Signal sends "kill" to a session in tmux, whose
+	// program then leaves no exit status to tell.
+	killed   atomic.Bool
+	command  string                   // for the job Disown makes of it
+	disowned atomic.Pointer[disowned] // set by Disown
 }
 
 // PTYManager manages multiple concurrent PTY sessions.
@@ -37,29 +56,57 @@ type PTYManager struct {
 	mu       sync.RWMutex
 	sessions map[string]*PTYSession
 	workDir  string
+	exec     *Executor // resolves session working directories
 	// OutputFunc is called when a PTY session produces output.
 	// The caller sets this to route output to the WebSocket.
 	OutputFunc func(sessionID string, data []byte)
-	// ExitFunc is called when a PTY session's process exits.
-	ExitFunc func(sessionID string, exitCode int)
+	// ExitFunc is called when a PTY session's process exits. Signal
+	// names the signal that killed it, e.g. "SIGINT" (exitCode is then
+	// -1), and reason is one of the PTYExit reasons if the runner closed
+	// the session.
+	ExitFunc func(sessionID string, exitCode int, signal, reason string)
+	// ResizePolicy controls debouncing and multi-viewer geometry.
+	ResizePolicy ResizePolicy
+	// IdleTimeout closes sessions with no input or output for this long;
+	// zero or negative disables it.
+	IdleTimeout time.Duration
+	// MaxSessions caps the concurrent sessions, if positive. OnLimit is
+	// PTYLimitReject (if empty) or PTYLimitEvict.
+	MaxSessions int
+	OnLimit     string
+	// Backend is PTYBackendDirect (if empty) or PTYBackendTmux.
+	Backend string
+	// Record records sessions under RecordingsDir unless pty_create says
+	// otherwise, with output passed through Redact if it is set.
+	Record bool
+	Redact func([]byte) []byte
 }
 
-// NewPTYManager creates a new PTY manager.
-func NewPTYManager(workDir string) *PTYManager {
+// NewPTYManager creates a PTY manager for e's work dir, which resolves
+// the working directories of sessions as e resolves paths.
+func NewPTYManager(e *Executor) *PTYManager {
 	return &PTYManager{
 		sessions: make(map[string]*PTYSession),
-		workDir:  workDir,
+		workDir:  e.workDir,
+		exec:     e,
 	}
 }
 
-// Create starts a new PTY session with the given command.
-func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
+// Create starts a new PTY session with the given command. At MaxSessions
+// it returns a *PTYLimitError, or with PTYLimitEvict first closes the
+// least recently active session. A command check refuses doesn't start.
+func (m *PTYManager) Create(p protocol.PTYCreatePayload, check CommandCheck) error {
+	m.makeRoom(p.SessionID)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.sessions[p.SessionID]; exists {
 		return fmt.Errorf("session %s already exists", p.SessionID)
 	}
+	if err := m.checkLimit(); err != nil {
+		return err
+	}
 
 	command := p.Command
 	if command == "" {
@@ -68,10 +115,22 @@ func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
 			command = "/bin/sh"
 		}
 	}
+	line := ptyCommandLine(command, p.Args)
+	if err := check.allow(Command{Kind: CommandPTY, Line: line, Env: p.Env}); err != nil {
+		return err
+	}
 
-	cmd := exec.Command(command, p.Args...)
-	cmd.Dir = m.workDir
-	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	dir, err := m.sessionDir(p.Cwd)
+	if err != nil {
+		return err
+	}
+	if err := validEnv(p.Env); err != nil {
+		return err
+	}
+	vars := map[string]string{"TERM": "xterm-256color"}
+	for k, v := range p.Env {
+		vars[k] = v
+	}
 
 	winSize := &pty.Winsize{
 		Cols: p.Cols,
@@ -84,54 +143,143 @@ func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
 		winSize.Rows = 24
 	}
 
+	var (
+		cmd  *exec.Cmd
+		name string
+	)
+	if m.Backend == PTYBackendTmux {
+		if name, cmd, err = tmuxNew(m.tmuxExitDir(), p.SessionID, dir, vars, command, p.Args, winSize.Cols, winSize.Rows); err != nil {
+			return err
+		}
+	} else {
+		cmd = exec.Command(command, p.Args...)
+		cmd.Dir = dir
+		cmd.Env = commandEnv(vars, false)
+	}
+
+	var rec *castRecorder
+	if m.recordingWanted(p) {
+		if rec, err = m.startRecording(p.SessionID, command, winSize.Cols, winSize.Rows); err != nil {
+			tmuxKill(name)
+			return err
+		}
+	}
+	if err := m.start(p.SessionID, cmd, winSize, rec, name); err != nil {
+		tmuxKill(name)
+		return err
+	}
+	m.sessions[p.SessionID].command = line
+
+	log.Printf("PTY session %s started: %s %v", p.SessionID, command, p.Args)
+	return nil
+}
+
+// Adopt attaches to tmux session name, which a previous runner process
+// started for PTY session sessionID, and manages it as if it had been
+// created here.
+func (m *PTYManager) Adopt(sessionID, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.sessions[sessionID]; exists {
+		return fmt.Errorf("session %s already exists", sessionID)
+	}
+	if !tmuxAlive(name) {
+		return fmt.Errorf("tmux session %s has ended", name)
+	}
+	winSize := &pty.Winsize{Cols: 80, Rows: 24}
+	var rec *castRecorder
+	if m.Record {
+		var err error
+		if rec, err = m.startRecording(sessionID, "tmux", winSize.Cols, winSize.Rows); err != nil {
+			return err
+		}
+	}
+	if err := m.start(sessionID, tmuxAttach(name), winSize, rec, name); err != nil {
+		return err
+	}
+	m.sessions[sessionID].command = "tmux -L " + tmuxSocket + " attach -t " + name
+	log.Printf("PTY session %s reattached to tmux session %s", sessionID, name)
+	return nil
+}
+
+// start runs cmd on a new PTY as session id. Must be called with m.mu
+// held.
+func (m *PTYManager) start(id string, cmd *exec.Cmd, winSize *pty.Winsize, rec *castRecorder, tmuxName string) error {
 	ptmx, err := pty.StartWithSize(cmd, winSize)
 	if err != nil {
+		rec.close()
 		return fmt.Errorf("start pty: %w", err)
 	}
 
 	session := &PTYSession{
-		id:   p.SessionID,
-		cmd:  cmd,
-		ptmx: ptmx,
-		done: make(chan struct{}),
+		id:      id,
+		cmd:     cmd,
+		ptmx:    ptmx,
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+		rec:     rec,
+		tmux:    tmuxName,
 	}
-	m.sessions[p.SessionID] = session
+	if tmuxName == "" {
+		session.screen = newVTScreen(int(winSize.Cols), int(winSize.Rows))
+	}
+	session.viewers = newViewerSet()
+	session.idle = newIdleTimer(m.IdleTimeout, func() {
+		log.Printf("PTY session %s idle for %s, closing", id, m.IdleTimeout)
+		_ = m.Close(id)
+	})
+	session.resizer = newResizer(m.ResizePolicy, winSize.Cols, winSize.Rows, func(cols, rows uint16) error {
+		if err := pty.Setsize(ptmx, &pty.Winsize{Cols: cols, Rows: rows}); err != nil {
+			return err
+		}
+		rec.resize(cols, rows)
+		session.screen.resize(int(cols), int(rows))
+		return nil
+	})
+	m.sessions[id] = session
 
 	go m.readLoop(session)
 	go m.waitLoop(session)
-
-	log.Printf("PTY session %s started: %s %v", p.SessionID, command, p.Args)
 	return nil
 }
 
-// Input writes data to a PTY session's stdin.
-func (m *PTYManager) Input(sessionID string, dataB64 string) error {
+// Input writes data to a PTY session's stdin on behalf of viewerID.
+func (m *PTYManager) Input(sessionID, viewerID string, dataB64 string) error {
 	m.mu.RLock()
 	session, ok := m.sessions[sessionID]
 	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
+	if !session.viewers.CanWrite(viewerID) {
+		return fmt.Errorf("viewer %s has read-only access to session %s", viewerID, sessionID)
+	}
 
 	data, err := base64.StdEncoding.DecodeString(dataB64)
 	if err != nil {
 		return fmt.Errorf("decode input: %w", err)
 	}
 
+	session.idle.touch()
 	_, err = session.ptmx.Write(data)
 	return err
 }
 
-// Resize changes the PTY window size.
-func (m *PTYManager) Resize(sessionID string, cols, rows uint16) error {
+// Resize records a viewer's window size and resizes the PTY according to
+// the manager's ResizePolicy. Read-only and unknown viewers may not resize.
+func (m *PTYManager) Resize(sessionID, viewerID string, cols, rows uint16) error {
 	m.mu.RLock()
 	session, ok := m.sessions[sessionID]
 	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
+	if err := session.viewers.checkResize(sessionID, viewerID); err != nil {
+		return err
+	}
 
-	return pty.Setsize(session.ptmx, &pty.Winsize{Cols: cols, Rows: rows})
+	return session.resizer.Request(viewerID, cols, rows)
 }
 
 // Close terminates a PTY session.
@@ -145,15 +293,160 @@ func (m *PTYManager) Close(sessionID string) error {
 	delete(m.sessions, sessionID)
 	m.mu.Unlock()
 
+	tmuxKill(session.tmux)
 	if session.cmd.Process != nil {
 		_ = session.cmd.Process.Kill()
 	}
+	session.resizer.Stop()
+	session.idle.Stop()
 	_ = session.ptmx.Close()
 
 	log.Printf("PTY session %s closed", sessionID)
 	return nil
 }
 
+// Disown ends a session without ending its program, which carries on as
+// a background job of the executor's: the session is gone, with its
+// viewers, and the program's output goes to the job's log instead. A
+// program in tmux is left there, where it outlives the runner; any other
+// keeps its terminal on the runner, so it ends with the runner.
+//
+// check decides, as for a job started with the session's command, whether
+// the program may carry on; if not, the session is left as it was.
+func (m *PTYManager) Disown(sessionID string, check CommandCheck) (protocol.JobInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return protocol.JobInfo{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	if m.exec.Jobs == nil {
+		return protocol.JobInfo{}, errors.New("background jobs are unavailable")
+	}
+	if err := check.allow(Command{Kind: CommandJob, Line: session.command}); err != nil {
+		return protocol.JobInfo{}, err
+	}
+	pid := session.cmd.Process.Pid
+	if session.tmux != "" {
+		var err error
+		if pid, _, err = tmuxPaneGroups(session.tmux); err != nil {
+			return protocol.JobInfo{}, err
+		}
+	}
+	j, f, err := m.exec.Jobs.track(pid, session.command)
+	if err != nil {
+		return protocol.JobInfo{}, err
+	}
+	if session.tmux != "" {
+		// tmux writes the log itself, so it stays complete without us.
+		f.Close()
+		if err := tmuxPipe(session.tmux, j.log); err != nil {
+			m.exec.Jobs.end(j, nil, "")
+			return protocol.JobInfo{}, err
+		}
+		session.disowned.Store(&disowned{job: j})
+		session.idle.closing(ptyDetached)
+		go m.waitTmux(session, j)
+		_ = session.cmd.Process.Kill()
+	} else {
+		session.disowned.Store(&disowned{job: j, log: f})
+	}
+	delete(m.sessions, sessionID)
+	session.resizer.Stop()
+	session.idle.Stop()
+
+	log.Printf("PTY session %s disowned: job %s", sessionID, j.info.JobID)
+	return j.info, nil
+}
+
+// waitTmux waits for the program of disowned session, which runs in tmux
+// as job j, to end.
+func (m *PTYManager) waitTmux(session *PTYSession, j *job) {
+	ticker := time.NewTicker(adoptedPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !tmuxAlive(session.tmux) {
+			break
+		}
+	}
+	code, signal := m.tmuxExit(session)
+	var exitCode *int
+	if code >= 0 {
+		exitCode = &code
+	}
+	m.exec.Jobs.end(j, exitCode, signal)
+	log.Printf("PTY session %s: job %s exited with code %d", session.id, j.info.JobID, code)
+}
+
+// tmuxExit returns the exit status and the signal, if one killed it, of
+// the program of session, which ran in tmux.
+func (m *PTYManager) tmuxExit(session *PTYSession) (int, string) {
+	code := tmuxExitCode(m.tmuxExitDir(), session.tmux)
+	switch {
+	case code > 128 && code < 128+32:
+		// The shell's report of a program killed by a signal.
+		return -1, signalName(syscall.Signal(code - 128))
+	case code == -1 && session.killed.Load():
+		return -1, signalName(syscall.SIGKILL)
+	}
+	return code, ""
+}
+
+// Signal sends sig ("term" by default, "int" or "kill") to a session's
+// process group and, if it is another, to the terminal's foreground
+// process group, which is what Ctrl-C would reach. Unlike input, this
+// works whatever the terminal's mode and whatever the program reads.
+func (m *PTYManager) Signal(sessionID, sig string) error {
+	sig, err := ptySignal(sig)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	var group, foreground int
+	if session.tmux != "" {
+		if group, foreground, err = tmuxPaneGroups(session.tmux); err != nil {
+			return err
+		}
+		if sig == "kill" {
+			session.killed.Store(true)
+		}
+	} else {
+		group, foreground = session.cmd.Process.Pid, foregroundGroup(session.ptmx)
+	}
+	if foreground > 0 && foreground != group {
+		if err := signalJob(foreground, sig); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("signal session %s: %w", sessionID, err)
+		}
+	}
+	if err := signalJob(group, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("signal session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// foregroundGroup returns the foreground process group of the terminal
+// whose master is ptmx, or 0 if it cannot tell.
+func foregroundGroup(ptmx *os.File) int {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var pgrp int32
+	var errno syscall.Errno
+	// Control, unlike Fd, leaves the file non-blocking for readLoop.
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	}); err != nil || errno != 0 {
+		return 0
+	}
+	return int(pgrp)
+}
+
 // ListSessions returns the IDs of all active PTY sessions.
 func (m *PTYManager) ListSessions() []string {
 	m.mu.RLock()
@@ -165,6 +458,18 @@ func (m *PTYManager) ListSessions() []string {
 	return ids
 }
 
+// Pid returns the process ID of a session's command, or 0 if the session
+// does not exist.
+func (m *PTYManager) Pid(sessionID string) int {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return session.cmd.Process.Pid
+}
+
 // CloseAll terminates all active PTY sessions (called on shutdown).
 func (m *PTYManager) CloseAll() {
 	m.mu.Lock()
@@ -176,9 +481,15 @@ func (m *PTYManager) CloseAll() {
 	m.mu.Unlock()
 
 	for id, session := range sessions {
+		if session.tmux != "" {
+			// Only detach, leaving the program to the next runner.
+			session.idle.closing(ptyDetached)
+		}
 		if session.cmd.Process != nil {
 			_ = session.cmd.Process.Kill()
 		}
+		session.resizer.Stop()
+		session.idle.Stop()
 		_ = session.ptmx.Close()
 		log.Printf("PTY session %s closed (cleanup)", id)
 	}
@@ -214,14 +525,34 @@ func (m *PTYManager) readLoop(session *PTYSession) {
 	}()
 
 	flush := func() {
-		if len(coalBuf) > 0 && m.OutputFunc != nil {
-			out := make([]byte, len(coalBuf))
-			copy(out, coalBuf)
-			m.OutputFunc(session.id, out)
+		if len(coalBuf) > 0 {
+			session.idle.touch()
+			session.rec.output(coalBuf)
+			session.screen.write(coalBuf)
+			if d := session.disowned.Load(); d != nil {
+				// With tmux, d.log is nil: tmux writes the log.
+				if d.log != nil {
+					if _, err := d.log.Write(coalBuf); err != nil {
+						log.Printf("PTY session %s: write job %s log: %v", session.id, d.job.info.JobID, err)
+					}
+				}
+			} else if m.OutputFunc != nil {
+				out := make([]byte, len(coalBuf))
+				copy(out, coalBuf)
+				m.OutputFunc(session.id, out)
+			}
 			coalBuf = coalBuf[:0]
 		}
 		timer.Stop()
 	}
+	// The last output has been flushed by the time this returns.
+	defer close(session.flushed)
+	defer session.rec.close()
+	defer func() {
+		if d := session.disowned.Load(); d != nil && d.log != nil {
+			d.log.Close()
+		}
+	}()
 
 	for {
 		select {
@@ -249,24 +580,60 @@ func (m *PTYManager) waitLoop(session *PTYSession) {
 	err := session.cmd.Wait()
 	close(session.done)
 
-	exitCode := 0
+	exitCode, signal := 0, ""
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
+			signal = exitSignal(exitErr.ProcessState)
 		} else {
 			exitCode = -1
 		}
 	}
 
 	m.mu.Lock()
-	delete(m.sessions, session.id)
+	if m.sessions[session.id] == session {
+		delete(m.sessions, session.id)
+	}
 	m.mu.Unlock()
 
+	session.resizer.Stop()
+	session.idle.Stop()
 	_ = session.ptmx.Close()
 
+	if d := session.disowned.Load(); d != nil && session.tmux == "" {
+		<-session.flushed // the job's log is complete
+		var code *int
+		if exitCode >= 0 {
+			code = &exitCode
+		}
+		m.exec.Jobs.end(d.job, code, signal)
+		log.Printf("PTY session %s: job %s exited with code %d", session.id, d.job.info.JobID, exitCode)
+		return
+	}
+
+	if session.tmux != "" {
+		if session.idle.Reason() == ptyDetached {
+			log.Printf("PTY session %s detached from tmux session %s", session.id, session.tmux)
+			return
+		}
+		// The attach client ends with the program, unless it was killed
+		// on its own; the program is not left behind either way.
+		tmuxKill(session.tmux)
+		exitCode, signal = m.tmuxExit(session)
+	}
+
 	if m.ExitFunc != nil {
-		m.ExitFunc(session.id, exitCode)
+		m.ExitFunc(session.id, exitCode, signal, session.idle.Reason())
 	}
 
 	log.Printf("PTY session %s exited with code %d", session.id, exitCode)
 }
+
+// ptyCommandLine is the command line of a session's program.
+func ptyCommandLine(command string, args []string) string {
+	words := append([]string{command}, args...)
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,503 @@
+package executor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Exit reasons of the sessions the runner closes itself.
+const (
+	// PTYExitIdleTimeout: the session was idle past the manager's
+	// IdleTimeout.
+	PTYExitIdleTimeout = "idle_timeout"
+	// PTYExitEvicted: the session was the least recently active when a
+	// new one needed its place (see PTYLimitEvict).
+	PTYExitEvicted = "evicted"
+	// ptyDetached: the runner is shutting down and leaves the session
+	// running in tmux. ExitFunc is not called.
+	ptyDetached = "detached"
+)
+
+// disowned is the job a session's program carries on as once Disown has
+// ended the session.
+type disowned struct {
+	job *job
+	log *os.File // written by readLoop; nil if tmux writes the log
+}
+
+// PTY session backends (PTYManager.Backend).
+const (
+	// PTYBackendDirect runs a session's command on a PTY of the runner's,
+	// so it ends with the runner.
+	PTYBackendDirect = "direct"
+	// PTYBackendTmux runs it in a tmux session on a tmux server of the
+	// runner's own, which outlives the runner: the runner only attaches
+	// to it, and a restarted runner can reattach (see Adopt). Not
+	// supported on Windows.
+	PTYBackendTmux = "tmux"
+)
+
+// Policies for a manager at MaxSessions (PTYManager.OnLimit).
+const (
+	// PTYLimitReject refuses new sessions with a *PTYLimitError.
+	PTYLimitReject = "reject"
+	// PTYLimitEvict closes the least recently active session to make room.
+	PTYLimitEvict = "evict_lru"
+)
+
+// PTYLimitError is returned by Create when the manager already has
+// MaxSessions sessions.
+type PTYLimitError struct {
+	Max      int
+	Sessions []string
+}
+
+func (e *PTYLimitError) Error() string {
+	return fmt.Sprintf("too many PTY sessions (the limit is %d); close one first", e.Max)
+}
+
+// Resize modes for ResizePolicy.Mode.
+const (
+	// ResizeLatest applies the most recently requested geometry.
+	ResizeLatest = "latest"
+	// ResizeLargest applies the largest geometry across all viewers that
+	// have reported a size, so no attached viewer sees a clipped TUI.
+	ResizeLargest = "largest"
+)
+
+// ResizePolicy controls how pty_resize requests are applied.
+type ResizePolicy struct {
+	Mode string
+	// Debounce merges resize requests arriving within this window into a
+	// single resize. Zero applies each request immediately.
+	Debounce time.Duration
+}
+
+// resizer tracks per-viewer terminal geometry for one session and applies
+// the effective size according to a ResizePolicy.
+type resizer struct {
+	policy ResizePolicy
+	apply  func(cols, rows uint16) error
+
+	mu      sync.Mutex
+	viewers map[string][2]uint16
+	pending [2]uint16
+	applied [2]uint16
+	timer   *time.Timer
+	stopped bool
+}
+
+func newResizer(policy ResizePolicy, cols, rows uint16, apply func(cols, rows uint16) error) *resizer {
+	return &resizer{
+		policy:  policy,
+		apply:   apply,
+		viewers: map[string][2]uint16{"": {cols, rows}},
+		applied: [2]uint16{cols, rows},
+	}
+}
+
+// Request records a viewer's geometry and schedules the resulting resize.
+// A zero cols or rows removes the viewer from consideration. Errors are
+// only returned when the resize is applied synchronously.
+func (r *resizer) Request(viewerID string, cols, rows uint16) error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+	if cols == 0 || rows == 0 {
+		delete(r.viewers, viewerID)
+	} else {
+		r.viewers[viewerID] = [2]uint16{cols, rows}
+	}
+
+	target := [2]uint16{cols, rows}
+	if r.policy.Mode == ResizeLargest || cols == 0 || rows == 0 {
+		target = r.largest()
+	}
+	if target[0] == 0 || target[1] == 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	r.pending = target
+
+	if r.policy.Debounce <= 0 {
+		r.mu.Unlock()
+		return r.flush()
+	}
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.policy.Debounce, func() {
+			if err := r.flush(); err != nil {
+				log.Printf("PTY resize failed: %v", err)
+			}
+		})
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// largest returns the maximum cols and rows across all known viewers.
+// Must be called with r.mu held.
+func (r *resizer) largest() [2]uint16 {
+	var size [2]uint16
+	for _, v := range r.viewers {
+		if v[0] > size[0] {
+			size[0] = v[0]
+		}
+		if v[1] > size[1] {
+			size[1] = v[1]
+		}
+	}
+	return size
+}
+
+// flush applies the pending geometry if it differs from the current one.
+func (r *resizer) flush() error {
+	r.mu.Lock()
+	r.timer = nil
+	if r.stopped || r.pending == r.applied {
+		r.mu.Unlock()
+		return nil
+	}
+	size := r.pending
+	r.applied = size
+	r.mu.Unlock()
+	return r.apply(size[0], size[1])
+}
+
+// Stop cancels any pending resize. Called when the session ends.
+func (r *resizer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// idleTimer tracks when a session last had input or output and, with a
+// timeout, closes it once it has been idle that long. It also keeps the
+// reason the runner closed the session, if it did.
+type idleTimer struct {
+	last   atomic.Int64 // UnixNano of the last input or output
+	reason atomic.Value // string
+
+	mu      sync.Mutex
+	timer   *time.Timer // nil without a timeout
+	stopped bool
+}
+
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	t := &idleTimer{}
+	t.touch()
+	if timeout <= 0 {
+		return t
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = time.AfterFunc(timeout, func() {
+		idle := time.Since(time.Unix(0, t.last.Load()))
+		if idle < timeout {
+			t.mu.Lock()
+			if !t.stopped {
+				t.timer.Reset(timeout - idle)
+			}
+			t.mu.Unlock()
+			return
+		}
+		t.closing(PTYExitIdleTimeout)
+		onIdle()
+	})
+	return t
+}
+
+// touch records input or output.
+func (t *idleTimer) touch() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+// closing records why the runner is closing the session.
+func (t *idleTimer) closing(reason string) {
+	t.reason.Store(reason)
+}
+
+// Reason returns why the runner closed the session, or "" if it did not.
+func (t *idleTimer) Reason() string {
+	reason, _ := t.reason.Load().(string)
+	return reason
+}
+
+// Stop cancels the timer. Called when the session ends.
+func (t *idleTimer) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// viewerSet tracks the viewers attached to a session. The session owner
+// (empty viewer ID) is implicit and always has write access.
+type viewerSet struct {
+	mu       sync.RWMutex
+	readOnly map[string]bool
+}
+
+func newViewerSet() *viewerSet {
+	return &viewerSet{readOnly: make(map[string]bool)}
+}
+
+// CanWrite reports whether viewerID may send input. Unknown viewer IDs are
+// rejected so a detached viewer cannot keep typing.
+func (v *viewerSet) CanWrite(viewerID string) bool {
+	if viewerID == "" {
+		return true
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	ro, ok := v.readOnly[viewerID]
+	return ok && !ro
+}
+
+// checkResize returns an error unless viewerID may resize the terminal
+// of session sessionID: only the owner and attached read-write viewers
+// may.
+func (v *viewerSet) checkResize(sessionID, viewerID string) error {
+	if viewerID == "" {
+		return nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	ro, ok := v.readOnly[viewerID]
+	if !ok {
+		return fmt.Errorf("viewer %s is not attached to session %s", viewerID, sessionID)
+	}
+	if ro {
+		return fmt.Errorf("viewer %s has read-only access to session %s", viewerID, sessionID)
+	}
+	return nil
+}
+
+// IDs returns the attached viewer IDs, excluding the owner.
+func (v *viewerSet) IDs() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if len(v.readOnly) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(v.readOnly))
+	for id := range v.readOnly {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Attach adds a viewer to an existing session. Read-only viewers receive
+// output but their input is rejected.
+func (m *PTYManager) Attach(sessionID, viewerID string, readOnly bool) error {
+	if viewerID == "" {
+		return fmt.Errorf("viewer_id is required")
+	}
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session.viewers.mu.Lock()
+	session.viewers.readOnly[viewerID] = readOnly
+	session.viewers.mu.Unlock()
+
+	log.Printf("PTY session %s: viewer %s attached (read-only=%v)", sessionID, viewerID, readOnly)
+	return nil
+}
+
+// Detach removes a viewer from a session and drops its reported geometry.
+func (m *PTYManager) Detach(sessionID, viewerID string) error {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session.viewers.mu.Lock()
+	_, attached := session.viewers.readOnly[viewerID]
+	delete(session.viewers.readOnly, viewerID)
+	session.viewers.mu.Unlock()
+	if !attached {
+		return fmt.Errorf("viewer %s is not attached to session %s", viewerID, sessionID)
+	}
+
+	_ = session.resizer.Request(viewerID, 0, 0)
+	log.Printf("PTY session %s: viewer %s detached", sessionID, viewerID)
+	return nil
+}
+
+// Viewers returns the IDs of the additional viewers attached to a session,
+// used to tag output for fan-out.
+func (m *PTYManager) Viewers(sessionID string) []string {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return session.viewers.IDs()
+}
+
+// makeRoom closes the least recently active session if the manager is at
+// MaxSessions and evicts sessions, so Create can start sessionID.
+func (m *PTYManager) makeRoom(sessionID string) {
+	if m.MaxSessions <= 0 || m.OnLimit != PTYLimitEvict {
+		return
+	}
+	m.mu.RLock()
+	var victim *PTYSession
+	_, exists := m.sessions[sessionID]
+	if !exists && len(m.sessions) >= m.MaxSessions {
+		for _, s := range m.sessions {
+			if victim == nil || s.idle.last.Load() < victim.idle.last.Load() {
+				victim = s
+			}
+		}
+	}
+	m.mu.RUnlock()
+	if victim == nil {
+		return
+	}
+	victim.idle.closing(PTYExitEvicted)
+	log.Printf("PTY session limit (%d) reached, evicting %s", m.MaxSessions, victim.id)
+	_ = m.Close(victim.id)
+}
+
+// checkLimit returns a *PTYLimitError if the manager has MaxSessions
+// sessions. Must be called with m.mu held.
+func (m *PTYManager) checkLimit() error {
+	if m.MaxSessions <= 0 || len(m.sessions) < m.MaxSessions {
+		return nil
+	}
+	ids := make([]string, 0, len(m.sessions))
+	for id := range m.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return &PTYLimitError{Max: m.MaxSessions, Sessions: ids}
+}
+
+// sessionDir returns the directory a session starts in: the work dir, or
+// cwd in it, resolved and checked as an exec's cwd is.
+func (m *PTYManager) sessionDir(cwd string) (string, error) {
+	if cwd == "" {
+		return m.workDir, nil
+	}
+	dir, err := m.exec.resolvePath(cwd)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("cwd %q does not exist", cwd)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cwd: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("cwd %q is not a directory", cwd)
+	}
+	return dir, nil
+}
+
+// Tmux returns the name of a session's tmux session, or "" if the session
+// does not exist or does not run in tmux.
+func (m *PTYManager) Tmux(sessionID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if session, ok := m.sessions[sessionID]; ok {
+		return session.tmux
+	}
+	return ""
+}
+
+// tmuxExitDir is where sessions run in tmux leave their exit status.
+func (m *PTYManager) tmuxExitDir() string {
+	return filepath.Join(m.workDir, ".xyzen", "tmux")
+}
+
+// Recording returns the ID of a session's recording, or "" if the session
+// does not exist or is not recorded.
+func (m *PTYManager) Recording(sessionID string) string {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok || session.rec == nil {
+		return ""
+	}
+	return session.rec.id
+}
+
+// Snapshot returns what a session's screen shows now, for a viewer that
+// attaches after the output that drew it. Sessions run in tmux are
+// captured from tmux; others are kept by the session's vtScreen. The text
+// goes through Redact, as output does.
+func (m *PTYManager) Snapshot(sessionID string) (protocol.PTYSnapshotResult, error) {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return protocol.PTYSnapshotResult{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	var snap vtSnapshot
+	if session.tmux != "" {
+		var err error
+		if snap, err = tmuxSnapshot(session.tmux); err != nil {
+			return protocol.PTYSnapshotResult{}, err
+		}
+	} else {
+		snap = session.screen.snapshot()
+	}
+	redact := func(s string) string {
+		if m.Redact == nil {
+			return s
+		}
+		return string(m.Redact([]byte(s)))
+	}
+	for i, line := range snap.lines {
+		snap.lines[i] = redact(line)
+	}
+	return protocol.PTYSnapshotResult{
+		SessionID:    sessionID,
+		Cols:         snap.cols,
+		Rows:         snap.rows,
+		CursorRow:    snap.y,
+		CursorCol:    snap.x,
+		CursorHidden: snap.hidden,
+		AltScreen:    snap.alt,
+		Lines:        snap.lines,
+		Data:         base64.StdEncoding.EncodeToString([]byte(redact(snap.ansi))),
+	}, nil
+}
+
+// ptySignal checks sig for Signal, which sends "term" (the default),
+// "int" or "kill".
+func ptySignal(sig string) (string, error) {
+	switch sig {
+	case "":
+		return "term", nil
+	case "term", "int", "kill":
+		return sig, nil
+	}
+	return "", fmt.Errorf("invalid signal %q (want term, int or kill)", sig)
+}
@@ -0,0 +1,298 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// RecordingsDir is where PTY sessions are recorded, relative to the work
+// dir.
+const RecordingsDir = ".xyzen/recordings"
+
+// castExt is the extension of asciicast files.
+const castExt = ".cast"
+
+// unsafeIDChars are replaced in the session ID part of a recording's
+// file name.
+var unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes a session's output and resizes as asciicast v2
+// events. Its methods do nothing on a nil recorder, and a recording that
+// cannot be written is abandoned so the session carries on.
+type castRecorder struct {
+	id     string
+	redact func([]byte) []byte
+
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+	// pending holds the start of a UTF-8 sequence the last output cut
+	// short, since events carry text.
+	pending []byte
+}
+
+// recordingWanted reports whether the session p creates is recorded.
+func (m *PTYManager) recordingWanted(p protocol.PTYCreatePayload) bool {
+	if p.Record != nil {
+		return *p.Record
+	}
+	return m.Record
+}
+
+// startRecording creates the recording of a session about to start.
+func (m *PTYManager) startRecording(sessionID, command string, cols, rows uint16) (*castRecorder, error) {
+	dir := filepath.Join(m.workDir, filepath.FromSlash(RecordingsDir))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	keepOutOfGit(filepath.Dir(dir))
+	start := time.Now()
+	id := unsafeIDChars.ReplaceAllString(sessionID, "_") + "-" + start.UTC().Format("20060102-150405")
+	f, err := os.OpenFile(filepath.Join(dir, id+castExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		id += start.UTC().Format(".000000")
+		f, err = os.OpenFile(filepath.Join(dir, id+castExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     int(cols),
+		Height:    int(rows),
+		Timestamp: start.Unix(),
+		Command:   command,
+		Title:     sessionID,
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": command},
+	})
+	if _, err := f.Write(append(header, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+	return &castRecorder{id: id, redact: m.Redact, f: f, start: start}, nil
+}
+
+// keepOutOfGit keeps the runner's files in xyzenDir, the work dir's
+// .xyzen, out of the user's version control, as the session scratch
+// directories there are.
+func keepOutOfGit(xyzenDir string) {
+	ignore := filepath.Join(xyzenDir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		_ = os.WriteFile(ignore, []byte("*\n"), 0o644)
+	}
+}
+
+// output records data the session wrote.
+func (r *castRecorder) output(data []byte) {
+	if r == nil {
+		return
+	}
+	if r.redact != nil {
+		data = r.redact(data)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data = append(r.pending, data...)
+	text := trimPartialRune(data)
+	r.pending = append([]byte(nil), data[len(text):]...)
+	if len(text) > 0 {
+		r.event("o", string(text))
+	}
+}
+
+// resize records the session's terminal being resized.
+func (r *castRecorder) resize(cols, rows uint16) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event writes one event. Must be called with r.mu held.
+func (r *castRecorder) event(code, data string) {
+	if r.f == nil {
+		return
+	}
+	t := float64(time.Since(r.start).Microseconds()) / 1e6
+	line, _ := json.Marshal([]interface{}{t, code, data})
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		log.Printf("PTY recording %s: %v", r.id, err)
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// close writes what output is pending and closes the recording, once the
+// session has produced its last output.
+func (r *castRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// recordingPath returns the file of recording id, refusing IDs that are
+// not a plain name.
+func (m *PTYManager) recordingPath(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid recording ID %q", id)
+	}
+	return filepath.Join(m.workDir, filepath.FromSlash(RecordingsDir), id+castExt), nil
+}
+
+// ListRecordings lists the recorded sessions, or only sessionID's if it
+// is set, newest first.
+func (m *PTYManager) ListRecordings(sessionID string) ([]protocol.PTYRecording, error) {
+	dir := filepath.Join(m.workDir, filepath.FromSlash(RecordingsDir))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []protocol.PTYRecording{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list recordings: %w", err)
+	}
+	active := make(map[string]bool)
+	m.mu.RLock()
+	for _, s := range m.sessions {
+		if s.rec != nil {
+			active[s.rec.id] = true
+		}
+	}
+	m.mu.RUnlock()
+
+	recordings := []protocol.PTYRecording{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, castExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		header, err := readCastHeader(filepath.Join(dir, name))
+		if err != nil || (sessionID != "" && header.Title != sessionID) {
+			continue
+		}
+		id := strings.TrimSuffix(name, castExt)
+		recordings = append(recordings, protocol.PTYRecording{
+			ID:        id,
+			SessionID: header.Title,
+			Path:      protocol.JoinPath(RecordingsDir, name),
+			Size:      info.Size(),
+			Cols:      header.Width,
+			Rows:      header.Height,
+			StartedAt: time.Unix(header.Timestamp, 0).UTC().Format(time.RFC3339),
+			Active:    active[id],
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt > recordings[j].StartedAt
+	})
+	return recordings, nil
+}
+
+func readCastHeader(path string) (castHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return castHeader{}, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return castHeader{}, err
+	}
+	var h castHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return castHeader{}, err
+	}
+	if h.Version != 2 {
+		return castHeader{}, fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	return h, nil
+}
+
+// ReadRecording reads up to p.MaxBytes (default and max maxOutputBytes)
+// of a recording from p.Offset, ending at a line break where it can.
+func (m *PTYManager) ReadRecording(p protocol.PTYRecordingFetchPayload) (protocol.PTYRecordingFetchResult, error) {
+	path, err := m.recordingPath(p.RecordingID)
+	if err != nil {
+		return protocol.PTYRecordingFetchResult{}, err
+	}
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > maxOutputBytes {
+		maxBytes = maxOutputBytes
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return protocol.PTYRecordingFetchResult{}, fmt.Errorf("recording %s not found", p.RecordingID)
+	}
+	if err != nil {
+		return protocol.PTYRecordingFetchResult{}, fmt.Errorf("open recording: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return protocol.PTYRecordingFetchResult{}, fmt.Errorf("stat recording: %w", err)
+	}
+	size := info.Size()
+	if p.Offset < 0 || p.Offset > size {
+		return protocol.PTYRecordingFetchResult{}, fmt.Errorf("offset %d is outside the recording (%d bytes)", p.Offset, size)
+	}
+	buf := make([]byte, min(int64(maxBytes), size-p.Offset))
+	n, err := f.ReadAt(buf, p.Offset)
+	if err != nil && err != io.EOF {
+		return protocol.PTYRecordingFetchResult{}, fmt.Errorf("read recording: %w", err)
+	}
+	buf = buf[:n]
+	// A recording still being written may end mid-line.
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i+1]
+	}
+	next := p.Offset + int64(len(buf))
+	return protocol.PTYRecordingFetchResult{
+		RecordingID: p.RecordingID,
+		Data:        string(buf),
+		Offset:      p.Offset,
+		NextOffset:  next,
+		Size:        size,
+		EOF:         next == size,
+	}, nil
+}
@@ -0,0 +1,218 @@
+//go:build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/process"
+)
+
+// tmuxSocket names the runner's tmux server, kept apart from the user's
+// own so their configuration and sessions do not mix with the runner's.
+const tmuxSocket = "xyzen-runner"
+
+// tmuxName returns the name of the tmux session for PTY session id.
+func tmuxName(id string) string {
+	return "xyzen-" + unsafeIDChars.ReplaceAllString(id, "_")
+}
+
+// tmuxCmd returns a tmux command for the runner's server, which ignores
+// the user's configuration. TMUX is cleared so tmux does not refuse to
+// run nested when the runner itself runs in tmux.
+func tmuxCmd(args ...string) *exec.Cmd {
+	cmd := exec.Command("tmux", append([]string{"-L", tmuxSocket, "-f", "/dev/null"}, args...)...)
+	cmd.Env = setEnv(os.Environ(), "TMUX", "")
+	return cmd
+}
+
+// tmuxNew starts command in a new detached tmux session for PTY session
+// id and returns the session's name and the command that attaches to it.
+// The program's exit status is left in exitDir, as the runner that
+// reports it may not be the one that started it.
+func tmuxNew(exitDir, id, dir string, vars map[string]string, command string, args []string, cols, rows uint16) (string, *exec.Cmd, error) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return "", nil, fmt.Errorf("tmux backend: tmux not found in PATH")
+	}
+	name := tmuxName(id)
+	if tmuxAlive(name) {
+		return "", nil, fmt.Errorf("tmux session %s already exists", name)
+	}
+	if err := os.MkdirAll(exitDir, 0o700); err != nil {
+		return "", nil, fmt.Errorf("tmux backend: %w", err)
+	}
+	keepOutOfGit(filepath.Dir(exitDir))
+	exitFile := filepath.Join(exitDir, name)
+	os.Remove(exitFile)
+
+	// A session gets the tmux server's environment, not the client's,
+	// so the command's variables are set by env.
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// The shell traps the signals Signal sends the pane's process group,
+	// so it outlives a program they kill and reports it.
+	argv := []string{"sh", "-c", `f=$1; shift; trap : HUP INT QUIT TERM; env "$@"; echo $? > "$f"`, "sh", exitFile}
+	for _, k := range keys {
+		argv = append(argv, k+"="+vars[k])
+	}
+	argv = append(argv, process.RunnerEnv+"="+strconv.Itoa(os.Getpid()), command)
+	argv = append(argv, args...)
+	// tmux would take the arguments ending in ";" for separators.
+	for i, a := range argv {
+		argv[i] = tmuxEscape(a)
+	}
+
+	// Keep tmux out of the way: no status line, no prefix key to steal
+	// the user's keystrokes and no delay after Escape.
+	cmd := tmuxCmd(append([]string{
+		"start-server", ";",
+		"set-option", "-g", "status", "off", ";",
+		"set-option", "-g", "prefix", "None", ";",
+		"set-option", "-g", "prefix2", "None", ";",
+		"set-option", "-s", "escape-time", "0", ";",
+		"new-session", "-d", "-s", name, "-x", strconv.Itoa(int(cols)), "-y", strconv.Itoa(int(rows)), "-c", tmuxEscape(dir), "--",
+	}, argv...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", nil, fmt.Errorf("tmux new-session: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return name, tmuxAttach(name), nil
+}
+
+// tmuxEscape escapes an argument of a tmux command line that ends in ";",
+// which tmux would otherwise take for (or strip as) a command separator.
+func tmuxEscape(arg string) string {
+	if strings.HasSuffix(arg, ";") {
+		return strings.TrimSuffix(arg, ";") + `\;`
+	}
+	return arg
+}
+
+// tmuxAttach returns the command that attaches to tmux session name.
+func tmuxAttach(name string) *exec.Cmd {
+	cmd := tmuxCmd("attach-session", "-t", "="+name)
+	cmd.Env = setEnv(cmd.Env, "TERM", "xterm-256color")
+	return cmd
+}
+
+// tmuxPipe appends the output of tmux session name to the file at path
+// from now on.
+func tmuxPipe(name, path string) error {
+	if out, err := tmuxCmd("pipe-pane", "-o", "-t", "="+name+":", "cat >> "+shellQuote(path)).CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux pipe-pane: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// tmuxAlive reports whether tmux session name exists.
+func tmuxAlive(name string) bool {
+	return tmuxCmd("has-session", "-t", "="+name).Run() == nil
+}
+
+// tmuxKill ends tmux session name, if there is one.
+func tmuxKill(name string) {
+	if name != "" {
+		_ = tmuxCmd("kill-session", "-t", "="+name).Run()
+	}
+}
+
+// tmuxExitCode returns the exit status the program of tmux session name
+// left in exitDir, or -1 if it left none (it was killed).
+func tmuxExitCode(exitDir, name string) int {
+	file := filepath.Join(exitDir, name)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return -1
+	}
+	os.Remove(file)
+	code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+// tmuxSnapshot captures the screen of tmux session name.
+func tmuxSnapshot(name string) (vtSnapshot, error) {
+	// A pane target needs the colon to take "=name" as a session.
+	out, err := tmuxCmd("display-message", "-p", "-t", "="+name+":",
+		"#{pane_width} #{pane_height} #{cursor_x} #{cursor_y} #{cursor_flag} #{alternate_on}").Output()
+	if err != nil {
+		return vtSnapshot{}, fmt.Errorf("tmux snapshot: %v", err)
+	}
+	var snap vtSnapshot
+	var cursor, alt int
+	if _, err := fmt.Sscan(string(out), &snap.cols, &snap.rows, &snap.x, &snap.y, &cursor, &alt); err != nil {
+		return vtSnapshot{}, fmt.Errorf("tmux snapshot: %q: %v", out, err)
+	}
+	snap.hidden, snap.alt = cursor == 0, alt == 1
+
+	capture := func(args ...string) ([]string, error) {
+		out, err := tmuxCmd(append([]string{"capture-pane", "-p", "-t", "=" + name + ":"}, args...)...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("tmux capture-pane: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+		return lines[:min(len(lines), snap.rows)], nil
+	}
+	if snap.lines, err = capture(); err != nil {
+		return vtSnapshot{}, err
+	}
+	for i, line := range snap.lines {
+		snap.lines[i] = strings.TrimRight(line, " ")
+	}
+	styled, err := capture("-e")
+	if err != nil {
+		return vtSnapshot{}, err
+	}
+	var b strings.Builder
+	if snap.alt {
+		b.WriteString("\x1b[?1049h")
+	}
+	b.WriteString("\x1b[0m\x1b[H\x1b[2J")
+	for i, line := range styled {
+		if line != "" {
+			b.WriteString("\x1b[" + strconv.Itoa(i+1) + ";1H" + line + "\x1b[0m")
+		}
+	}
+	b.WriteString("\x1b[" + strconv.Itoa(snap.y+1) + ";" + strconv.Itoa(snap.x+1) + "H")
+	if snap.hidden {
+		b.WriteString("\x1b[?25l")
+	}
+	snap.ansi = b.String()
+	return snap, nil
+}
+
+// tmuxPaneGroups returns the process group of tmux session name's
+// program, and its terminal's foreground process group where /proc tells
+// (0 otherwise).
+func tmuxPaneGroups(name string) (int, int, error) {
+	out, err := tmuxCmd("display-message", "-p", "-t", "="+name+":", "#{pane_pid}").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("tmux display-message: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid <= 0 {
+		return 0, 0, fmt.Errorf("tmux session %s has no program", name)
+	}
+	// The fields after the command name, which is in parentheses, are
+	// state, ppid, pgrp, session, tty_nr and tpgid.
+	foreground := 0
+	if stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); err == nil {
+		if i := strings.LastIndexByte(string(stat), ')'); i >= 0 {
+			if fields := strings.Fields(string(stat[i+1:])); len(fields) > 5 {
+				foreground, _ = strconv.Atoi(fields[5])
+			}
+		}
+	}
+	return pid, foreground, nil
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package executor
+
+import "testing"
+
+// TestTmuxEscape escapes arguments tmux would take for command
+// separators, and only those.
+func TestTmuxEscape(t *testing.T) {
+	for _, tc := range []struct {
+		arg, want string
+	}{
+		{";", `\;`},
+		{"a;", `a\;`},
+		// tmux turns a trailing `\;` back into ";", leaving `a\;`.
+		{`a\;`, `a\\;`},
+		{";a", ";a"},
+		{"a;b", "a;b"},
+		{"", ""},
+		{"kill-server", "kill-server"},
+	} {
+		if got := tmuxEscape(tc.arg); got != tc.want {
+			t.Errorf("tmuxEscape(%q) = %q, want %q", tc.arg, got, tc.want)
+		}
+	}
+}
@@ -5,10 +5,12 @@ package executor
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/UserExistsError/conpty"
@@ -26,6 +28,17 @@ type PTYSession struct {
 	cpty   *conpty.ConPty
 	cancel context.CancelFunc
 	done   chan struct{} // closed when the process exits
+	// flushed is closed once readLoop has passed on the last output.
+	flushed chan struct{}
+
+	resizer  *resizer
+	viewers  *viewerSet
+	rec      *castRecorder // nil unless the session is recorded
+	screen   *vtScreen     // nil with the tmux backend, which keeps its own
+	idle     *idleTimer
+	tmux     string                   // always empty: there is no tmux backend on Windows
+	command  string                   // for the job Disown makes of it
+	disowned atomic.Pointer[disowned] // set by Disown
 }
 
 // PTYManager manages multiple concurrent PTY sessions via Windows ConPTY.
@@ -33,17 +46,37 @@ type PTYManager struct {
 	mu       sync.RWMutex
 	sessions map[string]*PTYSession
 	workDir  string
+	exec     *Executor // resolves session working directories
 	// OutputFunc is called when a PTY session produces output.
 	OutputFunc func(sessionID string, data []byte)
-	// ExitFunc is called when a PTY session's process exits.
-	ExitFunc func(sessionID string, exitCode int)
+	// ExitFunc is called when a PTY session's process exits. Signal is
+	// always "" on Windows, and reason is one of the PTYExit reasons if
+	// the runner closed the session.
+	ExitFunc func(sessionID string, exitCode int, signal, reason string)
+	// ResizePolicy controls debouncing and multi-viewer geometry.
+	ResizePolicy ResizePolicy
+	// IdleTimeout closes sessions with no input or output for this long;
+	// zero or negative disables it.
+	IdleTimeout time.Duration
+	// MaxSessions caps the concurrent sessions, if positive. OnLimit is
+	// PTYLimitReject (if empty) or PTYLimitEvict.
+	MaxSessions int
+	OnLimit     string
+	// Backend must be PTYBackendDirect (or empty) on Windows.
+	Backend string
+	// Record records sessions under RecordingsDir unless pty_create says
+	// otherwise, with output passed through Redact if it is set.
+	Record bool
+	Redact func([]byte) []byte
 }
 
-// NewPTYManager creates a new PTY manager.
-func NewPTYManager(workDir string) *PTYManager {
+// NewPTYManager creates a PTY manager for e's work dir, which resolves
+// the working directories of sessions as e resolves paths.
+func NewPTYManager(e *Executor) *PTYManager {
 	return &PTYManager{
 		sessions: make(map[string]*PTYSession),
-		workDir:  workDir,
+		workDir:  e.workDir,
+		exec:     e,
 	}
 }
 
@@ -58,11 +91,17 @@ func detectShell() string {
 	return "cmd.exe"
 }
 
-// Create starts a new PTY session with the given command.
-func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
+// Create starts a new PTY session with the given command. At MaxSessions
+// it returns a *PTYLimitError, or with PTYLimitEvict first closes the
+// least recently active session. A command check refuses doesn't start.
+func (m *PTYManager) Create(p protocol.PTYCreatePayload, check CommandCheck) error {
 	if !conpty.IsConPtyAvailable() {
 		return fmt.Errorf("ConPTY is not available on this version of Windows")
 	}
+	if m.Backend == PTYBackendTmux {
+		return fmt.Errorf("the tmux backend is not supported on Windows")
+	}
+	m.makeRoom(p.SessionID)
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -70,6 +109,9 @@ func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
 	if _, exists := m.sessions[p.SessionID]; exists {
 		return fmt.Errorf("session %s already exists", p.SessionID)
 	}
+	if err := m.checkLimit(); err != nil {
+		return err
+	}
 
 	command := p.Command
 	if command == "" {
@@ -90,21 +132,62 @@ func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
 	for _, arg := range p.Args {
 		commandLine += " " + arg
 	}
+	if err := check.allow(Command{Kind: CommandPTY, Line: commandLine, Env: p.Env}); err != nil {
+		return err
+	}
+
+	var rec *castRecorder
+	if m.recordingWanted(p) {
+		var err error
+		if rec, err = m.startRecording(p.SessionID, command, cols, rows); err != nil {
+			return err
+		}
+	}
+
+	dir, err := m.sessionDir(p.Cwd)
+	if err != nil {
+		return err
+	}
+	if err := validEnv(p.Env); err != nil {
+		return err
+	}
+	opts := []conpty.ConPtyOption{conpty.ConPtyDimensions(int(cols), int(rows)), conpty.ConPtyWorkDir(dir)}
+	if len(p.Env) > 0 {
+		opts = append(opts, conpty.ConPtyEnv(commandEnv(p.Env, false)))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	cpty, err := conpty.Start(commandLine, conpty.ConPtyDimensions(int(cols), int(rows)), conpty.ConPtyWorkDir(m.workDir))
+	cpty, err := conpty.Start(commandLine, opts...)
 	if err != nil {
 		cancel()
+		rec.close()
 		return fmt.Errorf("start conpty: %w", err)
 	}
 
 	session := &PTYSession{
-		id:     p.SessionID,
-		cpty:   cpty,
-		cancel: cancel,
-		done:   make(chan struct{}),
+		id:      p.SessionID,
+		cpty:    cpty,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+		rec:     rec,
+		screen:  newVTScreen(int(cols), int(rows)),
+		command: commandLine,
 	}
+	session.viewers = newViewerSet()
+	session.idle = newIdleTimer(m.IdleTimeout, func() {
+		log.Printf("PTY session %s idle for %s, closing", p.SessionID, m.IdleTimeout)
+		_ = m.Close(p.SessionID)
+	})
+	session.resizer = newResizer(m.ResizePolicy, cols, rows, func(cols, rows uint16) error {
+		if err := cpty.Resize(int(cols), int(rows)); err != nil {
+			return err
+		}
+		rec.resize(cols, rows)
+		session.screen.resize(int(cols), int(rows))
+		return nil
+	})
 	m.sessions[p.SessionID] = session
 
 	go m.readLoop(session, ctx)
@@ -114,34 +197,52 @@ func (m *PTYManager) Create(p protocol.PTYCreatePayload) error {
 	return nil
 }
 
-// Input writes data to a PTY session's stdin.
-func (m *PTYManager) Input(sessionID string, dataB64 string) error {
+// Adopt is not supported on Windows, which has no tmux backend.
+func (m *PTYManager) Adopt(sessionID, name string) error {
+	return fmt.Errorf("the tmux backend is not supported on Windows")
+}
+
+// tmuxSnapshot is never called on Windows, which has no tmux backend.
+func tmuxSnapshot(name string) (vtSnapshot, error) {
+	return vtSnapshot{}, fmt.Errorf("the tmux backend is not supported on Windows")
+}
+
+// Input writes data to a PTY session's stdin on behalf of viewerID.
+func (m *PTYManager) Input(sessionID, viewerID string, dataB64 string) error {
 	m.mu.RLock()
 	session, ok := m.sessions[sessionID]
 	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
+	if !session.viewers.CanWrite(viewerID) {
+		return fmt.Errorf("viewer %s has read-only access to session %s", viewerID, sessionID)
+	}
 
 	data, err := base64.StdEncoding.DecodeString(dataB64)
 	if err != nil {
 		return fmt.Errorf("decode input: %w", err)
 	}
 
+	session.idle.touch()
 	_, err = session.cpty.Write(data)
 	return err
 }
 
-// Resize changes the PTY window size.
-func (m *PTYManager) Resize(sessionID string, cols, rows uint16) error {
+// Resize records a viewer's window size and resizes the PTY according to
+// the manager's ResizePolicy. Read-only and unknown viewers may not resize.
+func (m *PTYManager) Resize(sessionID, viewerID string, cols, rows uint16) error {
 	m.mu.RLock()
 	session, ok := m.sessions[sessionID]
 	m.mu.RUnlock()
 	if !ok {
 		return fmt.Errorf("session %s not found", sessionID)
 	}
+	if err := session.viewers.checkResize(sessionID, viewerID); err != nil {
+		return err
+	}
 
-	return session.cpty.Resize(int(cols), int(rows))
+	return session.resizer.Request(viewerID, cols, rows)
 }
 
 // Close terminates a PTY session.
@@ -156,6 +257,8 @@ func (m *PTYManager) Close(sessionID string) error {
 	m.mu.Unlock()
 
 	session.cancel()
+	session.resizer.Stop()
+	session.idle.Stop()
 	_ = session.cpty.Close()
 
 	log.Printf("PTY session %s closed", sessionID)
@@ -173,6 +276,71 @@ func (m *PTYManager) ListSessions() []string {
 	return ids
 }
 
+// Pid returns the process ID of a session's command, or 0 if the session
+// does not exist.
+func (m *PTYManager) Pid(sessionID string) int {
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return int(session.cpty.Pid())
+}
+
+// Disown ends a session without ending its program, which carries on as
+// a background job of the executor's: the session is gone, with its
+// viewers, and the program's output goes to the job's log instead. The
+// program keeps its pseudo console on the runner, so it ends with the
+// runner.
+//
+// check decides, as for a job started with the session's command, whether
+// the program may carry on; if not, the session is left as it was.
+func (m *PTYManager) Disown(sessionID string, check CommandCheck) (protocol.JobInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return protocol.JobInfo{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	if m.exec.Jobs == nil {
+		return protocol.JobInfo{}, errors.New("background jobs are unavailable")
+	}
+	if err := check.allow(Command{Kind: CommandJob, Line: session.command}); err != nil {
+		return protocol.JobInfo{}, err
+	}
+	j, f, err := m.exec.Jobs.track(int(session.cpty.Pid()), session.command)
+	if err != nil {
+		return protocol.JobInfo{}, err
+	}
+	session.disowned.Store(&disowned{job: j, log: f})
+	delete(m.sessions, sessionID)
+	session.resizer.Stop()
+	session.idle.Stop()
+
+	log.Printf("PTY session %s disowned: job %s", sessionID, j.info.JobID)
+	return j.info, nil
+}
+
+// Signal ends a session's process: Windows has no signals to send it, so
+// sig is only checked.
+func (m *PTYManager) Signal(sessionID, sig string) error {
+	sig, err := ptySignal(sig)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	session, ok := m.sessions[sessionID]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	if err := signalJob(int(session.cpty.Pid()), sig); err != nil {
+		return fmt.Errorf("signal session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
 // CloseAll terminates all active PTY sessions (called on shutdown).
 func (m *PTYManager) CloseAll() {
 	m.mu.Lock()
@@ -185,6 +353,8 @@ func (m *PTYManager) CloseAll() {
 
 	for id, session := range sessions {
 		session.cancel()
+		session.resizer.Stop()
+		session.idle.Stop()
 		_ = session.cpty.Close()
 		log.Printf("PTY session %s closed (cleanup)", id)
 	}
@@ -220,14 +390,31 @@ func (m *PTYManager) readLoop(session *PTYSession, ctx context.Context) {
 	}()
 
 	flush := func() {
-		if len(coalBuf) > 0 && m.OutputFunc != nil {
-			out := make([]byte, len(coalBuf))
-			copy(out, coalBuf)
-			m.OutputFunc(session.id, out)
+		if len(coalBuf) > 0 {
+			session.idle.touch()
+			session.rec.output(coalBuf)
+			session.screen.write(coalBuf)
+			if d := session.disowned.Load(); d != nil {
+				if _, err := d.log.Write(coalBuf); err != nil {
+					log.Printf("PTY session %s: write job %s log: %v", session.id, d.job.info.JobID, err)
+				}
+			} else if m.OutputFunc != nil {
+				out := make([]byte, len(coalBuf))
+				copy(out, coalBuf)
+				m.OutputFunc(session.id, out)
+			}
 			coalBuf = coalBuf[:0]
 		}
 		timer.Stop()
 	}
+	// The last output has been flushed by the time this returns.
+	defer close(session.flushed)
+	defer session.rec.close()
+	defer func() {
+		if d := session.disowned.Load(); d != nil {
+			d.log.Close()
+		}
+	}()
 
 	for {
 		select {
@@ -261,13 +448,28 @@ func (m *PTYManager) waitLoop(session *PTYSession, ctx context.Context) {
 	}
 
 	m.mu.Lock()
-	delete(m.sessions, session.id)
+	if m.sessions[session.id] == session {
+		delete(m.sessions, session.id)
+	}
 	m.mu.Unlock()
 
+	session.resizer.Stop()
+	session.idle.Stop()
 	_ = session.cpty.Close()
 
+	if d := session.disowned.Load(); d != nil {
+		<-session.flushed // the job's log is complete
+		var code *int
+		if exitCode >= 0 {
+			code = &exitCode
+		}
+		m.exec.Jobs.end(d.job, code, "")
+		log.Printf("PTY session %s: job %s exited with code %d", session.id, d.job.info.JobID, exitCode)
+		return
+	}
+
 	if m.ExitFunc != nil {
-		m.ExitFunc(session.id, exitCode)
+		m.ExitFunc(session.id, exitCode, "", session.idle.Reason())
 	}
 
 	log.Printf("PTY session %s exited with code %d", session.id, exitCode)
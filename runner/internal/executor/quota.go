@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when a write would take a directory with a
+// quota (see Quotas) over its limit.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quotas limits how many bytes file operations may store under given
+// directories. Writes by commands are not intercepted; their usage still
+// counts against later writes.
+type Quotas struct {
+	mu     sync.Mutex
+	limits map[string]int64 // host dir → bytes
+}
+
+// Set limits the bytes stored under dir.
+func (q *Quotas) Set(dir string, limit int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limits == nil {
+		q.limits = make(map[string]int64)
+	}
+	q.limits[filepath.Clean(dir)] = limit
+}
+
+// Remove drops dir's quota.
+func (q *Quotas) Remove(dir string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.limits, filepath.Clean(dir))
+}
+
+// Usage returns the bytes stored under dir.
+func Usage(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// check reports ErrQuotaExceeded if path, a host path about to become size
+// bytes long (or grow by size bytes, with grow), would exceed the quota
+// of a directory containing it.
+func (q *Quotas) check(path string, size int64, grow bool) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	var dir string
+	var limit int64
+	for d, l := range q.limits {
+		if strings.HasPrefix(path, d+string(filepath.Separator)) {
+			dir, limit = d, l
+			break
+		}
+	}
+	q.mu.Unlock()
+	if dir == "" {
+		return nil
+	}
+	used := Usage(dir)
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && !grow {
+		used -= info.Size() // being replaced
+	}
+	if used+size > limit {
+		return fmt.Errorf("%w: %s would use %d of %d bytes", ErrQuotaExceeded, filepath.Base(dir), used+size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	maxReadFiles            = 100
+	defaultReadFilesMaxFile = 256 * 1024
+	defaultReadFilesBudget  = 1024 * 1024
+)
+
+// ReadFiles reads several text files at once. Each file is cut at
+// p.MaxFileSize bytes and the files together at p.MaxTotalSize; files
+// past the total budget are skipped. Per-file failures are reported in
+// the file's entry rather than failing the request.
+func (e *Executor) ReadFiles(p protocol.ReadFilesPayload) (protocol.ReadFilesResult, error) {
+	if len(p.Paths) == 0 {
+		return protocol.ReadFilesResult{}, fmt.Errorf("no paths given")
+	}
+	if len(p.Paths) > maxReadFiles {
+		return protocol.ReadFilesResult{}, fmt.Errorf("too many paths (%d, max %d)", len(p.Paths), maxReadFiles)
+	}
+	perFile, budget := p.MaxFileSize, p.MaxTotalSize
+	if perFile <= 0 {
+		perFile = defaultReadFilesMaxFile
+	}
+	if budget <= 0 {
+		budget = defaultReadFilesBudget
+	}
+
+	result := protocol.ReadFilesResult{Files: make([]protocol.ReadFilesEntry, 0, len(p.Paths))}
+	for _, path := range p.Paths {
+		entry := protocol.ReadFilesEntry{Path: path}
+		if budget <= 0 {
+			entry.Skipped = true
+			result.Files = append(result.Files, entry)
+			continue
+		}
+		r, err := e.ReadFile(protocol.FilePayload{Path: path, Length: min(perFile, budget)})
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Content, entry.SHA256, entry.Size, entry.Truncated = r.Content, r.SHA256, r.Size, !r.EOF
+			budget -= int64(len(r.Content))
+		}
+		result.Files = append(result.Files, entry)
+	}
+	return result, nil
+}
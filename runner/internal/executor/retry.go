@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Transient local failures (a file briefly locked by an editor, indexer or
+// antivirus scanner) are retried with bounded backoff.
+const retryAttempts = 4
+
+var retryBase = 50 * time.Millisecond
+
+// Retries counts the retries made on behalf of one request.
+type Retries struct {
+	n atomic.Int32
+}
+
+// Count returns the number of retries so far.
+func (r *Retries) Count() int {
+	if r == nil {
+		return 0
+	}
+	return int(r.n.Load())
+}
+
+// WithRetries returns a copy of e that records its retries in r, so a
+// caller can report them for a single request.
+func (e *Executor) WithRetries(r *Retries) *Executor {
+	cp := *e
+	cp.retries = r
+	return &cp
+}
+
+// retry runs op, retrying transient failures.
+func (e *Executor) retry(op func() error) error {
+	delay := retryBase
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == retryAttempts || !isTransient(err) {
+			return err
+		}
+		if e.retries != nil {
+			e.retries.n.Add(1)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
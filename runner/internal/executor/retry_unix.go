@@ -0,0 +1,17 @@
+//go:build !windows
+
+package executor
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransient reports whether err is a local failure likely to succeed if
+// retried shortly.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.ETXTBSY) ||
+		errors.Is(err, syscall.EINTR)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package executor
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isTransient reports whether err is a local failure likely to succeed if
+// retried shortly: on Windows, files held open by another process.
+func isTransient(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/ignore"
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// errRipgrepUnavailable means rg could not be run and the built-in search
+// should be used instead.
+var errRipgrepUnavailable = errors.New("ripgrep unavailable")
+
+// rgText is ripgrep's representation of a path or line: UTF-8 text, or
+// base64 bytes when it is not valid UTF-8.
+type rgText struct {
+	Text  *string `json:"text"`
+	Bytes string  `json:"bytes"`
+}
+
+func (t rgText) String() string {
+	if t.Text != nil {
+		return *t.Text
+	}
+	b, _ := base64.StdEncoding.DecodeString(t.Bytes)
+	return string(b)
+}
+
+// rgMessage is one line of `rg --json` output; only matches are used.
+type rgMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       rgText `json:"path"`
+		Lines      rgText `json:"lines"`
+		LineNumber int    `json:"line_number"`
+	} `json:"data"`
+}
+
+// searchRipgrep runs the search with the rg binary at e.Ripgrep, with the
+// same semantics as the built-in walk: hidden files are searched, files
+// over p.MaxFileSize are skipped, and with p.RespectGitignore ignore files and the
+// default ignore list apply. It returns errRipgrepUnavailable if rg cannot
+// be started.
+func (e *Executor) searchRipgrep(p protocol.SearchPayload, resolved string, fn func(protocol.SearchMatchResult) bool) error {
+	args := []string{"--json", "--hidden", "--max-filesize", strconv.FormatInt(p.MaxFileSize, 10), "--no-config"}
+	if p.IgnoreEnabled() {
+		for _, pat := range ignore.Defaults {
+			args = append(args, "--glob", "!"+pat)
+		}
+	} else {
+		args = append(args, "--no-ignore")
+	}
+	if p.Include != "" {
+		args = append(args, "--glob", p.Include)
+	}
+	for _, pat := range p.Exclude {
+		args = append(args, "--glob", "!"+pat)
+	}
+	if p.Mode == SearchLiteral {
+		args = append(args, "--fixed-strings")
+	}
+	if p.CaseInsensitive {
+		args = append(args, "--ignore-case")
+	}
+	if p.WordBoundary {
+		args = append(args, "--word-regexp")
+	}
+	args = append(args, "--regexp", p.Pattern, "--", resolved)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.Ripgrep, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRipgrepUnavailable, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: %v", errRipgrepUnavailable, err)
+	}
+
+	count := 0
+	stopped := false
+	sc := bufio.NewScanner(out)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		var msg rgMessage
+		if json.Unmarshal(sc.Bytes(), &msg) != nil || msg.Type != "match" {
+			continue
+		}
+		path := msg.Data.Path.String()
+		file := filepath.ToSlash(path)
+		if rel, err := filepath.Rel(resolved, path); err == nil {
+			file = protocol.JoinPath(p.Root, filepath.ToSlash(rel))
+		}
+		count++
+		if !fn(protocol.SearchMatchResult{
+			File:    file,
+			Line:    msg.Data.LineNumber,
+			Content: truncate(strings.TrimSpace(msg.Data.Lines.String()), 500),
+		}) {
+			stopped = true
+			break
+		}
+	}
+	if stopped {
+		cancel()
+	}
+	err = cmd.Wait()
+	if stopped {
+		return nil
+	}
+	// Exit status 1 means no matches; 2 means an error, though rg also
+	// uses it for unreadable files while still reporting what it found.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 2 && count > 0) {
+		return nil
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ripgrep: %s", msg)
+		}
+		return fmt.Errorf("ripgrep: %w", err)
+	}
+	return nil
+}
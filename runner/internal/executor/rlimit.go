@@ -0,0 +1,55 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// How resource limits are enforced (protocol.ExecResultPayload.LimitedBy).
+const (
+	LimitedByCgroup    = "cgroup"
+	LimitedByUlimit    = "ulimit"
+	LimitedByContainer = "container"
+)
+
+// limitsFor returns the limits a command runs under: those requested,
+// within e.ResourceLimits, which also supplies any not requested.
+func (e *Executor) limitsFor(req *protocol.ResourceLimits) protocol.ResourceLimits {
+	l := e.ResourceLimits
+	if req == nil {
+		return l
+	}
+	if req.CPUs > 0 && (l.CPUs == 0 || req.CPUs < l.CPUs) {
+		l.CPUs = req.CPUs
+	}
+	l.MemoryBytes = limit(req.MemoryBytes, l.MemoryBytes, l.MemoryBytes)
+	l.MaxProcesses = int(limit(int64(req.MaxProcesses), int64(l.MaxProcesses), int64(l.MaxProcesses)))
+	return l
+}
+
+// validLimits checks requested resource limits.
+func validLimits(l *protocol.ResourceLimits) error {
+	if l != nil && (l.CPUs < 0 || l.MemoryBytes < 0 || l.MaxProcesses < 0) {
+		return fmt.Errorf("invalid limits: must not be negative")
+	}
+	return nil
+}
+
+// ulimitPrefix returns the shell commands that apply l's memory and
+// process limits with ulimit, and the limits they apply. A limit the
+// shell refuses is skipped rather than failing the command.
+func ulimitPrefix(l protocol.ResourceLimits) (string, protocol.ResourceLimits) {
+	var prefix string
+	var applied protocol.ResourceLimits
+	if l.MemoryBytes > 0 {
+		prefix += fmt.Sprintf("ulimit -v %d 2>/dev/null; ", max(l.MemoryBytes/1024, 1))
+		applied.MemoryBytes = l.MemoryBytes
+	}
+	if l.MaxProcesses > 0 {
+		// bash spells it -u, dash -p.
+		prefix += fmt.Sprintf("{ ulimit -u %[1]d || ulimit -p %[1]d; } 2>/dev/null; ", l.MaxProcesses)
+		applied.MaxProcesses = l.MaxProcesses
+	}
+	return prefix, applied
+}
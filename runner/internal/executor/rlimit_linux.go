@@ -0,0 +1,144 @@
+//go:build linux
+
+package executor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroups is the cgroup commands get their own child cgroups in: the one
+// the runner started in, once the runner has moved itself to a leaf
+// below it (cgroups v2 puts processes only in leaves of a cgroup whose
+// controllers are delegated to its children).
+var cgroups struct {
+	once sync.Once
+	dir  string
+	err  error
+}
+
+// limitCommand arranges for command to run under l, in a cgroup of its
+// own or else with ulimit. It returns the command to run, the limits
+// applied and how, the attributes to start its process with and a func to
+// call once it has exited.
+func limitCommand(command string, l protocol.ResourceLimits) (string, protocol.ResourceLimits, string, *syscall.SysProcAttr, func(), error) {
+	if l == (protocol.ResourceLimits{}) {
+		return command, l, "", nil, func() {}, nil
+	}
+	cgroups.once.Do(func() { cgroups.dir, cgroups.err = setupCgroups() })
+	if cgroups.err != nil {
+		prefix, applied := ulimitPrefix(l)
+		return prefix + command, applied, LimitedByUlimit, nil, func() {}, nil
+	}
+	var id [6]byte
+	_, _ = rand.Read(id[:])
+	dir := filepath.Join(cgroups.dir, "exec-"+hex.EncodeToString(id[:]))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", l, "", nil, nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	release := func() { _ = os.Remove(dir) }
+	write := func(file, value string) error {
+		return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+	}
+	var err error
+	if l.CPUs > 0 {
+		err = errors.Join(err, write("cpu.max", fmt.Sprintf("%d 100000", max(int64(l.CPUs*100000), 1000))))
+	}
+	if l.MemoryBytes > 0 {
+		err = errors.Join(err, write("memory.max", strconv.FormatInt(l.MemoryBytes, 10)))
+		// Without this the limit just moves the excess to swap.
+		_ = write("memory.swap.max", "0")
+	}
+	if l.MaxProcesses > 0 {
+		err = errors.Join(err, write("pids.max", strconv.Itoa(l.MaxProcesses)))
+	}
+	if err != nil {
+		release()
+		return "", l, "", nil, nil, fmt.Errorf("set cgroup limits: %w", err)
+	}
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		release()
+		return "", l, "", nil, nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	attr := &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: fd}
+	return command, l, LimitedByCgroup, attr, func() {
+		_ = syscall.Close(fd)
+		// Processes the command left running keep its cgroup, and its
+		// limits, until they exit; the cgroup is removed with the next
+		// command's.
+		release()
+		stale, _ := filepath.Glob(filepath.Join(cgroups.dir, "exec-*"))
+		for _, d := range stale {
+			_ = os.Remove(d)
+		}
+	}, nil
+}
+
+// setupCgroups moves the runner's processes to a leaf of its cgroup and
+// delegates the cpu, memory and pids controllers to the cgroup's
+// children, returning the cgroup. It fails unless the machine uses
+// cgroups v2 and the runner's cgroup is writable (as it is under a
+// systemd user session, or a service with Delegate=yes).
+func setupCgroups() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", errors.New("cgroups v2 is not mounted")
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	var rel string
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := strings.CutPrefix(line, "0::"); ok {
+			rel = p
+		}
+	}
+	if rel == "" {
+		return "", errors.New("no cgroups v2 membership")
+	}
+	dir := filepath.Join(cgroupRoot, rel)
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	var enable []string
+	for _, c := range strings.Fields(string(available)) {
+		if c == "cpu" || c == "memory" || c == "pids" {
+			enable = append(enable, "+"+c)
+		}
+	}
+	if len(enable) == 0 {
+		return "", errors.New("no cgroup controllers available")
+	}
+
+	leaf := filepath.Join(dir, "runner")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return "", err
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0o644); err != nil && pid == strconv.Itoa(os.Getpid()) {
+			return "", fmt.Errorf("move runner to %s: %w", leaf, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0o644); err != nil {
+		return "", fmt.Errorf("delegate controllers: %w", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+
+package executor
+
+import (
+	"syscall"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// limitCommand arranges for command to run under l's memory and process
+// limits with ulimit; CPU share cannot be limited here.
+func limitCommand(command string, l protocol.ResourceLimits) (string, protocol.ResourceLimits, string, *syscall.SysProcAttr, func(), error) {
+	if l == (protocol.ResourceLimits{}) {
+		return command, l, "", nil, func() {}, nil
+	}
+	prefix, applied := ulimitPrefix(l)
+	return prefix + command, applied, LimitedByUlimit, nil, func() {}, nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package executor
+
+import (
+	"syscall"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// limitCommand enforces no limits: Windows would need a job object.
+func limitCommand(command string, _ protocol.ResourceLimits) (string, protocol.ResourceLimits, string, *syscall.SysProcAttr, func(), error) {
+	return command, protocol.ResourceLimits{}, "", nil, func() {}, nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package executor
+
+import "os"
+
+// maxRSS returns 0: Windows reports no peak memory for a waited process.
+func maxRSS(*os.ProcessState) int64 { return 0 }
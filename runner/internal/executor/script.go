@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// scriptExtensions maps interpreters to the file extension their scripts
+// need (PowerShell refuses others) or conventionally have.
+var scriptExtensions = map[string]string{
+	"python": ".py", "python3": ".py", "python2": ".py",
+	"bash": ".sh", "sh": ".sh", "zsh": ".sh",
+	"node": ".js", "deno": ".ts", "ruby": ".rb", "perl": ".pl",
+	"Rscript": ".R", "julia": ".jl",
+	"pwsh": ".ps1", "powershell": ".ps1",
+}
+
+// RunScript writes p.Content to a temporary file, runs it as Exec runs a
+// command, and removes it. The script's content, not the command running
+// it, is what e's CommandCheck decides on.
+func (e *Executor) RunScript(p protocol.RunScriptPayload) protocol.ExecResultPayload {
+	if strings.TrimSpace(p.Interpreter) == "" {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: "interpreter is required"}
+	}
+	if e.Tripwire.CheckCommand(p.Content) {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: "script blocked: references a protected path"}
+	}
+	if err := e.checkCommand(Command{Kind: CommandScript, Line: p.Content, Env: p.Env}); err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: err.Error()}
+	}
+	path, err := e.writeScript(p)
+	if err != nil {
+		return protocol.ExecResultPayload{ExitCode: -1, Stderr: fmt.Sprintf("write script: %v", err)}
+	}
+	defer os.Remove(path)
+	defer e.useScratch(path)()
+	return e.WithCommandCheck(nil).Exec(protocol.ExecPayload{
+		Command:        scriptCommand(p.Interpreter, path, p.Args),
+		Cwd:            p.Cwd,
+		Timeout:        p.Timeout,
+		Env:            p.Env,
+		EnvClear:       p.EnvClear,
+		Limits:         p.Limits,
+		OutputEncoding: p.OutputEncoding,
+		Priority:       p.Priority,
+	})
+}
+
+func (e *Executor) writeScript(p protocol.RunScriptPayload) (string, error) {
+	if e.SnapshotDir != "" {
+		if err := os.MkdirAll(e.SnapshotDir, 0o700); err != nil {
+			return "", err
+		}
+	}
+	name := strings.TrimSuffix(filepath.Base(p.Interpreter), ".exe")
+	f, err := os.CreateTemp(e.SnapshotDir, "script-*"+scriptExtensions[name])
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(p.Content)
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// scriptCommand returns the shell command that runs script with
+// interpreter, passing args, each quoted for the platform shell.
+func scriptCommand(interpreter, script string, args []string) string {
+	words := append([]string{interpreter, script}, args...)
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+	if runtime.GOOS == "windows" {
+		return "& " + strings.Join(words, " ")
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s as a single word for sh or, on Windows, PowerShell:
+// both take single-quoted strings literally.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -2,105 +2,246 @@ package executor
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/scienceol/xyzen/runner/internal/ignore"
 	"github.com/scienceol/xyzen/runner/internal/protocol"
 )
 
+// Default limits of find_files and search_in_files requests that set
+// none.
 const (
-	maxFindResults   = 1000
-	maxSearchResults = 200
+	defaultFindResults    = 1000
+	defaultSearchResults  = 200
+	defaultSearchFileSize = 10 << 20
 )
 
-// FindFiles walks a directory tree and returns paths matching a glob pattern.
-func (e *Executor) FindFiles(root, pattern string) ([]string, error) {
+// SearchLimits are the ceilings on the limits find_files and
+// search_in_files requests may ask for; zero fields leave a limit
+// uncapped.
+type SearchLimits struct {
+	MaxFindResults   int
+	MaxSearchResults int
+	MaxFileSize      int64
+}
+
+// limit returns the limit a request asked for, or def if it asked for
+// none, capped at ceiling if that is non-zero.
+func limit(requested, def, ceiling int64) int64 {
+	n := def
+	if requested > 0 {
+		n = requested
+	}
+	if ceiling > 0 && n > ceiling {
+		n = ceiling
+	}
+	return n
+}
+
+// search_in_files pattern modes.
+const (
+	SearchRegex   = "regex"
+	SearchLiteral = "literal"
+)
+
+// FindFiles walks a directory tree and returns paths matching a glob
+// pattern (see ignore.Glob; "**" matches any number of directories),
+// sorted. Unless p.RespectGitignore is false, ignored files and
+// directories (see gitignore) are skipped, and so are those matching
+// p.Exclude (see excludes). At most p.MaxResults files are returned,
+// within e.Limits; directories are walked in parallel, so when more files
+// match, which of them are returned is not deterministic.
+func (e *Executor) FindFiles(p protocol.FindFilesPayload) (protocol.FindFilesResult, error) {
+	root, pattern := p.Root, p.Pattern
 	resolved, err := e.resolvePath(root)
 	if err != nil {
-		return nil, err
+		return protocol.FindFilesResult{}, err
+	}
+	glob, err := ignore.CompileGlob(pattern)
+	if err != nil {
+		return protocol.FindFilesResult{}, err
 	}
+	ign := e.gitignore(resolved, p.IgnoreEnabled())
+	exclude := excludes(resolved, p.Exclude)
+	maxResults := int(limit(int64(p.MaxResults), defaultFindResults, int64(e.Limits.MaxFindResults)))
 
-	var results []string
-	err = filepath.WalkDir(resolved, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil // Skip inaccessible paths
-		}
-		if len(results) >= maxFindResults {
-			return filepath.SkipAll
+	var (
+		mu        sync.Mutex
+		results   []string
+		truncated bool
+	)
+	err = e.walkParallel(resolved, func(path string, d os.DirEntry) error {
+		if exclude.Match(path, d.IsDir()) || ign.ignored(path, d, resolved) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if d.IsDir() {
 			return nil
 		}
 
-		matched, matchErr := filepath.Match(pattern, d.Name())
-		if matchErr != nil {
+		// Match and return the path relative to root
+		rel, relErr := filepath.Rel(resolved, path)
+		if relErr != nil {
 			return nil
 		}
-		if matched {
-			// Return path relative to root
-			rel, relErr := filepath.Rel(resolved, path)
-			if relErr != nil {
-				rel = path
+		if glob.Match(filepath.ToSlash(rel)) {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(results) >= maxResults {
+				truncated = true
+				return filepath.SkipAll
 			}
-			results = append(results, filepath.Join(root, rel))
+			results = append(results, protocol.JoinPath(root, filepath.ToSlash(rel)))
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("find files: %w", err)
+		return protocol.FindFilesResult{}, fmt.Errorf("find files: %w", err)
 	}
-	return results, nil
+	sort.Strings(results)
+	return protocol.FindFilesResult{Files: results, Truncated: truncated}, nil
 }
 
-// SearchInFiles searches file contents for a regex pattern.
-func (e *Executor) SearchInFiles(root, pattern, include string) ([]protocol.SearchMatchResult, error) {
+// SearchInFiles searches file contents as SearchInFilesFunc does.
+func (e *Executor) SearchInFiles(p protocol.SearchPayload) (protocol.SearchResult, error) {
+	var r protocol.SearchResult
+	truncated, err := e.SearchInFilesFunc(p, func(m protocol.SearchMatchResult) bool {
+		r.Matches = append(r.Matches, m)
+		return true
+	})
+	r.Truncated = truncated
+	return r, err
+}
+
+// SearchInFilesFunc searches file contents for a pattern, a regex or a
+// literal string per p.Mode, and calls fn for each match as it is found.
+// The walk stops after p.MaxResults matches, within e.Limits, reporting
+// whether more were found, or when fn returns false. Files larger than
+// p.MaxFileSize are skipped, and so are ignored and excluded files, as in
+// FindFiles. Files are scanned in parallel, so matches arrive grouped by
+// file but in no particular file order; fn is never called concurrently. Each match carries links to the
+// workspace files its content mentions. If e.Index is ready, only the
+// files it shortlists are scanned; otherwise, if e.Ripgrep is set, the
+// search is delegated to rg, falling back to the built-in walk if it
+// cannot be run.
+func (e *Executor) SearchInFilesFunc(p protocol.SearchPayload, fn func(protocol.SearchMatchResult) bool) (truncated bool, err error) {
+	root := p.Root
 	resolved, err := e.resolvePath(root)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	var include *ignore.Glob
+	if p.Include != "" {
+		if include, err = ignore.CompileGlob(p.Include); err != nil {
+			return false, err
+		}
 	}
 
-	re, err := regexp.Compile(pattern)
+	re, err := searchRegexp(p)
 	if err != nil {
-		return nil, fmt.Errorf("invalid regex: %w", err)
+		return false, err
+	}
+	p.MaxFileSize = limit(p.MaxFileSize, defaultSearchFileSize, e.Limits.MaxFileSize)
+	maxResults := int(limit(int64(p.MaxResults), defaultSearchResults, int64(e.Limits.MaxSearchResults)))
+	count := 0
+	linked := e.linkMatches(fn)
+	fn = func(m protocol.SearchMatchResult) bool {
+		if count >= maxResults {
+			truncated = true
+			return false
+		}
+		count++
+		return linked(m)
 	}
 
-	var results []protocol.SearchMatchResult
-	err = filepath.WalkDir(resolved, func(path string, d os.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return nil
+	if ok, err := e.searchIndexed(p, resolved, re, include, fn); ok {
+		return truncated, err
+	}
+	if e.Ripgrep != "" {
+		err := e.searchRipgrep(p, resolved, fn)
+		if !errors.Is(err, errRipgrepUnavailable) {
+			return truncated, err
 		}
-		if len(results) >= maxSearchResults {
-			return filepath.SkipAll
+	}
+
+	ign := e.gitignore(resolved, p.IgnoreEnabled())
+	exclude := excludes(resolved, p.Exclude)
+	var mu sync.Mutex // serializes fn
+	err = e.walkParallel(resolved, func(path string, d os.DirEntry) error {
+		if exclude.Match(path, d.IsDir()) || ign.ignored(path, d, resolved) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		if d.IsDir() {
 			return nil
 		}
 
-		// Apply include filter (glob on filename)
-		if include != "" {
-			matched, matchErr := filepath.Match(include, d.Name())
-			if matchErr != nil || !matched {
+		// Apply include filter (glob on the path relative to root)
+		if include != nil {
+			rel, relErr := filepath.Rel(resolved, path)
+			if relErr != nil || !include.Match(filepath.ToSlash(rel)) {
 				return nil
 			}
 		}
 
 		// Skip binary/large files
 		info, infoErr := d.Info()
-		if infoErr != nil || info.Size() > 10*1024*1024 { // Skip >10MB
+		if infoErr != nil || info.Size() > p.MaxFileSize {
 			return nil
 		}
 
 		matches := searchFile(path, re, root, resolved)
-		results = append(results, matches...)
+		if len(matches) == 0 {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range matches {
+			if !fn(m) {
+				return filepath.SkipAll
+			}
+		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("search in files: %w", err)
+		return truncated, fmt.Errorf("search in files: %w", err)
 	}
-	return results, nil
+	return truncated, nil
+}
+
+// searchRegexp compiles the pattern of p according to its mode and flags.
+func searchRegexp(p protocol.SearchPayload) (*regexp.Regexp, error) {
+	expr := p.Pattern
+	switch p.Mode {
+	case "", SearchRegex:
+	case SearchLiteral:
+		expr = regexp.QuoteMeta(expr)
+	default:
+		return nil, fmt.Errorf("invalid mode %q (want %s or %s)", p.Mode, SearchRegex, SearchLiteral)
+	}
+	if p.WordBoundary {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if p.CaseInsensitive {
+		expr = `(?i)` + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %w", err)
+	}
+	return re, nil
 }
 
 func searchFile(path string, re *regexp.Regexp, logicalRoot, resolvedRoot string) []protocol.SearchMatchResult {
@@ -119,9 +260,9 @@ func searchFile(path string, re *regexp.Regexp, logicalRoot, resolvedRoot string
 		if re.MatchString(line) {
 			// Build path relative to root
 			rel, relErr := filepath.Rel(resolvedRoot, path)
-			filePath := path
+			filePath := filepath.ToSlash(path)
 			if relErr == nil {
-				filePath = filepath.Join(logicalRoot, rel)
+				filePath = protocol.JoinPath(logicalRoot, filepath.ToSlash(rel))
 			}
 
 			results = append(results, protocol.SearchMatchResult{
@@ -134,6 +275,108 @@ func searchFile(path string, re *regexp.Regexp, logicalRoot, resolvedRoot string
 	return results
 }
 
+// linkMatches wraps fn to fill in the links in each match's content.
+// References are tried relative to the matched file's directory first.
+func (e *Executor) linkMatches(fn func(protocol.SearchMatchResult) bool) func(protocol.SearchMatchResult) bool {
+	return func(m protocol.SearchMatchResult) bool {
+		if dir, err := e.resolvePath(path.Dir(m.File)); err == nil {
+			m.Links = e.links(m.Content, dir)
+		}
+		return fn(m)
+	}
+}
+
+// walkIgnore applies gitignore rules during a walk; nil ignores nothing.
+type walkIgnore struct {
+	m *ignore.Matcher
+}
+
+// gitignore returns the ignore rules for a walk of resolved: the built-in
+// defaults, the repository's .git/info/exclude and the .gitignore files
+// from the repository root (or the work dir, outside a repository) down
+// to resolved. Deeper .gitignore files are picked up as the walk reaches
+// them. It returns nil when enabled is false.
+func (e *Executor) gitignore(resolved string, enabled bool) *walkIgnore {
+	if !enabled {
+		return nil
+	}
+	// Directories from resolved up to the work dir, then on up to the
+	// enclosing repository root if there is one.
+	var dirs []string
+	repo := ""
+	for dir := resolved; ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+			repo = dir
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if repo == "" {
+		for i, dir := range dirs {
+			if dir == e.workDir {
+				dirs = dirs[:i+1]
+				break
+			}
+		}
+	}
+
+	m := ignore.New(true)
+	if repo != "" {
+		_ = m.AddFile(repo, filepath.Join(repo, ".git", "info", "exclude"))
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = m.AddDir(dirs[i])
+	}
+	return &walkIgnore{m: m}
+}
+
+// ignored is called for each walked entry and reports whether to skip
+// it. It loads the .gitignore of each directory the walk enters.
+func (w *walkIgnore) ignored(path string, d os.DirEntry, root string) bool {
+	if w == nil || path == root {
+		return false
+	}
+	if w.m.Match(path, d.IsDir()) {
+		return true
+	}
+	if d.IsDir() {
+		_ = w.m.AddDir(path)
+	}
+	return false
+}
+
+// excludes returns a matcher for exclude patterns in gitignore syntax,
+// relative to the walk root resolved, or nil if there are none. The walk
+// prunes directories it matches; the nil Matcher matches nothing.
+func excludes(resolved string, patterns []string) *ignore.Matcher {
+	if len(patterns) == 0 {
+		return nil
+	}
+	m := ignore.New(false)
+	m.Add(resolved, patterns)
+	return m
+}
+
+// excludedPath reports whether the file at path, or any directory between
+// root and it, is excluded by m, as if a walk from root had pruned it.
+func excludedPath(m *ignore.Matcher, root, path string) bool {
+	if m == nil {
+		return false
+	}
+	if m.Match(path, false) {
+		return true
+	}
+	for dir := filepath.Dir(path); len(dir) > len(root) && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if m.Match(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// defaultShareTimeout bounds a share_file upload when the request gives
+// no timeout.
+const defaultShareTimeout = 10 * time.Minute
+
+// ShareFile uploads a workspace file to the presigned object-storage URL
+// supplied by the backend and returns the share link the backend minted
+// for it. The file is streamed, never held in memory.
+func (e *Executor) ShareFile(p protocol.ShareFilePayload) (protocol.ShareFileResult, error) {
+	resolved, err := e.resolvePath(p.Path)
+	if err != nil {
+		return protocol.ShareFileResult{}, err
+	}
+	u, err := url.Parse(p.UploadURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return protocol.ShareFileResult{}, fmt.Errorf("invalid upload_url")
+	}
+	method := p.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+	if method != http.MethodPut && method != http.MethodPost {
+		return protocol.ShareFileResult{}, fmt.Errorf("unsupported upload method %q", method)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return protocol.ShareFileResult{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return protocol.ShareFileResult{}, fmt.Errorf("stat file: %w", err)
+	}
+	if info.IsDir() {
+		return protocol.ShareFileResult{}, fmt.Errorf("%q is a directory", p.Path)
+	}
+
+	timeout := defaultShareTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	h := sha256.New()
+	req, err := http.NewRequestWithContext(ctx, method, p.UploadURL, io.TeeReader(f, h))
+	if err != nil {
+		return protocol.ShareFileResult{}, fmt.Errorf("build upload request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.ContentType != "" {
+		req.Header.Set("Content-Type", p.ContentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return protocol.ShareFileResult{}, fmt.Errorf("upload: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return protocol.ShareFileResult{}, fmt.Errorf("upload failed (HTTP %d): %s", resp.StatusCode, string(body))
+	}
+
+	return protocol.ShareFileResult{
+		URL:       p.ShareURL,
+		Size:      info.Size(),
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+		ExpiresAt: p.ExpiresAt,
+	}, nil
+}
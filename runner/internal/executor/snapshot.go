@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// maxSnapshotFiles bounds the cwd listing in an environment snapshot.
+	maxSnapshotFiles = 50
+	// toolVersionTimeout bounds each tool version probe.
+	toolVersionTimeout = 2 * time.Second
+)
+
+// snapshotEnv lists the environment variables worth reporting: they
+// select toolchains and interpreters but do not normally hold secrets.
+var snapshotEnv = []string{
+	"PATH", "SHELL", "LANG", "LC_ALL", "TERM",
+	"VIRTUAL_ENV", "CONDA_DEFAULT_ENV", "CONDA_PREFIX", "PYTHONPATH",
+	"GOPATH", "GOROOT", "GOFLAGS", "CGO_ENABLED",
+	"NODE_ENV", "NVM_BIN", "JAVA_HOME", "CARGO_HOME", "RUSTUP_TOOLCHAIN",
+	"CC", "CXX", "CUDA_HOME", "CUDA_VISIBLE_DEVICES", "LD_LIBRARY_PATH",
+}
+
+// snapshotTool is a version probe, run when one of its marker files is in
+// the working directory (or always, without markers).
+type snapshotTool struct {
+	name    string
+	argv    []string
+	markers []string
+}
+
+var snapshotTools = []snapshotTool{
+	{name: "git", argv: []string{"git", "--version"}},
+	{name: "go", argv: []string{"go", "version"}, markers: []string{"go.mod", "go.work"}},
+	{name: "node", argv: []string{"node", "--version"}, markers: []string{"package.json"}},
+	{name: "npm", argv: []string{"npm", "--version"}, markers: []string{"package-lock.json"}},
+	{name: "pnpm", argv: []string{"pnpm", "--version"}, markers: []string{"pnpm-lock.yaml"}},
+	{name: "yarn", argv: []string{"yarn", "--version"}, markers: []string{"yarn.lock"}},
+	{name: "python", argv: []string{"python3", "--version"}, markers: []string{"pyproject.toml", "requirements.txt", "setup.py", "environment.yml"}},
+	{name: "pip", argv: []string{"python3", "-m", "pip", "--version"}, markers: []string{"requirements.txt"}},
+	{name: "uv", argv: []string{"uv", "--version"}, markers: []string{"uv.lock"}},
+	{name: "conda", argv: []string{"conda", "--version"}, markers: []string{"environment.yml"}},
+	{name: "cargo", argv: []string{"cargo", "--version"}, markers: []string{"Cargo.toml"}},
+	{name: "java", argv: []string{"java", "-version"}, markers: []string{"pom.xml", "build.gradle", "build.gradle.kts"}},
+	{name: "make", argv: []string{"make", "--version"}, markers: []string{"Makefile"}},
+	{name: "cmake", argv: []string{"cmake", "--version"}, markers: []string{"CMakeLists.txt"}},
+	{name: "docker", argv: []string{"docker", "--version"}, markers: []string{"Dockerfile", "docker-compose.yml", "compose.yaml"}},
+}
+
+// snapshot describes the environment a command ran in: a listing of its
+// working directory, relevant variables of its environment env, and the
+// versions of tools the directory's files suggest it uses.
+func (e *Executor) snapshot(dir, cwd string, env []string) *protocol.EnvSnapshot {
+	s := &protocol.EnvSnapshot{Cwd: cwd, Env: make(map[string]string)}
+	if s.Cwd == "" {
+		s.Cwd = "."
+	}
+	entries, _ := os.ReadDir(dir)
+	present := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		present[entry.Name()] = true
+		if i >= maxSnapshotFiles {
+			s.FilesTruncated = true
+			continue
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		s.Files = append(s.Files, name)
+	}
+	for _, k := range snapshotEnv {
+		if v, ok := lookupEnv(env, k); ok {
+			s.Env[k] = v
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range snapshotTools {
+		if !t.wanted(present) {
+			continue
+		}
+		wg.Add(1)
+		go func(t snapshotTool) {
+			defer wg.Done()
+			if v := toolVersion(dir, t.argv); v != "" {
+				mu.Lock()
+				if s.Tools == nil {
+					s.Tools = make(map[string]string)
+				}
+				s.Tools[t.name] = v
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return s
+}
+
+func (t snapshotTool) wanted(present map[string]bool) bool {
+	if len(t.markers) == 0 {
+		return true
+	}
+	for _, m := range t.markers {
+		if present[m] {
+			return true
+		}
+	}
+	return false
+}
+
+// toolVersion runs a version probe and returns the first line it prints,
+// or "" if the tool is missing or fails.
+func toolVersion(dir string, argv []string) string {
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolVersionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, argv[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput() // java -version prints to stderr
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return truncate(strings.TrimSpace(line), 200)
+}
+
+// snapshotDir is the directory a snapshot lists: the command's working
+// directory, or workDir if it could not be resolved.
+func (e *Executor) snapshotDir(cwd string) string {
+	if cwd != "" {
+		if resolved, err := e.resolvePath(cwd); err == nil {
+			return resolved
+		}
+	}
+	return filepath.Clean(e.workDir)
+}
@@ -0,0 +1,213 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+	"github.com/scienceol/xyzen/runner/internal/textenc"
+)
+
+const (
+	// spillHeadBytes and spillTailBytes are how much of the start and the
+	// end of a truncated stream an exec result carries.
+	spillHeadBytes = maxOutputBytes / 2
+	spillTailBytes = maxOutputBytes / 2
+	// spillRecord is the file of a spill directory that records the
+	// encodings of its streams.
+	spillRecord = "output.json"
+)
+
+// spillMeta is the record kept with spilled output. An empty encoding is
+// UTF-8.
+type spillMeta struct {
+	Encodings map[string]string `json:"encodings"`
+}
+
+// outputSpill keeps the full output of one command that exceeds
+// maxOutputBytes, one file per stream in a directory under parent created
+// on first use. The directory's name is the output ID.
+type outputSpill struct {
+	parent string
+
+	mu  sync.Mutex
+	dir string
+}
+
+func (s *outputSpill) create(stream string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		if err := os.MkdirAll(s.parent, 0o700); err != nil {
+			return nil, err
+		}
+		dir, err := os.MkdirTemp(s.parent, time.Now().UTC().Format("20060102-150405-"))
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	return os.Create(filepath.Join(s.dir, stream))
+}
+
+// save records the encodings r's output was decoded from and returns the
+// output ID, or "" if nothing was spilled.
+func (s *outputSpill) save(r protocol.ExecResultPayload) string {
+	if s == nil || s.dir == "" {
+		return ""
+	}
+	meta := spillMeta{Encodings: map[string]string{"stdout": r.StdoutEncoding, "stderr": r.StderrEncoding}}
+	if data, err := json.Marshal(meta); err == nil {
+		_ = os.WriteFile(filepath.Join(s.dir, spillRecord), data, 0o600)
+	}
+	return filepath.Base(s.dir)
+}
+
+// startSpill begins keeping the stream past the limit: the tail starts as
+// the end of what was buffered, and with a spill the buffered output is
+// written to the stream's file. A file that cannot be written is dropped,
+// so read_exec_output reports the stream as not stored.
+func (lw *limitedWriter) startSpill() {
+	head := lw.w.Bytes()
+	lw.tail = append(lw.tail, head[max(len(head)-spillTailBytes, 0):]...)
+	if lw.spill == nil {
+		return
+	}
+	f, err := lw.spill.create(lw.stream)
+	if err != nil {
+		return
+	}
+	lw.file = f
+	lw.writeSpill(head)
+}
+
+// spillMore adds p, which went past the limit, to the tail and the file.
+func (lw *limitedWriter) spillMore(p []byte) {
+	lw.tail = append(lw.tail, p...)
+	if len(lw.tail) > 2*spillTailBytes {
+		lw.tail = append(lw.tail[:0], lw.tail[len(lw.tail)-spillTailBytes:]...)
+	}
+	lw.writeSpill(p)
+}
+
+func (lw *limitedWriter) writeSpill(p []byte) {
+	if lw.file == nil {
+		return
+	}
+	if _, err := lw.file.Write(p); err != nil {
+		lw.file.Close()
+		os.Remove(lw.file.Name())
+		lw.file = nil
+	}
+}
+
+// closeSpill closes the stream's file once the command has exited.
+func (lw *limitedWriter) closeSpill() {
+	if lw.file != nil {
+		lw.file.Close()
+		lw.file = nil
+	}
+}
+
+// tailBytes returns the last spillTailBytes of a truncated stream.
+func (lw *limitedWriter) tailBytes() []byte {
+	return lw.tail[max(len(lw.tail)-spillTailBytes, 0):]
+}
+
+// trimLeadingPartialRune drops the continuation bytes of a UTF-8
+// sequence cut short at the start of b.
+func trimLeadingPartialRune(b []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && i < len(b); i++ {
+		if utf8.RuneStart(b[i]) {
+			return b[i:]
+		}
+	}
+	return b
+}
+
+// ReadExecOutput reads up to p.MaxBytes (default and max maxOutputBytes)
+// of a stream an exec spilled to e.OutputDir, starting at p.Offset, and
+// converts it to UTF-8 from the encoding the exec result reported.
+func (e *Executor) ReadExecOutput(p protocol.ReadExecOutputPayload) (protocol.ReadExecOutputResult, error) {
+	stream := p.Stream
+	if stream == "" {
+		stream = "stdout"
+	}
+	if stream != "stdout" && stream != "stderr" {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("invalid stream %q (want stdout or stderr)", p.Stream)
+	}
+	if e.OutputDir == "" {
+		return protocol.ReadExecOutputResult{}, errors.New("exec output is not kept")
+	}
+	if p.OutputID == "" || filepath.Base(p.OutputID) != p.OutputID || p.OutputID == "." || p.OutputID == ".." {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("invalid output ID %q", p.OutputID)
+	}
+	dir := filepath.Join(e.OutputDir, p.OutputID)
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > maxOutputBytes {
+		maxBytes = maxOutputBytes
+	}
+
+	f, err := os.Open(filepath.Join(dir, stream))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, serr := os.Stat(dir); serr != nil {
+			return protocol.ReadExecOutputResult{}, fmt.Errorf("exec output %s not found (it may have expired)", p.OutputID)
+		}
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("exec output %s has no stored %s", p.OutputID, stream)
+	}
+	if err != nil {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("open exec output: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("stat exec output: %w", err)
+	}
+	size := info.Size()
+	if p.Offset < 0 || p.Offset > size {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("offset %d is outside the output (%d bytes)", p.Offset, size)
+	}
+	buf := make([]byte, min(int64(maxBytes), size-p.Offset))
+	n, err := f.ReadAt(buf, p.Offset)
+	if err != nil && err != io.EOF {
+		return protocol.ReadExecOutputResult{}, fmt.Errorf("read exec output: %w", err)
+	}
+	buf = buf[:n]
+
+	enc := textenc.Auto
+	if data, err := os.ReadFile(filepath.Join(dir, spillRecord)); err == nil {
+		var meta spillMeta
+		if json.Unmarshal(data, &meta) == nil {
+			if enc = meta.Encodings[stream]; enc == "" {
+				enc = textenc.UTF8
+			}
+		}
+	}
+	// Leave a character cut short by the read for the next one.
+	switch enc {
+	case textenc.UTF8:
+		if t := trimPartialRune(buf); len(t) > 0 && p.Offset+int64(n) < size {
+			buf = t
+		}
+	case textenc.UTF16LE, textenc.UTF16BE:
+		buf = buf[:len(buf)&^1]
+	}
+	data, _, _ := textenc.Decode(buf, enc)
+	next := p.Offset + int64(len(buf))
+	return protocol.ReadExecOutputResult{
+		OutputID:   p.OutputID,
+		Stream:     stream,
+		Data:       data,
+		Offset:     p.Offset,
+		NextOffset: next,
+		Size:       size,
+		EOF:        next == size,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// CreateSymlink creates a symlink at p.Path pointing to p.Target. The
+// link's directory must be inside the working directory, and so must
+// what the target resolves to unless p.AllowOutside is set. Missing
+// parent directories are created.
+func (e *Executor) CreateSymlink(p protocol.CreateSymlinkPayload) (protocol.SymlinkResult, error) {
+	if p.Target == "" {
+		return protocol.SymlinkResult{}, errors.New("create symlink: target is required")
+	}
+	link, err := e.resolveLink(p.Path)
+	if err != nil {
+		return protocol.SymlinkResult{}, err
+	}
+	target := filepath.FromSlash(p.Target)
+	result, err := e.symlinkResult(link, target)
+	if err != nil {
+		return protocol.SymlinkResult{}, err
+	}
+	if result.Outside && !p.AllowOutside {
+		return protocol.SymlinkResult{}, fmt.Errorf("target %q is outside the working directory (set allow_outside to link to it anyway)", p.Target)
+	}
+	if err := e.checkCaseConflict(link); err != nil {
+		return protocol.SymlinkResult{}, err
+	}
+	if info, err := os.Lstat(link); err == nil {
+		switch {
+		case info.IsDir():
+			return protocol.SymlinkResult{}, fmt.Errorf("%q is a directory", p.Path)
+		case !p.Overwrite:
+			return protocol.SymlinkResult{}, fmt.Errorf("%q already exists", p.Path)
+		}
+		if err := e.retry(func() error { return os.Remove(link) }); err != nil {
+			return protocol.SymlinkResult{}, fmt.Errorf("replace %q: %w", p.Path, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		return protocol.SymlinkResult{}, fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		return protocol.SymlinkResult{}, fmt.Errorf("create symlink: %w", err)
+	}
+	return result, nil
+}
+
+// ReadSymlink returns the target of the symlink at path and where it
+// resolves to. The link itself must be inside the working directory; its
+// target need not be.
+func (e *Executor) ReadSymlink(path string) (protocol.SymlinkResult, error) {
+	link, err := e.resolveLink(path)
+	if err != nil {
+		return protocol.SymlinkResult{}, err
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		return protocol.SymlinkResult{}, fmt.Errorf("read symlink: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return protocol.SymlinkResult{}, fmt.Errorf("%q is not a symlink", path)
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		return protocol.SymlinkResult{}, fmt.Errorf("read symlink: %w", err)
+	}
+	return e.symlinkResult(link, target)
+}
+
+// resolveLink is like resolvePath but does not follow a symlink at path
+// itself: only the directory holding it must be inside the working
+// directory.
+func (e *Executor) resolveLink(p string) (string, error) {
+	canonical, err := protocol.CleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	if canonical == "." {
+		return "", fmt.Errorf("%q is the working directory", p)
+	}
+	dir, err := e.resolvePath(path.Dir(canonical))
+	if err != nil {
+		return "", err
+	}
+	link := filepath.Join(dir, path.Base(canonical))
+	if e.Tripwire.CheckPath("file_access", link) {
+		return "", fmt.Errorf("access to %q denied", p)
+	}
+	return link, nil
+}
+
+// symlinkResult describes a link at link with the given target, which
+// need not exist yet.
+func (e *Executor) symlinkResult(link, target string) (protocol.SymlinkResult, error) {
+	result := protocol.SymlinkResult{Target: filepath.ToSlash(target)}
+	abs := target
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(filepath.Dir(link), target)
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		result.Dangling = true
+		real = filepath.Clean(abs)
+		if parent, err := filepath.EvalSymlinks(filepath.Dir(real)); err == nil {
+			real = filepath.Join(parent, filepath.Base(real))
+		}
+	}
+	if e.Tripwire.CheckPath("file_access", real) {
+		return protocol.SymlinkResult{}, fmt.Errorf("access to %q denied", result.Target)
+	}
+	workDir, err := filepath.EvalSymlinks(e.workDir)
+	if err != nil {
+		workDir = e.workDir
+	}
+	rel, err := filepath.Rel(workDir, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		result.Outside = true
+	} else {
+		result.Resolved = filepath.ToSlash(rel)
+	}
+	return result, nil
+}
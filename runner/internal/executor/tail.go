@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTailLines bounds tail_file's initial line count.
+	maxTailLines = 10000
+	// maxTailBytes bounds how far back from the end tail_file reads.
+	maxTailBytes = 4 << 20
+	// maxFollowChunk bounds how much appended data one poll forwards.
+	maxFollowChunk = 256 * 1024
+	// followInterval is how often a followed file is polled for growth.
+	followInterval = 500 * time.Millisecond
+)
+
+// TailFile returns up to n trailing lines of a file and the offset just
+// past the last complete line, where following should resume.
+func (e *Executor) TailFile(path string, n int) ([]string, int64, error) {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n <= 0 {
+		n = 10
+	}
+	if n > maxTailLines {
+		n = maxTailLines
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat file: %w", err)
+	}
+
+	start := max(info.Size()-maxTailBytes, 0)
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, 0, fmt.Errorf("read file: %w", err)
+	}
+	// Leave a trailing partial line for the follower to complete.
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	lines := strings.Split(string(buf[:end]), "\n")
+	lines = lines[:len(lines)-1]
+	if start > 0 && len(lines) > 0 {
+		lines = lines[1:] // first line is probably cut
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, start + int64(end), nil
+}
+
+// FollowFile polls a file from offset and calls fn with each batch of
+// complete appended lines until stop is closed. If the file shrinks or
+// is replaced (log rotation), following restarts from its beginning and
+// fn is told via reset; the replacement is resolved as path was, and
+// following ends with an error if it is refused.
+func (e *Executor) FollowFile(path string, offset int64, stop <-chan struct{}, fn func(lines []string, reset bool)) error {
+	resolved, err := e.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer func() { f.Close() }()
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	var partial []byte
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		reset := false
+		if cur, err := os.Stat(resolved); err == nil {
+			open, statErr := f.Stat()
+			if statErr == nil && !os.SameFile(cur, open) {
+				// The replacement may be a link out of the work dir or
+				// to a canary: resolve it afresh.
+				if resolved, err = e.resolvePath(path); err != nil {
+					return err
+				}
+				if nf, err := os.Open(resolved); err == nil {
+					f.Close()
+					f = nf
+					offset, partial, reset = 0, nil, true
+				}
+			} else if statErr == nil && open.Size() < offset {
+				offset, partial, reset = 0, nil, true
+			}
+		}
+
+		buf := make([]byte, maxFollowChunk)
+		n, err := f.ReadAt(buf, offset)
+		if n == 0 {
+			if reset {
+				fn(nil, true)
+			}
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("read file: %w", err)
+			}
+			continue
+		}
+		offset += int64(n)
+		data := append(partial, buf[:n]...)
+		end := bytes.LastIndexByte(data, '\n') + 1
+		partial = append([]byte(nil), data[end:]...)
+		if len(partial) > maxFollowChunk {
+			// A single enormous line: forward it rather than buffer forever.
+			end, partial = len(data), nil
+		}
+		if end == 0 && !reset {
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data[:end]), "\n"), "\n")
+		if end == 0 {
+			lines = nil
+		}
+		fn(lines, reset)
+	}
+}
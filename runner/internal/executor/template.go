@@ -0,0 +1,168 @@
+package executor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// maxTemplateEntries bounds the dirs, files and env files of a template.
+const maxTemplateEntries = 1000
+
+var (
+	envName     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envBareWord = regexp.MustCompile(`^[A-Za-z0-9_./:@+,-]*$`)
+)
+
+// ApplyTemplate materializes t under the directory root: directories,
+// then starter files, then env files (mode 0600), then the setup commands
+// in order from root, stopping at the first that fails. Every path and
+// secret is checked before anything is written. secret resolves the
+// secret names env files refer to.
+func (e *Executor) ApplyTemplate(root string, t protocol.WorkspaceTemplate, secret func(name string) (string, error)) (protocol.ApplyTemplateResult, error) {
+	var result protocol.ApplyTemplateResult
+	if n := len(t.Dirs) + len(t.Files) + len(t.EnvFiles); n > maxTemplateEntries {
+		return result, fmt.Errorf("template has %d entries (max %d)", n, maxTemplateEntries)
+	}
+	resolve := func(p string) (string, error) {
+		clean, err := protocol.CleanPath(p)
+		if err != nil {
+			return "", err
+		}
+		if clean == "." {
+			return "", fmt.Errorf("template path %q names the workspace root", p)
+		}
+		return e.resolvePath(protocol.JoinPath(root, clean))
+	}
+
+	dirs := make([]string, len(t.Dirs))
+	for i, d := range t.Dirs {
+		var err error
+		if dirs[i], err = resolve(d); err != nil {
+			return result, err
+		}
+	}
+	files := make([]string, len(t.Files))
+	contents := make([][]byte, len(t.Files))
+	for i, f := range t.Files {
+		var err error
+		if files[i], err = resolve(f.Path); err != nil {
+			return result, err
+		}
+		contents[i] = []byte(f.Content)
+		if f.Data != "" {
+			if f.Content != "" {
+				return result, fmt.Errorf("%s: content and data are mutually exclusive", f.Path)
+			}
+			if contents[i], err = base64.StdEncoding.DecodeString(f.Data); err != nil {
+				return result, fmt.Errorf("%s: base64 decode: %w", f.Path, err)
+			}
+		}
+	}
+	envFiles := make([]string, len(t.EnvFiles))
+	envContents := make([][]byte, len(t.EnvFiles))
+	for i, ef := range t.EnvFiles {
+		var err error
+		if envFiles[i], err = resolve(ef.Path); err != nil {
+			return result, err
+		}
+		if envContents[i], err = renderEnv(ef, secret); err != nil {
+			return result, fmt.Errorf("%s: %w", ef.Path, err)
+		}
+	}
+
+	rootDir, err := e.resolvePath(root)
+	if err != nil {
+		return result, err
+	}
+	if err := os.MkdirAll(rootDir, defaultDirMode); err != nil {
+		return result, fmt.Errorf("create workspace: %w", err)
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, defaultDirMode); err != nil {
+			return result, fmt.Errorf("create directory: %w", err)
+		}
+		result.Dirs++
+	}
+	write := func(path string, data []byte, mode os.FileMode) error {
+		if err := os.MkdirAll(filepath.Dir(path), defaultDirMode); err != nil {
+			return fmt.Errorf("create directory: %w", err)
+		}
+		if err := e.retry(func() error { return writeAtomic(path, data, mode) }); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		result.Files++
+		return nil
+	}
+	for i, f := range t.Files {
+		mode := os.FileMode(0o644)
+		if f.Executable {
+			mode = 0o755
+		}
+		if err := write(files[i], contents[i], mode); err != nil {
+			return result, err
+		}
+	}
+	for i := range t.EnvFiles {
+		if err := write(envFiles[i], envContents[i], 0o600); err != nil {
+			return result, err
+		}
+	}
+
+	result.Complete = true
+	for _, command := range t.Setup {
+		r := e.Exec(protocol.ExecPayload{Command: command, Cwd: root, Timeout: t.SetupTimeout})
+		result.Setup = append(result.Setup, protocol.SetupResult{Command: command, ExecResultPayload: r})
+		if r.ExitCode != 0 {
+			result.Complete = false
+			break
+		}
+	}
+	return result, nil
+}
+
+// renderEnv formats an env file as sorted KEY=value lines, quoting values
+// that are not plain words.
+func renderEnv(ef protocol.EnvFile, secret func(name string) (string, error)) ([]byte, error) {
+	vars := make(map[string]string, len(ef.Vars)+len(ef.Secrets))
+	for k, v := range ef.Vars {
+		vars[k] = v
+	}
+	for k, name := range ef.Secrets {
+		if _, dup := vars[k]; dup {
+			return nil, fmt.Errorf("%s is set both as a var and a secret", k)
+		}
+		if secret == nil {
+			return nil, fmt.Errorf("secrets are not available")
+		}
+		v, err := secret(name)
+		if err != nil {
+			return nil, err
+		}
+		vars[k] = v
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		if !envName.MatchString(k) {
+			return nil, fmt.Errorf("invalid variable name %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := vars[k]
+		if !envBareWord.MatchString(v) {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", k, v)
+	}
+	return []byte(b.String()), nil
+}
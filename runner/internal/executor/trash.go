@@ -0,0 +1,181 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// A trash entry is a directory under e.TrashDir holding the deleted file
+// or directory and a record of where it came from.
+const (
+	trashItem   = "item"
+	trashRecord = "deleted.json"
+)
+
+// trashMeta is the record kept with each trashed item.
+type trashMeta struct {
+	// Path is the wire path the item was deleted from.
+	Path      string    `json:"path"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// trash moves resolved, the file or directory at wire path path, to a new
+// entry in e.TrashDir and returns the entry's ID. Without a TrashDir, or
+// for something already in the trash, it removes resolved for good and
+// returns "".
+func (e *Executor) trash(resolved, path string) (string, error) {
+	if e.TrashDir == "" {
+		return "", e.retry(func() error { return os.RemoveAll(resolved) })
+	}
+	if err := os.MkdirAll(e.TrashDir, 0o700); err != nil {
+		return "", fmt.Errorf("trash: %w", err)
+	}
+	// Keep the trash out of the user's version control, as the session
+	// scratch directories beside it are.
+	ignore := filepath.Join(filepath.Dir(e.TrashDir), ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		_ = os.WriteFile(ignore, []byte("*\n"), 0o644)
+	}
+	trashDir, err := filepath.EvalSymlinks(e.TrashDir)
+	if err != nil {
+		return "", fmt.Errorf("trash: %w", err)
+	}
+	switch {
+	case within(trashDir, resolved):
+		return "", e.retry(func() error { return os.RemoveAll(resolved) })
+	case within(resolved, trashDir):
+		return "", fmt.Errorf("%q holds the trash", path)
+	}
+
+	entry, err := os.MkdirTemp(trashDir, time.Now().UTC().Format("20060102-150405-"))
+	if err != nil {
+		return "", fmt.Errorf("trash: %w", err)
+	}
+	canonical, _ := protocol.CleanPath(path)
+	meta, _ := json.Marshal(trashMeta{Path: canonical, DeletedAt: time.Now().UTC()})
+	if err := os.WriteFile(filepath.Join(entry, trashRecord), meta, 0o600); err != nil {
+		_ = os.RemoveAll(entry)
+		return "", fmt.Errorf("trash: %w", err)
+	}
+	if err := e.relocate(resolved, filepath.Join(entry, trashItem)); err != nil {
+		_ = os.RemoveAll(entry)
+		return "", fmt.Errorf("trash: %w", err)
+	}
+	return filepath.Base(entry), nil
+}
+
+// Undelete restores an entry from the trash (see protocol.UndeletePayload).
+func (e *Executor) Undelete(p protocol.UndeletePayload) (protocol.UndeleteResult, error) {
+	if e.TrashDir == "" {
+		return protocol.UndeleteResult{}, errors.New("the trash is disabled")
+	}
+	id := p.ID
+	if id == "" {
+		if p.Path == "" {
+			return protocol.UndeleteResult{}, errors.New("undelete: id or path is required")
+		}
+		canonical, err := protocol.CleanPath(p.Path)
+		if err != nil {
+			return protocol.UndeleteResult{}, err
+		}
+		if id = e.latestTrashed(canonical); id == "" {
+			return protocol.UndeleteResult{}, fmt.Errorf("nothing deleted from %q is in the trash", p.Path)
+		}
+	} else if id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return protocol.UndeleteResult{}, fmt.Errorf("invalid trash id %q", id)
+	}
+	entry := filepath.Join(e.TrashDir, id)
+	meta, err := readTrashMeta(entry)
+	if err != nil {
+		return protocol.UndeleteResult{}, fmt.Errorf("trash entry %q: %w", id, err)
+	}
+	dest := meta.Path
+	if p.Path != "" {
+		dest = p.Path
+	}
+	to, err := e.resolvePath(dest)
+	if err != nil {
+		return protocol.UndeleteResult{}, err
+	}
+	if _, err := os.Lstat(to); err == nil {
+		if !p.Overwrite {
+			return protocol.UndeleteResult{}, fmt.Errorf("%q already exists", dest)
+		}
+		if _, err := e.trash(to, dest); err != nil {
+			return protocol.UndeleteResult{}, fmt.Errorf("replace %q: %w", dest, err)
+		}
+	}
+	if err := e.checkCaseConflict(to); err != nil {
+		return protocol.UndeleteResult{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return protocol.UndeleteResult{}, fmt.Errorf("create directory: %w", err)
+	}
+	if err := e.relocate(filepath.Join(entry, trashItem), to); err != nil {
+		return protocol.UndeleteResult{}, fmt.Errorf("undelete: %w", err)
+	}
+	_ = os.RemoveAll(entry)
+	canonical, _ := protocol.CleanPath(dest)
+	return protocol.UndeleteResult{ID: id, Path: canonical}, nil
+}
+
+// latestTrashed returns the ID of the latest trash entry deleted from the
+// canonical wire path path, or "" if there is none.
+func (e *Executor) latestTrashed(path string) string {
+	dirents, err := os.ReadDir(e.TrashDir)
+	if err != nil {
+		return ""
+	}
+	var (
+		id     string
+		latest time.Time
+	)
+	for _, d := range dirents {
+		meta, err := readTrashMeta(filepath.Join(e.TrashDir, d.Name()))
+		if err != nil || meta.Path != path {
+			continue
+		}
+		if id == "" || meta.DeletedAt.After(latest) {
+			id, latest = d.Name(), meta.DeletedAt
+		}
+	}
+	return id
+}
+
+func readTrashMeta(entry string) (trashMeta, error) {
+	var meta trashMeta
+	data, err := os.ReadFile(filepath.Join(entry, trashRecord))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// relocate renames from to to, falling back to copy and delete across
+// filesystems.
+func (e *Executor) relocate(from, to string) error {
+	err := e.retry(func() error { return os.Rename(from, to) })
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) || errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := copyTree(from, to); err != nil {
+		_ = os.RemoveAll(to)
+		return err
+	}
+	return e.retry(func() error { return os.RemoveAll(from) })
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
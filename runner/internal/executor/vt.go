@@ -0,0 +1,644 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// vtPen is the rendition characters are drawn with. A color is -1 for the
+// default, 0-255 for the palette, or vtRGB|0xRRGGBB.
+type vtPen struct {
+	fg, bg int32
+	flags  uint16
+}
+
+const vtRGB = 1 << 24
+
+// vtPen flags, indexed by their SGR code.
+const (
+	vtBold      = 1 << 1
+	vtDim       = 1 << 2
+	vtItalic    = 1 << 3
+	vtUnderline = 1 << 4
+	vtBlink     = 1 << 5
+	vtReverse   = 1 << 7
+	vtHidden    = 1 << 8
+	vtStrike    = 1 << 9
+)
+
+var vtDefaultPen = vtPen{fg: -1, bg: -1}
+
+// vtCell is one character cell. The cell after a wide character holds 0.
+type vtCell struct {
+	r   rune
+	pen vtPen
+}
+
+// Parser states.
+const (
+	vtGround = iota
+	vtEscape
+	vtCSI
+	vtString    // OSC, DCS, SOS, PM or APC, up to BEL or ST
+	vtStringEsc // ESC seen in a string
+	vtSkipOne   // the byte after ESC ( and the like
+)
+
+// vtScreen is a lightweight terminal emulator that keeps the screen a
+// session's output draws, so it can be shown to a viewer who attaches
+// later. It understands cursor movement, erasing, scrolling regions, the
+// alternate screen and SGR renditions, which is what shells and
+// full-screen programs use; other sequences are skipped. Text does not
+// reflow on resize. Its methods do nothing on a nil screen.
+type vtScreen struct {
+	mu sync.Mutex
+
+	cols, rows  int
+	main, alt   [][]vtCell
+	grid        [][]vtCell // main or alt
+	onAlt       bool       // grid is alt
+	x, y        int
+	wrapNext    bool // the last column was written; wrap before the next
+	pen         vtPen
+	top, bottom int // scrolling region, inclusive
+	savedX      int
+	savedY      int
+	savedPen    vtPen
+	hidden      bool // cursor hidden
+	noWrap      bool // autowrap off
+
+	state   int
+	params  []byte
+	partial []byte // an incomplete UTF-8 sequence
+}
+
+func newVTScreen(cols, rows int) *vtScreen {
+	s := &vtScreen{}
+	s.reset(max(cols, 1), max(rows, 1))
+	return s
+}
+
+func (s *vtScreen) reset(cols, rows int) {
+	*s = vtScreen{cols: cols, rows: rows, pen: vtDefaultPen, bottom: rows - 1, savedPen: vtDefaultPen}
+	s.main = newVTGrid(cols, rows)
+	s.alt = newVTGrid(cols, rows)
+	s.grid = s.main
+}
+
+func newVTGrid(cols, rows int) [][]vtCell {
+	grid := make([][]vtCell, rows)
+	for i := range grid {
+		grid[i] = newVTLine(cols, vtDefaultPen)
+	}
+	return grid
+}
+
+func newVTLine(cols int, pen vtPen) []vtCell {
+	line := make([]vtCell, cols)
+	for i := range line {
+		line[i] = vtCell{' ', pen}
+	}
+	return line
+}
+
+// write feeds output to the screen.
+func (s *vtScreen) write(p []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := p
+	if len(s.partial) > 0 {
+		data = append(s.partial, p...)
+		s.partial = nil
+	}
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			s.partial = append([]byte(nil), data...)
+			return
+		}
+		r, n := utf8.DecodeRune(data)
+		data = data[n:]
+		s.feed(r)
+	}
+}
+
+// resize changes the screen's size, keeping what fits.
+func (s *vtScreen) resize(cols, rows int) {
+	if s == nil || cols <= 0 || rows <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resized := func(old [][]vtCell) [][]vtCell {
+		grid := newVTGrid(cols, rows)
+		for y := 0; y < rows && y < len(old); y++ {
+			copy(grid[y], old[y])
+		}
+		return grid
+	}
+	s.main, s.alt = resized(s.main), resized(s.alt)
+	s.grid = s.main
+	if s.onAlt {
+		s.grid = s.alt
+	}
+	s.cols, s.rows = cols, rows
+	s.top, s.bottom = 0, rows-1
+	s.x, s.y = min(s.x, cols-1), min(s.y, rows-1)
+	s.wrapNext = false
+}
+
+func (s *vtScreen) feed(r rune) {
+	switch s.state {
+	case vtEscape:
+		s.state = vtGround
+		s.escape(r)
+	case vtCSI:
+		switch {
+		case r >= 0x40 && r <= 0x7e:
+			s.state = vtGround
+			s.csi(r)
+		case r == 0x1b:
+			s.state = vtEscape
+		case r >= 0x20 && len(s.params) < 64:
+			s.params = append(s.params, byte(r))
+		}
+	case vtString:
+		switch r {
+		case 0x07:
+			s.state = vtGround
+		case 0x1b:
+			s.state = vtStringEsc
+		}
+	case vtStringEsc:
+		if r == '\\' {
+			s.state = vtGround
+		} else {
+			s.state = vtEscape
+			s.feed(r)
+		}
+	case vtSkipOne:
+		s.state = vtGround
+	default:
+		s.control(r)
+	}
+}
+
+func (s *vtScreen) control(r rune) {
+	switch r {
+	case 0x1b:
+		s.state = vtEscape
+	case '\r':
+		s.x, s.wrapNext = 0, false
+	case '\n', 0x0b, 0x0c:
+		s.lineFeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+	default:
+		if r >= 0x20 && r != 0x7f && !(r >= 0x80 && r < 0xa0) {
+			s.put(r)
+		}
+	}
+}
+
+func (s *vtScreen) escape(r rune) {
+	switch r {
+	case '[':
+		s.state, s.params = vtCSI, s.params[:0]
+	case ']', 'P', 'X', '^', '_':
+		s.state = vtString
+	case '(', ')', '*', '+', '-', '.', '/', '#', '%':
+		s.state = vtSkipOne
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset(s.cols, s.rows)
+	}
+}
+
+func (s *vtScreen) put(r rune) {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return // zero-width: combining marks are dropped
+	}
+	width := 1
+	if vtWide(r) {
+		width = 2
+	}
+	if s.wrapNext || s.x+width > s.cols {
+		if s.noWrap {
+			s.x = s.cols - width
+		} else {
+			s.x = 0
+			s.lineFeed()
+		}
+	}
+	s.wrapNext = false
+	line := s.grid[s.y]
+	line[s.x] = vtCell{r, s.pen}
+	if width == 2 && s.x+1 < s.cols {
+		line[s.x+1] = vtCell{0, s.pen}
+	}
+	if s.x+width >= s.cols {
+		s.x = s.cols - 1
+		s.wrapNext = true
+	} else {
+		s.x += width
+	}
+}
+
+// vtWide reports whether r takes two cells: East Asian wide and
+// fullwidth characters and emoji.
+func vtWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f ||
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f ||
+		r >= 0xac00 && r <= 0xd7a3 ||
+		r >= 0xf900 && r <= 0xfaff ||
+		r >= 0xfe30 && r <= 0xfe4f ||
+		r >= 0xff00 && r <= 0xff60 ||
+		r >= 0xffe0 && r <= 0xffe6 ||
+		r >= 0x1f300 && r <= 0x1f64f ||
+		r >= 0x1f900 && r <= 0x1f9ff ||
+		r >= 0x20000 && r <= 0x3fffd)
+}
+
+func (s *vtScreen) lineFeed() {
+	s.wrapNext = false
+	if s.y == s.bottom {
+		s.scrollUp(s.top, s.bottom, 1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+func (s *vtScreen) reverseIndex() {
+	s.wrapNext = false
+	if s.y == s.top {
+		s.scrollDown(s.top, s.bottom, 1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// blank returns an erased cell, which keeps the current background.
+func (s *vtScreen) blank() vtCell {
+	return vtCell{' ', vtPen{fg: -1, bg: s.pen.bg}}
+}
+
+func (s *vtScreen) scrollUp(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.grid[top:bottom+1], s.grid[top+n:bottom+1])
+	for y := bottom - n + 1; y <= bottom; y++ {
+		s.grid[y] = newVTLine(s.cols, s.blank().pen)
+	}
+}
+
+func (s *vtScreen) scrollDown(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.grid[top+n:bottom+1], s.grid[top:bottom+1-n])
+	for y := top; y < top+n; y++ {
+		s.grid[y] = newVTLine(s.cols, s.blank().pen)
+	}
+}
+
+func (s *vtScreen) erase(y, from, to int) {
+	line := s.grid[y]
+	for x := max(from, 0); x < min(to, s.cols); x++ {
+		line[x] = s.blank()
+	}
+}
+
+func (s *vtScreen) saveCursor() {
+	s.savedX, s.savedY, s.savedPen = s.x, s.y, s.pen
+}
+
+func (s *vtScreen) restoreCursor() {
+	s.x, s.y, s.pen = min(s.savedX, s.cols-1), min(s.savedY, s.rows-1), s.savedPen
+	s.wrapNext = false
+}
+
+func (s *vtScreen) csi(final rune) {
+	params := string(s.params)
+	private := ""
+	if params != "" && strings.ContainsRune("?<=>", rune(params[0])) {
+		private, params = params[:1], params[1:]
+	}
+	if final == 'm' && private == "" {
+		s.sgr(params)
+		return
+	}
+	var args []int
+	for _, f := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(strings.SplitN(f, ":", 2)[0])
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+	if private == "?" {
+		if final == 'h' || final == 'l' {
+			for _, mode := range args {
+				s.setMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+	if private != "" {
+		return
+	}
+	clampX := func(x int) int { return max(0, min(x, s.cols-1)) }
+	clampY := func(y int) int { return max(0, min(y, s.rows-1)) }
+	s.wrapNext = false
+	switch final {
+	case 'A':
+		s.y = clampY(s.y - arg(0, 1))
+	case 'B', 'e':
+		s.y = clampY(s.y + arg(0, 1))
+	case 'C', 'a':
+		s.x = clampX(s.x + arg(0, 1))
+	case 'D':
+		s.x = clampX(s.x - arg(0, 1))
+	case 'E':
+		s.x, s.y = 0, clampY(s.y+arg(0, 1))
+	case 'F':
+		s.x, s.y = 0, clampY(s.y-arg(0, 1))
+	case 'G', '`':
+		s.x = clampX(arg(0, 1) - 1)
+	case 'd':
+		s.y = clampY(arg(0, 1) - 1)
+	case 'H', 'f':
+		s.y, s.x = clampY(arg(0, 1)-1), clampX(arg(1, 1)-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+			for y := s.y + 1; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		case 1:
+			for y := 0; y < s.y; y++ {
+				s.erase(y, 0, s.cols)
+			}
+			s.erase(s.y, 0, s.x+1)
+		default:
+			for y := 0; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+		default:
+			s.erase(s.y, 0, s.cols)
+		}
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollDown(s.y, s.bottom, arg(0, 1))
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollUp(s.y, s.bottom, arg(0, 1))
+		}
+	case 'P':
+		line := s.grid[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x:], line[s.x+n:])
+		s.erase(s.y, s.cols-n, s.cols)
+	case '@':
+		line := s.grid[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		copy(line[s.x+n:], line[s.x:])
+		s.erase(s.y, s.x, s.x+n)
+	case 'X':
+		s.erase(s.y, s.x, s.x+arg(0, 1))
+	case 'S':
+		s.scrollUp(s.top, s.bottom, arg(0, 1))
+	case 'T':
+		if len(args) <= 1 {
+			s.scrollDown(s.top, s.bottom, arg(0, 1))
+		}
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	}
+}
+
+func (s *vtScreen) setMode(mode int, set bool) {
+	switch mode {
+	case 7:
+		s.noWrap = !set
+	case 25:
+		s.hidden = !set
+	case 47, 1047, 1049:
+		if set == s.onAlt {
+			return
+		}
+		if set {
+			if mode == 1049 {
+				s.saveCursor()
+			}
+			s.alt = newVTGrid(s.cols, s.rows)
+			s.grid = s.alt
+		} else {
+			s.grid = s.main
+			if mode == 1049 {
+				s.restoreCursor()
+			}
+		}
+		s.onAlt = set
+		s.wrapNext = false
+	}
+}
+
+func (s *vtScreen) sgr(params string) {
+	if params == "" {
+		s.pen = vtDefaultPen
+		return
+	}
+	groups := strings.Split(params, ";")
+	num := func(f string) int {
+		n, _ := strconv.Atoi(f)
+		return n
+	}
+	for i := 0; i < len(groups); i++ {
+		sub := strings.Split(groups[i], ":")
+		code := num(sub[0])
+		switch {
+		case code == 0:
+			s.pen = vtDefaultPen
+		case code >= 1 && code <= 9:
+			s.pen.flags |= 1 << code
+		case code == 21 || code == 22:
+			s.pen.flags &^= vtBold | vtDim
+		case code >= 23 && code <= 29:
+			s.pen.flags &^= 1 << (code - 20)
+		case code >= 30 && code <= 37:
+			s.pen.fg = int32(code - 30)
+		case code == 39:
+			s.pen.fg = -1
+		case code >= 40 && code <= 47:
+			s.pen.bg = int32(code - 40)
+		case code == 49:
+			s.pen.bg = -1
+		case code >= 90 && code <= 97:
+			s.pen.fg = int32(code - 90 + 8)
+		case code >= 100 && code <= 107:
+			s.pen.bg = int32(code - 100 + 8)
+		case code == 38 || code == 48 || code == 58:
+			// Extended color: 38;5;n or 38;2;r;g;b, or with colons.
+			var spec []string
+			if len(sub) > 1 {
+				spec = sub[1:]
+				if len(spec) == 5 && spec[0] == "2" {
+					spec = append(spec[:1], spec[2:]...) // 38:2:colorspace:r:g:b
+				}
+			} else if i+1 < len(groups) {
+				n := 2
+				if groups[i+1] == "2" {
+					n = 4
+				}
+				spec = groups[i+1 : min(i+1+n, len(groups))]
+				i += len(spec)
+			}
+			color := int32(-1)
+			switch {
+			case len(spec) >= 2 && spec[0] == "5":
+				color = int32(num(spec[1]) & 0xff)
+			case len(spec) >= 4 && spec[0] == "2":
+				color = vtRGB | int32(num(spec[1])&0xff)<<16 | int32(num(spec[2])&0xff)<<8 | int32(num(spec[3])&0xff)
+			}
+			if code == 38 {
+				s.pen.fg = color
+			} else if code == 48 {
+				s.pen.bg = color
+			}
+		}
+	}
+}
+
+// sgrString returns the SGR sequence that sets pen from the default.
+func (p vtPen) sgrString() string {
+	var b strings.Builder
+	b.WriteString("\x1b[0")
+	for code := 1; code <= 9; code++ {
+		if p.flags&(1<<code) != 0 {
+			b.WriteString(";" + strconv.Itoa(code))
+		}
+	}
+	color := func(c int32, base, bright, ext int) {
+		switch {
+		case c < 0:
+		case c&vtRGB != 0:
+			b.WriteString(";" + strconv.Itoa(ext) + ";2;" + strconv.Itoa(int(c>>16&0xff)) + ";" + strconv.Itoa(int(c>>8&0xff)) + ";" + strconv.Itoa(int(c&0xff)))
+		case c < 8:
+			b.WriteString(";" + strconv.Itoa(base+int(c)))
+		case c < 16:
+			b.WriteString(";" + strconv.Itoa(bright+int(c)-8))
+		default:
+			b.WriteString(";" + strconv.Itoa(ext) + ";5;" + strconv.Itoa(int(c)))
+		}
+	}
+	color(p.fg, 30, 90, 38)
+	color(p.bg, 40, 100, 48)
+	b.WriteString("m")
+	return b.String()
+}
+
+// vtSnapshot is the state of a screen.
+type vtSnapshot struct {
+	cols, rows int
+	x, y       int
+	hidden     bool
+	alt        bool
+	lines      []string // plain text, trailing spaces trimmed
+	ansi       string   // draws the screen on a reset terminal
+}
+
+// snapshot returns the screen's contents.
+func (s *vtScreen) snapshot() vtSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := vtSnapshot{
+		cols:   s.cols,
+		rows:   s.rows,
+		x:      s.x,
+		y:      s.y,
+		hidden: s.hidden,
+		alt:    s.onAlt,
+		lines:  make([]string, s.rows),
+	}
+	var ansi strings.Builder
+	if snap.alt {
+		ansi.WriteString("\x1b[?1049h")
+	}
+	ansi.WriteString("\x1b[0m\x1b[H\x1b[2J")
+	for y, line := range s.grid {
+		// Cells past the last one drawn on are left to the clear.
+		end := len(line)
+		for end > 0 && line[end-1].r == ' ' && line[end-1].pen == vtDefaultPen {
+			end--
+		}
+		var text strings.Builder
+		for _, c := range line[:end] {
+			if c.r != 0 {
+				text.WriteRune(c.r)
+			}
+		}
+		snap.lines[y] = strings.TrimRight(text.String(), " ")
+		if end == 0 {
+			continue
+		}
+		ansi.WriteString("\x1b[" + strconv.Itoa(y+1) + ";1H")
+		pen := vtDefaultPen
+		for _, c := range line[:end] {
+			if c.r == 0 {
+				continue
+			}
+			if c.pen != pen {
+				ansi.WriteString(c.pen.sgrString())
+				pen = c.pen
+			}
+			ansi.WriteRune(c.r)
+		}
+		if pen != vtDefaultPen {
+			ansi.WriteString("\x1b[0m")
+		}
+	}
+	ansi.WriteString(s.pen.sgrString())
+	ansi.WriteString("\x1b[" + strconv.Itoa(s.y+1) + ";" + strconv.Itoa(s.x+1) + "H")
+	if s.hidden {
+		ansi.WriteString("\x1b[?25l")
+	}
+	snap.ansi = ansi.String()
+	return snap
+}
@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// walkQueue holds the directories a parallel walk has yet to read.
+// pending counts directories queued or being read; the walk is over
+// when it drops to zero.
+type walkQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []string
+	pending int
+	stopped bool
+	err     error
+}
+
+func (q *walkQueue) push(dir string) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop waits for a directory; it returns false when the walk is over.
+func (q *walkQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.pending > 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped || len(q.dirs) == 0 {
+		return "", false
+	}
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return dir, true
+}
+
+func (q *walkQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+// stop ends the walk, recording err if it is the first failure.
+func (q *walkQueue) stop(err error) {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped, q.err = true, err
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// walkParallel is like filepath.WalkDir but reads directories, and calls
+// fn, from up to workers goroutines at once (GOMAXPROCS if workers <= 0),
+// so fn must be safe for concurrent use. fn is called for every entry
+// below root, in no particular order; returning filepath.SkipDir for a
+// directory skips it, filepath.SkipAll ends the walk, and any other error
+// ends the walk and is returned. Unreadable directories are skipped and
+// symlinks are not followed.
+func (e *Executor) walkParallel(root string, fn func(path string, d fs.DirEntry) error) error {
+	workers := e.WalkWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	q := &walkQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	q.push(root)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				dir, ok := q.pop()
+				if !ok {
+					return
+				}
+				q.readDir(dir, fn)
+				q.done()
+			}
+		}()
+	}
+	wg.Wait()
+	if q.err == filepath.SkipAll {
+		return nil
+	}
+	return q.err
+}
+
+func (q *walkQueue) readDir(dir string, fn func(path string, d fs.DirEntry) error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, d := range entries {
+		q.mu.Lock()
+		stopped := q.stopped
+		q.mu.Unlock()
+		if stopped {
+			return
+		}
+		path := filepath.Join(dir, d.Name())
+		switch err := fn(path, d); {
+		case err == filepath.SkipDir:
+		case err != nil:
+			q.stop(err)
+			return
+		case d.IsDir():
+			q.push(path)
+		}
+	}
+}
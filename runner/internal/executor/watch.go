@@ -0,0 +1,243 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	// defaultWatchInterval is how often watched paths are rescanned.
+	defaultWatchInterval = time.Second
+	// minWatchInterval bounds how often a watch may rescan.
+	minWatchInterval = 250 * time.Millisecond
+	// maxWatchEntries bounds how many files one watch tracks.
+	maxWatchEntries = 50000
+	// maxWatchPaths bounds how many roots one watch request names.
+	maxWatchPaths = 64
+)
+
+// watchEntry is what a watch remembers about one path between scans.
+type watchEntry struct {
+	info fs.FileInfo
+}
+
+func (w watchEntry) changed(info fs.FileInfo) bool {
+	return w.info.Size() != info.Size() || !w.info.ModTime().Equal(info.ModTime()) || w.info.Mode() != info.Mode()
+}
+
+// watchRoot is one watched path: its wire form and where it lives on disk.
+type watchRoot struct {
+	wire, resolved string
+}
+
+// Watch polls the paths in p and calls fn with the changes seen in each
+// scan until stop is closed. Changes are detected by comparing size,
+// modification time and mode, so several writes between two scans are
+// reported once; a delete and a create of the same file within one scan
+// are reported as a rename. Polling is used rather than OS notifications
+// so that behaviour is the same on every platform and across network
+// filesystems.
+func (e *Executor) Watch(p protocol.WatchPayload, stop <-chan struct{}, fn func([]protocol.FSEvent)) error {
+	if len(p.Paths) == 0 {
+		return fmt.Errorf("no paths to watch")
+	}
+	if len(p.Paths) > maxWatchPaths {
+		return fmt.Errorf("too many paths (max %d)", maxWatchPaths)
+	}
+	for _, pat := range append(append([]string(nil), p.Include...), p.Exclude...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pat, err)
+		}
+	}
+	interval := defaultWatchInterval
+	if p.IntervalMs > 0 {
+		interval = max(time.Duration(p.IntervalMs)*time.Millisecond, minWatchInterval)
+	}
+	roots := make([]watchRoot, 0, len(p.Paths))
+	for _, wire := range p.Paths {
+		clean, err := protocol.CleanPath(wire)
+		if err != nil {
+			return err
+		}
+		resolved, err := e.resolvePath(clean)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, watchRoot{wire: clean, resolved: resolved})
+	}
+
+	w := &watcher{roots: roots, include: p.Include, exclude: p.Exclude, recursive: p.IsRecursive()}
+	prev, err := w.scan()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		cur, err := w.scan()
+		if err != nil {
+			return err
+		}
+		if events := diffScans(prev, cur); len(events) > 0 {
+			fn(events)
+		}
+		prev = cur
+	}
+}
+
+type watcher struct {
+	roots            []watchRoot
+	include, exclude []string
+	recursive        bool
+}
+
+// scan snapshots every watched root, keyed by wire path. A missing root
+// is not an error: it is simply empty until it appears.
+func (w *watcher) scan() (map[string]watchEntry, error) {
+	snap := make(map[string]watchEntry)
+	for _, r := range w.roots {
+		info, err := os.Lstat(r.resolved)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("watch %s: %w", r.wire, err)
+		}
+		if !info.IsDir() {
+			snap[r.wire] = watchEntry{info: info}
+			continue
+		}
+		err = filepath.WalkDir(r.resolved, func(full string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) || os.IsPermission(err) {
+					return nil // removed mid-walk or unreadable
+				}
+				return err
+			}
+			if full == r.resolved {
+				return nil
+			}
+			rel, err := filepath.Rel(r.resolved, full)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if excluded(rel, w.exclude) || isWriteTemp(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if len(w.include) == 0 || excluded(rel, w.include) {
+				info, err := d.Info()
+				if err != nil {
+					return nil // removed mid-walk
+				}
+				if len(snap) >= maxWatchEntries {
+					return fmt.Errorf("too many files to watch (max %d); narrow the paths or add excludes", maxWatchEntries)
+				}
+				snap[protocol.JoinPath(r.wire, rel)] = watchEntry{info: info}
+			}
+			if d.IsDir() && !w.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("watch %s: %w", r.wire, err)
+		}
+	}
+	return snap, nil
+}
+
+// isWriteTemp reports whether name is an in-flight atomic write, which
+// would otherwise show up as a create and delete around every write.
+func isWriteTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".xyzen-tmp-")
+}
+
+// diffScans reports the changes from prev to cur in path order. A
+// deleted path and a created one are paired into a rename when they are
+// the same file, or failing that, have the same type, size and mtime.
+// Entries under a renamed directory are folded into its rename.
+func diffScans(prev, cur map[string]watchEntry) []protocol.FSEvent {
+	var created, deleted []string
+	var events []protocol.FSEvent
+	for p, c := range cur {
+		old, ok := prev[p]
+		switch {
+		case !ok:
+			created = append(created, p)
+		case c.changed(old.info) && !(c.info.IsDir() && old.info.IsDir()):
+			events = append(events, protocol.FSEvent{Op: protocol.FSModify, Path: p, IsDir: c.info.IsDir()})
+		}
+	}
+	for p := range prev {
+		if _, ok := cur[p]; !ok {
+			deleted = append(deleted, p)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(deleted)
+
+	paired := make(map[string]bool)
+	var movedDirs [][2]string // old, new
+	for _, d := range deleted {
+		if moved, ok := movedChild(d, movedDirs); ok {
+			paired[d] = true
+			if _, ok := cur[moved]; ok {
+				paired[moved] = true
+			}
+			continue
+		}
+		old := prev[d].info
+		for _, c := range created {
+			if paired[c] {
+				continue
+			}
+			info := cur[c].info
+			if os.SameFile(old, info) || (old.Mode() == info.Mode() && old.Size() == info.Size() && old.ModTime().Equal(info.ModTime())) {
+				paired[c], paired[d] = true, true
+				if info.IsDir() {
+					movedDirs = append(movedDirs, [2]string{d, c})
+				}
+				events = append(events, protocol.FSEvent{Op: protocol.FSRename, Path: c, OldPath: d, IsDir: info.IsDir()})
+				break
+			}
+		}
+	}
+	for _, c := range created {
+		if !paired[c] {
+			events = append(events, protocol.FSEvent{Op: protocol.FSCreate, Path: c, IsDir: cur[c].info.IsDir()})
+		}
+	}
+	for _, d := range deleted {
+		if !paired[d] {
+			events = append(events, protocol.FSEvent{Op: protocol.FSDelete, Path: d, IsDir: prev[d].info.IsDir()})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// movedChild returns where p ended up if it lies under a renamed directory.
+func movedChild(p string, movedDirs [][2]string) (string, bool) {
+	for _, m := range movedDirs {
+		if rest, ok := strings.CutPrefix(p, m[0]+"/"); ok {
+			return m[1] + "/" + rest, true
+		}
+	}
+	return "", false
+}
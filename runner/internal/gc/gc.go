@@ -0,0 +1,196 @@
+// Package gc enforces retention policies on the storage the runner manages
+// under its state directory, so trash, snapshots, recordings, artifacts,
+// job logs, spilled exec output, caches, temp workspaces and crash dumps
+// don't slowly fill the disk.
+package gc
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Area names. Each area is a directory under the state dir whose
+// top-level entries are collected as units.
+const (
+	Trash      = "trash"
+	Snapshots  = "snapshots"
+	Recordings = "recordings"
+	Artifacts  = "artifacts"
+	Jobs       = "jobs"
+	Cache      = "cache"
+	Workspaces = "workspaces"
+	Transfers  = "transfers"
+	Crashes    = "crashes"
+	ExecOutput = "exec-output"
+)
+
+// AreaNames lists every managed area.
+var AreaNames = []string{Trash, Snapshots, Recordings, Artifacts, Jobs, Cache, Workspaces, Transfers, Crashes, ExecOutput}
+
+// Policy bounds an area. Zero values mean "no limit".
+type Policy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// Area is a managed directory and its retention policy.
+type Area struct {
+	Name   string
+	Dir    string
+	Policy Policy
+}
+
+// AreaReport summarizes one collection pass over an area.
+type AreaReport struct {
+	Area      string
+	Removed   int
+	Freed     int64
+	Remaining int
+	Bytes     int64
+	Errors    []string
+}
+
+// Collector applies retention policies to a set of areas.
+type Collector struct {
+	Areas []Area
+	// Keep, if set, protects entries that are still in use (e.g. the log
+	// of a running job) from collection.
+	Keep func(area, path string) bool
+}
+
+// Dir returns the directory for an area under stateDir.
+func Dir(stateDir, area string) string {
+	return filepath.Join(stateDir, area)
+}
+
+// TrashDir returns the trash area of a work dir. Unlike the other areas
+// it lives in the work dir, so that deleting a file there is a rename.
+func TrashDir(workDir string) string {
+	return filepath.Join(workDir, ".xyzen", Trash)
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Run performs one collection pass. With dryRun set, nothing is removed
+// but the report shows what would be.
+func (c *Collector) Run(dryRun bool) []AreaReport {
+	reports := make([]AreaReport, 0, len(c.Areas))
+	now := time.Now()
+	for _, a := range c.Areas {
+		reports = append(reports, c.collect(a, now, dryRun))
+	}
+	return reports
+}
+
+func (c *Collector) collect(a Area, now time.Time, dryRun bool) AreaReport {
+	r := AreaReport{Area: a.Name}
+	dirents, err := os.ReadDir(a.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.Errors = append(r.Errors, err.Error())
+		}
+		return r
+	}
+
+	var entries []entry
+	for _, d := range dirents {
+		p := filepath.Join(a.Dir, d.Name())
+		if c.Keep != nil && c.Keep(a.Name, p) {
+			continue
+		}
+		size, mod := usage(p)
+		entries = append(entries, entry{path: p, size: size, modTime: mod})
+		r.Bytes += size
+	}
+	// Oldest first, so size pressure evicts the least recently used.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	for _, e := range entries {
+		expired := a.Policy.MaxAge > 0 && now.Sub(e.modTime) > a.Policy.MaxAge
+		oversize := a.Policy.MaxSize > 0 && r.Bytes > a.Policy.MaxSize
+		if !expired && !oversize {
+			r.Remaining++
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(e.path); err != nil {
+				r.Errors = append(r.Errors, err.Error())
+				r.Remaining++
+				continue
+			}
+		}
+		r.Removed++
+		r.Freed += e.size
+		r.Bytes -= e.size
+	}
+	return r
+}
+
+// Loop runs a collection pass immediately and then every interval until
+// stop is closed.
+func (c *Collector) Loop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, r := range c.Run(false) {
+			if r.Removed > 0 {
+				log.Printf("gc: %s: removed %d entries (%s)", r.Area, r.Removed, FormatBytes(r.Freed))
+			}
+			for _, e := range r.Errors {
+				log.Printf("gc: %s: %s", r.Area, e)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// usage returns the total size of path and the newest modification time
+// within it.
+func usage(path string) (int64, time.Time) {
+	var size int64
+	var newest time.Time
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return size, newest
+}
+
+// FormatBytes renders a byte count for humans.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
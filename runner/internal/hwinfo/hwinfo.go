@@ -0,0 +1,155 @@
+// Package hwinfo detects a machine's GPUs and compute accelerators:
+// NVIDIA GPUs and the CUDA versions available through nvidia-smi and
+// nvcc on any platform, and Metal GPUs through system_profiler on macOS.
+// The cloud uses it to route GPU work only to runners that can run it.
+package hwinfo
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// detectTimeout bounds the commands one detection runs.
+const detectTimeout = 10 * time.Second
+
+// GPU vendors and compute APIs (protocol.GPUInfo).
+const (
+	VendorNVIDIA = "nvidia"
+	VendorApple  = "apple"
+	APICUDA      = "cuda"
+	APIMetal     = "metal"
+)
+
+// Detect lists the machine's GPUs. A source that fails is recorded in
+// the result's Errors; a missing tool (no nvidia-smi) is not an error.
+func Detect(ctx context.Context) protocol.HWInfo {
+	ctx, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+	var r protocol.HWInfo
+	if err := detectNvidia(ctx, &r); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	if err := detectPlatform(ctx, &r); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	r.CUDAToolkitVersion = nvccVersion(ctx)
+	return r
+}
+
+// nvidiaFields are the nvidia-smi query fields detectNvidia parses, in
+// order. compute_cap needs driver 510 or later; older drivers are asked
+// without it.
+var nvidiaFields = []string{"index", "name", "uuid", "memory.total", "memory.free", "driver_version", "compute_cap"}
+
+var cudaVersion = regexp.MustCompile(`CUDA Version:\s*([\d.]+)`)
+
+// detectNvidia adds each NVIDIA GPU, and the newest CUDA version its
+// driver supports, if nvidia-smi is installed.
+func detectNvidia(ctx context.Context, r *protocol.HWInfo) error {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return nil
+	}
+	fields := nvidiaFields
+	out, err := exec.CommandContext(ctx, path, "--query-gpu="+strings.Join(fields, ","), "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		fields = fields[:len(fields)-1]
+		if out, err = exec.CommandContext(ctx, path, "--query-gpu="+strings.Join(fields, ","), "--format=csv,noheader,nounits").Output(); err != nil {
+			return fmt.Errorf("nvidia-smi: %w", err)
+		}
+	}
+	rd := csv.NewReader(strings.NewReader(string(out)))
+	rd.TrimLeadingSpace = true
+	records, err := rd.ReadAll()
+	if err != nil {
+		return fmt.Errorf("nvidia-smi: %w", err)
+	}
+	for _, rec := range records {
+		if len(rec) != len(fields) {
+			continue
+		}
+		index, err := strconv.Atoi(rec[0])
+		if err != nil {
+			continue
+		}
+		g := protocol.GPUInfo{
+			Index:         index,
+			Name:          rec[1],
+			Vendor:        VendorNVIDIA,
+			API:           APICUDA,
+			UUID:          rec[2],
+			VRAMBytes:     mib(rec[3]),
+			VRAMFreeBytes: mib(rec[4]),
+			DriverVersion: rec[5],
+		}
+		if len(rec) > 6 {
+			g.ComputeCapability = nvidiaString(rec[6])
+		}
+		r.GPUs = append(r.GPUs, g)
+	}
+	// The banner of plain nvidia-smi names the driver's CUDA version.
+	if out, err := exec.CommandContext(ctx, path).Output(); err == nil {
+		if m := cudaVersion.FindSubmatch(out); m != nil {
+			r.CUDADriverVersion = string(m[1])
+		}
+	}
+	return nil
+}
+
+// mib parses an nvidia-smi memory field in MiB, returning bytes.
+func mib(s string) int64 {
+	v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v << 20
+}
+
+// nvidiaString returns an nvidia-smi text field, or "" where it reads
+// "[N/A]" or "[Not Supported]".
+func nvidiaString(s string) string {
+	if s = strings.TrimSpace(s); strings.HasPrefix(s, "[") {
+		return ""
+	}
+	return s
+}
+
+var nvccRelease = regexp.MustCompile(`release ([\d.]+)`)
+
+// nvccVersion returns the version of the CUDA toolkit's nvcc, found on
+// PATH or under CUDA_PATH, CUDA_HOME or /usr/local/cuda.
+func nvccVersion(ctx context.Context) string {
+	path, err := exec.LookPath("nvcc")
+	if err != nil {
+		for _, dir := range []string{os.Getenv("CUDA_PATH"), os.Getenv("CUDA_HOME"), "/usr/local/cuda"} {
+			if dir == "" {
+				continue
+			}
+			if p, err := exec.LookPath(filepath.Join(dir, "bin", "nvcc")); err == nil {
+				path = p
+				break
+			}
+		}
+	}
+	if path == "" {
+		return ""
+	}
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	if m := nvccRelease.FindSubmatch(out); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
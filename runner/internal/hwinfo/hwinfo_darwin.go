@@ -0,0 +1,100 @@
+//go:build darwin
+
+package hwinfo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// spDisplay is the part of a system_profiler SPDisplaysDataType entry
+// detectPlatform reads.
+type spDisplay struct {
+	Model       string `json:"sppci_model"`
+	Vendor      string `json:"spdisplays_vendor"`
+	PCIVendor   string `json:"sppci_vendor"`
+	Cores       string `json:"sppci_cores"`
+	Metal       string `json:"spdisplays_mtlgpufamilysupport"`
+	VRAM        string `json:"spdisplays_vram"`
+	SharedVRAM  string `json:"spdisplays_vram_shared"`
+	DynamicVRAM string `json:"_spdisplays_vram"`
+}
+
+// detectPlatform adds the GPUs system_profiler reports. Apple silicon
+// GPUs share the system's memory, reported as their VRAM.
+func detectPlatform(ctx context.Context, r *protocol.HWInfo) error {
+	out, err := exec.CommandContext(ctx, "system_profiler", "-json", "SPDisplaysDataType").Output()
+	if err != nil {
+		return fmt.Errorf("system_profiler: %w", err)
+	}
+	var data struct {
+		Displays []spDisplay `json:"SPDisplaysDataType"`
+	}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return fmt.Errorf("system_profiler: %w", err)
+	}
+	index := 0
+	for _, d := range data.Displays {
+		vendor := d.Vendor
+		if vendor == "" {
+			vendor = d.PCIVendor
+		}
+		vendor = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(vendor), "sppci_vendor_"), "spdisplays_vendor_")
+		if vendor == VendorNVIDIA {
+			continue // listed by nvidia-smi, if it is installed
+		}
+		g := protocol.GPUInfo{Index: index, Name: d.Model, Vendor: vendor}
+		index++
+		if d.Metal != "" {
+			g.API = APIMetal
+			g.MetalFamily = strings.TrimPrefix(d.Metal, "spdisplays_")
+		}
+		g.Cores, _ = strconv.Atoi(d.Cores)
+		for _, v := range []string{d.VRAM, d.DynamicVRAM, d.SharedVRAM} {
+			if g.VRAMBytes = parseSize(v); g.VRAMBytes > 0 {
+				break
+			}
+		}
+		if vendor == VendorApple {
+			g.UnifiedMemory = true
+			g.VRAMBytes = memSize(ctx)
+		}
+		r.GPUs = append(r.GPUs, g)
+	}
+	return nil
+}
+
+// parseSize parses sizes such as "8 GB" or "1536 MB".
+func parseSize(s string) int64 {
+	f := strings.Fields(s)
+	if len(f) != 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToUpper(f[1]) {
+	case "GB":
+		return n << 30
+	case "MB":
+		return n << 20
+	}
+	return 0
+}
+
+// memSize returns the machine's memory in bytes.
+func memSize(ctx context.Context) int64 {
+	out, err := exec.CommandContext(ctx, "sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return n
+}
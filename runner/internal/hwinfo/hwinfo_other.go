@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package hwinfo
+
+import (
+	"context"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// detectPlatform detects nothing: only NVIDIA GPUs are detected on this
+// platform.
+func detectPlatform(context.Context, *protocol.HWInfo) error { return nil }
@@ -0,0 +1,138 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const keyFile = "identity.key"
+
+// Identity is the runner's persistent keypair. It survives token
+// rotations, letting the backend recognize the same machine over time.
+type Identity struct {
+	PublicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// LoadOrCreate reads the identity key from dir, generating and saving a new
+// one on first use.
+func LoadOrCreate(dir string) (*Identity, error) {
+	path := filepath.Join(dir, keyFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return create(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read identity: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("identity key %s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse identity key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity key %s is not an Ed25519 key", path)
+	}
+	return &Identity{PublicKey: priv.Public().(ed25519.PublicKey), privateKey: priv}, nil
+}
+
+func create(path string) (*Identity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate identity: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("encode identity: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create identity directory: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("save identity: %w", err)
+	}
+	return &Identity{PublicKey: pub, privateKey: priv}, nil
+}
+
+// PublicKeyString returns the base64-encoded public key.
+func (id *Identity) PublicKeyString() string {
+	return base64.StdEncoding.EncodeToString(id.PublicKey)
+}
+
+// Fingerprint returns an SSH-style fingerprint of the public key
+// ("SHA256:<base64>"), suitable for users to compare in the UI.
+func (id *Identity) Fingerprint() string {
+	sum := sha256.Sum256(id.PublicKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// HandshakeProof signs the current time so the backend can verify the
+// runner holds the private key. Returns the timestamp and signature.
+func (id *Identity) HandshakeProof() (timestamp, signature string) {
+	timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	msg := "xyzen-runner-identity\n" + timestamp + "\n" + id.Fingerprint()
+	return timestamp, base64.StdEncoding.EncodeToString(ed25519.Sign(id.privateKey, []byte(msg)))
+}
+
+// MachineFingerprint returns a stable, non-reversible fingerprint of the
+// host, derived from the OS machine ID. Empty if no ID is available.
+func MachineFingerprint() string {
+	raw := machineID()
+	if raw == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("xyzen-machine:" + raw))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+func machineID() string {
+	switch runtime.GOOS {
+	case "linux":
+		for _, p := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(p); err == nil {
+				return strings.TrimSpace(string(data))
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, "IOPlatformUUID") {
+				if parts := strings.Split(line, "\""); len(parts) >= 4 {
+					return parts[3]
+				}
+			}
+		}
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err != nil {
+			return ""
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) > 0 {
+			return fields[len(fields)-1]
+		}
+	}
+	return ""
+}
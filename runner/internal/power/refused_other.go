@@ -0,0 +1,13 @@
+//go:build !windows
+
+package power
+
+import (
+	"errors"
+	"syscall"
+)
+
+// refused reports whether a dial failed because the port was closed.
+func refused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package power
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeconnrefused is the Winsock error for a refused connection, which
+// package syscall does not name.
+const wsaeconnrefused syscall.Errno = 10061
+
+// refused reports whether a dial failed because the port was closed.
+func refused(err error) bool {
+	return errors.Is(err, wsaeconnrefused) || errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,90 @@
+package power
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBroadcast is where magic packets go when no address is given:
+// the limited broadcast address on the discard port.
+const DefaultBroadcast = "255.255.255.255:9"
+
+// MagicPacket returns the Wake-on-LAN magic packet for a MAC address:
+// six 0xFF bytes followed by the address repeated 16 times.
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q: want 6 bytes", mac)
+	}
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hw...)
+	}
+	return packet, nil
+}
+
+// Wake sends a magic packet for mac to the UDP broadcast address addr
+// (host:port; DefaultBroadcast if empty). Delivery is not confirmed: use
+// Probe to see whether the machine came up.
+func Wake(mac, addr string) error {
+	packet, err := MagicPacket(mac)
+	if err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = DefaultBroadcast
+	}
+	udp, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp4", nil, udp)
+	if err != nil {
+		return fmt.Errorf("wake %s: %w", mac, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("wake %s: %w", mac, err)
+	}
+	return nil
+}
+
+// Probe reports whether host is up by connecting to its TCP ports in
+// parallel: a host that accepts or refuses a connection on any of them
+// is up, one that does not answer within the context's deadline is not.
+// It also returns how long the first answer took.
+func Probe(ctx context.Context, host string, ports []int) (bool, time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	answered := make(chan time.Duration, len(ports))
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+			}
+			if err == nil || refused(err) {
+				answered <- time.Since(start)
+			}
+		}(port)
+	}
+	go func() {
+		wg.Wait()
+		close(answered)
+	}()
+	latency, ok := <-answered
+	return ok, latency
+}
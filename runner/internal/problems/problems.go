@@ -0,0 +1,201 @@
+// Package problems extracts compiler errors and stack traces from command
+// output into structured records with file and line references, so an
+// agent can go straight to the failing location instead of parsing logs.
+// Go, Python, JavaScript/TypeScript and Rust formats are recognized.
+package problems
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// MaxErrors bounds how many errors Extract returns.
+const MaxErrors = 20
+
+// Error kinds.
+const (
+	KindCompile = "compile" // compiler, type checker or vet diagnostic
+	KindRuntime = "runtime" // panic, uncaught exception or traceback
+)
+
+var (
+	goDiag       = regexp.MustCompile(`^\s*((?:[A-Za-z]:)?[^\s:]+\.go):(\d+)(?::(\d+))?: (.+)$`)
+	goPanic      = regexp.MustCompile(`^(?:panic|fatal error): (.+)$`)
+	goGoroutine  = regexp.MustCompile(`^goroutine \d+ \[.+\]:$`)
+	goFrameLoc   = regexp.MustCompile(`^\s+((?:[A-Za-z]:)?[^\s:]+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	pyTraceback  = regexp.MustCompile(`^Traceback \(most recent call last\):$`)
+	pyFrame      = regexp.MustCompile(`^\s+File "(.+)", line (\d+)(?:, in (.+))?$`)
+	jsError      = regexp.MustCompile(`^(?:Uncaught )?((?:[A-Z]\w*)?Error|Exception)(?:: (.*))?$`)
+	jsFrame      = regexp.MustCompile(`^\s+at (?:(.+?) \()?((?:[A-Za-z]:)?[^\s()]+?):(\d+):(\d+)\)?$`)
+	tsParen      = regexp.MustCompile(`^(.+\.(?:[cm]?[jt]sx?|vue|svelte))\((\d+),(\d+)\): error (TS\d+): (.+)$`)
+	tsDash       = regexp.MustCompile(`^(.+\.(?:[cm]?[jt]sx?|vue|svelte)):(\d+):(\d+) - error (TS\d+): (.+)$`)
+	rustDiag     = regexp.MustCompile(`^error(\[E\d+\])?: (.+)$`)
+	rustLoc      = regexp.MustCompile(`^\s*--> (.+):(\d+):(\d+)$`)
+	rustPanic    = regexp.MustCompile(`^thread '(.+)' panicked at (.+):(\d+):(\d+):$`)
+	rustPanicOld = regexp.MustCompile(`^thread '(.+)' panicked at '(.*)', (.+):(\d+):(\d+)$`)
+)
+
+// Extract returns the errors found in output, in order of appearance and
+// without duplicates, at most MaxErrors.
+func Extract(output string) []protocol.OutputError {
+	x := &extractor{lines: strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n"), seen: make(map[string]bool)}
+	for x.i = 0; x.i < len(x.lines) && len(x.errs) < MaxErrors; x.i++ {
+		x.line()
+	}
+	return x.errs
+}
+
+type extractor struct {
+	lines []string
+	i     int
+	errs  []protocol.OutputError
+	seen  map[string]bool
+}
+
+func (x *extractor) add(e protocol.OutputError) {
+	if len(e.Frames) > 0 && e.File == "" {
+		e.File, e.Line, e.Column = e.Frames[0].File, e.Frames[0].Line, e.Frames[0].Column
+	}
+	key := e.Language + "\x00" + e.File + "\x00" + strconv.Itoa(e.Line) + "\x00" + e.Message
+	if x.seen[key] {
+		return
+	}
+	x.seen[key] = true
+	x.errs = append(x.errs, e)
+}
+
+// next returns the line after the current one, or "" at the end.
+func (x *extractor) next() string {
+	if x.i+1 < len(x.lines) {
+		return x.lines[x.i+1]
+	}
+	return ""
+}
+
+func (x *extractor) line() {
+	l := x.lines[x.i]
+	switch {
+	case pyTraceback.MatchString(l):
+		x.python()
+	case goPanic.MatchString(l):
+		x.goPanic(goPanic.FindStringSubmatch(l)[1])
+	case rustPanic.MatchString(l):
+		m := rustPanic.FindStringSubmatch(l)
+		x.i++
+		x.add(protocol.OutputError{Kind: KindRuntime, Language: "rust", Message: strings.TrimSpace(x.lines[min(x.i, len(x.lines)-1)]), File: m[2], Line: atoi(m[3]), Column: atoi(m[4])})
+	case rustPanicOld.MatchString(l):
+		m := rustPanicOld.FindStringSubmatch(l)
+		x.add(protocol.OutputError{Kind: KindRuntime, Language: "rust", Message: m[2], File: m[3], Line: atoi(m[4]), Column: atoi(m[5])})
+	case rustDiag.MatchString(l):
+		x.rust(rustDiag.FindStringSubmatch(l))
+	case tsParen.MatchString(l):
+		m := tsParen.FindStringSubmatch(l)
+		x.add(protocol.OutputError{Kind: KindCompile, Language: "typescript", Code: m[4], Message: m[5], File: m[1], Line: atoi(m[2]), Column: atoi(m[3])})
+	case tsDash.MatchString(l):
+		m := tsDash.FindStringSubmatch(l)
+		x.add(protocol.OutputError{Kind: KindCompile, Language: "typescript", Code: m[4], Message: m[5], File: m[1], Line: atoi(m[2]), Column: atoi(m[3])})
+	case jsError.MatchString(l) && jsFrame.MatchString(x.next()):
+		x.javascript(jsError.FindStringSubmatch(l))
+	case goDiag.MatchString(l):
+		m := goDiag.FindStringSubmatch(l)
+		x.add(protocol.OutputError{Kind: KindCompile, Language: "go", Message: m[4], File: m[1], Line: atoi(m[2]), Column: atoi(m[3])})
+	}
+}
+
+// python parses a traceback; frames are listed innermost first.
+func (x *extractor) python() {
+	var frames []protocol.ErrorFrame
+	for x.i++; x.i < len(x.lines); x.i++ {
+		l := x.lines[x.i]
+		if m := pyFrame.FindStringSubmatch(l); m != nil {
+			frames = append([]protocol.ErrorFrame{{File: m[1], Line: atoi(m[2]), Function: m[3]}}, frames...)
+			continue
+		}
+		if strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") || l == "" {
+			continue // source line, caret markers
+		}
+		x.add(protocol.OutputError{Kind: KindRuntime, Language: "python", Message: l, Frames: frames})
+		return
+	}
+}
+
+// goPanic parses a panic and the stack of the goroutine that panicked.
+func (x *extractor) goPanic(msg string) {
+	e := protocol.OutputError{Kind: KindRuntime, Language: "go", Message: msg}
+	for x.i++; x.i < len(x.lines) && !goGoroutine.MatchString(x.lines[x.i]); x.i++ {
+		if x.i+1 < len(x.lines) && strings.TrimSpace(x.lines[x.i]) == "" && strings.TrimSpace(x.lines[x.i+1]) == "" {
+			x.add(e)
+			return
+		}
+	}
+	fn := ""
+	for x.i++; x.i < len(x.lines); x.i++ {
+		l := x.lines[x.i]
+		if strings.TrimSpace(l) == "" {
+			break
+		}
+		if m := goFrameLoc.FindStringSubmatch(l); m != nil {
+			e.Frames = append(e.Frames, protocol.ErrorFrame{File: m[1], Line: atoi(m[2]), Function: fn})
+			continue
+		}
+		if !goFrameLoc.MatchString(x.next()) {
+			x.i-- // end of the stack; let the caller see this line
+			break
+		}
+		fn = l
+		if j := strings.LastIndexByte(fn, '('); j > 0 {
+			fn = fn[:j]
+		}
+	}
+	// Skip runtime frames so the error points at user code.
+	for _, f := range e.Frames {
+		if !strings.Contains(f.File, "/src/runtime/") {
+			e.File, e.Line = f.File, f.Line
+			break
+		}
+	}
+	x.add(e)
+}
+
+func (x *extractor) javascript(m []string) {
+	e := protocol.OutputError{Kind: KindRuntime, Language: "javascript", Message: m[1]}
+	if m[2] != "" {
+		e.Message += ": " + m[2]
+	}
+	for x.i+1 < len(x.lines) {
+		f := jsFrame.FindStringSubmatch(x.lines[x.i+1])
+		if f == nil {
+			break
+		}
+		x.i++
+		e.Frames = append(e.Frames, protocol.ErrorFrame{File: strings.TrimPrefix(f[2], "file://"), Line: atoi(f[3]), Column: atoi(f[4]), Function: f[1]})
+	}
+	// Point at the first frame outside node's internals and dependencies.
+	for _, f := range e.Frames {
+		if !strings.HasPrefix(f.File, "node:") && !strings.Contains(f.File, "node_modules/") {
+			e.File, e.Line, e.Column = f.File, f.Line, f.Column
+			break
+		}
+	}
+	x.add(e)
+}
+
+// rust parses a rustc diagnostic, whose location follows on a "-->" line.
+// Summaries without a location ("could not compile") are dropped.
+func (x *extractor) rust(m []string) {
+	for j := x.i + 1; j < len(x.lines) && j <= x.i+3; j++ {
+		if loc := rustLoc.FindStringSubmatch(x.lines[j]); loc != nil {
+			x.add(protocol.OutputError{Kind: KindCompile, Language: "rust", Code: strings.Trim(m[1], "[]"), Message: m[2], File: loc[1], Line: atoi(loc[2]), Column: atoi(loc[3])})
+			x.i = j
+			return
+		}
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,65 @@
+package problems
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxReferences bounds how many references References returns.
+const MaxReferences = 50
+
+// Reference is a file location mentioned in text, as printed.
+type Reference struct {
+	Text         string // the matched text
+	File         string
+	Line, Column int
+}
+
+var (
+	// refColon matches "path.ext:line[:col]", the form used by most
+	// compilers, linters, test runners and grep. An extension is required
+	// so that times and host:port pairs are not taken for locations.
+	refColon = regexp.MustCompile(`((?:[A-Za-z]:)?[\w./\\~+@-]*[\w-]\.[A-Za-z][A-Za-z0-9]{0,9}):(\d+)(?::(\d+))?`)
+	// refParen matches "path.ext(line,col)" as printed by tsc and MSBuild.
+	refParen = regexp.MustCompile(`((?:[A-Za-z]:)?[\w./\\~+@-]*[\w-]\.[A-Za-z][A-Za-z0-9]{0,9})\((\d+),(\d+)\)`)
+	// refPython matches a Python traceback frame.
+	refPython = regexp.MustCompile(`File "([^"]+)", line (\d+)`)
+)
+
+// References returns the file locations mentioned in text, in order of
+// appearance and without duplicates, at most MaxReferences. Whether the
+// files exist is up to the caller to check.
+func References(text string) []Reference {
+	type hit struct {
+		at  int
+		ref Reference
+	}
+	var hits []hit
+	for _, re := range []*regexp.Regexp{refColon, refParen, refPython} {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			r := Reference{Text: text[m[0]:m[1]], File: text[m[2]:m[3]], Line: atoi(text[m[4]:m[5]])}
+			if len(m) > 6 && m[6] >= 0 {
+				r.Column = atoi(text[m[6]:m[7]])
+			}
+			r.File = strings.TrimPrefix(r.File, "file://")
+			hits = append(hits, hit{m[0], r})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].at < hits[j].at })
+	seen := make(map[Reference]bool)
+	var refs []Reference
+	for _, h := range hits {
+		key := h.ref
+		key.Text = ""
+		if h.ref.Line == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		refs = append(refs, h.ref)
+		if len(refs) == MaxReferences {
+			break
+		}
+	}
+	return refs
+}
@@ -0,0 +1,130 @@
+// Package process lists the processes on the runner's machine and
+// signals them, so that agents can find and stop what they started (a dev
+// server left running by an exec, say) without guessing at pkill
+// patterns. Processes are read from /proc on Linux, from ps on other Unix
+// systems, and from WMI on Windows.
+package process
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// RunnerEnv is set in the environment of the commands the runner starts.
+// Processes inherit it, so on Linux, where another process's environment
+// can be read, they are recognised as the runner's even after they have
+// outlived their parent (as "nohup server &" does).
+const RunnerEnv = "XYZEN_RUNNER_PID"
+
+// listTimeout bounds the commands one listing runs.
+const listTimeout = 10 * time.Second
+
+// proc is a process as the platform lists it.
+type proc struct {
+	protocol.ProcessInfo
+	// marked reports that RunnerEnv is in the process's environment.
+	marked bool
+	// cpu is the CPU time the process has used.
+	cpu time.Duration
+}
+
+// List returns the processes on the machine, ordered by PID. owned maps
+// the PIDs of the processes the runner started itself, and its own PID,
+// to the ID of the job each is, if any: these and their descendants are
+// marked StartedByRunner.
+func List(ctx context.Context, owned map[int]string) ([]protocol.ProcessInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, listTimeout)
+	defer cancel()
+	procs, err := list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+	parent := make(map[int]int, len(procs))
+	for _, p := range procs {
+		parent[p.PID] = p.PPID
+	}
+	infos := make([]protocol.ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		info := p.ProcessInfo
+		// Walk up to the first owned ancestor, if any. The step bound
+		// guards against cycles from PIDs reused mid-listing.
+		for pid, n := info.PID, 0; pid > 0 && n < len(procs); pid, n = parent[pid], n+1 {
+			if job, ok := owned[pid]; ok {
+				info.StartedByRunner, info.JobID = true, job
+				break
+			}
+		}
+		if p.marked {
+			info.StartedByRunner = true
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].PID < infos[j].PID })
+	return infos, nil
+}
+
+// CPUTime returns the CPU time used so far by the process pid and its
+// running descendants.
+func CPUTime(ctx context.Context, pid int) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, listTimeout)
+	defer cancel()
+	procs, err := list(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list processes: %w", err)
+	}
+	children := make(map[int][]proc, len(procs))
+	for _, p := range procs {
+		children[p.PPID] = append(children[p.PPID], p)
+	}
+	var total time.Duration
+	seen := map[int]bool{}
+	queue := []int{pid}
+	for _, p := range procs {
+		if p.PID == pid {
+			total += p.cpu
+		}
+	}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		for _, c := range children[parent] {
+			total += c.cpu
+			queue = append(queue, c.PID)
+		}
+	}
+	return total, nil
+}
+
+// Signal sends sig ("term", the default, "int" or "kill") to the process
+// pid.
+func Signal(pid int, sig string) error {
+	if sig == "" {
+		sig = "term"
+	}
+	switch sig {
+	case "term", "int", "kill":
+	default:
+		return fmt.Errorf("invalid signal %q (want term, int or kill)", sig)
+	}
+	if err := signal(pid, sig); err != nil {
+		return fmt.Errorf("signal process %d: %w", pid, err)
+	}
+	return nil
+}
+
+// cpuPercent is the share of one CPU a process has used since it started.
+func cpuPercent(cpu, elapsed time.Duration) *float64 {
+	if elapsed <= 0 {
+		return nil
+	}
+	pct := float64(cpu) / float64(elapsed) * 100
+	return &pct
+}
@@ -0,0 +1,80 @@
+//go:build !linux && !windows
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// list runs ps. time is cumulative CPU time, as in "1-02:03:04" or
+// "3:04.56", and lstart five fields, as in "Fri Oct 16 02:53:50 2026".
+func list(ctx context.Context) ([]proc, error) {
+	cmd := exec.CommandContext(ctx, "ps", "-axww", "-o", "pid=,ppid=,%cpu=,rss=,time=,lstart=,command=")
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var procs []proc
+	sc := bufio.NewScanner(strings.NewReader(string(out)))
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		f, command, ok := cutFields(sc.Text(), 10)
+		if !ok || command == "" {
+			continue
+		}
+		p := proc{}
+		p.PID, _ = strconv.Atoi(f[0])
+		p.PPID, _ = strconv.Atoi(f[1])
+		p.Command = command
+		if cpu, err := strconv.ParseFloat(f[2], 64); err == nil {
+			p.CPUPercent = &cpu
+		}
+		if rss, err := strconv.ParseUint(f[3], 10, 64); err == nil {
+			p.MemoryBytes = rss * 1024
+		}
+		p.cpu = parseCPUTime(f[4])
+		if t, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(f[5:10], " "), time.Local); err == nil {
+			p.StartedAt = t.UTC().Format(time.RFC3339)
+		}
+		procs = append(procs, p)
+	}
+	return procs, sc.Err()
+}
+
+// cutFields splits the first n space-separated fields off line and
+// returns them and the rest of it, which keeps its inner spacing.
+func cutFields(line string, n int) ([]string, string, bool) {
+	fields := make([]string, 0, n)
+	rest := strings.TrimLeft(line, " \t")
+	for len(fields) < n {
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			return nil, "", false
+		}
+		fields = append(fields, rest[:i])
+		rest = strings.TrimLeft(rest[i:], " \t")
+	}
+	return fields, rest, true
+}
+
+// parseCPUTime parses ps's [[dd-]hh:]mm:ss[.cc] CPU time.
+func parseCPUTime(s string) time.Duration {
+	var days float64
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		days, _ = strconv.ParseFloat(d, 64)
+		s = rest
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		secs = secs*60 + v
+	}
+	return time.Duration((days*86400 + secs) * float64(time.Second))
+}
@@ -0,0 +1,67 @@
+//go:build windows
+
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// wmiScript lists Win32_Process as JSON. CPU time is in 100ns units.
+const wmiScript = `Get-CimInstance Win32_Process | ForEach-Object { [pscustomobject]@{` +
+	`pid = $_.ProcessId; ppid = $_.ParentProcessId; name = $_.Name; command = $_.CommandLine; ` +
+	`memory = $_.WorkingSetSize; cpu = $_.KernelModeTime + $_.UserModeTime; ` +
+	`started = if ($_.CreationDate) { $_.CreationDate.ToUniversalTime().ToString('o') } } } | ConvertTo-Json -Compress`
+
+// list queries WMI through PowerShell.
+func list(ctx context.Context) ([]proc, error) {
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", wmiScript).Output()
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		PID     int    `json:"pid"`
+		PPID    int    `json:"ppid"`
+		Name    string `json:"name"`
+		Command string `json:"command"`
+		Memory  uint64 `json:"memory"`
+		CPU     uint64 `json:"cpu"`
+		Started string `json:"started"`
+	}
+	// ConvertTo-Json writes a lone object rather than a one-element array.
+	if len(out) > 0 && out[0] != '[' {
+		out = append(append([]byte{'['}, out...), ']')
+	}
+	if err := json.Unmarshal(out, &rows); err != nil {
+		return nil, err
+	}
+	procs := make([]proc, 0, len(rows))
+	for _, r := range rows {
+		p := proc{}
+		p.PID, p.PPID, p.Command, p.MemoryBytes = r.PID, r.PPID, r.Command, r.Memory
+		if p.Command == "" {
+			p.Command = r.Name
+		}
+		p.cpu = time.Duration(r.CPU) * 100
+		if t, err := time.Parse(time.RFC3339Nano, r.Started); err == nil {
+			p.StartedAt = t.UTC().Format(time.RFC3339)
+			p.CPUPercent = cpuPercent(p.cpu, time.Since(t))
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// signal kills the process pid: Windows has no signals to send it, so sig
+// is ignored.
+func signal(pid int, sig string) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	return p.Kill()
+}
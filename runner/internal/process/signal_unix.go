@@ -0,0 +1,16 @@
+//go:build !windows
+
+package process
+
+import "syscall"
+
+func signal(pid int, sig string) error {
+	s := syscall.SIGTERM
+	switch sig {
+	case "int":
+		s = syscall.SIGINT
+	case "kill":
+		s = syscall.SIGKILL
+	}
+	return syscall.Kill(pid, s)
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Paths on the wire are canonical: relative to the runner's working
+// directory and separated by forward slashes on every OS. The runner
+// translates to and from host paths at the executor boundary, so the
+// backend and agents never need per-OS path logic.
+
+// ErrNonCanonicalPath is wrapped by CleanPath errors.
+var ErrNonCanonicalPath = errors.New("non-canonical path")
+
+// CleanPath validates a wire path and returns its cleaned form ("." for the
+// working directory itself). Absolute paths, drive letters, UNC paths,
+// backslashes and paths escaping the working directory are rejected.
+func CleanPath(p string) (string, error) {
+	switch {
+	case p == "":
+		return ".", nil
+	case strings.Contains(p, `\`):
+		return "", fmt.Errorf("%w: %q uses backslashes; use '/' separators", ErrNonCanonicalPath, p)
+	case strings.HasPrefix(p, "/"):
+		return "", fmt.Errorf("%w: %q is absolute; use a path relative to the working directory", ErrNonCanonicalPath, p)
+	case len(p) >= 2 && p[1] == ':' && isLetter(p[0]):
+		return "", fmt.Errorf("%w: %q has a drive letter; use a path relative to the working directory", ErrNonCanonicalPath, p)
+	}
+	clean := path.Clean(p)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %q is outside the working directory", ErrNonCanonicalPath, p)
+	}
+	return clean, nil
+}
+
+// JoinPath joins canonical path elements.
+func JoinPath(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func isLetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCleanPath cleans relative wire paths and rejects absolute ones,
+// backslashes and paths escaping the working directory.
+func TestCleanPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"", "."},
+		{".", "."},
+		{"a/b", "a/b"},
+		{"./a//b/", "a/b"},
+		{"a/../b", "b"},
+		{"a/..", "."},
+		{"..a/b", "..a/b"},
+	} {
+		got, err := CleanPath(tc.path)
+		if err != nil || got != tc.want {
+			t.Errorf("CleanPath(%q) = %q, %v; want %q", tc.path, got, err, tc.want)
+		}
+	}
+
+	for _, p := range []string{
+		"/etc/passwd",
+		"//server/share",
+		`C:\Windows`,
+		"C:/Windows",
+		"c:",
+		`a\b`,
+		`\\server\share`,
+		`..\x`,
+		"..",
+		"../x",
+		"a/../../x",
+		"a/b/../../..",
+	} {
+		if got, err := CleanPath(p); !errors.Is(err, ErrNonCanonicalPath) {
+			t.Errorf("CleanPath(%q) = %q, %v; want ErrNonCanonicalPath", p, got, err)
+		}
+	}
+}
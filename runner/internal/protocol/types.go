@@ -1,27 +1,182 @@
 package protocol
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 // Request is a message from the cloud to the runner.
 type Request struct {
 	ID      string          `json:"id"`
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload"`
+	// Encryption names the E2E scheme when Payload is a sealed JSON string
+	// rather than a plain object; KeyID selects the negotiated key.
+	Encryption string `json:"encryption,omitempty"`
+	KeyID      string `json:"key_id,omitempty"`
+	// ContentEncoding is "gzip" when Payload is a base64 string of the
+	// gzipped JSON payload. AcceptEncoding lists encodings the sender
+	// accepts for the response payload, e.g. "gzip".
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	AcceptEncoding  string `json:"accept_encoding,omitempty"`
+	// Session is the agent session (see session_start) the request is
+	// made on behalf of. Requests on a session count against its budget.
+	Session string `json:"session,omitempty"`
+	// DryRun asks for a description of what a side-effecting request
+	// would do (see DryRunResult) instead of doing it, as when the
+	// runner runs with --dry-run.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ctx carries the runner's per-request values; it is not sent.
+	ctx context.Context
+}
+
+// Context returns the request's context, never nil.
+func (r Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
+// WithContext returns a copy of r with its context set to ctx.
+func (r Request) WithContext(ctx context.Context) Request {
+	r.ctx = ctx
+	return r
+}
+
+// EncodingGzip is the gzip payload content encoding. Payloads are
+// compressed before, and decompressed after, E2E encryption.
+const EncodingGzip = "gzip"
+
 // Response is a message from the runner to the cloud.
 type Response struct {
-	ID      string      `json:"id"`
-	Type    string      `json:"type"`
-	Success bool        `json:"success"`
-	Payload interface{} `json:"payload"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Success    bool        `json:"success"`
+	Payload    interface{} `json:"payload"`
+	Encryption string      `json:"encryption,omitempty"`
+	KeyID      string      `json:"key_id,omitempty"`
+	// ContentEncoding is set as on Request when the payload was
+	// compressed because the request accepted it.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Retries is how many times the runner retried transient local
+	// failures (locked or busy files) while handling the request.
+	Retries int `json:"retries,omitempty"`
 }
 
 // ExecPayload is the payload for an "exec" request.
+// GitHooks ("run" or "bypass") overrides the runner's policy on whether
+// git commands in Command execute repository hooks. SnapshotOnFailure
+// attaches an EnvSnapshot to the result if the command fails.
 type ExecPayload struct {
-	Command string `json:"command"`
-	Cwd     string `json:"cwd,omitempty"`
-	Timeout int    `json:"timeout,omitempty"`
+	Command           string `json:"command"`
+	Cwd               string `json:"cwd,omitempty"`
+	Timeout           int    `json:"timeout,omitempty"`
+	GitHooks          string `json:"git_hooks,omitempty"`
+	SnapshotOnFailure bool   `json:"snapshot_on_failure,omitempty"`
+	// CollectCrash enables core dumps for the command and, if it crashes
+	// (a fatal signal or a Go or Rust panic), keeps its crash log and
+	// core dump for post-mortem debugging (see CrashReport).
+	CollectCrash bool `json:"collect_crash,omitempty"`
+	// ExecuteIn is "workdir" (the default) or "snapshot", which runs the
+	// command in a throwaway copy of the work dir and reports the files
+	// it changed there in the result's Changes, leaving the real tree
+	// untouched.
+	ExecuteIn string `json:"execute_in,omitempty"`
+	// Env sets environment variables for the command, over the runner's
+	// own environment or, with EnvClear, over an empty one. Their values
+	// are redacted from the command as audited.
+	Env      map[string]string `json:"env,omitempty"`
+	EnvClear bool              `json:"env_clear,omitempty"`
+	// Container, if set, runs the command in a throwaway Docker or Podman
+	// container with the work dir mounted at /workspace, isolating it
+	// from the rest of the host. Only Env reaches its environment.
+	Container *ContainerSpec `json:"container,omitempty"`
+	// Limits caps the CPU, memory and processes the command may use. The
+	// runner's configured limits apply by default, and a request may
+	// lower them but not raise them.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// OutputEncoding is the encoding of the command's output: "auto"
+	// (the default) detects UTF-16, GBK and the system's legacy
+	// encoding, falling back to Windows-1252; or name one, e.g. "gbk",
+	// "shift_jis" or "latin1". Output is converted to UTF-8.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// Priority is "normal", "low" (nice 10, lowest best-effort IO) or
+	// "idle" (nice 19, idle IO class), so agent work yields to the user's
+	// own. The runner's configured priority is the highest a request may
+	// ask for.
+	Priority string `json:"priority,omitempty"`
+	// Retries reruns a failed command up to this many times (at most 5)
+	// on the runner, waiting 1s before the first retry and doubling the
+	// wait for each after, up to 30s. A command is retried if it exits
+	// with one of RetryOnExitCodes or, if there are none, with any
+	// failure code. Include -1 to retry commands that timed out or were
+	// killed by a signal.
+	Retries          int   `json:"retries,omitempty"`
+	RetryOnExitCodes []int `json:"retry_on_exit_codes,omitempty"`
+}
+
+// ResourceLimits bounds the resources of a command and everything it
+// starts: CPUs is a share of CPU time (1.5 is one and a half CPUs),
+// MemoryBytes its memory and MaxProcesses the processes it may run at
+// once. Zero is no limit.
+type ResourceLimits struct {
+	CPUs         float64 `json:"cpus,omitempty"`
+	MemoryBytes  int64   `json:"memory_bytes,omitempty"`
+	MaxProcesses int     `json:"max_processes,omitempty"`
+}
+
+// ContainerSpec is the container an exec command runs in. Network is
+// "none" (the default) or "bridge"; commands whose profile denies the
+// network always get "none".
+type ContainerSpec struct {
+	Image   string           `json:"image"`
+	Mounts  []ContainerMount `json:"mounts,omitempty"`
+	Network string           `json:"network,omitempty"`
+}
+
+// ContainerMount bind-mounts Path, a work dir path, at Target, an
+// absolute path in the container.
+type ContainerMount struct {
+	Path     string `json:"path"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// ExecProgressPayload is the payload for an "exec_progress" event (runner
+// → cloud, proactive), sent periodically while an exec or run_script
+// request's command runs past the runner's progress interval, so that a
+// command still working can be told from a hung one. StdoutBytes and
+// StderrBytes count all output so far, including any past the output
+// limit, and RecentOutputBytes the output since the previous event.
+// CPUPercent is the command's CPU use over that time (100 is one CPU),
+// omitted where it cannot be measured.
+type ExecProgressPayload struct {
+	RequestID         string   `json:"request_id"`
+	ElapsedMs         int64    `json:"elapsed_ms"`
+	StdoutBytes       int64    `json:"stdout_bytes"`
+	StderrBytes       int64    `json:"stderr_bytes"`
+	RecentOutputBytes int64    `json:"recent_output_bytes"`
+	CPUPercent        *float64 `json:"cpu_percent,omitempty"`
+}
+
+// RunScriptPayload is for run_script requests, which write Content to a
+// temporary file, run it with Interpreter (a program name or path, e.g.
+// "python3" or "bash") passing Args, and delete it. The rest are as for
+// exec, and the result is exec's.
+type RunScriptPayload struct {
+	Interpreter string            `json:"interpreter"`
+	Content     string            `json:"content"`
+	Args        []string          `json:"args,omitempty"`
+	Cwd         string            `json:"cwd,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	EnvClear    bool              `json:"env_clear,omitempty"`
+	Limits      *ResourceLimits   `json:"limits,omitempty"`
+	// OutputEncoding and Priority are as for exec.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	Priority       string `json:"priority,omitempty"`
 }
 
 // ExecResultPayload is the payload for an "exec_result" response.
@@ -29,45 +184,1174 @@ type ExecResultPayload struct {
 	ExitCode int    `json:"exit_code"`
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
+	Class    string `json:"class,omitempty"`     // command class that selected the execution profile
+	GitHooks string `json:"git_hooks,omitempty"` // git hooks policy the command ran under
+	// Environment is set for failed commands that asked for a snapshot.
+	Environment *EnvSnapshot `json:"environment,omitempty"`
+	// Errors lists compiler errors and stack traces found in the output
+	// of a failed command.
+	Errors []OutputError `json:"errors,omitempty"`
+	// Links lists the references to workspace files found in the output.
+	Links []FileLink `json:"links,omitempty"`
+	// Signal names the signal that killed the command's shell, e.g.
+	// "SIGSEGV" (ExitCode is then -1).
+	Signal string `json:"signal,omitempty"`
+	// Crash is set for a crashed command that asked to collect crashes.
+	Crash *CrashReport `json:"crash,omitempty"`
+	// Changes lists the files a command run in a snapshot created,
+	// modified or deleted, sorted by path; ChangesTruncated is set if
+	// there were too many to list.
+	Changes          []SnapshotChange `json:"changes,omitempty"`
+	ChangesTruncated bool             `json:"changes_truncated,omitempty"`
+	// Limits are the resource limits the command ran under and LimitedBy
+	// how they were enforced: "cgroup" (Linux cgroups v2), "ulimit" (best
+	// effort: it cannot limit CPU share, and its process limit counts all
+	// of the user's processes) or "container". Limits the platform
+	// cannot enforce are left out.
+	Limits    *ResourceLimits `json:"limits,omitempty"`
+	LimitedBy string          `json:"limited_by,omitempty"`
+	// Priority is the lowered priority the command ran at, if any; in a
+	// container it sets the container's CPU shares.
+	Priority string `json:"priority,omitempty"`
+	// Attempts is how many times a command that asked for retries ran;
+	// the rest of the result is its last attempt's.
+	Attempts int `json:"attempts,omitempty"`
+	// DurationMs is the command's wall-clock time. UserCPUMs, SystemCPUMs
+	// and MaxRSSBytes (the peak resident memory of the command or any
+	// process it waited for; not reported on Windows) are its resource
+	// usage, left zero for commands run in a container.
+	DurationMs  int64 `json:"duration_ms"`
+	UserCPUMs   int64 `json:"user_cpu_ms"`
+	SystemCPUMs int64 `json:"system_cpu_ms"`
+	MaxRSSBytes int64 `json:"max_rss_bytes,omitempty"`
+	// StdoutTruncated and StderrTruncated report that the stream exceeded
+	// the 1 MB output limit: Stdout or Stderr then holds its first 512 KB
+	// and StdoutTail or StderrTail its last 512 KB. Unless the runner
+	// could not store it, the full output is kept for read_exec_output
+	// under OutputID, and StdoutSize and StderrSize are the streams'
+	// lengths in bytes.
+	StdoutTruncated bool   `json:"stdout_truncated"`
+	StderrTruncated bool   `json:"stderr_truncated"`
+	StdoutTail      string `json:"stdout_tail,omitempty"`
+	StderrTail      string `json:"stderr_tail,omitempty"`
+	OutputID        string `json:"output_id,omitempty"`
+	StdoutSize      int64  `json:"stdout_size,omitempty"`
+	StderrSize      int64  `json:"stderr_size,omitempty"`
+	// StdoutEncoding and StderrEncoding name the encoding the output was
+	// converted from when it was not UTF-8, and InvalidSequences counts
+	// the bytes of invalid UTF-8 replaced with U+FFFD.
+	StdoutEncoding   string `json:"stdout_encoding,omitempty"`
+	StderrEncoding   string `json:"stderr_encoding,omitempty"`
+	InvalidSequences int    `json:"invalid_sequences,omitempty"`
+}
+
+// ReadExecOutputPayload is for read_exec_output requests, which page
+// through the full output of an exec whose result was truncated. Stream
+// is "stdout" (the default) or "stderr"; at most MaxBytes (default and
+// max 1 MB) are read from Offset.
+type ReadExecOutputPayload struct {
+	OutputID string `json:"output_id"`
+	Stream   string `json:"stream,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+}
+
+// ReadExecOutputResult is the response for read_exec_output. Data is
+// converted to UTF-8 like the exec result's output; NextOffset is where
+// the next read should start and Size the stream's length in bytes.
+type ReadExecOutputResult struct {
+	OutputID   string `json:"output_id"`
+	Stream     string `json:"stream"`
+	Data       string `json:"data"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	Size       int64  `json:"size"`
+	EOF        bool   `json:"eof"`
+}
+
+// DryRunResult is the successful result of a side-effecting request made
+// in dry-run mode: what it would have done. exec, run_script and
+// job_start describe the Command (for run_script, the script), the Cwd
+// it would run in, its Class and Timeout in seconds (0 for a job without
+// one); writes and remove_dir list their Effects. Other side-effecting
+// requests fail with code "dry_run_unsupported".
+type DryRunResult struct {
+	DryRun  bool           `json:"dry_run"`
+	Summary string         `json:"summary"`
+	Command string         `json:"command,omitempty"`
+	Cwd     string         `json:"cwd,omitempty"`
+	Class   string         `json:"class,omitempty"`
+	Timeout int            `json:"timeout,omitempty"`
+	Effects []DryRunEffect `json:"effects,omitempty"`
+}
+
+// DryRunEffect is a change a request would make to Path. Action is
+// "create", "overwrite", "append" or "delete"; Bytes is the size that
+// would be written, or that would be deleted along with Files files.
+type DryRunEffect struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Bytes  int64  `json:"bytes"`
+	Files  int    `json:"files,omitempty"`
 }
 
-// FilePayload is for read_file / write_file requests.
+// SnapshotChange is a file changed by a command run in a snapshot. Status
+// is "added", "modified" or "deleted". Diff is a unified diff as for
+// diff_files, omitted for binary files and once the diffs of a result
+// exceed 1 MB; changes inside .git are not reported.
+type SnapshotChange struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Diff   string `json:"diff,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+// CrashReport describes the artifacts collected for a crashed command,
+// which crash_fetch retrieves. Reason is the crash signal (e.g.
+// "SIGSEGV"), Windows exception (e.g. "EXCEPTION_ACCESS_VIOLATION") or
+// "go panic" / "rust panic". Log and Core are the artifact names; Note
+// explains why no core dump was collected.
+type CrashReport struct {
+	ID       string `json:"id"`
+	Reason   string `json:"reason"`
+	Log      string `json:"log"`
+	Core     string `json:"core,omitempty"`
+	CoreSize int64  `json:"core_size,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// CrashFetchPayload is for crash_fetch requests, which copy a crash
+// artifact (CrashReport.Log or Core) into a transfer to be read with
+// transfer_read. The result is a TransferInfo.
+type CrashFetchPayload struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// FileLink is a reference to a location in a workspace file found in
+// command output or file content. Text is the reference as it appeared;
+// Path is the file's canonical path relative to the working directory.
+type FileLink struct {
+	Text   string `json:"text"`
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Column int    `json:"column,omitempty"`
+}
+
+// OutputError is an error extracted from command output. Kind is
+// "compile" or "runtime"; File, Line and Column locate it as printed
+// (Column 0 if unknown). Frames, innermost first, are the stack of a
+// runtime error.
+type OutputError struct {
+	Kind     string       `json:"kind"`
+	Language string       `json:"language"`
+	Message  string       `json:"message"`
+	Code     string       `json:"code,omitempty"` // e.g. "TS2304", "E0425"
+	File     string       `json:"file,omitempty"`
+	Line     int          `json:"line,omitempty"`
+	Column   int          `json:"column,omitempty"`
+	Frames   []ErrorFrame `json:"frames,omitempty"`
+	// Path is File as a canonical workspace path, if it is a workspace file.
+	Path string `json:"path,omitempty"`
+}
+
+// ErrorFrame is one stack frame of an OutputError.
+type ErrorFrame struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Function string `json:"function,omitempty"`
+	Path     string `json:"path,omitempty"` // as in OutputError
+}
+
+// EnvSnapshot describes where a command ran: its working directory and
+// the entries in it (Files, directories suffixed "/"), toolchain-related
+// environment variables, and versions of the tools the directory's files
+// suggest (e.g. go for go.mod), keyed by tool name.
+type EnvSnapshot struct {
+	Cwd            string            `json:"cwd"`
+	Files          []string          `json:"files"`
+	FilesTruncated bool              `json:"files_truncated,omitempty"`
+	Env            map[string]string `json:"env"`
+	Tools          map[string]string `json:"tools,omitempty"`
+}
+
+// FilePayload is for read_file / write_file / append_file requests.
 type FilePayload struct {
 	Path    string `json:"path"`
 	Content string `json:"content,omitempty"`
 	Data    string `json:"data,omitempty"` // base64 for binary
+	// SHA256 optionally gives the hex checksum of the file bytes (the
+	// decoded Data or UTF-8 Content). Writes are rejected on mismatch.
+	SHA256 string `json:"sha256,omitempty"`
+	// Atomic makes write_file / write_file_bytes write a temp file and
+	// rename it into place. Defaults to true; false writes in place.
+	Atomic *bool `json:"atomic,omitempty"`
+	// Mode is an octal permission string such as "0755" that writes and
+	// appends set on the file. Without it, an existing file keeps its
+	// mode and a new one gets 0644.
+	Mode string `json:"mode,omitempty"`
+
+	// Reads only: select part of the file by byte Offset/Length (Length 0
+	// reads to the end) or by LineRange, to page through large files.
+	Offset    int64      `json:"offset,omitempty"`
+	Length    int64      `json:"length,omitempty"`
+	LineRange *LineRange `json:"line_range,omitempty"`
+
+	// read_file only: return the content as numbered Lines, optionally
+	// with the git commit that last modified each line.
+	LineNumbers bool `json:"line_numbers,omitempty"`
+	Blame       bool `json:"blame,omitempty"`
+}
+
+// AtomicWrite reports whether a write should be atomic.
+func (p FilePayload) AtomicWrite() bool {
+	return p.Atomic == nil || *p.Atomic
 }
 
-// FileResult is the response for read_file.
+// LineRange selects lines Start..End, 1-based and inclusive. End 0 means
+// to the end of the file.
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// FileResult is the response for read_file and append_file (where Offset
+// is where the appended bytes landed). SHA256 is of the returned
+// bytes. Size is the whole file's size; EOF is true if the returned part
+// reaches the end of the file.
 type FileResult struct {
-	Content string `json:"content,omitempty"`
-	Data    string `json:"data,omitempty"` // base64 for binary
+	Content   string     `json:"content,omitempty"`
+	Data      string     `json:"data,omitempty"` // base64 for binary
+	SHA256    string     `json:"sha256,omitempty"`
+	Size      int64      `json:"size"`
+	Offset    int64      `json:"offset,omitempty"`
+	EOF       bool       `json:"eof"`
+	LineRange *LineRange `json:"line_range,omitempty"`
+
+	// Lines replaces Content when line_numbers or blame was requested.
+	// BlameError says why blame metadata is missing (e.g. not a git
+	// repository); the lines are still returned.
+	Lines      []AnnotatedLine `json:"lines,omitempty"`
+	BlameError string          `json:"blame_error,omitempty"`
+}
+
+// AnnotatedLine is one line of a read_file result. Commit, Author, Time
+// (RFC 3339) and Summary describe the commit that last modified the line;
+// they are empty without blame or for uncommitted lines.
+type AnnotatedLine struct {
+	Number  int    `json:"number"`
+	Text    string `json:"text"`
+	Commit  string `json:"commit,omitempty"`
+	Author  string `json:"author,omitempty"`
+	Time    string `json:"time,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// ReadFilesPayload is for read_files requests. MaxFileSize caps each file
+// (default 256 KiB) and MaxTotalSize all of them together (default 1 MiB).
+type ReadFilesPayload struct {
+	Paths        []string `json:"paths"`
+	MaxFileSize  int64    `json:"max_file_size,omitempty"`
+	MaxTotalSize int64    `json:"max_total_size,omitempty"`
+}
+
+// ReadFilesResult is the response for read_files, with one entry per
+// requested path in order.
+type ReadFilesResult struct {
+	Files []ReadFilesEntry `json:"files"`
+}
+
+// ReadFilesEntry is one file of a read_files result. Truncated means
+// Content stops before the end of the file (Size is the full size);
+// Skipped means the total budget ran out before this file.
+type ReadFilesEntry struct {
+	Path      string `json:"path"`
+	Content   string `json:"content,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DiffFilesPayload is for diff_files requests. Context is the number of
+// context lines per hunk (default 3).
+type DiffFilesPayload struct {
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Context *int   `json:"context,omitempty"`
+}
+
+// DiffContentPayload is for diff_against_content requests: the diff turns
+// the file at Path into Content.
+type DiffContentPayload struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	Context *int   `json:"context,omitempty"`
+}
+
+// DiffResult is the response for diff_files and diff_against_content.
+// Diff is a unified diff with git-style a/ and b/ prefixes (or /dev/null
+// for a missing side), or a one-line notice if Binary.
+type DiffResult struct {
+	Diff      string `json:"diff"`
+	Identical bool   `json:"identical"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// ApplyPatchPayload is for apply_patch requests. Patch is a unified diff
+// whose paths are relative to Root. Fuzz (default 2, at most 3) is how
+// many mismatched context lines a hunk may ignore at each end. With
+// DryRun the result is reported but nothing is written.
+type ApplyPatchPayload struct {
+	Patch  string `json:"patch"`
+	Root   string `json:"root,omitempty"`
+	Fuzz   *int   `json:"fuzz,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// ApplyPatchResult is the response for apply_patch. Files are written only
+// if Applied, i.e. every hunk of every file applied.
+type ApplyPatchResult struct {
+	Applied bool              `json:"applied"`
+	DryRun  bool              `json:"dry_run,omitempty"`
+	Files   []PatchFileResult `json:"files"`
+}
+
+// PatchFileResult reports the patch of one file. Action is "modify",
+// "create", "delete" or "rename" (from OldPath).
+type PatchFileResult struct {
+	Path    string            `json:"path"`
+	OldPath string            `json:"old_path,omitempty"`
+	Action  string            `json:"action"`
+	Applied bool              `json:"applied"`
+	Hunks   []PatchHunkResult `json:"hunks"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// PatchHunkResult reports one hunk. Line is where it applied (1-based),
+// Offset how far that is from the line the hunk stated, and Fuzz how many
+// context lines were ignored.
+type PatchHunkResult struct {
+	Applied bool   `json:"applied"`
+	Line    int    `json:"line"`
+	Offset  int    `json:"offset,omitempty"`
+	Fuzz    int    `json:"fuzz,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ChecksumPayload is for checksum requests. Algorithms are any of
+// "sha256" (default), "sha512", "sha1" and "md5". Expected, if set, is
+// compared with the digest of the first algorithm.
+type ChecksumPayload struct {
+	Path       string   `json:"path"`
+	Algorithms []string `json:"algorithms,omitempty"`
+	Expected   string   `json:"expected,omitempty"`
+}
+
+// ChecksumResult is the response for checksum, with hex digests keyed by
+// algorithm.
+type ChecksumResult struct {
+	Path      string            `json:"path"`
+	Size      int64             `json:"size"`
+	Checksums map[string]string `json:"checksums"`
+	Match     *bool             `json:"match,omitempty"`
+}
+
+// MoveFilePayload is for move_file and copy_file requests. Directories are
+// copied recursively.
+type MoveFilePayload struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite,omitempty"`
+}
+
+// CreateDirPayload is for create_dir requests. Mode is an octal permission
+// string such as "0755"; Parents creates missing parents (mkdir -p).
+type CreateDirPayload struct {
+	Path    string `json:"path"`
+	Mode    string `json:"mode,omitempty"`
+	Parents bool   `json:"parents,omitempty"`
+}
+
+// RemoveDirPayload is for remove_dir requests. Without Recursive the
+// directory must be empty.
+type RemoveDirPayload struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// RemoveDirResult is the response for remove_dir. A recursively removed
+// directory is moved to the trash, from which undelete restores it until
+// the trash retention expires; TrashID names its trash entry.
+type RemoveDirResult struct {
+	TrashID string `json:"trash_id,omitempty"`
+}
+
+// UndeletePayload is for undelete requests, which restore an entry from
+// the trash: the one named ID, restored to Path if given and to where it
+// was deleted from otherwise, or, without ID, the latest entry deleted
+// from Path. An existing file or directory at the destination is moved
+// to the trash in its place only if Overwrite is set.
+type UndeletePayload struct {
+	ID        string `json:"id,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// UndeleteResult is the response for undelete: the entry restored and
+// where to.
+type UndeleteResult struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// CreateSymlinkPayload is for create_symlink requests, which create a
+// symlink at Path pointing to Target, stored as given: a relative Target
+// is relative to the link's directory, as with ln -s. Unless AllowOutside
+// is set, Target must resolve inside the work dir. Overwrite replaces an
+// existing file or symlink at Path, never a directory.
+type CreateSymlinkPayload struct {
+	Path         string `json:"path"`
+	Target       string `json:"target"`
+	Overwrite    bool   `json:"overwrite,omitempty"`
+	AllowOutside bool   `json:"allow_outside,omitempty"`
+}
+
+// ReadSymlinkPayload is for read_symlink requests.
+type ReadSymlinkPayload struct {
+	Path string `json:"path"`
+}
+
+// SymlinkResult is the result of create_symlink and read_symlink. Target
+// is the link's target as stored. Resolved is the canonical path of what
+// the link finally points to, following any further links, if that is
+// inside the work dir; Outside is set if it is not. Dangling is set if
+// it does not exist.
+type SymlinkResult struct {
+	Target   string `json:"target"`
+	Resolved string `json:"resolved,omitempty"`
+	Outside  bool   `json:"outside,omitempty"`
+	Dangling bool   `json:"dangling,omitempty"`
+}
+
+// ShareFilePayload is for share_file requests. The backend presigns
+// UploadURL in its object storage and mints ShareURL, the time-limited
+// link handed to the user; the runner only uploads.
+type ShareFilePayload struct {
+	Path        string            `json:"path"`
+	UploadURL   string            `json:"upload_url"`
+	Method      string            `json:"method,omitempty"` // PUT (default) or POST
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	ShareURL    string            `json:"share_url"`
+	ExpiresAt   string            `json:"expires_at,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"` // seconds
+}
+
+// ArtifactPutPayload is for artifact_put requests, which store a
+// workspace file in the runner's configured artifacts storage. Name
+// defaults to the file's base name.
+type ArtifactPutPayload struct {
+	Path string `json:"path"`
+	Name string `json:"name,omitempty"`
+}
+
+// StoredObject is the response for artifact_put: where the object was
+// stored. URL is a local path, an s3:// URL or the presigned URL without
+// its query.
+type StoredObject struct {
+	Backend string `json:"backend"`
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	URL     string `json:"url,omitempty"`
+}
+
+// PresignPayload is the payload of a "storage_presign" request (runner →
+// cloud) asking for a URL to apply Method to the object Key in Area. The
+// cloud answers with a "storage_presign_result" message carrying the same
+// ID and a PresignResult.
+type PresignPayload struct {
+	Area   string `json:"area"`
+	Key    string `json:"key"`
+	Method string `json:"method"`
+}
+
+// PresignResult is the payload of a "storage_presign_result" message.
+type PresignResult struct {
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// RunTrackStartPayload is for run_track_start requests, which start a
+// run on the runner's configured experiment tracking server (MLflow or
+// W&B). Experiment, an MLflow experiment or W&B project, is created if
+// it does not exist; empty selects the server's default. Params are
+// logged as the run starts.
+type RunTrackStartPayload struct {
+	Experiment string            `json:"experiment,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+}
+
+// TrackedRun is the response for run_track_start. RunID names the run in
+// later run_track requests; URL is its page in the tracking server's UI.
+type TrackedRun struct {
+	Backend    string `json:"backend"`
+	RunID      string `json:"run_id"`
+	Experiment string `json:"experiment"`
+	Name       string `json:"name,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// RunTrackLogPayload is for run_track_log requests, which log parameters
+// and metrics to a run.
+type RunTrackLogPayload struct {
+	RunID   string            `json:"run_id"`
+	Params  map[string]string `json:"params,omitempty"`
+	Metrics []RunMetric       `json:"metrics,omitempty"`
+}
+
+// RunMetric is one metric value. Timestamp (RFC 3339) defaults to now.
+type RunMetric struct {
+	Key       string  `json:"key"`
+	Value     float64 `json:"value"`
+	Step      int64   `json:"step,omitempty"`
+	Timestamp string  `json:"timestamp,omitempty"`
+}
+
+// RunTrackArtifactPayload is for run_track_artifact requests, which
+// upload a workspace file as an artifact of a run. Name, a relative
+// slash path within the run's artifacts, defaults to the file's base
+// name.
+type RunTrackArtifactPayload struct {
+	RunID string `json:"run_id"`
+	Path  string `json:"path"`
+	Name  string `json:"name,omitempty"`
+}
+
+// RunArtifactResult is the response for run_track_artifact.
+type RunArtifactResult struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// RunTrackEndPayload is for run_track_end requests. Status is
+// "finished" (default), "failed" or "killed".
+type RunTrackEndPayload struct {
+	RunID  string `json:"run_id"`
+	Status string `json:"status,omitempty"`
+}
+
+// ShareFileResult is the response for share_file.
+type ShareFileResult struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// TailFilePayload is for tail_file requests. With Follow set, appended
+// lines are streamed as "file_tail" messages until tail_cancel.
+type TailFilePayload struct {
+	Path   string `json:"path"`
+	Lines  int    `json:"lines,omitempty"` // default 10
+	Follow bool   `json:"follow,omitempty"`
+}
+
+// TailFileResult is the response for tail_file. TailID identifies the
+// follow stream (it is the request ID).
+type TailFileResult struct {
+	Lines     []string `json:"lines"`
+	TailID    string   `json:"tail_id,omitempty"`
+	Following bool     `json:"following"`
+}
+
+// FileTailPayload is a "file_tail" event (runner → cloud, proactive).
+// Reset means the file was truncated or replaced and Lines start from its
+// beginning. Done marks the end of the stream, with Error if it failed.
+type FileTailPayload struct {
+	TailID string   `json:"tail_id"`
+	Path   string   `json:"path"`
+	Lines  []string `json:"lines,omitempty"`
+	Reset  bool     `json:"reset,omitempty"`
+	Done   bool     `json:"done,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// TailCancelPayload is for tail_cancel requests.
+type TailCancelPayload struct {
+	TailID string `json:"tail_id"`
+}
+
+// JobStartPayload is for job_start requests, which run Command in the
+// background: the job's output goes to a log on disk and it keeps running
+// across reconnects, and is adopted by the next runner process if this
+// one exits (see RecoveredItem). Cwd, Env and EnvClear are as for exec.
+// Timeout, in seconds, kills the job if set; otherwise it runs until it
+// exits or is killed. Priority is as for exec.
+type JobStartPayload struct {
+	Command  string            `json:"command"`
+	Cwd      string            `json:"cwd,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	EnvClear bool              `json:"env_clear,omitempty"`
+	Timeout  int               `json:"timeout,omitempty"`
+	Priority string            `json:"priority,omitempty"`
+}
+
+// JobPayload is for job_status requests.
+type JobPayload struct {
+	JobID string `json:"job_id"`
+}
+
+// JobKillPayload is for job_kill requests. Signal is "term" (default),
+// "int" or "kill"; on Windows every signal kills the job's process.
+type JobKillPayload struct {
+	JobID  string `json:"job_id"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// JobLogsPayload is for job_logs requests, which read a job's output from
+// Offset, at most MaxBytes (default and max 1 MB) of it. With Follow, the
+// output written after that is streamed as "job_log" events until the job
+// ends or the stream is cancelled with tail_cancel.
+type JobLogsPayload struct {
+	JobID    string `json:"job_id"`
+	Offset   int64  `json:"offset,omitempty"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+	Follow   bool   `json:"follow,omitempty"`
+}
+
+// JobLogsResult is the response for job_logs. NextOffset is where the
+// next read should start and Size the current length of the log. With
+// Follow, StreamID names the stream of "job_log" events.
+type JobLogsResult struct {
+	Job        JobInfo `json:"job"`
+	Data       string  `json:"data"`
+	NextOffset int64   `json:"next_offset"`
+	Size       int64   `json:"size"`
+	StreamID   string  `json:"stream_id,omitempty"`
+}
+
+// JobLogPayload is a "job_log" event (runner → cloud, proactive) carrying
+// output a followed job wrote at Offset. Done marks the end of the
+// stream, with the job's final state in Job if it ended, or Error if the
+// log could not be read.
+type JobLogPayload struct {
+	StreamID string   `json:"stream_id"`
+	JobID    string   `json:"job_id"`
+	Data     string   `json:"data,omitempty"`
+	Offset   int64    `json:"offset"`
+	Done     bool     `json:"done,omitempty"`
+	Job      *JobInfo `json:"job,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// JobInfo describes a background job. Status is "running", "exited",
+// "killed" (by job_kill) or "timed_out". ExitCode is unknown for a job
+// adopted from a previous runner process, which could not wait for it.
+type JobInfo struct {
+	JobID     string `json:"job_id"`
+	Command   string `json:"command"`
+	Cwd       string `json:"cwd,omitempty"`
+	PID       int    `json:"pid"`
+	Status    string `json:"status"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	StartedAt string `json:"started_at"`
+	EndedAt   string `json:"ended_at,omitempty"`
+	LogBytes  int64  `json:"log_bytes"`
+	Adopted   bool   `json:"adopted,omitempty"`
+}
+
+// JobListResult is the response for job_list: running jobs and recently
+// ended ones, oldest first.
+type JobListResult struct {
+	Jobs []JobInfo `json:"jobs"`
+}
+
+// ProcessListPayload is for process_list requests. Filter, if set, keeps
+// processes whose command contains it; RunnerOnly keeps those the runner
+// started.
+type ProcessListPayload struct {
+	Filter     string `json:"filter,omitempty"`
+	RunnerOnly bool   `json:"runner_only,omitempty"`
+}
+
+// ProcessInfo describes a process on the runner's machine. CPUPercent is
+// the average since the process started, as ps reports it, and may
+// exceed 100 on several cores. StartedByRunner marks processes run by an
+// exec, job or terminal of this or an earlier runner, and JobID the job
+// a process belongs to.
+type ProcessInfo struct {
+	PID             int      `json:"pid"`
+	PPID            int      `json:"ppid"`
+	Command         string   `json:"command"`
+	CPUPercent      *float64 `json:"cpu_percent,omitempty"`
+	MemoryBytes     uint64   `json:"memory_bytes"`
+	StartedAt       string   `json:"started_at,omitempty"`
+	StartedByRunner bool     `json:"started_by_runner"`
+	JobID           string   `json:"job_id,omitempty"`
+}
+
+// ProcessListResult is the response for process_list, ordered by PID.
+type ProcessListResult struct {
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// ProcessKillPayload is for process_kill requests. Signal is "term" (the
+// default), "int" or "kill"; Windows can only kill. Processes the runner
+// did not start are refused unless Force is set.
+type ProcessKillPayload struct {
+	PID    int    `json:"pid"`
+	Signal string `json:"signal,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+// WatchPayload is for watch requests: Paths are files or directories to
+// watch. Include and Exclude filter reported paths with glob patterns
+// (matched against the base name, or the path below the watched root if
+// the pattern contains "/"). Recursive defaults to true; IntervalMs is
+// the poll interval (default 1000, min 250).
+type WatchPayload struct {
+	Paths      []string `json:"paths"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	Recursive  *bool    `json:"recursive,omitempty"`
+	IntervalMs int      `json:"interval_ms,omitempty"`
+}
+
+// IsRecursive reports whether directories are watched recursively.
+func (p WatchPayload) IsRecursive() bool {
+	return p.Recursive == nil || *p.Recursive
+}
+
+// WatchResult is the response for watch. WatchID identifies the event
+// stream (it is the request ID).
+type WatchResult struct {
+	WatchID string `json:"watch_id"`
+}
+
+// FS event ops reported in FSEvent.Op.
+const (
+	FSCreate = "create"
+	FSModify = "modify"
+	FSDelete = "delete"
+	FSRename = "rename"
+)
+
+// FSEvent is one change to a watched path. OldPath is set for renames.
+type FSEvent struct {
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	IsDir   bool   `json:"is_dir,omitempty"`
+}
+
+// FSEventPayload is an "fs_event" message (runner → cloud, proactive)
+// carrying the changes seen in one poll. Done marks the end of the
+// stream, with Error if it failed.
+type FSEventPayload struct {
+	WatchID string    `json:"watch_id"`
+	Events  []FSEvent `json:"events,omitempty"`
+	Done    bool      `json:"done,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// UnwatchPayload is for unwatch requests.
+type UnwatchPayload struct {
+	WatchID string `json:"watch_id"`
+}
+
+// ApplyTemplatePayload is for workspace_apply_template requests. Path is
+// a new directory in the work dir to materialize Template into (an
+// existing non-empty one needs Overwrite); without Path a temp workspace
+// is created outside the work dir and removed when the runner stops or
+// by workspace_remove.
+type ApplyTemplatePayload struct {
+	Path      string            `json:"path,omitempty"`
+	Template  WorkspaceTemplate `json:"template"`
+	Overwrite bool              `json:"overwrite,omitempty"`
+}
+
+// WorkspaceTemplate declares a workspace: Dirs and Files to create, env
+// files to write, then Setup commands run in order from its root until
+// one fails. SetupTimeout applies to each command (seconds).
+type WorkspaceTemplate struct {
+	Dirs         []string       `json:"dirs,omitempty"`
+	Files        []TemplateFile `json:"files,omitempty"`
+	EnvFiles     []EnvFile      `json:"env_files,omitempty"`
+	Setup        []string       `json:"setup,omitempty"`
+	SetupTimeout int            `json:"setup_timeout,omitempty"`
+}
+
+// TemplateFile is a starter file: Content, or base64 Data for binary.
+type TemplateFile struct {
+	Path       string `json:"path"`
+	Content    string `json:"content,omitempty"`
+	Data       string `json:"data,omitempty"`
+	Executable bool   `json:"executable,omitempty"`
+}
+
+// EnvFile is a dotenv file of literal Vars plus Secrets, which map a
+// variable to the name of a secret configured on the runner
+// (templates.secrets); secret values never travel over the wire.
+type EnvFile struct {
+	Path    string            `json:"path"`
+	Vars    map[string]string `json:"vars,omitempty"`
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// ApplyTemplateResult is the response for workspace_apply_template. Root
+// is the workspace's wire path, or for a temp workspace (WorkspaceID set)
+// its absolute path on the runner host. Complete is false if a setup
+// command failed; Setup holds the commands that ran.
+type ApplyTemplateResult struct {
+	WorkspaceID string        `json:"workspace_id,omitempty"`
+	Root        string        `json:"root"`
+	Dirs        int           `json:"dirs"`
+	Files       int           `json:"files"`
+	Setup       []SetupResult `json:"setup,omitempty"`
+	Complete    bool          `json:"complete"`
+}
+
+// SetupResult is the outcome of one template setup command.
+type SetupResult struct {
+	Command string `json:"command"`
+	ExecResultPayload
+}
+
+// WorkspaceRemovePayload is for workspace_remove requests.
+type WorkspaceRemovePayload struct {
+	WorkspaceID string `json:"workspace_id"`
+}
+
+// SessionPayload is for session_start and session_end requests. A session
+// ID is 1–64 letters, digits, '-' or '_'.
+type SessionPayload struct {
+	SessionID string `json:"session_id"`
+}
+
+// SessionStartResult is the response for session_start: the session's
+// scratch directory (a wire path, created empty), its quota in bytes and
+// its budget. Starting a session that is already running returns the same
+// directory and resets its budget.
+type SessionStartResult struct {
+	SessionID  string        `json:"session_id"`
+	ScratchDir string        `json:"scratch_dir"`
+	QuotaBytes int64         `json:"quota_bytes"`
+	UsedBytes  int64         `json:"used_bytes"`
+	Budget     SessionBudget `json:"budget"`
+}
+
+// SessionBudget is a session's usage against the runner's per-session
+// limits. A zero maximum means no limit.
+type SessionBudget struct {
+	Requests       int     `json:"requests"`
+	MaxRequests    int     `json:"max_requests,omitempty"`
+	ExecSeconds    float64 `json:"exec_seconds"`
+	MaxExecSeconds float64 `json:"max_exec_seconds,omitempty"`
+}
+
+// BudgetExceededPayload is the payload for a "session_budget_exceeded"
+// event (runner → cloud, proactive), sent once when a session first
+// exceeds its budget. Its later requests fail with code
+// "budget_exceeded" until it is started again.
+type BudgetExceededPayload struct {
+	SessionID string        `json:"session_id"`
+	Reason    string        `json:"reason"`
+	Budget    SessionBudget `json:"budget"`
+}
+
+// SessionSummaryResult is the response for session_summary (payload
+// SessionPayload): what the session's requests did, from the audit log.
+// Paths lists the files and directories it accessed, hottest first; Dirs
+// rolls the same accesses up by parent directory.
+type SessionSummaryResult struct {
+	SessionID      string     `json:"session_id"`
+	Start          string     `json:"start,omitempty"` // RFC 3339
+	End            string     `json:"end,omitempty"`
+	Requests       int        `json:"requests"`
+	Failures       int        `json:"failures"`
+	Commands       int        `json:"commands"`
+	Paths          []PathHeat `json:"paths"`
+	Dirs           []PathHeat `json:"dirs"`
+	PathsTruncated bool       `json:"paths_truncated,omitempty"`
+}
+
+// PathHeat counts a session's accesses to one path.
+type PathHeat struct {
+	Path    string `json:"path"`
+	Reads   int    `json:"reads"`
+	Writes  int    `json:"writes"`
+	Deletes int    `json:"deletes"`
+	Last    string `json:"last"` // RFC 3339
+}
+
+// CheckPathsPayload is for check_paths requests: canonical paths relative
+// to Root that the sender intends to write (e.g. before a sync).
+type CheckPathsPayload struct {
+	Root  string   `json:"root"`
+	Paths []string `json:"paths"`
+}
+
+// PathConflict is a path that cannot be written here as-is. Kind is
+// "case_collision" (Other is the colliding incoming path), "case_rename"
+// (Other is the existing on-disk name), "reserved_name" or "invalid_name".
+type PathConflict struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"`
+	Other string `json:"other,omitempty"`
+}
+
+// CheckPathsResult is the response for check_paths.
+type CheckPathsResult struct {
+	Conflicts []PathConflict `json:"conflicts"`
 }
 
 // ListFilesPayload is for list_files requests.
+// Recursive descends into subdirectories, up to MaxDepth levels (default
+// 10) and MaxEntries entries (default 1000, max 10000). Tree nests each
+// directory's entries under its Children instead of listing them flat.
+// Pattern filters entries by a glob on their name; SortBy is "name"
+// (default), "size" or "mtime", and Reverse flips the order. Offset and
+// Limit page through the result (Limit 0 returns everything).
 type ListFilesPayload struct {
-	Path string `json:"path"`
+	Path       string `json:"path"`
+	Recursive  bool   `json:"recursive,omitempty"`
+	MaxDepth   int    `json:"max_depth,omitempty"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	Tree       bool   `json:"tree,omitempty"`
+	Pattern    string `json:"pattern,omitempty"`
+	SortBy     string `json:"sort_by,omitempty"`
+	Reverse    bool   `json:"reverse,omitempty"`
+	Offset     int    `json:"offset,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
 }
 
-// FileInfoResult represents a single file entry.
-type FileInfoResult struct {
+// DiskUsagePayload is for disk_usage requests. Path is a directory
+// (default the work dir).
+type DiskUsagePayload struct {
+	Path string `json:"path,omitempty"`
+}
+
+// DiskUsageResult is the response for disk_usage. Total, Used and Free
+// are the bytes of the volume holding Path; Free is the space available
+// to the runner, which leaves out space reserved for the superuser or
+// beyond a disk quota, so Used+Free may fall short of Total. Size is the
+// total of Entries, each an entry directly under Path, largest first;
+// Truncated means only the largest were listed.
+type DiskUsageResult struct {
+	Path      string     `json:"path"`
+	Total     int64      `json:"total"`
+	Used      int64      `json:"used"`
+	Free      int64      `json:"free"`
+	Size      int64      `json:"size"`
+	Entries   []DirUsage `json:"entries"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// DirUsage is the apparent size of the regular files in a file or
+// directory tree, and how many there are.
+type DirUsage struct {
 	Name  string `json:"name"`
-	Path  string `json:"path"`
 	IsDir bool   `json:"is_dir"`
-	Size  *int64 `json:"size,omitempty"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
 }
 
-// FindFilesPayload is for find_files requests.
+// LimitsInfoResult is the response for limits_info: the limits the
+// runner enforces on the requesting session's work, so an agent can plan
+// within them instead of discovering them through failures. Session is
+// set only for requests made on behalf of an agent session. ApprovalMode
+// means mutating requests are refused until the user resumes the runner.
+// A zero maximum means no limit.
+type LimitsInfoResult struct {
+	Session         *SessionLimits `json:"session,omitempty"`
+	ApprovalMode    bool           `json:"approval_mode"`
+	MaxPayloadBytes int64          `json:"max_payload_bytes"`
+	Exec            ExecLimits     `json:"exec"`
+	Search          SearchLimits   `json:"search"`
+	Files           FileLimits     `json:"files"`
+}
+
+// DescribeResult is the response for describe: a schema for the payload
+// and result of every request type the runner handles, and the limits
+// that apply to the requesting session. Payload is absent for request
+// types that take none; Mutating request types change the machine. They
+// are refused in approval mode, as are requests that open tunnels or read
+// out file contents in bulk.
+type DescribeResult struct {
+	Requests []RequestSchema  `json:"requests"`
+	Limits   LimitsInfoResult `json:"limits"`
+}
+
+// RequestSchema describes one request type.
+type RequestSchema struct {
+	Type     string  `json:"type"`
+	Mutating bool    `json:"mutating"`
+	Payload  *Schema `json:"payload,omitempty"`
+	Result   *Schema `json:"result"`
+}
+
+// SessionLimits is what an agent session has left of its scratch quota
+// and budget.
+type SessionLimits struct {
+	SessionID  string        `json:"session_id"`
+	ScratchDir string        `json:"scratch_dir"`
+	QuotaBytes int64         `json:"quota_bytes,omitempty"`
+	UsedBytes  int64         `json:"used_bytes"`
+	Budget     SessionBudget `json:"budget"`
+}
+
+// ExecLimits are the limits on exec: the default timeout in seconds of
+// each command class ("default" for unclassified commands), the classes
+// run without network access, the bytes kept of each output stream and
+// the largest work dir a snapshot run copies.
+type ExecLimits struct {
+	TimeoutSeconds   map[string]int `json:"timeout_seconds"`
+	NetworkDenied    []string       `json:"network_denied,omitempty"`
+	MaxOutputBytes   int64          `json:"max_output_bytes"`
+	SnapshotMaxBytes int64          `json:"snapshot_max_bytes"`
+	// Resources are the default and maximum resource limits of a command.
+	Resources *ResourceLimits `json:"resources,omitempty"`
+}
+
+// SearchLimits are the defaults find_files and search_in_files apply when
+// a request sets no limit, and the most a request may ask for.
+type SearchLimits struct {
+	DefaultFindResults   int   `json:"default_find_results"`
+	MaxFindResults       int   `json:"max_find_results,omitempty"`
+	DefaultSearchResults int   `json:"default_search_results"`
+	MaxSearchResults     int   `json:"max_search_results,omitempty"`
+	DefaultFileSize      int64 `json:"default_file_size"`
+	MaxFileSize          int64 `json:"max_file_size,omitempty"`
+}
+
+// FileLimits are the fixed limits of file requests.
+type FileLimits struct {
+	MaxReadFiles    int   `json:"max_read_files"`
+	MaxListEntries  int   `json:"max_list_entries"`
+	MaxDiffBytes    int64 `json:"max_diff_bytes"`
+	MaxTailBytes    int64 `json:"max_tail_bytes"`
+	MaxTailLines    int   `json:"max_tail_lines"`
+	MaxWatchPaths   int   `json:"max_watch_paths"`
+	MaxExtractBytes int64 `json:"max_extract_bytes"`
+}
+
+// ListFilesResult is the response for list_files. Total counts the
+// entries before paging and HasMore means entries remain past this page.
+// Truncated means MaxEntries was reached before the walk finished.
+type ListFilesResult struct {
+	Files     []FileInfoResult `json:"files"`
+	Total     int              `json:"total"`
+	HasMore   bool             `json:"has_more"`
+	Truncated bool             `json:"truncated,omitempty"`
+}
+
+// FileInfoResult represents a single file entry. Children is only set in
+// tree listings.
+type FileInfoResult struct {
+	Name     string           `json:"name"`
+	Path     string           `json:"path"`
+	IsDir    bool             `json:"is_dir"`
+	Size     *int64           `json:"size,omitempty"`
+	ModTime  string           `json:"mod_time,omitempty"` // RFC 3339
+	Children []FileInfoResult `json:"children,omitempty"`
+}
+
+// FindFilesPayload is for find_files requests. Pattern is a glob; one
+// containing "/" is matched against the path relative to Root, with "**"
+// matching any number of directories (e.g. "src/**/*.test.ts"), and one
+// without is matched against file names.
+// RespectGitignore (default true) skips paths ignored by .gitignore files
+// and a built-in list of dependency, cache and VCS directories.
 type FindFilesPayload struct {
-	Root    string `json:"root"`
-	Pattern string `json:"pattern"`
+	Root             string `json:"root"`
+	Pattern          string `json:"pattern"`
+	RespectGitignore *bool  `json:"respect_gitignore,omitempty"`
+	// Exclude lists gitignore-style patterns, relative to Root, of paths
+	// to skip whether or not RespectGitignore is set (e.g. "dist/",
+	// "*.min.js"). A matching directory is pruned without being walked.
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxResults caps the files returned (default 1000), up to the
+	// runner's configured ceiling.
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+// FindFilesResult is the result of a find_files request. Truncated is set
+// when more files matched than MaxResults allowed.
+type FindFilesResult struct {
+	Files     []string `json:"files"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// IgnoreEnabled reports whether ignored paths are skipped.
+func (p FindFilesPayload) IgnoreEnabled() bool {
+	return p.RespectGitignore == nil || *p.RespectGitignore
 }
 
 // SearchPayload is for search_in_files requests.
 type SearchPayload struct {
 	Root    string `json:"root"`
 	Pattern string `json:"pattern"`
+	// Include restricts the search to files matching a glob, as for
+	// FindFilesPayload.Pattern.
 	Include string `json:"include,omitempty"`
+	// Stream delivers matches in batches as "search_progress" events while
+	// the walk is running; the final result then carries only a summary.
+	Stream    bool `json:"stream,omitempty"`
+	BatchSize int  `json:"batch_size,omitempty"` // matches per batch (default 20)
+	// RespectGitignore and Exclude are as for FindFilesPayload.
+	RespectGitignore *bool    `json:"respect_gitignore,omitempty"`
+	Exclude          []string `json:"exclude,omitempty"`
+	// Mode is "regex" (the default, Go RE2 syntax) or "literal".
+	Mode            string `json:"mode,omitempty"`
+	CaseInsensitive bool   `json:"case_insensitive,omitempty"`
+	// WordBoundary matches Pattern only as a whole word.
+	WordBoundary bool `json:"word_boundary,omitempty"`
+	// MaxResults caps the matches returned (default 200) and MaxFileSize
+	// the size in bytes of the files scanned (default 10 MB), each up to
+	// the runner's configured ceiling.
+	MaxResults  int   `json:"max_results,omitempty"`
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+}
+
+// SearchResult is the result of a search_in_files request that is not
+// streamed. Truncated is set when more lines matched than MaxResults
+// allowed.
+type SearchResult struct {
+	Matches   []SearchMatchResult `json:"matches"`
+	Truncated bool                `json:"truncated,omitempty"`
+}
+
+// IgnoreEnabled reports whether ignored paths are skipped.
+func (p SearchPayload) IgnoreEnabled() bool {
+	return p.RespectGitignore == nil || *p.RespectGitignore
+}
+
+// SearchProgressPayload is the payload for a "search_progress" event
+// (runner → cloud, proactive) carrying a batch of streamed matches.
+type SearchProgressPayload struct {
+	RequestID string              `json:"request_id"`
+	Batch     int                 `json:"batch"` // 1-based sequence number
+	Matches   []SearchMatchResult `json:"matches"`
+}
+
+// SearchSummaryResult is the final search_in_files result for a streamed
+// search. DroppedMatches counts the matches, of TotalMatches, in batches
+// that could not be sent because the connection stalled; their batch
+// numbers are missing from the search_progress sequence.
+type SearchSummaryResult struct {
+	Streamed       bool `json:"streamed"`
+	TotalMatches   int  `json:"total_matches"`
+	Batches        int  `json:"batches"`
+	Truncated      bool `json:"truncated,omitempty"`
+	DroppedMatches int  `json:"dropped_matches,omitempty"`
 }
 
 // SearchMatchResult represents a single search match.
@@ -75,18 +1359,355 @@ type SearchMatchResult struct {
 	File    string `json:"file"`
 	Line    int    `json:"line"`
 	Content string `json:"content"`
+	// Links lists the references to workspace files found in Content.
+	Links []FileLink `json:"links,omitempty"`
+}
+
+// ManifestEntry describes one regular file in a transfer manifest. Path is
+// slash-separated and relative to the manifest root.
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestPayload is for "manifest" requests.
+type ManifestPayload struct {
+	Root string `json:"root"`
+}
+
+// ManifestResult is the response for manifest. Digest is the SHA-256 of
+// the canonical manifest (entries sorted by path, one
+// "path\x00size\x00sha256\n" line each), so two ends can compare a
+// multi-thousand-file tree with a single value.
+type ManifestResult struct {
+	Root    string          `json:"root"`
+	Entries []ManifestEntry `json:"entries"`
+	Files   int             `json:"files"`
+	Bytes   int64           `json:"bytes"`
+	Digest  string          `json:"digest"`
+}
+
+// VerifyManifestPayload is for "verify_manifest" requests: the sender's
+// manifest for files it transferred under Root.
+type VerifyManifestPayload struct {
+	Root    string          `json:"root"`
+	Entries []ManifestEntry `json:"entries"`
+	// Digest, if set, is checked against the digest of Entries to catch a
+	// manifest that was itself damaged in transit.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ManifestMismatch is a file whose size or checksum differs.
+type ManifestMismatch struct {
+	Path     string        `json:"path"`
+	Expected ManifestEntry `json:"expected"`
+	Actual   ManifestEntry `json:"actual"`
+}
+
+// VerifyManifestResult is the response for verify_manifest. OK is true only
+// if every entry is present and matches.
+type VerifyManifestResult struct {
+	OK         bool               `json:"ok"`
+	Checked    int                `json:"checked"`
+	Missing    []string           `json:"missing,omitempty"`
+	Mismatched []ManifestMismatch `json:"mismatched,omitempty"`
+	Digest     string             `json:"digest"`
+}
+
+// ActivityPayload is an "activity" event: a workspace change or command
+// caused by a request, for the live activity timeline. Kind is one of
+// file_created, file_modified, file_deleted, file_moved, file_copied
+// (From is the source), dir_created, dir_removed, archive_extracted (Path
+// is the destination), command_run (Command is redacted) or job_finished.
+type ActivityPayload struct {
+	Kind       string `json:"kind"`
+	Path       string `json:"path,omitempty"`
+	From       string `json:"from,omitempty"`
+	Command    string `json:"command,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Time       string `json:"time"`
 }
 
 // InfoPayload is sent by the runner on connect.
 type InfoPayload struct {
-	OS          string   `json:"os"`
-	WorkDir     string   `json:"work_dir"`
-	PTYSessions []string `json:"pty_sessions,omitempty"`
+	OS          string        `json:"os"`
+	WorkDir     string        `json:"work_dir"`
+	PTYSessions []string      `json:"pty_sessions,omitempty"`
+	Identity    *IdentityInfo `json:"identity,omitempty"`
+	// E2E is the runner's end-to-end encryption mode: "off", "optional"
+	// or "required".
+	E2E string `json:"e2e,omitempty"`
+	// ContentEncodings lists the payload encodings the runner accepts and
+	// can produce (see Request.AcceptEncoding).
+	ContentEncodings []string `json:"content_encodings,omitempty"`
+	// Recovered lists resources left by a previous runner process that
+	// exited uncleanly, so the backend can reconcile its state.
+	Recovered []RecoveredItem `json:"recovered,omitempty"`
+	// DryRun is set when the runner only describes side effects (see
+	// DryRunResult).
+	DryRun bool `json:"dry_run,omitempty"`
+	// Hardware lists the runner's GPUs, detected when it first connects;
+	// hw_info detects them afresh.
+	Hardware *HWInfo `json:"hardware,omitempty"`
+}
+
+// RecoveredItem is a PTY session, job, temp workspace or agent session
+// found at startup.
+// Action is "terminated", "exited", "adopted", "reattached" (a PTY
+// session kept in tmux, listed again in PTYSessions), "cleaned" or
+// "orphaned" (found but could not be cleaned up).
+type RecoveredItem struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	PID    int    `json:"pid,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Action string `json:"action"`
+}
+
+// IdentityInfo describes the runner's persistent identity.
+type IdentityInfo struct {
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	Machine     string `json:"machine,omitempty"`
+	DeviceName  string `json:"device_name,omitempty"`
+}
+
+// StatusPayload is the response for a "status" request.
+type StatusPayload struct {
+	Workers       int  `json:"workers"`
+	ActiveWorkers int  `json:"active_workers"`
+	QueueDepth    int  `json:"queue_depth"`
+	QueueCapacity int  `json:"queue_capacity"`
+	PTYSessions   int  `json:"pty_sessions"`
+	ApprovalMode  bool `json:"approval_mode"`
+}
+
+// PingPayload is the optional payload of a runner heartbeat ("ping").
+type PingPayload struct {
+	Sensors *SensorsResult `json:"sensors,omitempty"`
+}
+
+// SensorsResult is the response for sensors_read, and the reading
+// heartbeats carry: hardware temperatures and fan speeds, and per-GPU
+// readings from nvidia-smi. Hot is set when a temperature reaches its
+// sensor's high or critical threshold, or the runner's configured
+// maximum, so agents can throttle work. Errors lists sources that could
+// not be read.
+type SensorsResult struct {
+	Time         string        `json:"time"`
+	Temperatures []Temperature `json:"temperatures,omitempty"`
+	Fans         []FanSpeed    `json:"fans,omitempty"`
+	GPUs         []GPUSensors  `json:"gpus,omitempty"`
+	MaxCelsius   float64       `json:"max_celsius,omitempty"`
+	Hot          bool          `json:"hot"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+// Temperature is one temperature sensor. Source names the chip or
+// driver, e.g. "coretemp" or "smc"; High and Critical are the sensor's
+// thresholds, if it reports them.
+type Temperature struct {
+	Source   string  `json:"source"`
+	Label    string  `json:"label"`
+	Celsius  float64 `json:"celsius"`
+	High     float64 `json:"high,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// FanSpeed is one fan.
+type FanSpeed struct {
+	Source string `json:"source"`
+	Label  string `json:"label"`
+	RPM    int    `json:"rpm"`
+}
+
+// GPUSensors is the reading of one GPU. Values the GPU does not report
+// are omitted.
+type GPUSensors struct {
+	Index       int      `json:"index"`
+	Name        string   `json:"name"`
+	Celsius     *float64 `json:"celsius,omitempty"`
+	FanPercent  *float64 `json:"fan_percent,omitempty"`
+	PowerWatts  *float64 `json:"power_watts,omitempty"`
+	Utilization *float64 `json:"utilization,omitempty"`
+}
+
+// HWInfo is the response for hw_info, and the hardware the info message
+// reports: the machine's GPUs and the CUDA versions available, so the
+// cloud can route GPU work to runners that can run it. CUDADriverVersion
+// is the newest CUDA version the NVIDIA driver supports and
+// CUDAToolkitVersion that of the installed nvcc. Errors lists sources
+// that could not be read.
+type HWInfo struct {
+	GPUs               []GPUInfo `json:"gpus,omitempty"`
+	CUDADriverVersion  string    `json:"cuda_driver_version,omitempty"`
+	CUDAToolkitVersion string    `json:"cuda_toolkit_version,omitempty"`
+	Errors             []string  `json:"errors,omitempty"`
+}
+
+// GPUInfo is one GPU. Index is nvidia-smi's index for NVIDIA GPUs and the
+// position among the others; API is the compute API it offers, "cuda" or
+// "metal". VRAMFreeBytes is reported for NVIDIA GPUs only. A GPU with
+// UnifiedMemory, such as Apple silicon's, shares the system's memory,
+// which VRAMBytes then is.
+type GPUInfo struct {
+	Index             int    `json:"index"`
+	Name              string `json:"name"`
+	Vendor            string `json:"vendor"`
+	API               string `json:"api,omitempty"`
+	UUID              string `json:"uuid,omitempty"`
+	DriverVersion     string `json:"driver_version,omitempty"`
+	ComputeCapability string `json:"compute_capability,omitempty"`
+	MetalFamily       string `json:"metal_family,omitempty"`
+	Cores             int    `json:"cores,omitempty"`
+	VRAMBytes         int64  `json:"vram_bytes,omitempty"`
+	VRAMFreeBytes     int64  `json:"vram_free_bytes,omitempty"`
+	UnifiedMemory     bool   `json:"unified_memory,omitempty"`
+}
+
+// WolSendPayload is for wol_send requests, which send a Wake-on-LAN
+// magic packet to a machine named in the runner's power config. With
+// Wait, the runner then probes the machine for up to Wait seconds (max
+// 300) and reports whether it came up.
+type WolSendPayload struct {
+	Machine string `json:"machine"`
+	Wait    int    `json:"wait,omitempty"`
+}
+
+// WolSendResult is the response for wol_send. Status is set with Wait.
+type WolSendResult struct {
+	Machine string       `json:"machine"`
+	MAC     string       `json:"mac"`
+	Status  *PowerStatus `json:"status,omitempty"`
+}
+
+// PowerStatusPayload is for power_status requests. An empty Machine
+// checks every configured machine that has a host.
+type PowerStatusPayload struct {
+	Machine string `json:"machine,omitempty"`
+}
+
+// PowerStatusResult is the response for power_status, sorted by machine.
+type PowerStatusResult struct {
+	Machines []PowerStatus `json:"machines"`
 }
 
-// ErrorPayload for error responses.
+// PowerStatus is whether a machine answered a probe of its TCP ports.
+// LatencyMs is how long the first answer took.
+type PowerStatus struct {
+	Machine   string `json:"machine"`
+	Host      string `json:"host"`
+	Up        bool   `json:"up"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+}
+
+// AuditQueryPayload is for audit_query requests, which read the runner's
+// local audit log. Since and Until are RFC 3339 times; Path matches
+// events on that path or under it. Empty fields match everything.
+type AuditQueryPayload struct {
+	Since     string `json:"since,omitempty"`
+	Until     string `json:"until,omitempty"`
+	Type      string `json:"type,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Limit     int    `json:"limit,omitempty"` // most recent matches (default 100, max 1000)
+	// IncludeDiffs returns the content diffs recorded for writes.
+	IncludeDiffs bool `json:"include_diffs,omitempty"`
+}
+
+// AuditQueryResult is the response for audit_query, oldest event first.
+// Truncated is set when older matches were left out.
+type AuditQueryResult struct {
+	Events    []AuditEvent `json:"events"`
+	Truncated bool         `json:"truncated,omitempty"`
+}
+
+// AuditEvent is one audit log entry.
+type AuditEvent struct {
+	Time          string `json:"time"` // RFC 3339
+	RequestID     string `json:"request_id,omitempty"`
+	Type          string `json:"type"`
+	Target        string `json:"target,omitempty"`
+	Success       bool   `json:"success"`
+	Error         string `json:"error,omitempty"`
+	DurationMs    int64  `json:"duration_ms"`
+	Diff          string `json:"diff,omitempty"`
+	DiffTruncated bool   `json:"diff_truncated,omitempty"`
+}
+
+// SecurityAlertPayload is the payload for a "security_alert" event
+// (runner → cloud, proactive).
+type SecurityAlertPayload struct {
+	Kind      string `json:"kind"` // e.g. "canary"
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Path      string `json:"path,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Time      string `json:"time"` // RFC 3339
+}
+
+// PolicyViolationPayload is the error payload (code "policy_violation")
+// of an exec, run_script or job_start request the runner's command
+// policy refused. Rule names the rule that denied it and Reason why;
+// Command is the command (for run_script, the script) as redacted. The
+// cloud can ask the user to approve it and send policy_approve with
+// ApprovalID: the same command from the same session then runs once if
+// sent again, as a new request, before ExpiresAt.
+type PolicyViolationPayload struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	Command    string `json:"command"`
+	Rule       string `json:"rule"`
+	Reason     string `json:"reason"`
+	ApprovalID string `json:"approval_id"`
+	ExpiresAt  string `json:"expires_at"` // RFC 3339
+}
+
+// PolicyApprovePayload is for policy_approve requests, sent when the user
+// approves a command the policy refused.
+type PolicyApprovePayload struct {
+	ApprovalID string `json:"approval_id"`
+}
+
+// RequestStats aggregates handling of one request type.
+type RequestStats struct {
+	Count   int   `json:"count"`
+	Errors  int   `json:"errors"`
+	TotalMs int64 `json:"total_ms"`
+	MaxMs   int64 `json:"max_ms"`
+}
+
+// TelemetryPayload is the payload for a "runner_telemetry" event
+// (runner → cloud, proactive, opt-in).
+type TelemetryPayload struct {
+	PeriodStart   string                  `json:"period_start"` // RFC 3339
+	PeriodEnd     string                  `json:"period_end"`
+	Requests      map[string]RequestStats `json:"requests"`
+	AuditEvents   int                     `json:"audit_events"`
+	AuditFailures int                     `json:"audit_failures"`
+	AuditByType   map[string]int          `json:"audit_by_type"`
+	QueueDepth    int                     `json:"queue_depth"`
+	ActiveWorkers int                     `json:"active_workers"`
+	PTYSessions   int                     `json:"pty_sessions"`
+}
+
+// NotifyPayload is the payload for a "notify" message (cloud → runner).
+// Notifications are one-way operational signals and get no response.
+type NotifyPayload struct {
+	Topic   string          `json:"topic"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// ErrorPayload for error responses. Code is a stable machine-readable
+// reason for errors the cloud is expected to act on.
 type ErrorPayload struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 // --- PTY (terminal session) payloads ---
@@ -98,34 +1719,367 @@ type PTYCreatePayload struct {
 	Args      []string `json:"args,omitempty"`
 	Cols      uint16   `json:"cols,omitempty"`
 	Rows      uint16   `json:"rows,omitempty"`
+	// Cwd is the directory the session starts in, relative to the work
+	// dir (the default) and checked as an exec's cwd is. Env sets
+	// environment variables over the runner's own environment.
+	Cwd string            `json:"cwd,omitempty"`
+	Env map[string]string `json:"env,omitempty"`
+	// Record, if set, overrides the runner's pty.record setting for this
+	// session (see PTYRecording).
+	Record *bool `json:"record,omitempty"`
+}
+
+// PTYCreateResult is the payload for a "pty_create_result" response.
+// RecordingID is set if the session is being recorded.
+type PTYCreateResult struct {
+	RecordingID string `json:"recording_id,omitempty"`
+}
+
+// PTYLimitPayload is the error payload of a "pty_create_result" refused
+// because the runner already has MaxSessions sessions; Sessions lists
+// them, so the cloud can close one.
+type PTYLimitPayload struct {
+	Error       string   `json:"error"`
+	Code        string   `json:"code"` // "pty_session_limit"
+	MaxSessions int      `json:"max_sessions"`
+	Sessions    []string `json:"sessions"`
 }
 
 // PTYInputPayload is the payload for a "pty_input" message (cloud → runner).
+// ViewerID is empty for the session owner.
 type PTYInputPayload struct {
 	SessionID string `json:"session_id"`
+	ViewerID  string `json:"viewer_id,omitempty"`
 	Data      string `json:"data"` // raw terminal input (base64)
 }
 
 // PTYOutputPayload is the payload for a "pty_output" message (runner → cloud, proactive).
+// Viewers lists the attached viewers the output should be fanned out to,
+// in addition to the session owner.
 type PTYOutputPayload struct {
+	SessionID string   `json:"session_id"`
+	Data      string   `json:"data"` // raw terminal output (base64)
+	Viewers   []string `json:"viewers,omitempty"`
+}
+
+// PTYAttachPayload is the payload for a "pty_attach" request.
+type PTYAttachPayload struct {
+	SessionID string `json:"session_id"`
+	ViewerID  string `json:"viewer_id"`
+	ReadOnly  bool   `json:"read_only"`
+}
+
+// PTYDetachPayload is the payload for a "pty_detach" request.
+type PTYDetachPayload struct {
 	SessionID string `json:"session_id"`
-	Data      string `json:"data"` // raw terminal output (base64)
+	ViewerID  string `json:"viewer_id"`
 }
 
 // PTYResizePayload is the payload for a "pty_resize" request.
+// ViewerID identifies which attached viewer reported the size; a zero
+// Cols/Rows removes that viewer's geometry.
 type PTYResizePayload struct {
 	SessionID string `json:"session_id"`
+	ViewerID  string `json:"viewer_id,omitempty"`
 	Cols      uint16 `json:"cols"`
 	Rows      uint16 `json:"rows"`
 }
 
-// PTYClosePayload is the payload for a "pty_close" request.
+// PTYClosePayload is the payload for a "pty_close" request, which kills
+// the session's program. With Detach, the session is closed but its
+// program keeps running as a background job instead (see PTYCloseResult):
+// its output goes to the job's log and job_kill ends it. A program on the
+// tmux backend stays in tmux and outlives the runner; any other still
+// ends with the runner. No "pty_exit" is sent for a detached session.
 type PTYClosePayload struct {
 	SessionID string `json:"session_id"`
+	Detach    bool   `json:"detach,omitempty"`
+}
+
+// PTYCloseResult is the payload for a "pty_close_result" response. Job is
+// set with Detach: the job the session's program carries on as.
+type PTYCloseResult struct {
+	Job *JobInfo `json:"job,omitempty"`
+}
+
+// PTYSignalPayload is the payload for a "pty_signal" request. Signal is
+// "term" (the default), "int" or "kill", sent to the session's process
+// group and the terminal's foreground process group; on Windows every
+// signal ends the session's process.
+type PTYSignalPayload struct {
+	SessionID string `json:"session_id"`
+	Signal    string `json:"signal,omitempty"`
 }
 
 // PTYExitPayload is the payload for a "pty_exit" event (runner → cloud, proactive).
+// Reason is set if the runner closed the session itself: "idle_timeout"
+// if it had no input or output for longer than pty.idle_timeout, or
+// "evicted" if it was the least recently active session when pty_create
+// found the runner at pty.max_sessions with pty.on_limit "evict_lru".
+//
+// Signaled is set if a signal killed the program, and Signal names it,
+// e.g. "SIGINT" (ExitCode is then -1). With the tmux backend the shell
+// running the program reports this, as exit code 128+n.
 type PTYExitPayload struct {
 	SessionID string `json:"session_id"`
 	ExitCode  int    `json:"exit_code"`
+	Signaled  bool   `json:"signaled,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// PTYRecordingListPayload is the payload for a "pty_recording_list"
+// request. SessionID, if set, lists only that session's recordings.
+type PTYRecordingListPayload struct {
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// PTYRecording describes a recorded PTY session: an asciicast v2 file
+// (https://docs.asciinema.org/manual/asciicast/v2/) of the session's
+// output, as redacted for pty_output, and its resizes, under Path in the
+// work dir. Input is not recorded. Active is set while the session runs.
+type PTYRecording struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Cols      int    `json:"cols"`
+	Rows      int    `json:"rows"`
+	StartedAt string `json:"started_at"`
+	Active    bool   `json:"active,omitempty"`
+}
+
+// PTYRecordingListResult is the payload for a "pty_recording_list_result"
+// response, newest first.
+type PTYRecordingListResult struct {
+	Recordings []PTYRecording `json:"recordings"`
+}
+
+// PTYRecordingFetchPayload is the payload for a "pty_recording_fetch"
+// request. It reads up to MaxBytes (default and max 1 MB) of the
+// recording from Offset.
+type PTYRecordingFetchPayload struct {
+	RecordingID string `json:"recording_id"`
+	Offset      int64  `json:"offset,omitempty"`
+	MaxBytes    int    `json:"max_bytes,omitempty"`
+}
+
+// PTYRecordingFetchResult is the payload for a "pty_recording_fetch_result"
+// response. Data ends at a line break unless EOF is set or a single line
+// is longer than MaxBytes, so each chunk holds whole events; the next
+// chunk starts at NextOffset.
+type PTYRecordingFetchResult struct {
+	RecordingID string `json:"recording_id"`
+	Data        string `json:"data"`
+	Offset      int64  `json:"offset"`
+	NextOffset  int64  `json:"next_offset"`
+	Size        int64  `json:"size"`
+	EOF         bool   `json:"eof"`
+}
+
+// PTYSnapshotPayload is the payload for a "pty_snapshot" request.
+type PTYSnapshotPayload struct {
+	SessionID string `json:"session_id"`
+}
+
+// PTYSnapshotResult is the payload for a "pty_snapshot_result" response:
+// the session's screen as it is now. Data (base64, like pty_output's)
+// redraws it, colors included, when written to a freshly reset terminal
+// of Cols x Rows, so a viewer can show it and then apply pty_output as it
+// comes; Lines is the same screen as plain text. CursorRow and CursorCol
+// are zero-based.
+type PTYSnapshotResult struct {
+	SessionID    string   `json:"session_id"`
+	Cols         int      `json:"cols"`
+	Rows         int      `json:"rows"`
+	CursorRow    int      `json:"cursor_row"`
+	CursorCol    int      `json:"cursor_col"`
+	CursorHidden bool     `json:"cursor_hidden,omitempty"`
+	AltScreen    bool     `json:"alt_screen,omitempty"`
+	Lines        []string `json:"lines"`
+	Data         string   `json:"data"`
+}
+
+// --- Tunnel (socket / named pipe forwarding) payloads ---
+
+// TunnelOpenPayload is the payload for a "tunnel_open" request. Target is
+// an allowlisted address such as "unix:///var/run/docker.sock" or
+// "npipe://docker_engine".
+type TunnelOpenPayload struct {
+	TunnelID string `json:"tunnel_id"`
+	Target   string `json:"target"`
+}
+
+// TunnelDataPayload is the payload for a "tunnel_data" message, sent in
+// both directions without a response.
+type TunnelDataPayload struct {
+	TunnelID string `json:"tunnel_id"`
+	Data     string `json:"data"` // base64
+}
+
+// TunnelClosePayload is the payload for a "tunnel_close" request.
+type TunnelClosePayload struct {
+	TunnelID string `json:"tunnel_id"`
+}
+
+// TunnelClosedPayload is the payload for a "tunnel_closed" event
+// (runner → cloud, proactive).
+type TunnelClosedPayload struct {
+	TunnelID string `json:"tunnel_id"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DisplayOpenPayload is the payload for a "display_open" request. The
+// display stream is carried over the tunnel TunnelID (tunnel_data /
+// tunnel_close / tunnel_closed).
+type DisplayOpenPayload struct {
+	TunnelID string `json:"tunnel_id"`
+}
+
+// DisplayOpenResult is the payload for a "display_open_result" response.
+// Protocol is "rfb" (VNC) or "xpra".
+type DisplayOpenResult struct {
+	TunnelID string `json:"tunnel_id"`
+	Protocol string `json:"protocol"`
+}
+
+// --- End-to-end encryption payloads ---
+
+// E2EInitPayload is the payload for an "e2e_init" request. PublicKey is the
+// frontend's base64 X25519 public key; the relay cannot derive the key.
+type E2EInitPayload struct {
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// E2EInitResult is the payload for an "e2e_init_result" response.
+// VerifyCode is derived from the shared secret so both ends can display it
+// and users can compare.
+type E2EInitResult struct {
+	KeyID      string `json:"key_id"`
+	Scheme     string `json:"scheme"`
+	PublicKey  string `json:"public_key"`
+	VerifyCode string `json:"verify_code"`
+}
+
+// --- Chunked transfer payloads ---
+
+// SpilledPayload replaces a response payload that exceeded the runner's
+// size cap. The original payload (JSON) is fetched with transfer_read.
+type SpilledPayload struct {
+	Spilled     bool   `json:"spilled"`
+	TransferID  string `json:"transfer_id"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+}
+
+// TransferPayload is for transfer_stat and transfer_delete requests.
+type TransferPayload struct {
+	TransferID string `json:"transfer_id"`
+}
+
+// TransferInfo is the response for transfer_stat.
+type TransferInfo struct {
+	TransferID  string `json:"transfer_id"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type"`
+	Complete    bool   `json:"complete"`
+	// MaxChunk is the largest chunk transfer_read returns and
+	// transfer_write accepts.
+	MaxChunk int `json:"max_chunk"`
+	// MaxParallel is how many chunk requests per transfer the runner
+	// serves concurrently; more are queued.
+	MaxParallel int `json:"max_parallel"`
+}
+
+// ArchiveDirPayload is for archive_dir requests. Format is "tar.gz"
+// (default) or "zip". Exclude holds glob patterns; one containing "/" is
+// matched against the path relative to Path, otherwise against each name.
+type ArchiveDirPayload struct {
+	Path    string   `json:"path"`
+	Format  string   `json:"format,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ArchiveStats summarizes an archive: Files and Bytes count the regular
+// files stored (uncompressed), Excluded the entries skipped by patterns.
+type ArchiveStats struct {
+	Format   string `json:"format"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Excluded int    `json:"excluded,omitempty"`
+}
+
+// ArchiveDirResult is the response for archive_dir. The archive is held
+// as a transfer; fetch it with transfer_read and delete it afterwards.
+type ArchiveDirResult struct {
+	TransferInfo
+	ArchiveStats
+}
+
+// ExtractArchivePayload is for extract_archive requests. The archive is
+// a committed upload (TransferID, deleted after extraction) or a
+// workspace file (Source).
+type ExtractArchivePayload struct {
+	TransferID  string `json:"transfer_id,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite,omitempty"`
+}
+
+// ExtractStats is the response for extract_archive. Links counts symbolic
+// and hard links; Skipped counts entries such as devices that were not
+// extracted. On failure, the counts cover what was extracted so far.
+type ExtractStats struct {
+	Format  string `json:"format"`
+	Files   int    `json:"files"`
+	Dirs    int    `json:"dirs"`
+	Links   int    `json:"links,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	Skipped int    `json:"skipped,omitempty"`
+}
+
+// TransferReadPayload is for transfer_read requests.
+type TransferReadPayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`
+	Length     int    `json:"length,omitempty"`
+}
+
+// TransferChunk is the response for transfer_read.
+type TransferChunk struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`
+	Data       string `json:"data"` // base64
+	EOF        bool   `json:"eof"`
+	// SHA256 of the decoded chunk, so corruption is caught per chunk.
+	SHA256 string `json:"sha256"`
+}
+
+// TransferCreatePayload starts a chunked upload (cloud → runner) of Size
+// bytes whose content hashes to SHA256.
+type TransferCreatePayload struct {
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// TransferWritePayload is one upload chunk. Chunks may be sent in
+// parallel and in any order.
+type TransferWritePayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`
+	Data       string `json:"data"` // base64
+	SHA256     string `json:"sha256"`
+}
+
+// TransferCommitPayload completes an upload and moves the reassembled
+// file to Path in the workspace. Without Path the upload stays in the
+// transfer store, e.g. for extract_archive.
+type TransferCommitPayload struct {
+	TransferID string `json:"transfer_id"`
+	Path       string `json:"path"`
+	Overwrite  bool   `json:"overwrite,omitempty"`
 }
@@ -0,0 +1,155 @@
+package redact
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// placeholder replaces every redacted secret.
+const placeholder = "[REDACTED]"
+
+// rule is a pattern plus the replacement template applied to each match.
+// Templates may reference capture groups to keep non-secret context
+// (e.g. the "API_KEY=" prefix) visible.
+type rule struct {
+	re   *regexp.Regexp
+	repl []byte
+}
+
+// defaultRules cover credentials commonly printed by `env`, CLI tools and
+// build logs.
+var defaultRules = []rule{
+	// PEM private key blocks
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), []byte(placeholder)},
+	// AWS access key IDs
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), []byte(placeholder)},
+	// GitHub tokens
+	{regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), []byte(placeholder)},
+	// OpenAI / Anthropic style secret keys
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{20,}`), []byte(placeholder)},
+	// Slack tokens
+	{regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`), []byte(placeholder)},
+	// Google API keys
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`), []byte(placeholder)},
+	// JSON Web Tokens
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{8,}\.eyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`), []byte(placeholder)},
+	// Bearer credentials in headers
+	{regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9_\-.=+/]{16,}`), []byte("${1}" + placeholder)},
+	// KEY=value / key: value assignments whose name looks sensitive
+	{regexp.MustCompile(`(?i)(\b[A-Za-z0-9_]*(?:api[_-]?key|secret|token|passw(?:or)?d|credential)[A-Za-z0-9_]*\s*[=:]\s*)(["']?)[^\s"']{6,}`), []byte("${1}${2}" + placeholder)},
+}
+
+// Redactor scrubs secrets from byte streams before they leave the machine.
+// A nil *Redactor is valid and performs no redaction.
+type Redactor struct {
+	rules    []rule
+	literals [][]byte
+}
+
+// New returns a Redactor using the built-in rules plus extra regular
+// expressions whose whole match is redacted.
+func New(extra []string) (*Redactor, error) {
+	r := &Redactor{rules: append([]rule(nil), defaultRules...)}
+	for _, p := range extra {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.rules = append(r.rules, rule{re: re, repl: []byte(placeholder)})
+	}
+	return r, nil
+}
+
+// AddLiteral registers an exact secret value (e.g. the runner's own token)
+// to be redacted wherever it appears. Values shorter than 6 bytes are
+// ignored to avoid mangling ordinary output.
+func (r *Redactor) AddLiteral(secret string) {
+	if r == nil || len(secret) < 6 {
+		return
+	}
+	r.literals = append(r.literals, []byte(secret))
+}
+
+// With returns a copy of r that also redacts the given secrets, as if
+// added with AddLiteral; r itself is unchanged.
+func (r *Redactor) With(secrets ...string) *Redactor {
+	cp := &Redactor{}
+	if r != nil {
+		cp.rules = r.rules
+		cp.literals = append([][]byte(nil), r.literals...)
+	}
+	for _, s := range secrets {
+		cp.AddLiteral(s)
+	}
+	return cp
+}
+
+// Redact returns data with all known secrets replaced. The input slice is
+// not modified. Secrets split across two calls are not detected; chunked
+// output should go through a Stream.
+func (r *Redactor) Redact(data []byte) []byte {
+	if r == nil || len(data) == 0 {
+		return data
+	}
+	out := data
+	for _, lit := range r.literals {
+		if bytes.Contains(out, lit) {
+			out = bytes.ReplaceAll(out, lit, []byte(placeholder))
+		}
+	}
+	for _, rl := range r.rules {
+		if rl.re.Match(out) {
+			out = rl.re.ReplaceAll(out, rl.repl)
+		}
+	}
+	return out
+}
+
+// streamWindow bounds the output a Stream holds back: secrets matched by
+// the built-in rules fit well within it.
+const streamWindow = 512
+
+// Stream redacts a byte stream delivered in arbitrary chunks, such as PTY
+// reads. It holds back the last, unterminated line of each chunk, up to
+// the longest secret it redacts, so that a secret split across two chunks
+// is still matched once the rest arrives. Secrets do not span lines,
+// except PEM blocks, which are only matched within one chunk.
+type Stream struct {
+	r      *Redactor
+	window int
+	held   []byte
+}
+
+// Stream returns a Stream redacting with r.
+func (r *Redactor) Stream() *Stream {
+	s := &Stream{r: r, window: streamWindow}
+	if r != nil {
+		for _, lit := range r.literals {
+			s.window = max(s.window, len(lit))
+		}
+	}
+	return s
+}
+
+// Write adds data to the stream and returns the redacted output that is
+// ready to be emitted, which may be empty.
+func (s *Stream) Write(data []byte) []byte {
+	buf := append(s.held, data...)
+	cut := bytes.LastIndexByte(buf, '\n') + 1
+	if len(buf)-cut > s.window {
+		cut = len(buf) - s.window
+	}
+	s.held = append([]byte(nil), buf[cut:]...)
+	return s.r.Redact(buf[:cut])
+}
+
+// Flush returns the redacted output held back, emptying the stream.
+func (s *Stream) Flush() []byte {
+	out := s.r.Redact(s.held)
+	s.held = nil
+	return out
+}
+
+// Held reports whether the stream is holding back output.
+func (s *Stream) Held() bool { return len(s.held) > 0 }
@@ -0,0 +1,85 @@
+package redact
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const literal = "s3cr3t-runner-token"
+
+// TestStream feeds output in chunks that split secrets and checks that
+// none reaches the output.
+func TestStream(t *testing.T) {
+	key := "sk-" + strings.Repeat("a", 40)
+	for _, tc := range []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole", []string{"key " + key + "\n"}, "key " + placeholder + "\n"},
+		{"split", []string{"key sk-aaaa", strings.Repeat("a", 36) + "\n"}, "key " + placeholder + "\n"},
+		{"split literal", []string{"token s3cr3t-ru", "nner-token done\n"}, "token " + placeholder + " done\n"},
+		{"split each byte", strings.Split("x "+literal+" y\n", ""), "x " + placeholder + " y\n"},
+		{"held until flush", []string{"key " + key[:10], key[10:]}, "key " + placeholder},
+		{"lines", []string{"a\nb ", literal[:4], literal[4:] + "\nc"}, "a\nb " + placeholder + "\nc"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.AddLiteral(literal)
+			s := r.Stream()
+			var out bytes.Buffer
+			for _, c := range tc.chunks {
+				out.Write(s.Write([]byte(c)))
+			}
+			out.Write(s.Flush())
+			if out.String() != tc.want {
+				t.Errorf("output %q, want %q", out.String(), tc.want)
+			}
+			if s.Held() {
+				t.Error("stream holds output after Flush")
+			}
+		})
+	}
+}
+
+// TestStreamWindow emits a long unterminated line, holding back no more
+// than the window, which covers the longest literal.
+func TestStreamWindow(t *testing.T) {
+	r, _ := New(nil)
+	long := strings.Repeat("x", streamWindow+100)
+	r.AddLiteral(long)
+	s := r.Stream()
+	if s.window != len(long) {
+		t.Fatalf("window = %d, want the longest literal's %d", s.window, len(long))
+	}
+
+	r, _ = New(nil)
+	s = r.Stream()
+	out := s.Write([]byte(strings.Repeat("y", 3*streamWindow)))
+	if len(out) != 2*streamWindow {
+		t.Errorf("emitted %d bytes, want %d", len(out), 2*streamWindow)
+	}
+	if len(s.held) != streamWindow {
+		t.Errorf("held %d bytes, want %d", len(s.held), streamWindow)
+	}
+	if got := s.Write([]byte("z\n")); len(got) != streamWindow+2 {
+		t.Errorf("emitted %d bytes at the newline, want %d", len(got), streamWindow+2)
+	}
+	if s.Held() {
+		t.Error("stream holds output after a newline")
+	}
+}
+
+// TestStreamNil passes output through a nil Redactor's Stream.
+func TestStreamNil(t *testing.T) {
+	var r *Redactor
+	s := r.Stream()
+	out := append(s.Write([]byte("a\nb")), s.Flush()...)
+	if string(out) != "a\nb" {
+		t.Errorf("output %q", out)
+	}
+}
@@ -0,0 +1,111 @@
+// Package sensors reads hardware temperatures and fan speeds: from the
+// kernel's hwmon interface on Linux (the source lm-sensors reads), from
+// the SMC on macOS, and from nvidia-smi for NVIDIA GPUs on any platform.
+// Agents running long computations on lab workstations use the readings
+// to back off when a machine runs hot.
+package sensors
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// readTimeout bounds the commands one reading runs.
+const readTimeout = 5 * time.Second
+
+// Read takes a reading. A source that cannot be read is recorded in the
+// result's Errors; a source the machine does not have (no hwmon chips,
+// no nvidia-smi) is not an error. maxCelsius, if positive, marks the
+// reading Hot when any temperature reaches it.
+func Read(ctx context.Context, maxCelsius float64) protocol.SensorsResult {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+	r := protocol.SensorsResult{Time: time.Now().UTC().Format(time.RFC3339)}
+	if err := readPlatform(ctx, &r); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	if err := readNvidia(ctx, &r); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	summarize(&r, maxCelsius)
+	return r
+}
+
+// summarize sets r's MaxCelsius and Hot.
+func summarize(r *protocol.SensorsResult, maxCelsius float64) {
+	check := func(c float64) {
+		r.MaxCelsius = max(r.MaxCelsius, c)
+		if maxCelsius > 0 && c >= maxCelsius {
+			r.Hot = true
+		}
+	}
+	for _, t := range r.Temperatures {
+		check(t.Celsius)
+		if (t.High > 0 && t.Celsius >= t.High) || (t.Critical > 0 && t.Celsius >= t.Critical) {
+			r.Hot = true
+		}
+	}
+	for _, g := range r.GPUs {
+		if g.Celsius != nil {
+			check(*g.Celsius)
+		}
+	}
+}
+
+// nvidiaFields are the nvidia-smi query fields readNvidia parses, in
+// order.
+const nvidiaFields = "index,name,temperature.gpu,fan.speed,power.draw,utilization.gpu"
+
+// readNvidia adds a reading of each NVIDIA GPU, if nvidia-smi is
+// installed.
+func readNvidia(ctx context.Context, r *protocol.SensorsResult) error {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, path, "--query-gpu="+nvidiaFields, "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return fmt.Errorf("nvidia-smi: %w", err)
+	}
+	rd := csv.NewReader(strings.NewReader(string(out)))
+	rd.TrimLeadingSpace = true
+	records, err := rd.ReadAll()
+	if err != nil {
+		return fmt.Errorf("nvidia-smi: %w", err)
+	}
+	for _, rec := range records {
+		if len(rec) != 6 {
+			continue
+		}
+		index, err := strconv.Atoi(rec[0])
+		if err != nil {
+			continue
+		}
+		r.GPUs = append(r.GPUs, protocol.GPUSensors{
+			Index:       index,
+			Name:        rec[1],
+			Celsius:     nvidiaValue(rec[2]),
+			FanPercent:  nvidiaValue(rec[3]),
+			PowerWatts:  nvidiaValue(rec[4]),
+			Utilization: nvidiaValue(rec[5]),
+		})
+	}
+	return nil
+}
+
+// nvidiaValue parses a numeric nvidia-smi field, which reads "[N/A]" or
+// "[Not Supported]" when the GPU does not report it.
+func nvidiaValue(s string) *float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,48 @@
+//go:build darwin
+
+package sensors
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+var (
+	smcTemp = regexp.MustCompile(`^(.+) temperature: ([\d.]+) C$`)
+	smcFan  = regexp.MustCompile(`^Fan: ([\d.]+) rpm$`)
+)
+
+// readPlatform reads the SMC through powermetrics, which only root may
+// run. Apple silicon Macs report no SMC temperatures this way.
+func readPlatform(ctx context.Context, r *protocol.SensorsResult) error {
+	if os.Geteuid() != 0 {
+		return errors.New("smc: powermetrics requires root")
+	}
+	out, err := exec.CommandContext(ctx, "powermetrics", "--samplers", "smc", "-n", "1", "-i", "1").Output()
+	if err != nil {
+		return fmt.Errorf("smc: powermetrics: %w", err)
+	}
+	sc := bufio.NewScanner(strings.NewReader(string(out)))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if m := smcTemp.FindStringSubmatch(line); m != nil {
+			if c, err := strconv.ParseFloat(m[2], 64); err == nil {
+				r.Temperatures = append(r.Temperatures, protocol.Temperature{Source: "smc", Label: m[1], Celsius: c})
+			}
+		} else if m := smcFan.FindStringSubmatch(line); m != nil {
+			if rpm, err := strconv.ParseFloat(m[1], 64); err == nil {
+				r.Fans = append(r.Fans, protocol.FanSpeed{Source: "smc", Label: fmt.Sprintf("fan%d", len(r.Fans)+1), RPM: int(rpm)})
+			}
+		}
+	}
+	return nil
+}
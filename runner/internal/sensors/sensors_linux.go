@@ -0,0 +1,113 @@
+//go:build linux
+
+package sensors
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+const (
+	hwmonDir   = "/sys/class/hwmon"
+	thermalDir = "/sys/class/thermal"
+)
+
+// readPlatform reads the hwmon chips, falling back to the ACPI thermal
+// zones when no chip reports a temperature (as on many ARM boards).
+func readPlatform(_ context.Context, r *protocol.SensorsResult) error {
+	chips, _ := filepath.Glob(filepath.Join(hwmonDir, "hwmon*"))
+	sort.Strings(chips)
+	for _, chip := range chips {
+		readHwmon(chip, r)
+	}
+	if len(r.Temperatures) == 0 {
+		zones, _ := filepath.Glob(filepath.Join(thermalDir, "thermal_zone*"))
+		sort.Strings(zones)
+		for _, zone := range zones {
+			milli, ok := readInt(filepath.Join(zone, "temp"))
+			if !ok {
+				continue
+			}
+			r.Temperatures = append(r.Temperatures, protocol.Temperature{
+				Source:  "thermal",
+				Label:   readString(filepath.Join(zone, "type"), filepath.Base(zone)),
+				Celsius: float64(milli) / 1000,
+			})
+		}
+	}
+	return nil
+}
+
+// readHwmon adds the temperatures and fans of one hwmon chip. Files
+// follow the kernel's sysfs-interface: tempN_input in millidegrees with
+// optional tempN_label, tempN_max and tempN_crit, and fanN_input in RPM.
+func readHwmon(chip string, r *protocol.SensorsResult) {
+	name := readString(filepath.Join(chip, "name"), filepath.Base(chip))
+	for _, input := range sortedGlob(filepath.Join(chip, "temp*_input")) {
+		milli, ok := readInt(input)
+		if !ok {
+			continue
+		}
+		prefix := strings.TrimSuffix(input, "_input")
+		t := protocol.Temperature{
+			Source:  name,
+			Label:   readString(prefix+"_label", filepath.Base(prefix)),
+			Celsius: float64(milli) / 1000,
+		}
+		if v, ok := readInt(prefix + "_max"); ok && v > 0 {
+			t.High = float64(v) / 1000
+		}
+		if v, ok := readInt(prefix + "_crit"); ok && v > 0 {
+			t.Critical = float64(v) / 1000
+		}
+		r.Temperatures = append(r.Temperatures, t)
+	}
+	for _, input := range sortedGlob(filepath.Join(chip, "fan*_input")) {
+		rpm, ok := readInt(input)
+		if !ok {
+			continue
+		}
+		prefix := strings.TrimSuffix(input, "_input")
+		r.Fans = append(r.Fans, protocol.FanSpeed{
+			Source: name,
+			Label:  readString(prefix+"_label", filepath.Base(prefix)),
+			RPM:    int(rpm),
+		})
+	}
+}
+
+// sortedGlob returns the matches of pattern in natural order, so that
+// temp10 follows temp9.
+func sortedGlob(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	num := func(p string) int {
+		base := strings.TrimLeft(filepath.Base(p), "abcdefghijklmnopqrstuvwxyz")
+		n, _ := strconv.Atoi(strings.TrimSuffix(base, "_input"))
+		return n
+	}
+	sort.Slice(matches, func(i, j int) bool { return num(matches[i]) < num(matches[j]) })
+	return matches
+}
+
+func readString(path, fallback string) string {
+	data, err := os.ReadFile(path)
+	if s := strings.TrimSpace(string(data)); err == nil && s != "" {
+		return s
+	}
+	return fallback
+}
+
+func readInt(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return v, err == nil
+}
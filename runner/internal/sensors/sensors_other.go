@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+
+package sensors
+
+import (
+	"context"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// readPlatform reads nothing: only GPUs are read on this platform.
+func readPlatform(context.Context, *protocol.SensorsResult) error { return nil }
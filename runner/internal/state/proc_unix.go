@@ -0,0 +1,27 @@
+//go:build !windows
+
+package state
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Alive reports whether a process with the given PID exists.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Terminate hangs up the process group led by pid. PTY and job processes
+// are started as session leaders, so signalling the group (rather than
+// the bare PID) avoids hitting an unrelated process that reused the PID.
+func Terminate(pid int) error {
+	if pid <= 0 {
+		return nil
+	}
+	return syscall.Kill(-pid, syscall.SIGHUP)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package state
+
+import (
+	"errors"
+	"os"
+)
+
+// Alive reports whether a process with the given PID exists.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// On Windows FindProcess opens a handle and fails if there is no
+	// such process.
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// Terminate is not supported on Windows: without process groups a stale
+// PID cannot be told apart from an unrelated process. ConPTY children
+// exit on their own when the runner's pseudo console handle closes.
+func Terminate(pid int) error {
+	return errors.New("not supported on windows")
+}
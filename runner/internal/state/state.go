@@ -0,0 +1,134 @@
+// Package state persists records of long-lived resources the runner
+// creates (PTY sessions, background jobs, temp workspaces, session scratch
+// dirs) so a restarted runner can find what a previous, crashed run left
+// behind.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Record kinds.
+const (
+	KindPTY       = "pty"
+	KindJob       = "job"
+	KindWorkspace = "workspace"
+	KindSession   = "session"
+	// KindRunner is a connected runner itself, so that other commands
+	// can tell it is running.
+	KindRunner = "runner"
+)
+
+// Record describes one resource owned by a runner process.
+type Record struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	// Owner is the PID of the runner that created the resource.
+	Owner int `json:"owner"`
+	// PID of the resource's process, if it has one.
+	PID int `json:"pid,omitempty"`
+	// Path of the resource on disk (workspace dir, job log, session
+	// scratch dir), if any.
+	Path    string    `json:"path,omitempty"`
+	Command string    `json:"command,omitempty"`
+	Started time.Time `json:"started"`
+	// Tmux names the tmux session of a PTY session kept in tmux.
+	Tmux string `json:"tmux,omitempty"`
+}
+
+// Store is a directory of JSON records, one file per resource.
+type Store struct {
+	dir string
+}
+
+// Open creates the store directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put writes a record, stamping Owner with the current process.
+func (s *Store) Put(r Record) error {
+	if s == nil {
+		return nil
+	}
+	r.Owner = os.Getpid()
+	if r.Started.IsZero() {
+		r.Started = time.Now()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	path := s.path(r.Kind, r.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write state record: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes a record. Missing records are not an error.
+func (s *Store) Remove(kind, id string) error {
+	if s == nil {
+		return nil
+	}
+	if err := os.Remove(s.path(kind, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns every record in the store. Unreadable files are skipped.
+func (s *Store) List() ([]Record, error) {
+	if s == nil {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var r Record
+		if json.Unmarshal(data, &r) == nil {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// Runner returns the PID of a live runner other than the calling
+// process, or 0 if there is none.
+func (s *Store) Runner() (int, error) {
+	records, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range records {
+		if r.Kind == KindRunner && r.Owner != os.Getpid() && Alive(r.Owner) {
+			return r.Owner, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *Store) path(kind, id string) string {
+	// IDs come from the cloud; keep them from escaping the directory.
+	safe := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(id)
+	return filepath.Join(s.dir, kind+"-"+safe+".json")
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Grant is a presigned URL for one operation on one object, with any
+// headers the signature covers.
+type Grant struct {
+	URL     string
+	Headers map[string]string
+}
+
+// Presigned stores objects through URLs the Xyzen backend presigns per
+// object and operation, so the runner needs no storage credentials.
+type Presigned struct {
+	// Presign asks the backend for a URL to use method on key.
+	Presign func(ctx context.Context, key, method string) (Grant, error)
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (p *Presigned) Name() string { return BackendPresigned }
+
+func (p *Presigned) Put(ctx context.Context, key string, r io.Reader, size int64) (Object, error) {
+	h := sha256.New()
+	resp, grant, err := p.do(ctx, http.MethodPut, key, io.TeeReader(r, h), size)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	u, _, _ := strings.Cut(grant.URL, "?")
+	return Object{Backend: BackendPresigned, Key: key, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), URL: u}, nil
+}
+
+func (p *Presigned) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, _, err := p.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (p *Presigned) Delete(ctx context.Context, key string) error {
+	resp, _, err := p.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (p *Presigned) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, Grant, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, Grant{}, err
+	}
+	grant, err := p.Presign(ctx, key, method)
+	if err != nil {
+		return nil, Grant{}, fmt.Errorf("presign %s %s: %w", method, key, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, grant.URL, body)
+	if err != nil {
+		return nil, grant, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range grant.Headers {
+		req.Header.Set(k, v)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, grant, fmt.Errorf("%s %s: %w", method, key, err)
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+		return resp, grant, nil
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return resp, grant, nil
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, grant, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return nil, grant, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
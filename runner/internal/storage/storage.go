@@ -0,0 +1,60 @@
+// Package storage writes objects the runner produces — recordings,
+// snapshots and collected artifacts — to a configurable backend: a local
+// directory, an S3-compatible bucket, or URLs presigned per object by the
+// Xyzen backend. Lab machines often have small system disks but a NAS or
+// object store close by.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Backend names.
+const (
+	BackendLocal     = "local"
+	BackendS3        = "s3"
+	BackendPresigned = "presigned"
+)
+
+// ErrNotFound is returned by Get for a key that is not stored.
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object. URL locates it for humans and other
+// tools: a file path, an s3:// URL, or the presigned URL without its query.
+type Object struct {
+	Backend string `json:"backend"`
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Backend stores objects by key. Keys are slash-separated relative paths
+// (see ValidKey).
+type Backend interface {
+	Name() string
+	// Put stores size bytes from r under key, replacing any object there.
+	Put(ctx context.Context, key string, r io.Reader, size int64) (Object, error)
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key; a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey checks that key is a relative slash path without "." or ".."
+// elements, so that it maps safely onto directories and object names.
+func ValidKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
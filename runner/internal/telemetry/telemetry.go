@@ -0,0 +1,56 @@
+package telemetry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/scienceol/xyzen/runner/internal/protocol"
+)
+
+// Metrics collects per-request-type counters between snapshots.
+type Metrics struct {
+	mu       sync.Mutex
+	start    time.Time
+	requests map[string]*protocol.RequestStats
+}
+
+// NewMetrics returns an empty collector.
+func NewMetrics() *Metrics {
+	return &Metrics{start: time.Now(), requests: make(map[string]*protocol.RequestStats)}
+}
+
+// Observe records one handled request.
+func (m *Metrics) Observe(reqType string, success bool, d time.Duration) {
+	ms := d.Milliseconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.requests[reqType]
+	if !ok {
+		st = &protocol.RequestStats{}
+		m.requests[reqType] = st
+	}
+	st.Count++
+	if !success {
+		st.Errors++
+	}
+	st.TotalMs += ms
+	if ms > st.MaxMs {
+		st.MaxMs = ms
+	}
+}
+
+// Take returns the stats accumulated since the previous call, along with
+// the period they cover, and resets the collector.
+func (m *Metrics) Take() (start, end time.Time, stats map[string]protocol.RequestStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	end = time.Now()
+	stats = make(map[string]protocol.RequestStats, len(m.requests))
+	for k, v := range m.requests {
+		stats[k] = *v
+	}
+	start = m.start
+	m.start = end
+	m.requests = make(map[string]*protocol.RequestStats)
+	return start, end, stats
+}
@@ -0,0 +1,236 @@
+// Package textenc turns command output in whatever encoding a tool wrote
+// it (UTF-16 from Windows tools, GBK from Chinese-locale compilers,
+// Latin-1 logs) into UTF-8. Single-byte encodings and UTF-16 are decoded
+// here; other multi-byte encodings are converted by the platform, with
+// iconv on Unix and MultiByteToWideChar on Windows.
+package textenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding names (Decode's enc and its result).
+const (
+	Auto    = "auto"
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "latin1"
+	CP1252  = "windows-1252"
+	GBK     = "gbk"
+)
+
+// multiByte are the multi-byte legacy encodings the platform converts,
+// by their iconv names and Windows code pages.
+var multiByte = map[string]struct {
+	iconv    string
+	codePage uint32
+}{
+	GBK:         {"GBK", 936},
+	"gb18030":   {"GB18030", 54936},
+	"big5":      {"BIG5", 950},
+	"shift_jis": {"SHIFT_JIS", 932},
+	"euc-jp":    {"EUC-JP", 20932},
+	"euc-kr":    {"EUC-KR", 949},
+}
+
+// aliases maps other common names to the names above.
+var aliases = map[string]string{
+	"utf8": UTF8, "utf16le": UTF16LE, "utf16be": UTF16BE,
+	"iso-8859-1": Latin1, "iso8859-1": Latin1, "latin-1": Latin1,
+	"cp1252": CP1252, "cp936": GBK, "gb2312": GBK,
+	"sjis": "shift_jis", "cp932": "shift_jis", "eucjp": "euc-jp", "euckr": "euc-kr", "cp949": "euc-kr",
+}
+
+// Valid checks an encoding name.
+func Valid(enc string) error {
+	if enc == "" || canonical(enc) != "" {
+		return nil
+	}
+	return fmt.Errorf("unsupported encoding %q", enc)
+}
+
+// canonical returns enc's name as above, or "" if it is not supported.
+func canonical(enc string) string {
+	enc = strings.ToLower(strings.TrimSpace(enc))
+	if a, ok := aliases[enc]; ok {
+		enc = a
+	}
+	switch enc {
+	case Auto, UTF8, UTF16LE, UTF16BE, Latin1, CP1252:
+		return enc
+	}
+	if _, ok := multiByte[enc]; ok {
+		return enc
+	}
+	return ""
+}
+
+// Decode converts b from enc, or with enc "" or Auto from the encoding
+// it detects, to UTF-8. It returns the text, the encoding it decoded and
+// the number of invalid sequences replaced with U+FFFD.
+func Decode(b []byte, enc string) (string, string, int) {
+	enc = canonical(enc)
+	if enc == "" || enc == Auto {
+		enc = Detect(b)
+	}
+	switch enc {
+	case UTF16LE, UTF16BE:
+		return decodeUTF16(b, enc == UTF16BE), enc, 0
+	case Latin1:
+		return decodeSingle(b, nil), enc, 0
+	case CP1252:
+		return decodeSingle(b, cp1252[:]), enc, 0
+	}
+	if m, ok := multiByte[enc]; ok {
+		if s, err := convert(b, m.iconv, m.codePage); err == nil {
+			return s, enc, 0
+		}
+	}
+	s, bad := decodeUTF8(b)
+	return s, UTF8, bad
+}
+
+// Detect guesses b's encoding: UTF-16 if it has a byte order mark or
+// the NUL bytes of ASCII text, UTF-8 if it is valid, GBK if its high
+// bytes pair up as GB2312 characters do, and otherwise the system's
+// legacy encoding if it has one, or Windows-1252.
+func Detect(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return UTF16LE
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return UTF16BE
+	}
+	if enc := detectUTF16(b); enc != "" {
+		return enc
+	}
+	if utf8.Valid(b) {
+		return UTF8
+	}
+	if looksGBK(b) {
+		return GBK
+	}
+	if enc := canonical(systemEncoding()); enc != "" && enc != UTF8 && enc != Auto {
+		return enc
+	}
+	return CP1252
+}
+
+// detectUTF16 recognizes UTF-16 without a byte order mark from the NUL
+// high bytes of mostly-ASCII text.
+func detectUTF16(b []byte) string {
+	if len(b) < 4 || len(b)%2 != 0 {
+		return ""
+	}
+	var even, odd int
+	for i := 0; i < len(b); i += 2 {
+		if b[i] == 0 {
+			even++
+		}
+		if b[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := len(b) / 2
+	switch {
+	case odd*10 >= pairs*9 && even == 0:
+		return UTF16LE
+	case even*10 >= pairs*9 && odd == 0:
+		return UTF16BE
+	}
+	return ""
+}
+
+// looksGBK reports whether every high byte in b belongs to a GBK
+// two-byte character and most of them to the GB2312 area (0xA1-0xF7
+// lead, 0xA1-0xFE trail) where common hanzi are. Latin-1 text, whose
+// accented letters are mostly followed by ASCII, fails the second test.
+func looksGBK(b []byte) bool {
+	var pairs, gb2312 int
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c < 0x80 {
+			continue
+		}
+		if c == 0x80 || c == 0xFF || i+1 >= len(b) {
+			return false
+		}
+		t := b[i+1]
+		if t < 0x40 || t == 0x7F || t == 0xFF {
+			return false
+		}
+		pairs++
+		if c >= 0xA1 && c <= 0xF7 && t >= 0xA1 {
+			gb2312++
+		}
+		i++
+	}
+	return pairs > 0 && gb2312*10 >= pairs*8
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	order := binary.ByteOrder(binary.LittleEndian)
+	bom := []byte{0xFF, 0xFE}
+	if bigEndian {
+		order, bom = binary.BigEndian, []byte{0xFE, 0xFF}
+	}
+	b = bytes.TrimPrefix(b, bom)
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	s := string(utf16.Decode(u))
+	if len(b)%2 != 0 {
+		s += string(utf8.RuneError)
+	}
+	return s
+}
+
+// decodeSingle decodes a single-byte encoding: Latin-1, or with high the
+// code points of bytes 0x80-0x9F, Windows-1252.
+func decodeSingle(b []byte, high []rune) string {
+	var sb strings.Builder
+	sb.Grow(len(b) + len(b)/4)
+	for _, c := range b {
+		switch {
+		case c >= 0x80 && c < 0xA0 && high != nil:
+			sb.WriteRune(high[c-0x80])
+		default:
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
+
+// decodeUTF8 replaces b's invalid sequences with U+FFFD, counting them.
+func decodeUTF8(b []byte) (string, int) {
+	if utf8.Valid(b) {
+		return string(b), 0
+	}
+	var sb strings.Builder
+	bad := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			bad++
+		}
+		sb.WriteRune(r)
+		b = b[size:]
+	}
+	return sb.String(), bad
+}
+
+// cp1252 maps bytes 0x80-0x9F of Windows-1252; the five it leaves
+// undefined keep their Latin-1 (C1 control) meaning.
+var cp1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
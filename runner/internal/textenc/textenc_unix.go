@@ -0,0 +1,46 @@
+//go:build !windows
+
+package textenc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// convertTimeout bounds one iconv run.
+const convertTimeout = 10 * time.Second
+
+// convert converts b from the encoding iconv calls name with iconv, which
+// fails on any invalid sequence.
+func convert(b []byte, name string, _ uint32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), convertTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "iconv", "-f", name, "-t", "UTF-8")
+	cmd.Stdin = bytes.NewReader(b)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(out) {
+		return "", os.ErrInvalid
+	}
+	return string(out), nil
+}
+
+// systemEncoding returns the charset of the locale, as in
+// LANG=zh_CN.GBK, or "".
+func systemEncoding() string {
+	for _, v := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(v); locale != "" {
+			_, charset, _ := strings.Cut(locale, ".")
+			charset, _, _ = strings.Cut(charset, "@")
+			return charset
+		}
+	}
+	return ""
+}
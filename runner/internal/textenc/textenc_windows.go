@@ -0,0 +1,55 @@
+//go:build windows
+
+package textenc
+
+import (
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procMultiByteToWideChar = kernel32.NewProc("MultiByteToWideChar")
+	procGetACP              = kernel32.NewProc("GetACP")
+)
+
+// mbErrInvalidChars makes MultiByteToWideChar fail on invalid input.
+const mbErrInvalidChars = 0x8
+
+// convert converts b from the Windows code page codePage.
+func convert(b []byte, _ string, codePage uint32) (string, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	n, _, err := procMultiByteToWideChar.Call(uintptr(codePage), mbErrInvalidChars,
+		uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0, 0)
+	if n == 0 {
+		return "", err
+	}
+	u := make([]uint16, n)
+	n, _, err = procMultiByteToWideChar.Call(uintptr(codePage), mbErrInvalidChars,
+		uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(unsafe.Pointer(&u[0])), n)
+	if n == 0 {
+		return "", err
+	}
+	return string(utf16.Decode(u[:n])), nil
+}
+
+// systemEncoding returns the name of the ANSI code page, e.g. "gbk" for
+// 936, or "" if this package does not know it.
+func systemEncoding() string {
+	acp, _, _ := procGetACP.Call()
+	switch acp {
+	case 1252:
+		return CP1252
+	case 65001:
+		return UTF8
+	}
+	for name, m := range multiByte {
+		if uintptr(m.codePage) == acp {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package tracking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// mlflowDefaultExperiment is the ID of the experiment MLflow creates
+	// at startup.
+	mlflowDefaultExperiment = "0"
+	// MLflow caps the entities of one log-batch call.
+	mlflowMaxParams  = 100
+	mlflowMaxMetrics = 1000
+)
+
+// MLflow logs runs to an MLflow tracking server through its REST API.
+// Artifacts are uploaded through the server's artifact proxy, so the
+// server must serve artifacts (mlflow server --serve-artifacts, the
+// default since MLflow 2.0).
+type MLflow struct {
+	// URL is the tracking server, e.g. http://localhost:5000.
+	URL string
+	// Token, if set, is sent as a bearer token; otherwise Username and
+	// Password, if set, are sent with basic auth.
+	Token    string
+	Username string
+	Password string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (m *MLflow) Name() string { return BackendMLflow }
+
+type mlflowTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (m *MLflow) StartRun(ctx context.Context, experiment, name string, tags map[string]string) (*Run, error) {
+	expID := mlflowDefaultExperiment
+	if experiment != "" {
+		var err error
+		if expID, err = m.experiment(ctx, experiment); err != nil {
+			return nil, err
+		}
+	}
+	req := struct {
+		ExperimentID string      `json:"experiment_id"`
+		RunName      string      `json:"run_name,omitempty"`
+		StartTime    int64       `json:"start_time"`
+		Tags         []mlflowTag `json:"tags,omitempty"`
+	}{ExperimentID: expID, RunName: name, StartTime: time.Now().UnixMilli()}
+	for _, k := range sortedKeys(tags) {
+		req.Tags = append(req.Tags, mlflowTag{Key: k, Value: tags[k]})
+	}
+	var resp struct {
+		Run struct {
+			Info struct {
+				RunID        string `json:"run_id"`
+				RunName      string `json:"run_name"`
+				ExperimentID string `json:"experiment_id"`
+				ArtifactURI  string `json:"artifact_uri"`
+			} `json:"info"`
+		} `json:"run"`
+	}
+	if err := m.call(ctx, http.MethodPost, "runs/create", req, &resp); err != nil {
+		return nil, err
+	}
+	info := resp.Run.Info
+	return &Run{
+		ID:          info.RunID,
+		Experiment:  info.ExperimentID,
+		Name:        info.RunName,
+		URL:         fmt.Sprintf("%s/#/experiments/%s/runs/%s", strings.TrimRight(m.URL, "/"), info.ExperimentID, info.RunID),
+		artifactURI: info.ArtifactURI,
+	}, nil
+}
+
+// experiment returns the ID of the named experiment, creating it if it
+// does not exist.
+func (m *MLflow) experiment(ctx context.Context, name string) (string, error) {
+	var got struct {
+		Experiment struct {
+			ExperimentID string `json:"experiment_id"`
+		} `json:"experiment"`
+	}
+	err := m.call(ctx, http.MethodGet, "experiments/get-by-name?experiment_name="+url.QueryEscape(name), nil, &got)
+	if err == nil {
+		return got.Experiment.ExperimentID, nil
+	}
+	var created struct {
+		ExperimentID string `json:"experiment_id"`
+	}
+	if cerr := m.call(ctx, http.MethodPost, "experiments/create", map[string]string{"name": name}, &created); cerr != nil {
+		return "", fmt.Errorf("experiment %q: %w", name, err)
+	}
+	return created.ExperimentID, nil
+}
+
+func (m *MLflow) LogParams(ctx context.Context, run *Run, params map[string]string) error {
+	keys := sortedKeys(params)
+	for len(keys) > 0 {
+		n := min(len(keys), mlflowMaxParams)
+		batch := make([]mlflowTag, 0, n)
+		for _, k := range keys[:n] {
+			batch = append(batch, mlflowTag{Key: k, Value: params[k]})
+		}
+		keys = keys[n:]
+		if err := m.call(ctx, http.MethodPost, "runs/log-batch", map[string]interface{}{"run_id": run.ID, "params": batch}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MLflow) LogMetrics(ctx context.Context, run *Run, metrics []Metric) error {
+	type metric struct {
+		Key       string  `json:"key"`
+		Value     float64 `json:"value"`
+		Timestamp int64   `json:"timestamp"`
+		Step      int64   `json:"step"`
+	}
+	for len(metrics) > 0 {
+		n := min(len(metrics), mlflowMaxMetrics)
+		batch := make([]metric, 0, n)
+		for _, mt := range metrics[:n] {
+			batch = append(batch, metric{Key: mt.Key, Value: mt.Value, Timestamp: millis(mt.Timestamp), Step: mt.Step})
+		}
+		metrics = metrics[n:]
+		if err := m.call(ctx, http.MethodPost, "runs/log-batch", map[string]interface{}{"run_id": run.ID, "metrics": batch}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MLflow) LogArtifact(ctx context.Context, run *Run, name string, r io.Reader, size int64) error {
+	if err := ValidArtifactName(name); err != nil {
+		return err
+	}
+	// The proxy addresses artifacts by the path of the run's
+	// mlflow-artifacts: URI, e.g. mlflow-artifacts:/1/<run>/artifacts.
+	u, err := url.Parse(run.artifactURI)
+	if err != nil || u.Scheme != "mlflow-artifacts" {
+		return fmt.Errorf("run artifacts are stored at %q, which the tracking server does not proxy (start it with --serve-artifacts)", run.artifactURI)
+	}
+	var segs []string
+	for _, s := range strings.Split(strings.Trim(u.Path, "/")+"/"+name, "/") {
+		segs = append(segs, url.PathEscape(s))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimRight(m.URL, "/")+"/api/2.0/mlflow-artifacts/artifacts/"+strings.Join(segs, "/"), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := m.do(req)
+	if err != nil {
+		return err
+	}
+	if err := check(resp, "mlflow upload "+name); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (m *MLflow) EndRun(ctx context.Context, run *Run, status string) error {
+	if err := ValidStatus(status); err != nil {
+		return err
+	}
+	req := map[string]interface{}{
+		"run_id":   run.ID,
+		"status":   strings.ToUpper(status),
+		"end_time": time.Now().UnixMilli(),
+	}
+	return m.call(ctx, http.MethodPost, "runs/update", req, nil)
+}
+
+// call sends a REST API request with a JSON body (if in is non-nil) and
+// decodes the JSON response into out (if non-nil).
+func (m *MLflow) call(ctx context.Context, method, endpoint string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(m.URL, "/")+"/api/2.0/mlflow/"+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := m.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			ErrorCode string `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e) == nil && e.Message != "" {
+			return fmt.Errorf("mlflow %s: %s: %s", endpoint, e.ErrorCode, e.Message)
+		}
+		return fmt.Errorf("mlflow %s: %s", endpoint, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("mlflow %s: invalid response: %w", endpoint, err)
+	}
+	return nil
+}
+
+func (m *MLflow) do(req *http.Request) (*http.Response, error) {
+	switch {
+	case m.Token != "":
+		req.Header.Set("Authorization", "Bearer "+m.Token)
+	case m.Username != "" || m.Password != "":
+		req.SetBasicAuth(m.Username, m.Password)
+	}
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mlflow: %w", err)
+	}
+	return resp, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
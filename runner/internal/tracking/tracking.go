@@ -0,0 +1,113 @@
+// Package tracking logs experiment runs — parameters, metrics and
+// artifacts — to an experiment tracking server: MLflow or Weights &
+// Biases. Scientific workflows the runner executes then land in the
+// tracking system a team already uses.
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Backend names.
+const (
+	BackendMLflow = "mlflow"
+	BackendWandb  = "wandb"
+)
+
+// Run end statuses.
+const (
+	StatusFinished = "finished"
+	StatusFailed   = "failed"
+	StatusKilled   = "killed"
+)
+
+// ValidStatus checks that status is a run end status.
+func ValidStatus(status string) error {
+	switch status {
+	case StatusFinished, StatusFailed, StatusKilled:
+		return nil
+	}
+	return fmt.Errorf("invalid status %q (want %s, %s or %s)", status, StatusFinished, StatusFailed, StatusKilled)
+}
+
+// Metric is one metric value. A zero Timestamp means now.
+type Metric struct {
+	Key       string
+	Value     float64
+	Step      int64
+	Timestamp time.Time
+}
+
+// Run is a run started on a tracking server. Besides its identity it
+// carries what the backend needs to keep logging to it, so a Run must
+// only be used by one goroutine at a time.
+type Run struct {
+	ID string
+	// Experiment is the MLflow experiment ID or the W&B project.
+	Experiment string
+	Name       string
+	// URL is the run's page in the tracking server's UI.
+	URL string
+
+	// artifactURI is where MLflow stores the run's artifacts.
+	artifactURI string
+	// entity, config, summary and history are the W&B run's entity,
+	// logged parameters and latest metric values, and the number of
+	// history lines streamed so far.
+	entity  string
+	config  map[string]string
+	summary map[string]float64
+	history int
+}
+
+// Tracker is an experiment tracking server.
+type Tracker interface {
+	Name() string
+	// StartRun starts a run in experiment, creating the experiment if
+	// needed. An empty experiment selects the server's default.
+	StartRun(ctx context.Context, experiment, name string, tags map[string]string) (*Run, error)
+	LogParams(ctx context.Context, run *Run, params map[string]string) error
+	LogMetrics(ctx context.Context, run *Run, metrics []Metric) error
+	// LogArtifact uploads size bytes from r as the run artifact name, a
+	// slash-separated relative path.
+	LogArtifact(ctx context.Context, run *Run, name string, r io.Reader, size int64) error
+	EndRun(ctx context.Context, run *Run, status string) error
+}
+
+// ValidArtifactName checks that name is a relative slash path without
+// "." or ".." elements.
+func ValidArtifactName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return fmt.Errorf("invalid artifact name %q", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid artifact name %q", name)
+		}
+	}
+	return nil
+}
+
+// millis returns t, or now if t is zero, in Unix milliseconds.
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixMilli()
+}
+
+// check returns an error for a failed response, including the start of
+// its body, and closes the body. what names the call.
+func check(resp *http.Response, what string) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return fmt.Errorf("%s: %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,330 @@
+package tracking
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// wandbDefaultProject is the project W&B logs to when none is named.
+	wandbDefaultProject = "uncategorized"
+	// wandbRunIDChars are the characters of generated run IDs, which W&B
+	// uses as run names in URLs.
+	wandbRunIDChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Wandb logs runs to a Weights & Biases server (W&B Server or the cloud
+// API) through its GraphQL and file stream APIs, as the wandb client
+// library does. Experiments are W&B projects. W&B tags are plain
+// strings, so a tag with a value is sent as "key:value".
+type Wandb struct {
+	// URL is the API server, e.g. http://localhost:8080 for W&B Server
+	// or https://api.wandb.ai.
+	URL    string
+	APIKey string
+	// Entity is the user or team that owns the runs; empty selects the
+	// API key's default entity.
+	Entity string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (w *Wandb) Name() string { return BackendWandb }
+
+const wandbUpsertBucket = `mutation UpsertBucket($name: String, $project: String, $entity: String, $displayName: String, $tags: [String!], $config: JSONString) {
+  upsertBucket(input: {name: $name, modelName: $project, entityName: $entity, displayName: $displayName, tags: $tags, config: $config}) {
+    bucket { name displayName project { name entity { name } } }
+  }
+}`
+
+type wandbBucket struct {
+	UpsertBucket struct {
+		Bucket struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"displayName"`
+			Project     struct {
+				Name   string `json:"name"`
+				Entity struct {
+					Name string `json:"name"`
+				} `json:"entity"`
+			} `json:"project"`
+		} `json:"bucket"`
+	} `json:"upsertBucket"`
+}
+
+func (w *Wandb) StartRun(ctx context.Context, experiment, name string, tags map[string]string) (*Run, error) {
+	if experiment == "" {
+		experiment = wandbDefaultProject
+	}
+	id, err := wandbRunID()
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]interface{}{"name": id, "project": experiment}
+	if w.Entity != "" {
+		vars["entity"] = w.Entity
+	}
+	if name != "" {
+		vars["displayName"] = name
+	}
+	if len(tags) > 0 {
+		list := make([]string, 0, len(tags))
+		for _, k := range sortedKeys(tags) {
+			if tags[k] == "" {
+				list = append(list, k)
+			} else {
+				list = append(list, k+":"+tags[k])
+			}
+		}
+		vars["tags"] = list
+	}
+	var resp wandbBucket
+	if err := w.graphql(ctx, wandbUpsertBucket, vars, &resp); err != nil {
+		return nil, err
+	}
+	b := resp.UpsertBucket.Bucket
+	run := &Run{
+		ID:         b.Name,
+		Experiment: b.Project.Name,
+		Name:       b.DisplayName,
+		entity:     b.Project.Entity.Name,
+		config:     map[string]string{},
+		summary:    map[string]float64{},
+	}
+	run.URL = fmt.Sprintf("%s/%s/%s/runs/%s", strings.TrimRight(w.URL, "/"), url.PathEscape(run.entity), url.PathEscape(run.Experiment), url.PathEscape(run.ID))
+	return run, nil
+}
+
+// LogParams records params in the run's config. W&B replaces the whole
+// config on update, so every parameter logged so far is sent.
+func (w *Wandb) LogParams(ctx context.Context, run *Run, params map[string]string) error {
+	config := make(map[string]interface{}, len(run.config)+len(params))
+	for k, v := range run.config {
+		config[k] = map[string]string{"value": v}
+	}
+	for k, v := range params {
+		config[k] = map[string]string{"value": v}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	vars := map[string]interface{}{"name": run.ID, "project": run.Experiment, "entity": run.entity, "config": string(data)}
+	if err := w.graphql(ctx, wandbUpsertBucket, vars, nil); err != nil {
+		return err
+	}
+	for k, v := range params {
+		run.config[k] = v
+	}
+	return nil
+}
+
+// LogMetrics appends one history row per step, in step order, and
+// updates the run summary with the latest values. W&B expects steps to
+// increase across calls.
+func (w *Wandb) LogMetrics(ctx context.Context, run *Run, metrics []Metric) error {
+	rows := map[int64]map[string]interface{}{}
+	var steps []int64
+	summary := make(map[string]float64, len(run.summary))
+	for k, v := range run.summary {
+		summary[k] = v
+	}
+	for _, m := range metrics {
+		row, ok := rows[m.Step]
+		if !ok {
+			row = map[string]interface{}{
+				"_step":      m.Step,
+				"_timestamp": float64(millis(m.Timestamp)) / 1000,
+			}
+			rows[m.Step] = row
+			steps = append(steps, m.Step)
+		}
+		row[m.Key] = m.Value
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+	lines := make([]string, 0, len(steps))
+	for _, step := range steps {
+		for k, v := range rows[step] {
+			if k[0] != '_' {
+				summary[k] = v.(float64)
+			}
+		}
+		data, err := json.Marshal(rows[step])
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(data))
+	}
+	summaryData, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	err = w.stream(ctx, run, map[string]interface{}{
+		"files": map[string]interface{}{
+			"wandb-history.jsonl": map[string]interface{}{"offset": run.history, "content": lines},
+			"wandb-summary.json":  map[string]interface{}{"offset": 0, "content": []string{string(summaryData)}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	run.history += len(lines)
+	run.summary = summary
+	return nil
+}
+
+const wandbCreateRunFiles = `mutation CreateRunFiles($entity: String!, $project: String!, $run: String!, $files: [String!]!) {
+  createRunFiles(input: {entityName: $entity, projectName: $project, runName: $run, files: $files}) {
+    uploadHeaders
+    files { name uploadUrl }
+  }
+}`
+
+// LogArtifact uploads a file to the run's files.
+func (w *Wandb) LogArtifact(ctx context.Context, run *Run, name string, r io.Reader, size int64) error {
+	if err := ValidArtifactName(name); err != nil {
+		return err
+	}
+	var resp struct {
+		CreateRunFiles struct {
+			UploadHeaders []string `json:"uploadHeaders"`
+			Files         []struct {
+				Name      string `json:"name"`
+				UploadURL string `json:"uploadUrl"`
+			} `json:"files"`
+		} `json:"createRunFiles"`
+	}
+	vars := map[string]interface{}{"entity": run.entity, "project": run.Experiment, "run": run.ID, "files": []string{name}}
+	if err := w.graphql(ctx, wandbCreateRunFiles, vars, &resp); err != nil {
+		return err
+	}
+	files := resp.CreateRunFiles.Files
+	if len(files) == 0 || files[0].UploadURL == "" {
+		return fmt.Errorf("wandb: no upload URL for %s", name)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, files[0].UploadURL, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for _, h := range resp.CreateRunFiles.UploadHeaders {
+		if k, v, ok := strings.Cut(h, ":"); ok {
+			req.Header.Set(k, v)
+		}
+	}
+	up, err := w.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("wandb upload %s: %w", name, err)
+	}
+	if err := check(up, "wandb upload "+name); err != nil {
+		return err
+	}
+	up.Body.Close()
+	return nil
+}
+
+// EndRun marks the run complete. W&B only tells success from failure,
+// so failed and killed runs both end with exit code 1.
+func (w *Wandb) EndRun(ctx context.Context, run *Run, status string) error {
+	if err := ValidStatus(status); err != nil {
+		return err
+	}
+	code := 0
+	if status != StatusFinished {
+		code = 1
+	}
+	return w.stream(ctx, run, map[string]interface{}{"complete": true, "exitcode": code})
+}
+
+// graphql sends a query and decodes its data into out (if non-nil).
+func (w *Wandb) graphql(ctx context.Context, query string, vars map[string]interface{}, out interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+	resp, err := w.post(ctx, "/graphql", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("wandb: invalid response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("wandb: %s", result.Errors[0].Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("wandb: invalid response: %w", err)
+	}
+	return nil
+}
+
+// stream posts a request to the run's file stream.
+func (w *Wandb) stream(ctx context.Context, run *Run, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := w.post(ctx, fmt.Sprintf("/files/%s/%s/%s/file_stream", url.PathEscape(run.entity), url.PathEscape(run.Experiment), url.PathEscape(run.ID)), data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// post sends an authenticated JSON request and returns the response if it
+// succeeded.
+func (w *Wandb) post(ctx context.Context, endpoint string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(w.URL, "/")+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("api", w.APIKey)
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("wandb: %w", err)
+	}
+	if err := check(resp, "wandb "+endpoint); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (w *Wandb) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return http.DefaultClient
+}
+
+// wandbRunID returns a random 8-character run ID, as the wandb client
+// library generates.
+func wandbRunID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate run id: %w", err)
+	}
+	for i := range b {
+		b[i] = wandbRunIDChars[int(b[i])%len(wandbRunIDChars)]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,435 @@
+// Package transfer stores payloads too large for a single WebSocket
+// message so the cloud can fetch them in chunks by ID.
+package transfer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dataFile = "data"
+	metaFile = "meta.json"
+)
+
+// DefaultMaxParallel is the default bound on concurrent chunk operations
+// per transfer.
+const DefaultMaxParallel = 4
+
+// Info describes a stored transfer. Complete is false while an upload is
+// still receiving chunks.
+type Info struct {
+	ID          string    `json:"id"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ContentType string    `json:"content_type"`
+	Created     time.Time `json:"created"`
+	Complete    bool      `json:"complete"`
+}
+
+// upload tracks chunks received for an in-progress upload.
+type upload struct {
+	mu       sync.Mutex
+	received map[int64]int // offset → length
+}
+
+// Store keeps each transfer in its own directory under dir, so the GC
+// can expire transfers as units.
+type Store struct {
+	dir string
+	// MaxParallel bounds concurrent chunk reads/writes per transfer;
+	// further chunk requests wait. Defaults to DefaultMaxParallel.
+	MaxParallel int
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+	slots   map[string]chan struct{}
+}
+
+// Open creates the store directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create transfer dir: %w", err)
+	}
+	return &Store{
+		dir:         dir,
+		MaxParallel: DefaultMaxParallel,
+		uploads:     make(map[string]*upload),
+		slots:       make(map[string]chan struct{}),
+	}, nil
+}
+
+// Put stores data and returns its descriptor.
+func (s *Store) Put(data []byte, contentType string) (Info, error) {
+	id, err := newID()
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		ID:          id,
+		Size:        int64(len(data)),
+		SHA256:      Checksum(data),
+		ContentType: contentType,
+		Created:     time.Now(),
+		Complete:    true,
+	}
+	dir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return Info{}, fmt.Errorf("create transfer: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, dataFile), data, 0o600); err != nil {
+		_ = os.RemoveAll(dir)
+		return Info{}, fmt.Errorf("write transfer: %w", err)
+	}
+	if err := s.writeMeta(info); err != nil {
+		_ = os.RemoveAll(dir)
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// PutStream stores the output of write, which streams the content to w,
+// without holding it in memory. If write fails nothing is stored.
+func (s *Store) PutStream(contentType string, write func(w io.Writer) error) (Info, error) {
+	id, err := newID()
+	if err != nil {
+		return Info{}, err
+	}
+	dir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return Info{}, fmt.Errorf("create transfer: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return Info{}, fmt.Errorf("create transfer: %w", err)
+	}
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(f, h)}
+	err = write(cw)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	info := Info{
+		ID:          id,
+		Size:        cw.n,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		ContentType: contentType,
+		Created:     time.Now(),
+		Complete:    true,
+	}
+	if err == nil {
+		err = s.writeMeta(info)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return Info{}, err
+	}
+	return info, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Create starts an upload of size bytes whose content must hash to sum.
+// Chunks may then be written in any order, concurrently, with WriteAt.
+func (s *Store) Create(size int64, sum, contentType string) (Info, error) {
+	if size < 0 {
+		return Info{}, fmt.Errorf("invalid size %d", size)
+	}
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 64 {
+		return Info{}, fmt.Errorf("invalid sha256 %q", sum)
+	}
+	id, err := newID()
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{ID: id, Size: size, SHA256: strings.ToLower(sum), ContentType: contentType, Created: time.Now()}
+	dir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return Info{}, fmt.Errorf("create transfer: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		err = f.Truncate(size)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = s.writeMeta(info)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return Info{}, fmt.Errorf("create transfer: %w", err)
+	}
+	s.mu.Lock()
+	s.uploads[id] = &upload{received: make(map[int64]int)}
+	s.mu.Unlock()
+	return info, nil
+}
+
+// WriteAt stores one chunk of an upload after checking it against its
+// SHA-256. Rewriting a chunk (a retry) is allowed.
+func (s *Store) WriteAt(id string, offset int64, data []byte, sum string) error {
+	dir, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	up := s.uploads[id]
+	s.mu.Unlock()
+	if up == nil {
+		return fmt.Errorf("transfer %s is not an open upload", id)
+	}
+	if got := Checksum(data); !strings.EqualFold(got, sum) {
+		return fmt.Errorf("chunk at offset %d: checksum mismatch (got %s, expected %s)", offset, got, sum)
+	}
+	info, err := s.Stat(id)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset+int64(len(data)) > info.Size {
+		return fmt.Errorf("chunk at offset %d (%d bytes) is outside the %d-byte transfer", offset, len(data), info.Size)
+	}
+
+	release := s.acquire(id)
+	defer release()
+	f, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, offset); err != nil {
+		f.Close()
+		return fmt.Errorf("write chunk: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	up.mu.Lock()
+	up.received[offset] = len(data)
+	up.mu.Unlock()
+	return nil
+}
+
+// Commit finishes an upload: every byte must have been received and the
+// whole content must match the SHA-256 given to Create. It returns the
+// path of the assembled data.
+func (s *Store) Commit(id string) (string, error) {
+	dir, err := s.path(id)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	up := s.uploads[id]
+	s.mu.Unlock()
+	if up == nil {
+		return "", fmt.Errorf("transfer %s is not an open upload", id)
+	}
+	info, err := s.Stat(id)
+	if err != nil {
+		return "", err
+	}
+	if missing := up.missing(info.Size); missing > 0 {
+		return "", fmt.Errorf("transfer %s incomplete: %d of %d bytes missing", id, missing, info.Size)
+	}
+
+	path := filepath.Join(dir, dataFile)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != info.SHA256 {
+		return "", fmt.Errorf("transfer %s: checksum mismatch after reassembly (got %s, expected %s)", id, got, info.SHA256)
+	}
+
+	info.Complete = true
+	if err := s.writeMeta(info); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	return path, nil
+}
+
+// missing returns how many of size bytes have not been covered by a
+// received chunk.
+func (u *upload) missing(size int64) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	offsets := make([]int64, 0, len(u.received))
+	for off := range u.received {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	var covered, end int64
+	for _, off := range offsets {
+		stop := off + int64(u.received[off])
+		if stop <= end {
+			continue
+		}
+		if off < end {
+			off = end
+		}
+		covered += stop - off
+		end = stop
+	}
+	return size - covered
+}
+
+// acquire takes one of the transfer's MaxParallel slots.
+func (s *Store) acquire(id string) (release func()) {
+	s.mu.Lock()
+	slots, ok := s.slots[id]
+	if !ok {
+		n := s.MaxParallel
+		if n <= 0 {
+			n = DefaultMaxParallel
+		}
+		slots = make(chan struct{}, n)
+		s.slots[id] = slots
+	}
+	s.mu.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+func (s *Store) writeMeta(info Info) error {
+	meta, _ := json.Marshal(info)
+	path := filepath.Join(s.dir, info.ID, metaFile)
+	if err := os.WriteFile(path+".tmp", meta, 0o600); err != nil {
+		return fmt.Errorf("write transfer: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Checksum returns the hex SHA-256 of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Stat returns a transfer's descriptor.
+func (s *Store) Stat(id string) (Info, error) {
+	dir, err := s.path(id)
+	if err != nil {
+		return Info{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, fmt.Errorf("transfer %s not found (expired?)", id)
+		}
+		return Info{}, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, fmt.Errorf("transfer %s: corrupt metadata: %w", id, err)
+	}
+	return info, nil
+}
+
+// ReadAt reads up to length bytes at offset. eof reports whether the
+// chunk reaches the end of the transfer.
+func (s *Store) ReadAt(id string, offset int64, length int) (data []byte, eof bool, err error) {
+	dir, err := s.path(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if info, err := s.Stat(id); err != nil {
+		return nil, false, err
+	} else if !info.Complete {
+		return nil, false, fmt.Errorf("transfer %s is still uploading", id)
+	}
+	release := s.acquire(id)
+	defer release()
+	f, err := os.Open(filepath.Join(dir, dataFile))
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	if offset < 0 {
+		return nil, false, fmt.Errorf("invalid offset %d", offset)
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	st, statErr := f.Stat()
+	if statErr != nil {
+		return nil, false, statErr
+	}
+	return buf[:n], offset+int64(n) >= st.Size(), nil
+}
+
+// DataPath returns the host path of a complete transfer's content.
+func (s *Store) DataPath(id string) (string, error) {
+	dir, err := s.path(id)
+	if err != nil {
+		return "", err
+	}
+	info, err := s.Stat(id)
+	if err != nil {
+		return "", err
+	}
+	if !info.Complete {
+		return "", fmt.Errorf("transfer %s is still uploading", id)
+	}
+	return filepath.Join(dir, dataFile), nil
+}
+
+// Delete removes a transfer. Missing transfers are not an error.
+func (s *Store) Delete(id string) error {
+	dir, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.uploads, id)
+	delete(s.slots, id)
+	s.mu.Unlock()
+	return os.RemoveAll(dir)
+}
+
+func (s *Store) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return "", fmt.Errorf("invalid transfer id %q", id)
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate transfer id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
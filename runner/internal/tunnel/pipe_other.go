@@ -0,0 +1,12 @@
+//go:build !windows
+
+package tunnel
+
+import (
+	"fmt"
+	"io"
+)
+
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// dialPipe opens a Windows named pipe, e.g. "docker_engine" →
+// \\.\pipe\docker_engine.
+func dialPipe(name string) (io.ReadWriteCloser, error) {
+	path := name
+	if !strings.HasPrefix(path, `\\`) {
+		path = `\\.\pipe\` + strings.TrimPrefix(strings.ReplaceAll(name, "/", `\`), `pipe\`)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open named pipe %s: %w", path, err)
+	}
+	return f, nil
+}
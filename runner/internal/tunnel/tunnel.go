@@ -0,0 +1,294 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	readBufSize = 32 * 1024
+	// writeQueueSize is the number of pending chunks per tunnel before the
+	// tunnel is closed for lack of backpressure.
+	writeQueueSize = 256
+)
+
+// tunnel is one open forwarded stream.
+type tunnel struct {
+	id     string
+	conn   io.ReadWriteCloser
+	writes chan []byte
+	once   sync.Once
+	done   chan struct{}
+}
+
+// Manager forwards byte streams between the cloud and allowlisted local
+// endpoints: Unix domain sockets ("unix:///var/run/docker.sock"), Windows
+// named pipes ("npipe://docker_engine") and TCP ports ("tcp://127.0.0.1:5900").
+type Manager struct {
+	allow []string
+
+	mu      sync.Mutex
+	tunnels map[string]*tunnel
+
+	// OutputFunc receives data read from a local endpoint.
+	OutputFunc func(tunnelID string, data []byte)
+	// ClosedFunc is called once when a tunnel closes; err is nil for a
+	// clean close.
+	ClosedFunc func(tunnelID string, err error)
+}
+
+// NewManager creates a Manager that only connects to targets matching one
+// of the allow patterns (exact addresses or path globs).
+func NewManager(allow []string) *Manager {
+	return &Manager{allow: allow, tunnels: make(map[string]*tunnel)}
+}
+
+// Allowed reports whether target, in its canonical form (see
+// parseTarget), matches the allowlist.
+func (m *Manager) Allowed(target string) bool {
+	t, err := parseTarget(target)
+	return err == nil && m.allowed(t.String())
+}
+
+func (m *Manager) allowed(target string) bool {
+	for _, pattern := range m.allow {
+		if pattern == target {
+			return true
+		}
+		if ok, err := filepath.Match(pattern, target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Open connects a new tunnel to target. The allowlist is matched against
+// the canonical form of target, which is what is dialed.
+func (m *Manager) Open(id, target string) error {
+	t, err := parseTarget(target)
+	if err != nil {
+		return err
+	}
+	if !m.allowed(t.String()) {
+		return fmt.Errorf("target %q is not in the tunnel allowlist", target)
+	}
+	return m.open(id, t)
+}
+
+// OpenDirect is like Open but skips the allowlist. It is for targets the
+// runner chose from its own configuration, never for cloud-supplied ones.
+func (m *Manager) OpenDirect(id, target string) error {
+	t, err := parseTarget(target)
+	if err != nil {
+		return err
+	}
+	return m.open(id, t)
+}
+
+func (m *Manager) open(id string, target endpoint) error {
+	if id == "" {
+		return fmt.Errorf("tunnel_id is required")
+	}
+
+	m.mu.Lock()
+	if _, exists := m.tunnels[id]; exists {
+		m.mu.Unlock()
+		return fmt.Errorf("tunnel %s already exists", id)
+	}
+	m.mu.Unlock()
+
+	conn, err := dial(target)
+	if err != nil {
+		return err
+	}
+
+	tun := &tunnel{
+		id:     id,
+		conn:   conn,
+		writes: make(chan []byte, writeQueueSize),
+		done:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	if _, exists := m.tunnels[id]; exists {
+		m.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("tunnel %s already exists", id)
+	}
+	m.tunnels[id] = tun
+	m.mu.Unlock()
+
+	go m.readLoop(tun)
+	go m.writeLoop(tun)
+
+	log.Printf("Tunnel %s opened to %s", id, target)
+	return nil
+}
+
+// Write queues data for the local endpoint. It never blocks: if the
+// endpoint cannot keep up the tunnel is closed.
+func (m *Manager) Write(id string, data []byte) error {
+	m.mu.Lock()
+	t, ok := m.tunnels[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("tunnel %s not found", id)
+	}
+	select {
+	case t.writes <- data:
+		return nil
+	case <-t.done:
+		return fmt.Errorf("tunnel %s is closed", id)
+	default:
+		err := fmt.Errorf("tunnel %s write queue full", id)
+		m.close(t, err)
+		return err
+	}
+}
+
+// Close closes a tunnel.
+func (m *Manager) Close(id string) error {
+	m.mu.Lock()
+	t, ok := m.tunnels[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("tunnel %s not found", id)
+	}
+	m.close(t, nil)
+	return nil
+}
+
+// CloseAll closes every tunnel (called on shutdown).
+func (m *Manager) CloseAll() {
+	m.mu.Lock()
+	tunnels := make([]*tunnel, 0, len(m.tunnels))
+	for _, t := range m.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	m.mu.Unlock()
+	for _, t := range tunnels {
+		m.close(t, nil)
+	}
+}
+
+func (m *Manager) close(t *tunnel, err error) {
+	t.once.Do(func() {
+		m.mu.Lock()
+		delete(m.tunnels, t.id)
+		m.mu.Unlock()
+		close(t.done)
+		_ = t.conn.Close()
+		if m.ClosedFunc != nil {
+			m.ClosedFunc(t.id, err)
+		}
+		log.Printf("Tunnel %s closed", t.id)
+	})
+}
+
+func (m *Manager) readLoop(t *tunnel) {
+	buf := make([]byte, readBufSize)
+	for {
+		n, err := t.conn.Read(buf)
+		if n > 0 && m.OutputFunc != nil {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			m.OutputFunc(t.id, chunk)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			select {
+			case <-t.done:
+				// Closed locally — not an error.
+				err = nil
+			default:
+			}
+			m.close(t, err)
+			return
+		}
+	}
+}
+
+func (m *Manager) writeLoop(t *tunnel) {
+	for {
+		select {
+		case <-t.done:
+			return
+		case data := <-t.writes:
+			if _, err := t.conn.Write(data); err != nil {
+				m.close(t, err)
+				return
+			}
+		}
+	}
+}
+
+// endpoint is a parsed tunnel target.
+type endpoint struct {
+	scheme string // "unix", "tcp" or "npipe"
+	addr   string // socket path, host:port or pipe name
+}
+
+// String returns the canonical form of the target.
+func (t endpoint) String() string {
+	return t.scheme + "://" + t.addr
+}
+
+// parseTarget parses a tunnel target. Targets whose address is escaped,
+// or that carry a user, query or fragment, are refused, so that the
+// canonical form matched against the allowlist is the address dialed; a
+// socket path is cleaned of "." and "..".
+func parseTarget(target string) (endpoint, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return endpoint{}, fmt.Errorf("invalid tunnel target %q: %w", target, err)
+	}
+	if u.Opaque != "" || u.RawPath != "" || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" || u.User != nil || strings.Contains(target, "%") {
+		return endpoint{}, fmt.Errorf("invalid tunnel target %q: want a plain scheme://address", target)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			return endpoint{}, fmt.Errorf("invalid tunnel target %q: want unix:///absolute/path", target)
+		}
+		return endpoint{scheme: "unix", addr: filepath.Clean(u.Path)}, nil
+	case "tcp":
+		if u.Host == "" || u.Path != "" {
+			return endpoint{}, fmt.Errorf("invalid tunnel target %q: want tcp://host:port", target)
+		}
+		return endpoint{scheme: "tcp", addr: u.Host}, nil
+	case "npipe":
+		name := strings.TrimPrefix(u.Host+u.Path, "/")
+		for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+			if part == "." || part == ".." {
+				return endpoint{}, fmt.Errorf("invalid tunnel target %q: pipe name has %q", target, part)
+			}
+		}
+		if name == "" {
+			return endpoint{}, fmt.Errorf("invalid tunnel target %q: want npipe://name", target)
+		}
+		return endpoint{scheme: "npipe", addr: name}, nil
+	default:
+		return endpoint{}, fmt.Errorf("unsupported tunnel target scheme %q", u.Scheme)
+	}
+}
+
+// dial connects to a tunnel target.
+func dial(target endpoint) (io.ReadWriteCloser, error) {
+	switch target.scheme {
+	case "unix", "tcp":
+		conn, err := net.Dial(target.scheme, target.addr)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s: %w", target, err)
+		}
+		return conn, nil
+	default:
+		return dialPipe(target.addr)
+	}
+}
@@ -0,0 +1,55 @@
+package tunnel
+
+import "testing"
+
+// TestAllowed matches targets against the allowlist in their canonical
+// form, so escaped or dotted paths cannot step out of an allowed glob.
+func TestAllowed(t *testing.T) {
+	m := NewManager([]string{"unix:///var/run/*.sock", "tcp://127.0.0.1:5900", "npipe://docker_engine"})
+	for _, tc := range []struct {
+		target string
+		want   bool
+	}{
+		{"unix:///var/run/docker.sock", true},
+		{"unix:///var/run/./docker.sock", true},
+		{"unix:///var/run/%2e%2e%2f%2e%2e%2froot%2fagent.sock", false},
+		{"unix:///var/run/%2e%2e/root.sock", false},
+		{"unix:///var/run/..%2froot.sock", false},
+		{"unix:///var/run/docker%2esock", false},
+		{"unix:///var/run/../../root/agent.sock", false},
+		{"unix:///var/run/x/../../../root.sock", false},
+		{"unix:///var/run/docker.sock?x=1", false},
+		{"unix:///var/run/docker.sock#x", false},
+		{"unix://host/var/run/docker.sock", false},
+		{"tcp://127.0.0.1:5900", true},
+		{"tcp://user@127.0.0.1:5900", false},
+		{"tcp://127.0.0.1:5900/", false},
+		{"npipe://docker_engine", true},
+		{"npipe://docker_engine/../x", false},
+	} {
+		if got := m.Allowed(tc.target); got != tc.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tc.target, got, tc.want)
+		}
+	}
+}
+
+// TestParseTarget checks the canonical form that is dialed.
+func TestParseTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target, want string
+	}{
+		{"unix:///var/run/./a/../docker.sock", "unix:///var/run/docker.sock"},
+		{"unix:////var//run/docker.sock", "unix:///var/run/docker.sock"},
+		{"tcp://127.0.0.1:5900", "tcp://127.0.0.1:5900"},
+		{"npipe://docker_engine", "npipe://docker_engine"},
+	} {
+		got, err := parseTarget(tc.target)
+		if err != nil {
+			t.Errorf("parseTarget(%q): %v", tc.target, err)
+			continue
+		}
+		if got.String() != tc.want {
+			t.Errorf("parseTarget(%q) = %q, want %q", tc.target, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const manifestURL = "https://xyzen.ai/xyzen/api/v1/runners/cli/releases/%s/manifest"
+
+// ReleasePublicKey is the base64 Ed25519 key that signs release
+// manifests. It is set at build time with
+// -ldflags "-X github.com/scienceol/xyzen/runner/internal/updater.ReleasePublicKey=...";
+// without it manifests are checked by checksum only.
+var ReleasePublicKey string
+
+// Manifest lists the SHA-256 of a release's binary per platform
+// ("linux-amd64", ...), signed over its canonical form.
+type Manifest struct {
+	Version   string            `json:"version"`
+	Checksums map[string]string `json:"checksums"`
+	Signature string            `json:"signature"`
+}
+
+// canonical is the signed form: the version, then "platform sha256"
+// lines sorted by platform, each newline-terminated.
+func (m Manifest) canonical() []byte {
+	platforms := make([]string, 0, len(m.Checksums))
+	for p := range m.Checksums {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	var sb strings.Builder
+	sb.WriteString(m.Version + "\n")
+	for _, p := range platforms {
+		sb.WriteString(p + " " + strings.ToLower(m.Checksums[p]) + "\n")
+	}
+	return []byte(sb.String())
+}
+
+// Verification is the outcome of checking a binary against its release.
+type Verification struct {
+	Path     string
+	Version  string
+	Platform string
+	SHA256   string
+	Expected string
+	// Signed is true if the manifest signature was checked against
+	// ReleasePublicKey.
+	Signed bool
+}
+
+// OK reports whether the binary matches the release checksum.
+func (v Verification) OK() bool {
+	return v.Expected != "" && strings.EqualFold(v.SHA256, v.Expected)
+}
+
+// VerifyBinary hashes the running executable and compares it with the
+// release manifest for version. A manifest with a bad signature is an
+// error; a checksum mismatch is reported through Verification.OK.
+func VerifyBinary(version string) (Verification, error) {
+	v := Verification{Version: version, Platform: runtime.GOOS + "-" + runtime.GOARCH}
+	exe, err := os.Executable()
+	if err != nil {
+		return v, fmt.Errorf("locate executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	v.Path = exe
+	if v.SHA256, err = hashFile(exe); err != nil {
+		return v, err
+	}
+
+	m, err := fetchManifest(version)
+	if err != nil {
+		return v, err
+	}
+	if m.Version != version {
+		return v, fmt.Errorf("manifest is for version %s, not %s", m.Version, version)
+	}
+	if v.Signed, err = checkSignature(m); err != nil {
+		return v, err
+	}
+	v.Expected = m.Checksums[v.Platform]
+	if v.Expected == "" {
+		return v, fmt.Errorf("release %s has no binary for %s", version, v.Platform)
+	}
+	return v, nil
+}
+
+func fetchManifest(version string) (Manifest, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(fmt.Sprintf(manifestURL, version))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("fetch release manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Manifest{}, fmt.Errorf("fetch release manifest: server returned %s", resp.Status)
+	}
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("decode release manifest: %w", err)
+	}
+	return m, nil
+}
+
+// checkSignature verifies the manifest signature if a release key is
+// built in, reporting whether it did.
+func checkSignature(m Manifest) (bool, error) {
+	if ReleasePublicKey == "" {
+		return false, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(ReleasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("invalid built-in release key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(key, m.canonical(), sig) {
+		return false, fmt.Errorf("release manifest signature is invalid")
+	}
+	return true, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read executable: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read executable: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}